@@ -0,0 +1,307 @@
+// Package analyser provides the analyses of dingo-hunter as a library.
+//
+// Analyze builds the SSA IR of a program, extracts MiGo types and CFSMs from
+// each analysis root, verifies them with the built-in checkers and runs the
+// loop fairness check. Each call works on its own state (SSA IR, extraction
+// and logs), so Analyze is safe to call concurrently from many goroutines.
+package analyser // import "github.com/damifur/dingo-hunter/analyser"
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/damifur/dingo-hunter/cfsmcheck"
+	"github.com/damifur/dingo-hunter/cfsmextract"
+	"github.com/damifur/dingo-hunter/cfsmextract/sesstype"
+	"github.com/damifur/dingo-hunter/diagnostics"
+	"github.com/damifur/dingo-hunter/fairness"
+	"github.com/damifur/dingo-hunter/migocheck"
+	"github.com/damifur/dingo-hunter/migoextract"
+	"github.com/damifur/dingo-hunter/ssabuilder"
+	"github.com/damifur/dingo-hunter/stubspec"
+	"github.com/damifur/migo"
+	"golang.org/x/tools/go/ssa"
+)
+
+var (
+	// ErrNoInput is returned if no source files, packages or source code are
+	// given.
+	ErrNoInput = errors.New("no files, packages or source code to analyse")
+
+	// ErrNoRoot is returned if no analysis root is given and the program has
+	// no main function.
+	ErrNoRoot = errors.New("no main function found (use Roots, Lib or Tests to select analysis roots)")
+)
+
+// Analysis is a set of analyses to run.
+type Analysis uint
+
+const (
+	MiGo      Analysis = 1 << iota // Extract MiGo types.
+	MiGoCheck                      // Check MiGo types with the built-in checker.
+	CFSMs                          // Extract CFSMs.
+	CFSMCheck                      // Check CFSMs with the built-in GMC check.
+	Fairness                       // Check loop fairness.
+
+	All = MiGo | MiGoCheck | CFSMs | CFSMCheck | Fairness
+)
+
+// Options are the options of an analysis.
+//
+// The program is given by one of Source, Files or Patterns.
+type Options struct {
+	Source   string   // Go source code of a main package.
+	Files    []string // Go source files of a package.
+	Patterns []string // Package patterns (module-aware), e.g. "./...".
+	Dir      string   // Directory to resolve Patterns in.
+	Tests    bool     // Load _test.go files, and use tests as roots (unless Roots is given).
+
+	Roots []string // Analysis root functions (default main.main), e.g. "pkg.Func".
+	Lib   bool     // Use every exported function as analysis root.
+
+	Dispatch ssabuilder.Dispatch // Resolution of dynamic calls (default static).
+
+	// Packages are the policies of packages, which replace the default
+	// policies of the packages given (see ssabuilder.Policy).
+	Packages []ssabuilder.PackagePolicy
+
+	Analyses Analysis // Analyses to run (default All).
+
+	BufferPolicy  ssabuilder.BufferPolicy // Size of channels with non-constant buffer size.
+	RWReaders     int                     // Readers holding a RWMutex (default migoextract.DefaultRWReaders).
+	MiGoCheckOpts migocheck.Options       // Bounds of MiGo check (default migocheck.DefaultOptions).
+	CFSMCheckOpts cfsmcheck.Options       // Bounds of GMC check (channel machines are idle).
+	Log           io.Writer               // Logs of the analyses (default: discarded).
+
+	// Budget is the resource budget of the extractions, the Timeout applies
+	// to all analyses after the program is built. Results out of budget are
+	// partial.
+	Budget ssabuilder.Budget
+
+	Jobs int // Goroutines analysed concurrently by the extractions (default 1).
+
+	// CacheDir is the directory of the on-disk cache of MiGo function
+	// summaries, which is disabled if empty (see migoextract.Cache).
+	CacheDir string
+
+	// Stubs are the stub specifications of functions which are not analysed
+	// (default stubspec.Load(), the standard stub specifications).
+	Stubs *stubspec.Spec
+
+	cache *migoextract.Cache
+}
+
+// Result is the result of the analyses of an analysis root.
+type Result struct {
+	Root        *ssa.Function                  // Analysis root.
+	MiGo        *migo.Program                  // MiGo types, if extracted.
+	Summaries   *migoextract.Summaries         // Function summaries of MiGo types.
+	MiGoCheck   *migocheck.Result              // MiGo check result, if checked.
+	CFSMs       *sesstype.CFSMs                // CFSMs, if extracted.
+	CFSMCheck   *cfsmcheck.Result              // GMC check result, if checked.
+	Skipped     []*ssabuilder.UnsupportedError // Functions skipped in extraction.
+	Partial     ssabuilder.Partial             // Reasons of partial extraction, the checks are then skipped.
+	Diagnostics []diagnostics.Diagnostic       // Diagnostics of the root.
+}
+
+// Report is the report of an analysis.
+type Report struct {
+	SSA         *ssabuilder.SSAInfo      // SSA IR of the program.
+	Results     []*Result                // Results per analysis root.
+	Partial     ssabuilder.Partial       // Reasons of partial results, including those of Results.
+	Diagnostics []diagnostics.Diagnostic // All diagnostics, including those of Results.
+}
+
+// Analyze runs the analyses of opts. It returns an error if the program cannot
+// be built or analysed, or if ctx is done before the analyses finish. If the
+// analyses run out of budget, the report is partial.
+func Analyze(ctx context.Context, opts Options) (*Report, error) {
+	if opts.Analyses == 0 {
+		opts.Analyses = All
+	}
+	if opts.Log == nil {
+		opts.Log = ioutil.Discard
+	}
+	if opts.MiGoCheckOpts == (migocheck.Options{}) {
+		opts.MiGoCheckOpts = migocheck.DefaultOptions
+	}
+	if opts.Stubs == nil {
+		stubs, err := stubspec.Load()
+		if err != nil {
+			return nil, err
+		}
+		opts.Stubs = stubs
+	}
+	conf, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	info, err := conf.Build()
+	if err != nil {
+		return nil, err
+	}
+	roots, err := info.Roots(opts.Roots, opts.Lib, opts.Tests)
+	if err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 {
+		mainPkg := ssabuilder.MainPkg(info.Prog)
+		if mainPkg == nil || mainPkg.Func("main") == nil {
+			return nil, ErrNoRoot
+		}
+		roots = []*ssa.Function{mainPkg.Func("main")}
+	}
+
+	if opts.CacheDir != "" && opts.Analyses&(MiGo|MiGoCheck) != 0 {
+		if opts.cache, err = migoextract.OpenCache(opts.CacheDir); err != nil {
+			return nil, err
+		}
+	}
+	budgetCtx, cancel := opts.Budget.Context(ctx)
+	defer cancel()
+	opts.Budget.Timeout = 0 // Applied to budgetCtx.
+
+	report := &Report{SSA: info}
+	for _, root := range roots {
+		res, err := analyzeRoot(budgetCtx, info, root, opts)
+		if err == nil {
+			err = ctx.Err() // Cancelled by the caller, not out of budget.
+		}
+		if err != nil {
+			return nil, err
+		}
+		report.Results = append(report.Results, res)
+		for _, err := range res.Partial {
+			report.Partial.Add(err)
+		}
+		report.Diagnostics = append(report.Diagnostics, res.Diagnostics...)
+	}
+	if opts.Analyses&Fairness != 0 {
+		if err := budgetCtx.Err(); err != nil {
+			report.Partial.Add(err)
+		} else {
+			report.Diagnostics = append(report.Diagnostics, fairness.CheckLog(info, opts.Log)...)
+		}
+	}
+	return report, nil
+}
+
+// newConfig returns the build configuration of the program of opts.
+func newConfig(opts Options) (*ssabuilder.Config, error) {
+	var (
+		conf *ssabuilder.Config
+		err  error
+	)
+	switch {
+	case opts.Source != "":
+		conf, err = ssabuilder.NewConfigFromString(opts.Source)
+	case len(opts.Files) > 0:
+		conf, err = ssabuilder.NewConfig(opts.Files)
+	case len(opts.Patterns) > 0:
+		conf, err = ssabuilder.NewConfigFromPackages(opts.Patterns)
+		if conf != nil {
+			conf.Dir = opts.Dir
+		}
+	default:
+		return nil, ErrNoInput
+	}
+	if err != nil {
+		return nil, err
+	}
+	conf.Tests = opts.Tests
+	conf.BuildLog = opts.Log
+	conf.Dispatch = opts.Dispatch
+	for _, p := range opts.Packages {
+		conf.SetPolicy(p.Package, p.Policy, p.Reason)
+	}
+	return conf, nil
+}
+
+// analyzeRoot runs the analyses of opts from root.
+func analyzeRoot(ctx context.Context, info *ssabuilder.SSAInfo, root *ssa.Function, opts Options) (*Result, error) {
+	res := &Result{Root: root}
+	if opts.Analyses&(MiGo|MiGoCheck) != 0 {
+		infer, err := extractMiGo(ctx, info, root, opts)
+		if err != nil {
+			return nil, err
+		}
+		res.MiGo = infer.Env.MigoProg
+		res.Summaries = infer.Env.Summaries
+		res.Skipped = append(res.Skipped, infer.Skipped...)
+		res.Partial = append(res.Partial, infer.Partial...)
+		res.Diagnostics = append(res.Diagnostics, infer.Diagnostics...)
+		if opts.Analyses&MiGoCheck != 0 && len(infer.Partial) == 0 {
+			check, err := migocheck.Check(res.MiGo, "main.main", opts.MiGoCheckOpts)
+			if err != nil {
+				return nil, err
+			}
+			res.MiGoCheck = check
+			res.Diagnostics = append(res.Diagnostics, MiGoDiagnostics(info, root, check)...)
+		}
+	}
+	if opts.Analyses&(CFSMs|CFSMCheck) != 0 {
+		extract, err := extractCFSMs(ctx, info, root, opts)
+		if err != nil {
+			return nil, err
+		}
+		res.CFSMs = sesstype.NewCFSMs(extract.Session())
+		res.Skipped = append(res.Skipped, extract.Skipped...)
+		for _, err := range extract.Partial {
+			res.Partial.Add(err)
+		}
+		res.Diagnostics = append(res.Diagnostics, extract.Diagnostics...)
+		if opts.Analyses&CFSMCheck != 0 && len(extract.Partial) == 0 {
+			res.CFSMCheck = CheckCFSMs(res.CFSMs, opts.CFSMCheckOpts)
+			res.Diagnostics = append(res.Diagnostics, CFSMDiagnostics(root, res.CFSMCheck)...)
+		}
+	}
+	return res, nil
+}
+
+// extractMiGo extracts the MiGo types of root.
+func extractMiGo(ctx context.Context, info *ssabuilder.SSAInfo, root *ssa.Function, opts Options) (*migoextract.TypeInfer, error) {
+	infer, err := migoextract.New(info, opts.Log)
+	if err != nil {
+		return nil, err
+	}
+	infer.Root = root
+	infer.BufferPolicy = opts.BufferPolicy
+	if opts.RWReaders > 0 {
+		infer.RWReaders = opts.RWReaders
+	}
+	infer.Budget = opts.Budget
+	infer.Jobs = opts.Jobs
+	infer.Cache = opts.cache
+	infer.Stubs = opts.Stubs
+	go infer.RunContext(ctx)
+
+	// The extraction stops early (with partial types) when ctx is done.
+	select {
+	case err := <-infer.Error:
+		return nil, err
+	case <-infer.Done:
+	}
+	infer.Env.MigoProg.CleanUp()
+	return infer, nil
+}
+
+// extractCFSMs extracts the CFSMs of root.
+func extractCFSMs(ctx context.Context, info *ssabuilder.SSAInfo, root *ssa.Function, opts Options) (*cfsmextract.CFSMExtract, error) {
+	extract := cfsmextract.New(info, "", "")
+	extract.Root = root
+	extract.Output, extract.Log = opts.Log, opts.Log
+	extract.Budget = opts.Budget
+	extract.BufferPolicy = opts.BufferPolicy
+	extract.Jobs = opts.Jobs
+	extract.Stubs = opts.Stubs
+	go extract.RunContext(ctx)
+
+	select {
+	case err := <-extract.Error:
+		return nil, err
+	case <-extract.Done:
+	}
+	return extract, nil
+}
@@ -0,0 +1,369 @@
+package analyser
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/damifur/dingo-hunter/diagnostics"
+	"github.com/damifur/dingo-hunter/ssabuilder"
+	"github.com/damifur/dingo-hunter/stubspec"
+)
+
+const deadlock = `package main
+
+func main() {
+	ch := make(chan int)
+	go func() { ch <- 1 }()
+	<-ch
+	<-ch
+}
+`
+
+const live = `package main
+
+func main() {
+	ch := make(chan int)
+	go func() { ch <- 1 }()
+	<-ch
+}
+`
+
+// TestAnalyzeConcurrent runs analyses concurrently, the results must not be
+// affected by each other.
+func TestAnalyzeConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		src, wantLive := live, true
+		if i%2 == 0 {
+			src, wantLive = deadlock, false
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report, err := Analyze(context.Background(), Options{Source: src, Analyses: MiGoCheck | CFSMs})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if len(report.Results) != 1 {
+				t.Errorf("expecting 1 result, got %d", len(report.Results))
+				return
+			}
+			res := report.Results[0]
+			if res.MiGoCheck == nil || res.MiGoCheck.Live() != wantLive {
+				t.Errorf("expecting live=%t, got %+v", wantLive, res.MiGoCheck)
+			}
+			if res.CFSMs == nil || len(res.CFSMs.Chans) != 1 {
+				t.Errorf("expecting CFSMs with 1 channel, got %+v", res.CFSMs)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestAnalyzeNoInput(t *testing.T) {
+	if _, err := Analyze(context.Background(), Options{}); err != ErrNoInput {
+		t.Errorf("expecting %v, got %v", ErrNoInput, err)
+	}
+}
+
+// TestAnalyzeBudget checks that the results out of budget are partial, and
+// are not checked.
+func TestAnalyzeBudget(t *testing.T) {
+	opts := Options{Source: live, Analyses: MiGoCheck | CFSMCheck}
+	report, err := Analyze(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Partial) != 0 {
+		t.Errorf("expecting complete result, got partial %v", report.Partial)
+	}
+	opts.Budget.Timeout = time.Nanosecond
+	report, err = Analyze(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := report.Results[0]
+	if len(res.Partial) == 0 || len(report.Partial) == 0 {
+		t.Errorf("expecting partial result")
+	}
+	if res.MiGoCheck != nil || res.CFSMCheck != nil {
+		t.Errorf("expecting partial result not checked")
+	}
+}
+
+// TestAnalyzeBudgetLimits checks that the extractions exceeding the limits of
+// blocks, instances (recv has an instance per constant n) or goroutines are
+// partial.
+func TestAnalyzeBudgetLimits(t *testing.T) {
+	const src = `package main
+
+func send(ch chan int) { ch <- 1 }
+
+func recv(ch chan int, n int) {
+	for i := 0; i < n; i++ {
+		<-ch
+	}
+}
+
+func main() {
+	ch := make(chan int)
+	go send(ch)
+	go send(ch)
+	recv(ch, 1)
+	recv(ch, 2)
+}
+`
+	for _, tc := range []struct {
+		name   string
+		budget ssabuilder.Budget
+	}{
+		{"max-blocks", ssabuilder.Budget{MaxBlocks: 1}},
+		{"max-instances", ssabuilder.Budget{MaxInstances: 1}},
+		{"max-goroutines", ssabuilder.Budget{MaxGoroutines: 1}},
+	} {
+		for name, analysis := range map[string]Analysis{"MiGo": MiGo, "CFSMs": CFSMs} {
+			report, err := Analyze(context.Background(), Options{Source: src, Analyses: analysis, Budget: tc.budget})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var exhausted bool
+			for _, err := range report.Results[0].Partial {
+				if e, ok := err.(*ssabuilder.BudgetError); ok && e.Budget == tc.name {
+					exhausted = true
+				}
+			}
+			if !exhausted {
+				t.Errorf("%s: expecting partial %s, got %v", tc.name, name, report.Results[0].Partial)
+			}
+		}
+	}
+}
+
+func TestAnalyzeCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Analyze(ctx, Options{Source: live}); err != context.Canceled {
+		t.Errorf("expecting %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestAnalyzeFairness(t *testing.T) {
+	const src = `package main
+
+func produce(ch chan int) {
+	ch <- 1
+	close(ch)
+}
+
+func main() {
+	a, b := make(chan int), make(chan int)
+	go produce(a)
+	go func() { b <- 1 }()
+	for range a {
+	}
+	for range b {
+	}
+}
+`
+	report, err := Analyze(context.Background(), Options{Source: src, Analyses: Fairness})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unfair []int
+	for _, d := range report.Diagnostics {
+		if d.Severity == diagnostics.Warning {
+			unfair = append(unfair, d.Line)
+		}
+	}
+	if len(unfair) != 1 || unfair[0] != 14 {
+		t.Errorf("expecting only the range over b (line 14) to be unfair, got %+v", report.Diagnostics)
+	}
+}
+
+func TestAnalyzeCache(t *testing.T) {
+	const src = `package main
+
+func send(ch chan int) { ch <- 1 }
+
+func loop(ch chan int) {
+	for i := 0; i < 2; i++ {
+		<-ch
+	}
+}
+
+func pair(n int) {
+	ch := make(chan int, n)
+	go send(ch)
+	go send(ch)
+	loop(ch)
+}
+
+func main() {
+	pair(0)
+	pair(1)
+}
+`
+	dir, err := ioutil.TempDir("", "dingo-hunter-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	analyze := func(src, cacheDir string) ([]string, string) {
+		var log bytes.Buffer
+		report, err := Analyze(context.Background(), Options{Source: src, Analyses: MiGo, CacheDir: cacheDir, Log: &log})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var defs []string
+		for _, fn := range report.Results[0].MiGo.Funcs {
+			defs = append(defs, fn.String())
+		}
+		sort.Strings(defs)
+		return defs, log.String()
+	}
+	// The edit changes loop, and so its callers, but not send.
+	edited := strings.Replace(src, "i < 2", "i < 3", 1)
+	for _, src := range []string{src, edited} {
+		want, _ := analyze(src, "")
+		for i := 0; i < 2; i++ { // Stores then loads the summaries.
+			got, log := analyze(src, dir)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("expecting MiGo types with cache as without:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+			}
+			if cached := strings.Contains(log, "cached summary main.pair "); cached != (i == 1) {
+				t.Errorf("run %d: expecting summary main.pair cached=%t", i, i == 1)
+			}
+			// The summary of send is stored before the edit, and is loaded
+			// (not analysed again) when pair is analysed after the edit.
+			if src == edited && i == 0 && !strings.Contains(log, "cached summary main.send ") {
+				t.Errorf("expecting summary main.send cached after the edit")
+			}
+		}
+	}
+	_, log := analyze(src, dir)
+	if !strings.Contains(log, "cached summary main.pair ") {
+		t.Errorf("expecting summary main.pair cached before the edit")
+	}
+}
+
+// tempModule writes files (by path) of module example.com/m to a temporary
+// directory, and returns the directory.
+func tempModule(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "dingo-hunter-module")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files["go.mod"] = "module example.com/m\n\ngo 1.16\n"
+	for name, src := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestAnalyzePackages(t *testing.T) {
+	dir := tempModule(t, map[string]string{
+		"lib/lib.go": "package lib\n\nfunc Send(ch chan int) { ch <- 1 }\n",
+		"main.go":    "package main\n\nimport \"example.com/m/lib\"\n\nfunc main() {\n\tch := make(chan int)\n\tgo lib.Send(ch)\n\t<-ch\n}\n",
+	})
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		policy  ssabuilder.PackagePolicy
+		opaque  bool // Warning of opaque function.
+		ignored bool // Package not loaded.
+	}{
+		{ssabuilder.PackagePolicy{Package: "lib", Policy: ssabuilder.Analyse}, false, false},
+		{ssabuilder.PackagePolicy{Package: "example.com/m/lib", Policy: ssabuilder.Opaque}, true, false},
+		{ssabuilder.PackagePolicy{Package: "lib", Policy: ssabuilder.Pure}, false, false},
+		{ssabuilder.PackagePolicy{Package: "lib", Policy: ssabuilder.Skip}, false, true},
+	}
+	for _, tc := range tests {
+		opts := Options{Patterns: []string{"./..."}, Dir: dir, Analyses: MiGo | CFSMs, Packages: []ssabuilder.PackagePolicy{tc.policy}}
+		report, err := Analyze(context.Background(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var opaque int
+		for _, d := range report.Diagnostics {
+			if strings.Contains(d.Message, "opaque function example.com/m/lib.Send") {
+				opaque++
+			}
+		}
+		if tc.opaque && opaque != 2 { // One per extraction.
+			t.Errorf("%s %s: expecting warnings of opaque function from MiGo and CFSMs, got %d", tc.policy.Policy, tc.policy.Package, opaque)
+		}
+		if !tc.opaque && opaque > 0 {
+			t.Errorf("%s %s: expecting no warning of opaque function, got %d", tc.policy.Policy, tc.policy.Package, opaque)
+		}
+		ignored := false
+		for _, path := range report.SSA.IgnoredPkgs {
+			ignored = ignored || path == "example.com/m/lib"
+		}
+		if ignored != tc.ignored {
+			t.Errorf("%s %s: expecting package ignored=%t, got %t", tc.policy.Policy, tc.policy.Package, tc.ignored, ignored)
+		}
+	}
+}
+
+// TestAnalyzeStubs analyses calls of functions of a skipped package, which
+// are modelled by stub specifications.
+func TestAnalyzeStubs(t *testing.T) {
+	dir := tempModule(t, map[string]string{
+		"lib/lib.go": "package lib\n\nfunc Start(ch chan int) { go func() { ch <- 1 }() }\n\nfunc Wait(ch chan int) { <-ch }\n",
+		"main.go":    "package main\n\nimport \"example.com/m/lib\"\n\nfunc send(ch chan int) { ch <- 1 }\n\nfunc main() {\n\tch := make(chan int)\n\tlib.Start(ch)\n\t<-ch\n\tdone := make(chan int)\n\tgo send(done)\n\tlib.Wait(done)\n}\n",
+	})
+	defer os.RemoveAll(dir)
+	const spec = `-- Start spawns a sender, Wait receives.
+stub "example.com/m/lib.Start" = lib.start;
+def lib.start(c): spawn lib.send(c);
+def lib.send(c): send c;
+def example.com/m/lib.Wait(c): recv c;
+`
+	for _, tc := range []struct {
+		spec string
+		live bool
+	}{
+		{spec, true},
+		{"", false}, // Standard stubs only.
+	} {
+		stubs, err := stubspec.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := stubs.Parse(strings.NewReader(tc.spec), "test"); err != nil {
+			t.Fatal(err)
+		}
+		opts := Options{
+			Patterns: []string{"./..."},
+			Dir:      dir,
+			Analyses: MiGoCheck | CFSMCheck,
+			Packages: []ssabuilder.PackagePolicy{{Package: "example.com/m/lib", Policy: ssabuilder.Skip}},
+			Stubs:    stubs,
+		}
+		report, err := Analyze(context.Background(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := report.Results[0]
+		if res.MiGoCheck == nil || res.MiGoCheck.Live() != tc.live || !res.MiGoCheck.Safe() {
+			t.Errorf("stubs %q: expecting MiGo live=%t and safe, got %+v\n%s", tc.spec, tc.live, res.MiGoCheck, res.MiGo)
+		}
+		if res.CFSMCheck == nil || (len(res.CFSMCheck.Stuck) == 0) != tc.live {
+			t.Errorf("stubs %q: expecting CFSMs live=%t, got %+v", tc.spec, tc.live, res.CFSMCheck)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package analyser
+
+import (
+	"go/token"
+
+	"github.com/damifur/dingo-hunter/cfsmcheck"
+	"github.com/damifur/dingo-hunter/cfsmextract/sesstype"
+	"github.com/damifur/dingo-hunter/diagnostics"
+	"github.com/damifur/dingo-hunter/migocheck"
+	"github.com/damifur/dingo-hunter/ssabuilder"
+	"github.com/damifur/dingo-hunter/trace"
+	"github.com/nickng/cfsm"
+	"golang.org/x/tools/go/ssa"
+)
+
+// MiGoDiagnostics returns the violations of a MiGo check of root as
+// diagnostics, located in the Go source with their counterexample traces,
+// followed by the verdict.
+func MiGoDiagnostics(info *ssabuilder.SSAInfo, root *ssa.Function, res *migocheck.Result) []diagnostics.Diagnostic {
+	reporter := trace.NewReporter(info, root)
+	var diags []diagnostics.Diagnostic
+	for _, v := range res.Violations {
+		loc := reporter.Locate(v.At)
+		d := diagnostics.New(diagnostics.Error, "migocheck", token.Position{Filename: loc.File, Line: loc.Line}, "%s: %s", v.Kind, v.Message)
+		d.Function, d.Channel = loc.Function, loc.Channel
+		d.Trace = reporter.Diagnostics(v)
+		diags = append(diags, d)
+	}
+	sev := diagnostics.Info
+	if !res.Complete {
+		sev = diagnostics.Warning
+	}
+	d := diagnostics.New(sev, "migocheck", root.Prog.Fset.Position(root.Pos()), "Liveness: %t Safety: %t (%d states explored, complete: %t)", res.Live(), res.Safe(), res.States, res.Complete)
+	d.Function = root.String()
+	return append(diags, d)
+}
+
+// CheckCFSMs runs the GMC check on the CFSMs, where the channel machines are
+// idle (in addition to opts.Idle).
+func CheckCFSMs(cfsms *sesstype.CFSMs, opts cfsmcheck.Options) *cfsmcheck.Result {
+	idle := append([]*cfsm.CFSM{}, opts.Idle...)
+	for _, m := range cfsms.Chans {
+		idle = append(idle, m)
+	}
+	opts.Idle = idle
+	return cfsmcheck.Check(cfsms.Sys, opts)
+}
+
+// CFSMDiagnostics returns the violations of a GMC check of root as
+// diagnostics positioned at root, followed by the verdict.
+func CFSMDiagnostics(root *ssa.Function, res *cfsmcheck.Result) []diagnostics.Diagnostic {
+	pos := root.Prog.Fset.Position(root.Pos())
+	var diags []diagnostics.Diagnostic
+	add := func(sev diagnostics.Severity, format string, args ...interface{}) {
+		d := diagnostics.New(sev, "cfsmcheck", pos, format, args...)
+		d.Function = root.String()
+		diags = append(diags, d)
+	}
+	for _, t := range res.Unrepresented {
+		add(diagnostics.Error, "not representable: %s", t)
+	}
+	for _, b := range res.Branching {
+		add(diagnostics.Error, "branching property violated at %s: %s / %s", b.Config, b.Left, b.Right)
+	}
+	for _, c := range res.Stuck {
+		add(diagnostics.Error, "stuck configuration %s after %d transitions", c, len(c.Trace))
+	}
+	sev := diagnostics.Info
+	if !res.Complete {
+		sev = diagnostics.Warning
+	}
+	add(sev, "GMC: %t Representable: %t Branching: %t Stuck: %d (%d configurations explored, complete: %t)",
+		res.GMC(), res.Representable(), res.WellBranched(), len(res.Stuck), res.States, res.Complete)
+	return diags
+}
@@ -0,0 +1,353 @@
+// Package cfsmcheck provides a generalised multiparty compatibility (GMC)
+// check of communicating finite state machines.
+//
+// The check explores the synchronous transition system (STS) of a CFSM system
+// (i.e. a send and the matching receive fire together), and reports
+//   - representability: every local transition is fired in the STS
+//   - branching property: every choice in the STS is made by a single machine,
+//     or else the alternatives commute (concurrent transitions)
+//   - stuck states: configurations without transitions where some machine
+//     can still act
+//
+// Idle machines (e.g. the channel machines of sesstype.CFSMs) may stop in any
+// state and are not required to be representable.
+package cfsmcheck // import "github.com/damifur/dingo-hunter/cfsmcheck"
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nickng/cfsm"
+)
+
+// Options are the options of a check.
+type Options struct {
+	MaxStates int          // Maximum number of configurations explored.
+	Idle      []*cfsm.CFSM // Machines which may stop in any state.
+}
+
+// DefaultMaxStates is the default bound of configurations explored.
+const DefaultMaxStates = 100000
+
+// Sync is a synchronous transition, i.e. machine From sends Msg to To.
+type Sync struct {
+	From int    // Sender machine ID.
+	To   int    // Receiver machine ID.
+	Msg  string // Message.
+}
+
+func (s Sync) String() string { return fmt.Sprintf("%d → %d: %s", s.From, s.To, s.Msg) }
+
+// LocalTransition is a transition of a single machine.
+type LocalTransition struct {
+	Machine int    // Machine ID.
+	State   string // Source state.
+	Label   string // Transition label, e.g. "1 ! int".
+}
+
+func (t LocalTransition) String() string {
+	return fmt.Sprintf("machine %d: %s --%s-->", t.Machine, t.State, t.Label)
+}
+
+// Config is a reachable configuration of the STS.
+type Config struct {
+	States map[int]string // Machine ID -> local state.
+	Trace  []Sync         // Transitions from the initial configuration.
+}
+
+func (c Config) String() string {
+	ids := make([]int, 0, len(c.States))
+	for id := range c.States {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprintf("%d:%s", id, c.States[id])
+	}
+	return "(" + strings.Join(parts, ", ") + ")"
+}
+
+// Branch is a violation of the branching property: two alternative
+// transitions of a configuration which are not chosen by a single machine
+// and do not commute.
+type Branch struct {
+	Config
+	Left, Right Sync
+}
+
+// Result is the result of a GMC check.
+type Result struct {
+	States        int               // Number of configurations explored.
+	Complete      bool              // False if the bound is reached.
+	Unrepresented []LocalTransition // Local transitions never fired.
+	Branching     []Branch          // Branching property violations.
+	Stuck         []Config          // Stuck configurations.
+}
+
+// Representable returns true if all local transitions are fired in the STS.
+func (r *Result) Representable() bool { return len(r.Unrepresented) == 0 }
+
+// WellBranched returns true if the branching property holds.
+func (r *Result) WellBranched() bool { return len(r.Branching) == 0 }
+
+// GMC returns true if the system is generalised multiparty compatible and
+// free of stuck configurations.
+func (r *Result) GMC() bool {
+	return r.Representable() && r.WellBranched() && len(r.Stuck) == 0
+}
+
+// label is a parsed transition label.
+type label struct {
+	send bool
+	peer int
+	msg  string
+}
+
+// parseLabel parses the label of a transition, i.e. "peer ! msg" for send and
+// "peer ? msg" for receive.
+func parseLabel(t cfsm.Transition) (label, bool) {
+	l := t.Label()
+	for _, sep := range []string{" ! ", " ? "} {
+		if i := strings.Index(l, sep); i > 0 {
+			peer, err := strconv.Atoi(l[:i])
+			if err != nil {
+				return label{}, false
+			}
+			return label{send: sep == " ! ", peer: peer, msg: l[i+len(sep):]}, true
+		}
+	}
+	return label{}, false
+}
+
+// move is a transition of the STS.
+type move struct {
+	sync     Sync
+	from, to int             // Index of sender/receiver machines.
+	sent     cfsm.Transition // Local transition of sender.
+	recvd    cfsm.Transition // Local transition of receiver.
+}
+
+// config is a configuration of the STS, the local state of each machine.
+type config []*cfsm.State
+
+func (c config) key() string {
+	var buf strings.Builder
+	for _, s := range c {
+		buf.WriteString(s.Name())
+		buf.WriteByte(',')
+	}
+	return buf.String()
+}
+
+type node struct {
+	conf   config
+	parent int
+	sync   Sync
+}
+
+type checker struct {
+	opts     Options
+	machines []*cfsm.CFSM
+	index    map[int]int // Machine ID -> index.
+	idle     map[int]bool
+	seen     map[string]int
+	nodes    []node
+	fired    map[*cfsm.State]map[string]bool // Fired local transitions.
+	result   Result
+}
+
+// Check runs the GMC check on sys. Machines without start state are ignored.
+func Check(sys *cfsm.System, opts Options) *Result {
+	if opts.MaxStates <= 0 {
+		opts.MaxStates = DefaultMaxStates
+	}
+	c := &checker{
+		opts:  opts,
+		index: make(map[int]int),
+		idle:  make(map[int]bool),
+		seen:  make(map[string]int),
+		fired: make(map[*cfsm.State]map[string]bool),
+	}
+	c.result.Complete = true
+	for _, m := range opts.Idle {
+		c.idle[m.ID] = true
+	}
+	init := config{}
+	for _, m := range sys.CFSMs {
+		if m.Start == nil {
+			continue
+		}
+		c.index[m.ID] = len(c.machines)
+		c.machines = append(c.machines, m)
+		init = append(init, m.Start)
+	}
+	c.explore(init)
+	if c.result.Complete {
+		c.checkRepresentability()
+	}
+	return &c.result
+}
+
+// moves returns the enabled transitions of conf.
+func (c *checker) moves(conf config) []move {
+	var moves []move
+	for i, s := range conf {
+		for _, t := range s.Transitions() {
+			l, ok := parseLabel(t)
+			if !ok || !l.send {
+				continue
+			}
+			j, ok := c.index[l.peer]
+			if !ok {
+				continue
+			}
+			for _, r := range conf[j].Transitions() {
+				rl, ok := parseLabel(r)
+				if ok && !rl.send && rl.peer == c.machines[i].ID && rl.msg == l.msg {
+					moves = append(moves, move{
+						sync: Sync{From: c.machines[i].ID, To: l.peer, Msg: l.msg},
+						from: i, to: j, sent: t, recvd: r,
+					})
+				}
+			}
+		}
+	}
+	return moves
+}
+
+func (c *checker) apply(conf config, mv move) config {
+	next := make(config, len(conf))
+	copy(next, conf)
+	next[mv.from], next[mv.to] = mv.sent.State(), mv.recvd.State()
+	return next
+}
+
+// explore runs a breadth-first search of the STS.
+func (c *checker) explore(init config) {
+	c.visit(init, -1, Sync{})
+	for n := 0; n < len(c.nodes); n++ {
+		if len(c.nodes) >= c.opts.MaxStates {
+			c.result.Complete = false
+			break
+		}
+		conf := c.nodes[n].conf
+		moves := c.moves(conf)
+		for _, mv := range moves {
+			c.markFired(conf[mv.from], mv.sent)
+			c.markFired(conf[mv.to], mv.recvd)
+			c.visit(c.apply(conf, mv), n, mv.sync)
+		}
+		if len(moves) == 0 && c.canAct(conf) {
+			c.result.Stuck = append(c.result.Stuck, c.config(n))
+		}
+		c.checkBranching(n, moves)
+	}
+	c.result.States = len(c.nodes)
+}
+
+func (c *checker) visit(conf config, parent int, sync Sync) {
+	key := conf.key()
+	if _, ok := c.seen[key]; ok {
+		return
+	}
+	c.seen[key] = len(c.nodes)
+	c.nodes = append(c.nodes, node{conf: conf, parent: parent, sync: sync})
+}
+
+func (c *checker) markFired(s *cfsm.State, t cfsm.Transition) {
+	if c.fired[s] == nil {
+		c.fired[s] = make(map[string]bool)
+	}
+	c.fired[s][t.Label()] = true
+}
+
+// canAct returns true if a non-idle machine has transitions in conf.
+func (c *checker) canAct(conf config) bool {
+	for i, s := range conf {
+		if !c.idle[c.machines[i].ID] && len(s.Transitions()) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// config returns the configuration of node n with its trace.
+func (c *checker) config(n int) Config {
+	states := make(map[int]string)
+	for i, s := range c.nodes[n].conf {
+		states[c.machines[i].ID] = s.Name()
+	}
+	var trace []Sync
+	for ; c.nodes[n].parent >= 0; n = c.nodes[n].parent {
+		trace = append(trace, c.nodes[n].sync)
+	}
+	for i, j := 0, len(trace)-1; i < j; i, j = i+1, j-1 {
+		trace[i], trace[j] = trace[j], trace[i]
+	}
+	return Config{States: states, Trace: trace}
+}
+
+// checkBranching checks that alternative moves of node n are either chosen by
+// the same sender, or commute.
+func (c *checker) checkBranching(n int, moves []move) {
+	conf := c.nodes[n].conf
+	for i := 0; i < len(moves); i++ {
+		for j := i + 1; j < len(moves); j++ {
+			m1, m2 := moves[i], moves[j]
+			if m1.from == m2.from {
+				continue // Choice of a single sender.
+			}
+			if m1.to != m2.to && m1.to != m2.from && m2.to != m1.from {
+				continue // Independent machines.
+			}
+			if c.commute(conf, m1, m2) {
+				continue
+			}
+			c.result.Branching = append(c.result.Branching, Branch{Config: c.config(n), Left: m1.sync, Right: m2.sync})
+		}
+	}
+}
+
+// commute returns true if m1 and m2 can fire in any order to reach the same
+// configuration.
+func (c *checker) commute(conf config, m1, m2 move) bool {
+	after := func(first, second move) (config, bool) {
+		next := c.apply(conf, first)
+		for _, mv := range c.moves(next) {
+			if mv.sync == second.sync && mv.from == second.from && mv.to == second.to {
+				return c.apply(next, mv), true
+			}
+		}
+		return nil, false
+	}
+	c1, ok1 := after(m1, m2)
+	c2, ok2 := after(m2, m1)
+	return ok1 && ok2 && c1.key() == c2.key()
+}
+
+// checkRepresentability finds local transitions (of reachable local states of
+// non-idle machines) which are never fired in the STS.
+func (c *checker) checkRepresentability() {
+	for i, m := range c.machines {
+		if c.idle[m.ID] {
+			continue
+		}
+		reached := make(map[*cfsm.State]bool)
+		for _, n := range c.nodes {
+			reached[n.conf[i]] = true
+		}
+		for _, s := range m.States() {
+			if !reached[s] {
+				continue
+			}
+			for _, t := range s.Transitions() {
+				if !c.fired[s][t.Label()] {
+					c.result.Unrepresented = append(c.result.Unrepresented, LocalTransition{Machine: m.ID, State: s.Name(), Label: t.Label()})
+				}
+			}
+		}
+	}
+}
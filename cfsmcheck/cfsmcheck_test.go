@@ -0,0 +1,51 @@
+package cfsmcheck
+
+import (
+	"testing"
+
+	"github.com/nickng/cfsm"
+)
+
+// newSystem returns a system of two machines, where p sends msgs to q in
+// order and q receives rcvs in order.
+func newSystem(msgs, rcvs []string) *cfsm.System {
+	sys := cfsm.NewSystem()
+	p, q := sys.NewMachine(), sys.NewMachine()
+	chain := func(m, peer *cfsm.CFSM, labels []string, send bool) {
+		s := m.NewState()
+		m.Start = s
+		for _, l := range labels {
+			next := m.NewState()
+			if send {
+				tr := cfsm.NewSend(peer, l)
+				tr.SetNext(next)
+				s.AddTransition(tr)
+			} else {
+				tr := cfsm.NewRecv(peer, l)
+				tr.SetNext(next)
+				s.AddTransition(tr)
+			}
+			s = next
+		}
+	}
+	chain(p, q, msgs, true)
+	chain(q, p, rcvs, false)
+	return sys
+}
+
+func TestCheckGMC(t *testing.T) {
+	res := Check(newSystem([]string{"a", "b"}, []string{"a", "b"}), Options{})
+	if !res.Complete || !res.GMC() {
+		t.Errorf("expecting GMC system, got %+v", res)
+	}
+}
+
+func TestCheckStuck(t *testing.T) {
+	res := Check(newSystem([]string{"a", "b"}, []string{"a", "c"}), Options{})
+	if res.GMC() || len(res.Stuck) != 1 || res.Representable() {
+		t.Fatalf("expecting stuck and unrepresentable system, got %+v", res)
+	}
+	if trace := res.Stuck[0].Trace; len(trace) != 1 || trace[0].Msg != "a" {
+		t.Errorf("expecting stuck after a, got %v", trace)
+	}
+}
@@ -0,0 +1,70 @@
+package cfsmextract
+
+import (
+	"go/token"
+
+	"github.com/damifur/dingo-hunter/ssabuilder"
+	"golang.org/x/tools/go/ssa"
+)
+
+// partial records err as a reason of the session being partial, with a
+// warning at pos in function fn.
+func (extract *CFSMExtract) partial(fn *ssa.Function, pos token.Pos, err error) {
+	if extract.Partial.Add(err) {
+		if pos == token.NoPos && fn != nil {
+			pos = fn.Pos()
+		}
+		extract.warn(fn, pos, "partial result: %v", err)
+	}
+}
+
+// cancelled returns true if the analysis is cancelled or out of time.
+func (extract *CFSMExtract) cancelled(fn *ssa.Function) bool {
+	if extract.ctx == nil {
+		return false
+	}
+	if err := extract.ctx.Err(); err != nil {
+		extract.partial(fn, token.NoPos, err)
+		return true
+	}
+	return false
+}
+
+// enterBlock counts a visit of blk in fr, returns false if the analysis should
+// not continue with blk.
+func (fr *frame) enterBlock(blk *ssa.BasicBlock) bool {
+	extract := fr.env.extract
+	if extract.cancelled(blk.Parent()) {
+		return false
+	}
+	fr.blocks++
+	if max := extract.Budget.MaxBlocks; max > 0 && fr.blocks > max {
+		extract.partial(blk.Parent(), token.NoPos, &ssabuilder.BudgetError{Budget: "max-blocks", Limit: max})
+		return false
+	}
+	return true
+}
+
+// enterCall counts an instance of callee, returns false if the instances of
+// the function are out of budget and the call should not be visited.
+func (caller *frame) enterCall(callee *frame, pos token.Pos) bool {
+	extract := caller.env.extract
+	if max := extract.Budget.MaxInstances; max > 0 && caller.env.instances[callee.fn] >= max {
+		extract.partial(caller.fn, pos, &ssabuilder.BudgetError{Budget: "max-instances", Limit: max})
+		return false
+	}
+	caller.env.instances[callee.fn]++
+	return true
+}
+
+// enterGo counts a queued goroutine, returns false if the goroutines are out
+// of budget and the goroutine should not be queued.
+func (caller *frame) enterGo(pos token.Pos) bool {
+	extract := caller.env.extract
+	if max := extract.Budget.MaxGoroutines; max > 0 && extract.queued >= max {
+		extract.partial(caller.fn, pos, &ssabuilder.BudgetError{Budget: "max-goroutines", Limit: max})
+		return false
+	}
+	extract.queued++
+	return true
+}
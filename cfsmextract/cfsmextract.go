@@ -5,26 +5,58 @@ package cfsmextract
 //  - Set up session variables
 
 import (
+	"context"
 	"fmt"
+	"go/token"
 	"go/types"
-	"log"
+	"io"
 	"os"
 	"time"
 
 	"github.com/damifur/dingo-hunter/cfsmextract/sesstype"
 	"github.com/damifur/dingo-hunter/cfsmextract/utils"
+	"github.com/damifur/dingo-hunter/diagnostics"
 	"github.com/damifur/dingo-hunter/ssabuilder"
+	"github.com/damifur/dingo-hunter/stubspec"
 	"golang.org/x/tools/go/ssa"
 )
 
 type CFSMExtract struct {
 	SSA   *ssabuilder.SSAInfo
+	Root  *ssa.Function // Analysis root (main.main if nil).
 	Time  time.Duration
 	Done  chan struct{}
 	Error chan error
 
+	Output      io.Writer                // Output for results (default: stdout).
+	Log         io.Writer                // Output for analysis logs (default: stderr).
+	Diagnostics []diagnostics.Diagnostic // Results as diagnostics.
+	CFSMPath    string                   // Path of CFSMs written by WriteOutput.
+	NumChans    int                      // Number of channel CFSMs written.
+	CFSMs       *sesstype.CFSMs          // CFSMs written by WriteOutput.
+
+	Skipped []*ssabuilder.UnsupportedError // Functions skipped in analysis.
+
+	// Budget is the resource budget of the analysis. If any budget is
+	// exhausted (or the analysis is cancelled), the reasons are recorded in
+	// Partial and the session is partial.
+	Budget  ssabuilder.Budget
+	Partial ssabuilder.Partial
+
+	Jobs int // Goroutines analysed concurrently (see runQueue).
+
+	// BufferPolicy is the buffer size of channels created with sizes which
+	// cannot be resolved by constant propagation.
+	BufferPolicy ssabuilder.BufferPolicy
+
+	// Stubs are the stub specifications of functions which are not analysed
+	// (nil if none).
+	Stubs *stubspec.Spec
+
+	ctx     context.Context // Context of the running analysis.
 	session *sesstype.Session
 	goQueue []*frame
+	queued  int // Number of goroutines queued.
 	prefix  string
 	outdir  string
 }
@@ -32,8 +64,11 @@ type CFSMExtract struct {
 func New(ssainfo *ssabuilder.SSAInfo, prefix, outdir string) *CFSMExtract {
 	return &CFSMExtract{
 		SSA:   ssainfo,
-		Done:  make(chan struct{}),
-		Error: make(chan error),
+		Done:  make(chan struct{}, 1),
+		Error: make(chan error, 1),
+
+		Output: os.Stdout,
+		Log:    os.Stderr,
 
 		session: sesstype.CreateSession(),
 		goQueue: []*frame{},
@@ -42,17 +77,36 @@ func New(ssainfo *ssabuilder.SSAInfo, prefix, outdir string) *CFSMExtract {
 	}
 }
 
-// Run function analyses main.main() then all the goroutines collected, and
-// finally output the analysis results.
+// Run function analyses main.main() (or Root if set) then all the goroutines
+// collected, and finally output the analysis results.
 func (extract *CFSMExtract) Run() {
+	extract.RunContext(context.Background())
+}
+
+// RunContext analyses the program like Run until ctx is done or the Budget
+// runs out, in which case the analysis stops early and the session is partial.
+func (extract *CFSMExtract) RunContext(ctx context.Context) {
+	ctx, cancel := extract.Budget.Context(ctx)
+	defer cancel()
+	extract.ctx = ctx
+	extract.run()
+}
+
+func (extract *CFSMExtract) run() {
 	startTime := time.Now()
-	mainPkg := ssabuilder.MainPkg(extract.SSA.Prog)
-	if mainPkg == nil {
-		fmt.Fprintf(os.Stderr, "Error: 'main' package not found\n")
-		os.Exit(1)
+	var init, main *ssa.Function
+	if extract.Root != nil {
+		init = extract.Root.Pkg.Func("init")
+		main = extract.Root
+	} else {
+		mainPkg := ssabuilder.MainPkg(extract.SSA.Prog)
+		if mainPkg == nil {
+			extract.Error <- ErrNoMainPkg
+			return
+		}
+		init = mainPkg.Func("init")
+		main = mainPkg.Func("main")
 	}
-	init := mainPkg.Func("init")
-	main := mainPkg.Func("main")
 	fr := makeToplevelFrame(extract)
 	for _, pkg := range extract.SSA.Prog.AllPackages() {
 		for _, memb := range pkg.Members {
@@ -60,84 +114,132 @@ func (extract *CFSMExtract) Run() {
 			case *ssa.Global:
 				switch derefAll(val.Type()).(type) {
 				case *types.Array:
-					vd := utils.NewDef(val)
+					vd := fr.env.vers.NewDef(val)
 					fr.env.globals[val] = vd
 					fr.env.arrays[vd] = make(Elems)
 
 				case *types.Struct:
-					vd := utils.NewDef(val)
+					vd := fr.env.vers.NewDef(val)
 					fr.env.globals[val] = vd
 					fr.env.structs[vd] = make(Fields)
 
 				case *types.Chan:
 					var c *types.Chan
-					vd := utils.NewDef(utils.EmptyValue{T: c})
+					vd := fr.env.vers.NewDef(utils.EmptyValue{T: c})
 					fr.env.globals[val] = vd
 
 				default:
-					fr.env.globals[val] = utils.NewDef(val)
+					fr.env.globals[val] = fr.env.vers.NewDef(val)
 				}
 			}
 		}
 	}
 
-	fmt.Fprintf(os.Stderr, "++ call.toplevel %s()\n", orange("init"))
+	fmt.Fprintf(extract.Log, "++ call.toplevel %s()\n", orange("init"))
 	visitFunc(init, fr)
 	if main == nil {
-		fmt.Fprintf(os.Stderr, "Error: 'main()' function not found in 'main' package\n")
-		os.Exit(1)
+		extract.Error <- ErrNoMainFunc
+		return
+	}
+	if extract.Root != nil {
+		makeRootChans(extract.Root, fr)
 	}
-	fmt.Fprintf(os.Stderr, "++ call.toplevel %s()\n", orange("main"))
+	fmt.Fprintf(extract.Log, "++ call.toplevel %s()\n", orange(main.Name()))
+	fr.blocks = 0 // init and main share the toplevel frame.
 	visitFunc(main, fr)
 
 	fr.env.session.Types[fr.gortn.role] = fr.gortn.root
 
-	var goFrm *frame
-	for len(extract.goQueue) > 0 {
-		goFrm, extract.goQueue = extract.goQueue[0], extract.goQueue[1:]
-		fmt.Fprintf(os.Stderr, "\n%s\nLOCATION: %s%s\n", goFrm.fn.Name(), goFrm.gortn.role.Name(), loc(goFrm, goFrm.fn.Pos()))
-		visitFunc(goFrm.fn, goFrm)
-		goFrm.env.session.Types[goFrm.gortn.role] = goFrm.gortn.root
-	}
+	extract.runQueue(fr.env)
 
 	extract.Time = time.Since(startTime)
 	extract.Done <- struct{}{}
 }
 
+// makeRootChans creates fresh channels for the channel parameters of the root
+// function, as the root has no caller to supply them.
+func makeRootChans(root *ssa.Function, fr *frame) {
+	for _, param := range root.Params {
+		if _, ok := param.Type().Underlying().(*types.Chan); !ok {
+			continue
+		}
+		vd := fr.env.vers.NewDef(param)
+		ch := fr.env.session.MakeChan(vd, fr.gortn.role)
+		fr.env.chans[vd] = &ch
+		fr.gortn.AddNode(sesstype.NewNewChanNode(ch))
+		fr.locals[param] = vd
+		fmt.Fprintf(fr.env.extract.Log, "   New channel %s { type: %s } for root parameter %s\n", green(ch.Name()), ch.Type(), param.Name())
+	}
+}
+
+// warn records a warning diagnostic at pos in function fn.
+func (extract *CFSMExtract) warn(fn *ssa.Function, pos token.Pos, format string, args ...interface{}) {
+	d := diagnostics.New(diagnostics.Warning, "cfsms", extract.SSA.FSet.Position(pos), format, args...)
+	if fn != nil {
+		d.Function = fn.String()
+	}
+	extract.Diagnostics = append(extract.Diagnostics, d)
+	fmt.Fprintf(extract.Log, "   Warning: %s\n", fmt.Sprintf(format, args...))
+}
+
+// skip records a function skipped on an unsupported construct.
+func (extract *CFSMExtract) skip(err *ssabuilder.UnsupportedError) {
+	extract.Skipped = append(extract.Skipped, err)
+	d := diagnostics.New(diagnostics.Warning, "cfsms", err.Pos, "skipped: unsupported: %s", err.Reason)
+	if err.Func != nil {
+		d.Function = err.Func.String()
+	}
+	extract.Diagnostics = append(extract.Diagnostics, d)
+	fmt.Fprintf(extract.Log, "   Skipped: %v\n", err)
+}
+
 // Session returns the session after extraction.
 func (extract *CFSMExtract) Session() *sesstype.Session {
 	return extract.session
 }
 
-func (extract *CFSMExtract) WriteOutput() {
-	fmt.Printf(" ----- Results ----- \n%s\n", extract.session.String())
+// WriteOutput writes the results of the analysis: the session types, a dot
+// graph and the CFSMs files.
+func (extract *CFSMExtract) WriteOutput() error {
+	fmt.Fprintf(extract.Output, " ----- Results ----- \n%s\n", extract.session.String())
 
-	sesstype.PrintNodeSummary(extract.session)
+	sesstype.WriteNodeSummary(extract.Output, extract.session)
 
 	dotFile, err := os.OpenFile(fmt.Sprintf("%s.dot", extract.prefix), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer dotFile.Close()
 
 	dot := sesstype.NewGraphvizDot(extract.session)
 	if _, err = dot.WriteTo(dotFile); err != nil {
-		panic(err)
+		return err
 	}
 
-	os.MkdirAll(extract.outdir, 0750)
+	if err := os.MkdirAll(extract.outdir, 0750); err != nil {
+		return err
+	}
 	cfsmPath := fmt.Sprintf("%s/%s_cfsms", extract.outdir, extract.prefix)
 	cfsmFile, err := os.OpenFile(cfsmPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer cfsmFile.Close()
 
 	cfsms := sesstype.NewCFSMs(extract.session)
 	if _, err := cfsms.WriteTo(cfsmFile); err != nil {
-		log.Fatalf("Cannot write CFSMs to file: %v", err)
+		return fmt.Errorf("cannot write CFSMs to file: %v", err)
 	}
 
-	fmt.Fprintf(os.Stderr, "CFSMs written to %s\n", cfsmPath)
-	cfsms.PrintSummary()
+	fmt.Fprintf(extract.Log, "CFSMs written to %s\n", cfsmPath)
+	cfsms.WriteSummary(extract.Output)
+	extract.CFSMPath, extract.NumChans, extract.CFSMs = cfsmPath, len(cfsms.Chans), cfsms
+
+	d := diagnostics.New(diagnostics.Info, "cfsms", token.Position{}, "%d CFSMs (%d are channels) written to %s",
+		len(cfsms.Roles)+len(cfsms.Chans), len(cfsms.Chans), cfsmPath)
+	if extract.Root != nil {
+		d.Function = extract.Root.String()
+	}
+	extract.Diagnostics = append(extract.Diagnostics, d)
+	return nil
 }
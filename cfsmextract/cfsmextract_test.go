@@ -0,0 +1,99 @@
+package cfsmextract
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/damifur/dingo-hunter/cfsmcheck"
+	"github.com/damifur/dingo-hunter/cfsmextract/sesstype"
+	"github.com/damifur/dingo-hunter/ssabuilder"
+	"github.com/nickng/cfsm"
+)
+
+// build returns the SSA IR of main package src, where dynamic calls are
+// resolved by dispatch.
+func build(t *testing.T, src string, dispatch ssabuilder.Dispatch) *ssabuilder.SSAInfo {
+	conf, err := ssabuilder.NewConfigFromString(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Dispatch = dispatch
+	info, err := conf.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+// run extracts the session of main.main, and waits for the extraction.
+func run(t *testing.T, extract *CFSMExtract) *sesstype.Session {
+	extract.Output, extract.Log = ioutil.Discard, ioutil.Discard
+	go extract.Run()
+	select {
+	case err := <-extract.Error:
+		t.Fatal(err)
+	case <-extract.Done:
+	}
+	return extract.Session()
+}
+
+// stuck returns the stuck configurations of the CFSMs of session, where the
+// channel machines are idle.
+func stuck(session *sesstype.Session) []cfsmcheck.Config {
+	cfsms := sesstype.NewCFSMs(session)
+	var idle []*cfsm.CFSM
+	for _, m := range cfsms.Chans {
+		idle = append(idle, m)
+	}
+	return cfsmcheck.Check(cfsms.Sys, cfsmcheck.Options{Idle: idle}).Stuck
+}
+
+// nodes returns the session type of role name in session, each node as its
+// kind and channel, and the branches of a node in braces.
+func nodes(t *testing.T, session *sesstype.Session, name string) string {
+	role, ok := session.Roles[name]
+	if !ok {
+		t.Fatalf("expecting role %s, got:\n%s", name, session)
+	}
+	return nodeString(session.Types[role])
+}
+
+// nodeString returns node and its children as their kind and channel.
+func nodeString(node sesstype.Node) string {
+	var s string
+	switch node := node.(type) {
+	case *sesstype.NewChanNode:
+		s = "newchan " + node.Chan().Name()
+	case *sesstype.SendNode:
+		s = "send " + node.To().Name()
+	case *sesstype.RecvNode:
+		s = "recv " + node.From().Name()
+		if node.Stop() {
+			s = "recv STOP " + node.From().Name()
+		}
+	case *sesstype.EndNode:
+		s = "close " + node.Chan().Name()
+	case *sesstype.LabelNode:
+		s = "label"
+	case *sesstype.GotoNode:
+		s = "goto"
+	case *sesstype.EmptyBodyNode:
+		s = "empty"
+	default:
+		s = fmt.Sprintf("%T", node)
+	}
+	switch children := node.Children(); len(children) {
+	case 0:
+		return s
+	case 1:
+		return s + "; " + nodeString(children[0])
+	default:
+		branches := make([]string, len(children))
+		for i, c := range children {
+			branches[i] = nodeString(c)
+		}
+		return s + "; { " + strings.Join(branches, " | ") + " }"
+	}
+}
@@ -0,0 +1,26 @@
+package cfsmextract
+
+// Functions for handling package context.
+//
+// The context package is not built (see ssabuilder), and the cancellation of
+// contexts is not modelled in CFSMs (unlike in MiGo types, see migoextract):
+// the channel of ctx.Done() is an external channel, which no role sends to or
+// closes. A goroutine waiting only for cancellation is then stuck, so a
+// warning is reported at each call of Done.
+
+import (
+	"go/types"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// warnContextDone reports a warning if common is a call of Done of a
+// context.Context, whose channel is not modelled.
+func (caller *frame) warnContextDone(common *ssa.CallCommon) {
+	if !common.IsInvoke() || common.Method.Name() != "Done" {
+		return
+	}
+	if named, ok := common.Value.Type().(*types.Named); ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == "context" {
+		caller.env.extract.warn(caller.fn, common.Pos(), "cancellation of %s is not modelled in CFSMs (Done is an external channel)", common.Value.Name())
+	}
+}
@@ -0,0 +1,31 @@
+package cfsmextract
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/damifur/dingo-hunter/ssabuilder"
+)
+
+// TestContextDone checks that the channel of ctx.Done(), which is not
+// modelled, is reported.
+func TestContextDone(t *testing.T) {
+	const src = `package main
+
+import "context"
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	go cancel()
+	<-ctx.Done()
+}
+`
+	extract := New(build(t, src, ssabuilder.StaticDispatch), "", "")
+	run(t, extract)
+	for _, d := range extract.Diagnostics {
+		if strings.Contains(d.Message, "Done is an external channel") {
+			return
+		}
+	}
+	t.Errorf("expecting warning of ctx.Done(), got %v", extract.Diagnostics)
+}
@@ -0,0 +1,92 @@
+package cfsmextract
+
+// Functions for handling dynamic calls (through interfaces and function
+// values).
+//
+// The callees of a dynamic call are resolved by the call graph of the SSA
+// program (see ssabuilder.Dispatch). A call with several callees is a branch
+// of the session per callee, the branches are joined (Goto to a Label on the
+// first branch) before the caller continues, and the branch of a callee which
+// does no communication joins them without transition. A go statement with
+// several callees spawns a goroutine whose session is a branch per callee.
+
+import (
+	"fmt"
+
+	"github.com/damifur/dingo-hunter/cfsmextract/sesstype"
+	"golang.org/x/tools/go/ssa"
+)
+
+// dynamicCallees returns the callees of dynamic call site, with the arguments
+// of the call (the receiver first if site is an invocation).
+func (caller *frame) dynamicCallees(site ssa.CallInstruction) ([]*ssa.Function, *ssa.CallCommon) {
+	common := site.Common()
+	fns := caller.env.extract.SSA.Callees(site)
+	if len(fns) == 0 {
+		fmt.Fprintf(caller.env.extract.Log, "     ^ no callee found for %s\n", common.String())
+		return nil, nil
+	}
+	for _, fn := range fns {
+		fmt.Fprintf(caller.env.extract.Log, "     ^ dynamic callee %s\n", fn.String())
+	}
+	if !common.IsInvoke() {
+		return fns, common
+	}
+	invoke := *common
+	invoke.Args = append([]ssa.Value{common.Value}, common.Args...)
+	return fns, &invoke
+}
+
+// callDynamic calls the callees of dynamic call, each in a branch of the
+// session. The results are those of the callee if there is only one.
+func (caller *frame) callDynamic(call *ssa.Call) {
+	fns, common := caller.dynamicCallees(call)
+	switch len(fns) {
+	case 0:
+		return
+	case 1:
+		caller.callFunc(call, fns[0], common)
+		return
+	}
+	parent := *caller.gortn.leaf
+	pending := caller.env.pendingCounts()
+	leaves := make([]*sesstype.Node, len(fns))
+	for i, fn := range fns {
+		caller.env.restorePending(pending)
+		branch := parent
+		caller.gortn.leaf = &branch
+		caller.gortn.AddNode(&sesstype.EmptyBodyNode{})
+		caller.callFunc(call, fn, common)
+		leaves[i] = caller.gortn.leaf
+	}
+	caller.gortn.labels++
+	label := fmt.Sprintf("%s_%d_dispatch_%d", caller.gortn.role.Name(), int(call.Pos()), caller.gortn.labels)
+	caller.gortn.leaf = caller.gortn.join(leaves, label)
+
+	// The results depend on the callee, so they are external.
+	delete(caller.locals, call.Value())
+	delete(caller.tuples, call.Value())
+	caller.handleExtRetvals(call.Value(), &frame{fn: fns[0]})
+}
+
+// visitGo visits the callee of goroutine goFrm, or each callee in a branch of
+// the goroutine if the go statement has several callees.
+func visitGo(goFrm *frame) {
+	if len(goFrm.alts) == 0 {
+		visitFunc(goFrm.fn, goFrm)
+		return
+	}
+	root := goFrm.gortn.root
+	for _, fr := range goFrm.goFrames() {
+		branch := root
+		fr.gortn.leaf = &branch
+		fr.gortn.AddNode(&sesstype.EmptyBodyNode{})
+		visitFunc(fr.fn, fr)
+	}
+}
+
+// goFrames returns the frames of goroutine goFrm, one per callee of the go
+// statement.
+func (goFrm *frame) goFrames() []*frame {
+	return append([]*frame{goFrm}, goFrm.alts...)
+}
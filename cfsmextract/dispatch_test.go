@@ -0,0 +1,118 @@
+package cfsmextract
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/damifur/dingo-hunter/ssabuilder"
+)
+
+// TestDynamicCallees checks that every callee of a dynamic call (or go
+// statement) is followed, in a branch of the session.
+func TestDynamicCallees(t *testing.T) {
+	const src = `package main
+
+var flag bool
+
+func one(ch chan int) { ch <- 1 }
+func two(ch chan int) { ch <- 2 }
+
+func relay(f func(chan int), ch chan int) {
+	f(ch)
+	ch <- 3
+}
+
+func main() {
+	ch := make(chan int)
+	f := one
+	if flag {
+		f = two
+	}
+	%s
+}
+`
+	for _, tc := range []struct {
+		body string
+		role string
+		want string
+	}{
+		{"go f(ch); <-ch", "t2_233", "label; { empty; send main.main.t0@0 | empty; send main.main.t0@0 }"},
+		{"go relay(f, ch); <-ch; <-ch", "relay_233", "label; { empty; send main.main.t0@0; label; send main.main.t0@0 | empty; send main.main.t0@0; goto }"},
+	} {
+		extract := New(build(t, fmt.Sprintf(src, tc.body), ssabuilder.VTADispatch), "", "")
+		if got := nodes(t, run(t, extract), tc.role); got != tc.want {
+			t.Errorf("%s: expecting %s %q, got %q", tc.body, tc.role, tc.want, got)
+		}
+	}
+}
+
+// TestDispatch checks that the callees of a call through a function value are
+// only followed if resolved by the dispatch.
+func TestDispatch(t *testing.T) {
+	const src = `package main
+
+type job struct{ f func(chan int) }
+
+func send(ch chan int) { ch <- 1 }
+
+func main() {
+	ch := make(chan int)
+	j := job{f: send}
+	go j.f(ch)
+	<-ch
+}
+`
+	for _, tc := range []struct {
+		dispatch ssabuilder.Dispatch
+		spawned  bool
+	}{
+		{ssabuilder.StaticDispatch, false},
+		{ssabuilder.CHADispatch, true},
+		{ssabuilder.VTADispatch, true},
+	} {
+		extract := New(build(t, src, tc.dispatch), "", "")
+		session := run(t, extract)
+		if got, want := nodes(t, session, "main"), "label; newchan main.main.t0@0; recv main.main.t0@0"; got != want {
+			t.Errorf("%s: expecting main %q, got %q", tc.dispatch, want, got)
+		}
+		if _, ok := session.Roles["t4_144"]; ok != tc.spawned {
+			t.Errorf("%s: expecting spawned=%t, got roles %v", tc.dispatch, tc.spawned, session.Roles)
+		} else if ok {
+			if got, want := nodes(t, session, "t4_144"), "label; send main.main.t0@0"; got != want {
+				t.Errorf("%s: expecting t4_144 %q, got %q", tc.dispatch, want, got)
+			}
+		}
+	}
+}
+
+// TestDynamicCalleesLive checks that the sends of every callee of a dynamic
+// call are received.
+func TestDynamicCalleesLive(t *testing.T) {
+	const src = `package main
+
+var flag bool
+
+func one(ch chan int) { ch <- 1 }
+func two(ch chan int) { ch <- 2 }
+
+func relay(f func(chan int), ch chan int) {
+	f(ch)
+	ch <- 3
+}
+
+func main() {
+	ch := make(chan int)
+	f := one
+	if flag {
+		f = two
+	}
+	go relay(f, ch)
+	<-ch
+	<-ch
+}
+`
+	extract := New(build(t, src, ssabuilder.VTADispatch), "", "")
+	if s := stuck(run(t, extract)); len(s) != 0 {
+		t.Errorf("expecting live CFSMs, got stuck %v", s)
+	}
+}
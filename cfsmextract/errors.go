@@ -0,0 +1,10 @@
+package cfsmextract
+
+// Predefined errors
+
+import "errors"
+
+var (
+	ErrNoMainPkg  = errors.New("'main' package not found")
+	ErrNoMainFunc = errors.New("'main()' function not found in 'main' package")
+)
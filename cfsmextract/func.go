@@ -2,11 +2,12 @@ package cfsmextract
 
 import (
 	"fmt"
+	"go/constant"
 	"go/types"
-	"os"
 
 	"github.com/damifur/dingo-hunter/cfsmextract/sesstype"
 	"github.com/damifur/dingo-hunter/cfsmextract/utils"
+	"github.com/damifur/dingo-hunter/ssabuilder"
 	"golang.org/x/tools/go/ssa"
 )
 
@@ -50,19 +51,24 @@ type frame struct {
 	caller  *frame                          // Ptr to caller's frame, nil if main/ext
 	env     *environ                        // Environment
 	gortn   *goroutine                      // Current goroutine
+	blocks  int                             // Number of blocks visited
+	alts    []*frame                        // Frames of the other callees of a go statement
+	common  *ssa.CallCommon                 // Call of the function, nil if main/ext
 }
 
 // Environment: Variables/info available globally for all goroutines
 type environ struct {
-	session  *sesstype.Session
-	extract  *CFSMExtract
-	globals  map[ssa.Value]*utils.Definition      // Globals
-	arrays   map[*utils.Definition]Elems          // Array elements
-	structs  map[*utils.Definition]Fields         // Struct fields
-	chans    map[*utils.Definition]*sesstype.Chan // Channels
-	extern   map[ssa.Value]types.Type             // Values that originates externally, we are only sure of its type
-	closures map[ssa.Value]Captures               // Closure captures
-	selNode  map[ssa.Value]struct {               // Parent nodes of select
+	session    *sesstype.Session
+	extract    *CFSMExtract
+	globals    map[ssa.Value]*utils.Definition      // Globals
+	arrays     map[*utils.Definition]Elems          // Array elements
+	structs    map[*utils.Definition]Fields         // Struct fields
+	chans      map[*utils.Definition]*sesstype.Chan // Channels
+	waitGroups map[*utils.Definition]*waitGroup     // Channels of WaitGroups
+	timers     map[*utils.Definition]*sesstype.Chan // Channels of Timers and Tickers
+	extern     map[ssa.Value]types.Type             // Values that originates externally, we are only sure of its type
+	closures   map[ssa.Value]Captures               // Closure captures
+	selNode    map[ssa.Value]struct {               // Parent nodes of select
 		parent   *sesstype.Node
 		blocking bool
 	}
@@ -71,15 +77,17 @@ type environ struct {
 		idx int       // The index of the branch
 		tpl ssa.Value // The SelectState tuple which the branch originates from
 	}
-	recvTest map[ssa.Value]*sesstype.Chan // Receive test
-	ifparent *sesstype.NodeStack
+	recvTest  map[ssa.Value]*sesstype.Chan // Receive test
+	ifparent  *sesstype.NodeStack
+	vers      utils.Versions        // Versions of variable definitions
+	instances map[*ssa.Function]int // Number of instances of functions
 }
 
 func (env *environ) GetSessionChan(vd *utils.Definition) *sesstype.Chan {
 	if ch, ok := env.session.Chans[vd]; ok {
 		return &ch
 	}
-	panic(fmt.Sprintf("Channel %s undefined in session", vd.String()))
+	panic(ssabuilder.NewUnsupportedError(nil, nil, "Channel %s undefined in session", vd.String()))
 }
 
 func makeToplevelFrame(extract *CFSMExtract) *frame {
@@ -95,14 +103,16 @@ func makeToplevelFrame(extract *CFSMExtract) *frame {
 		defers:  make([]*ssa.Defer, 0),
 		caller:  nil,
 		env: &environ{
-			session:  extract.session,
-			extract:  extract,
-			globals:  make(map[ssa.Value]*utils.Definition),
-			arrays:   make(map[*utils.Definition]Elems),
-			structs:  make(map[*utils.Definition]Fields),
-			chans:    make(map[*utils.Definition]*sesstype.Chan),
-			extern:   make(map[ssa.Value]types.Type),
-			closures: make(map[ssa.Value]Captures),
+			session:    extract.session,
+			extract:    extract,
+			globals:    make(map[ssa.Value]*utils.Definition),
+			arrays:     make(map[*utils.Definition]Elems),
+			structs:    make(map[*utils.Definition]Fields),
+			chans:      make(map[*utils.Definition]*sesstype.Chan),
+			waitGroups: make(map[*utils.Definition]*waitGroup),
+			timers:     make(map[*utils.Definition]*sesstype.Chan),
+			extern:     make(map[ssa.Value]types.Type),
+			closures:   make(map[ssa.Value]Captures),
 			selNode: make(map[ssa.Value]struct {
 				parent   *sesstype.Node
 				blocking bool
@@ -112,8 +122,10 @@ func makeToplevelFrame(extract *CFSMExtract) *frame {
 				idx int
 				tpl ssa.Value
 			}),
-			recvTest: make(map[ssa.Value]*sesstype.Chan),
-			ifparent: sesstype.NewNodeStack(),
+			recvTest:  make(map[ssa.Value]*sesstype.Chan),
+			ifparent:  sesstype.NewNodeStack(),
+			vers:      make(utils.Versions),
+			instances: make(map[*ssa.Function]int),
 		},
 		gortn: &goroutine{
 			role:    extract.session.GetRole("main"),
@@ -132,24 +144,24 @@ func (caller *frame) callBuiltin(common *ssa.CallCommon) {
 	if builtin.Name() == "close" {
 		if len(common.Args) == 1 {
 			if ch, ok := caller.env.chans[caller.locals[common.Args[0]]]; ok {
-				fmt.Fprintf(os.Stderr, "++ call builtin %s(%s channel %s)\n", orange(builtin.Name()), green(common.Args[0].Name()), ch.Name())
+				fmt.Fprintf(caller.env.extract.Log, "++ call builtin %s(%s channel %s)\n", orange(builtin.Name()), green(common.Args[0].Name()), ch.Name())
 				visitClose(*ch, caller)
 			} else {
-				panic("Builtin close() called with non-channel\n")
+				caller.unsupported(nil, "Builtin close() called with non-channel")
 			}
 		}
 	} else if builtin.Name() == "copy" {
 		dst := common.Args[0]
 		src := common.Args[1]
-		fmt.Fprintf(os.Stderr, "++ call builtin %s(%s <- %s)\n", orange("copy"), dst.Name(), src.Name())
+		fmt.Fprintf(caller.env.extract.Log, "++ call builtin %s(%s <- %s)\n", orange("copy"), dst.Name(), src.Name())
 		caller.locals[dst] = caller.locals[src]
 		return
 	} else {
-		fmt.Fprintf(os.Stderr, "++ call builtin %s(", builtin.Name())
+		fmt.Fprintf(caller.env.extract.Log, "++ call builtin %s(", builtin.Name())
 		for _, arg := range common.Args {
-			fmt.Fprintf(os.Stderr, "%s", arg.Name())
+			fmt.Fprintf(caller.env.extract.Log, "%s", arg.Name())
 		}
-		fmt.Fprintf(os.Stderr, ") # TODO (handle builtin)\n")
+		fmt.Fprintf(caller.env.extract.Log, ") # TODO (handle builtin)\n")
 	}
 }
 
@@ -164,122 +176,60 @@ func (caller *frame) callCommon(call *ssa.Call, common *ssa.CallCommon) {
 
 	case *ssa.MakeClosure:
 		// TODO(nickng) Handle calling closure
-		fmt.Fprintf(os.Stderr, "   # TODO (handle closure) %s\n", fn.String())
+		fmt.Fprintf(caller.env.extract.Log, "   # TODO (handle closure) %s\n", fn.String())
 
 	case *ssa.Function:
 		if common.StaticCallee() == nil {
-			panic("Call with nil CallCommon!")
+			caller.unsupported(nil, "Call with nil CallCommon!")
 		}
-
-		callee := &frame{
-			fn:      common.StaticCallee(),
-			locals:  make(map[ssa.Value]*utils.Definition),
-			arrays:  make(map[*utils.Definition]Elems),
-			structs: make(map[*utils.Definition]Fields),
-			tuples:  make(map[ssa.Value]Tuples),
-			phi:     make(map[ssa.Value][]ssa.Value),
-			recvok:  make(map[ssa.Value]*sesstype.Chan),
-			retvals: make(Tuples, common.Signature().Results().Len()),
-			defers:  make([]*ssa.Defer, 0),
-			caller:  caller,
-			env:     caller.env,   // Use the same env as caller
-			gortn:   caller.gortn, // Use the same role as caller
+		if caller.callSync(common) || caller.callTimer(call, common) || caller.callSignal(common) || caller.callStub(call, common, false, common.Pos()) {
+			return
 		}
 
-		fmt.Fprintf(os.Stderr, "++ call %s(", orange(common.StaticCallee().String()))
-		callee.translate(common)
-		fmt.Fprintf(os.Stderr, ")\n")
-
-		if callee.isRecursive() {
-			fmt.Fprintf(os.Stderr, "-- Recursive %s()\n", orange(common.StaticCallee().String()))
-			callee.printCallStack()
-		} else {
-			if hasCode := visitFunc(callee.fn, callee); hasCode {
-				caller.handleRetvals(call.Value(), callee)
-			} else {
-				caller.handleExtRetvals(call.Value(), callee)
-			}
-			fmt.Fprintf(os.Stderr, "-- return from %s (%d retvals)\n", orange(common.StaticCallee().String()), len(callee.retvals))
-		}
+		caller.callFunc(call, common.StaticCallee(), common)
 
 	default:
 		if !common.IsInvoke() {
-			fmt.Fprintf(os.Stderr, "Unknown call type %v\n", common)
+			caller.callDynamic(call)
 			return
 		}
+		caller.warnContextDone(common)
 
 		switch vd, kind := caller.get(common.Value); kind {
 		case Struct, LocalStruct:
-			fmt.Fprintf(os.Stderr, "++ invoke %s.%s, type=%s\n", reg(common.Value), common.Method.String(), vd.Var.Type().String())
+			fmt.Fprintf(caller.env.extract.Log, "++ invoke %s.%s, type=%s\n", reg(common.Value), common.Method.String(), vd.Var.Type().String())
 			// If dealing with interfaces, check that the method is invokable
 			if iface, ok := common.Value.Type().Underlying().(*types.Interface); ok {
 				if meth, _ := types.MissingMethod(vd.Var.Type(), iface, true); meth != nil {
-					fmt.Fprintf(os.Stderr, "     ^ interface not fully implemented\n")
+					fmt.Fprintf(caller.env.extract.Log, "     ^ interface not fully implemented\n")
 				} else {
-					fn := findMethod(common.Value.Parent().Prog, common.Method, vd.Var.Type())
+					fn := caller.findMethod(common.Value.Parent().Prog, common.Method, vd.Var.Type())
 					if fn != nil {
-						fmt.Fprintf(os.Stderr, "     ^ found function %s\n", fn.String())
-
-						callee := &frame{
-							fn:      fn,
-							locals:  make(map[ssa.Value]*utils.Definition),
-							arrays:  make(map[*utils.Definition]Elems),
-							structs: make(map[*utils.Definition]Fields),
-							tuples:  make(map[ssa.Value]Tuples),
-							phi:     make(map[ssa.Value][]ssa.Value),
-							recvok:  make(map[ssa.Value]*sesstype.Chan),
-							retvals: make(Tuples, common.Signature().Results().Len()),
-							defers:  make([]*ssa.Defer, 0),
-							caller:  caller,
-							env:     caller.env,   // Use the same env as caller
-							gortn:   caller.gortn, // Use the same role as caller
-						}
-
-						common.Args = append([]ssa.Value{common.Value}, common.Args...)
-						fmt.Fprintf(os.Stderr, "++ call %s(", orange(fn.String()))
-						callee.translate(common)
-						fmt.Fprintf(os.Stderr, ")\n")
-
-						if callee.isRecursive() {
-							fmt.Fprintf(os.Stderr, "-- Recursive %s()\n", orange(fn.String()))
-							callee.printCallStack()
-						} else {
-							if hasCode := visitFunc(callee.fn, callee); hasCode {
-								caller.handleRetvals(call.Value(), callee)
-							} else {
-								caller.handleExtRetvals(call.Value(), callee)
-							}
-							fmt.Fprintf(os.Stderr, "-- return from %s (%d retvals)\n", orange(fn.String()), len(callee.retvals))
-						}
+						fmt.Fprintf(caller.env.extract.Log, "     ^ found function %s\n", fn.String())
 
+						invoke := *common
+						invoke.Args = append([]ssa.Value{common.Value}, common.Args...)
+						caller.callFunc(call, fn, &invoke)
 					} else {
-						panic(fmt.Sprintf("Cannot call function: %s.%s is abstract (program not well-formed)", common.Value, common.Method.String()))
+						caller.unsupported(nil, "Cannot call function: %s.%s is abstract (program not well-formed)", common.Value, common.Method.String())
 					}
 				}
 			} else {
-				fmt.Fprintf(os.Stderr, "     ^ method %s.%s does not exist\n", reg(common.Value), common.Method.String())
+				fmt.Fprintf(caller.env.extract.Log, "     ^ method %s.%s does not exist\n", reg(common.Value), common.Method.String())
 			}
 
 		default:
-			fmt.Fprintf(os.Stderr, "++ invoke %s.%s\n", reg(common.Value), common.Method.String())
+			fmt.Fprintf(caller.env.extract.Log, "++ invoke %s.%s\n", reg(common.Value), common.Method.String())
+			caller.callDynamic(call)
 		}
 	}
 }
 
-func findMethod(prog *ssa.Program, meth *types.Func, typ types.Type) *ssa.Function {
-	if meth != nil {
-		fmt.Fprintf(os.Stderr, "     ^ finding method for type: %s pkg: %s name: %s\n", typ.String(), meth.Pkg().Name(), meth.Name())
-	}
-	return prog.LookupMethod(typ, meth.Pkg(), meth.Name())
-}
-
-func (caller *frame) callGo(g *ssa.Go) {
-	common := g.Common()
-	goname := fmt.Sprintf("%s_%d", common.Value.Name(), int(g.Pos()))
-	gorole := caller.env.session.GetRole(goname)
-
+// callFunc calls fn with the arguments of common (the receiver first if fn is
+// a method), the results are stored in call.
+func (caller *frame) callFunc(call *ssa.Call, fn *ssa.Function, common *ssa.CallCommon) {
 	callee := &frame{
-		fn:      common.StaticCallee(),
+		fn:      fn,
 		locals:  make(map[ssa.Value]*utils.Definition),
 		arrays:  make(map[*utils.Definition]Elems),
 		structs: make(map[*utils.Definition]Fields),
@@ -289,25 +239,94 @@ func (caller *frame) callGo(g *ssa.Go) {
 		retvals: make(Tuples, common.Signature().Results().Len()),
 		defers:  make([]*ssa.Defer, 0),
 		caller:  caller,
-		env:     caller.env, // Use the same env as caller
-		gortn: &goroutine{
-			role:    gorole,
-			root:    sesstype.NewLabelNode(goname),
-			leaf:    nil,
-			visited: make(map[*ssa.BasicBlock]sesstype.Node),
-		},
+		env:     caller.env,   // Use the same env as caller
+		gortn:   caller.gortn, // Use the same role as caller
 	}
-	callee.gortn.leaf = &callee.gortn.root
 
-	fmt.Fprintf(os.Stderr, "@@ queue go %s(", common.StaticCallee().String())
+	fmt.Fprintf(caller.env.extract.Log, "++ call %s(", orange(fn.String()))
 	callee.translate(common)
-	fmt.Fprintf(os.Stderr, ")\n")
+	fmt.Fprintf(caller.env.extract.Log, ")\n")
+
+	if callee.isRecursive() {
+		fmt.Fprintf(caller.env.extract.Log, "-- Recursive %s()\n", orange(fn.String()))
+		callee.printCallStack()
+	} else if !caller.enterCall(callee, common.Pos()) {
+		caller.handleExtRetvals(call.Value(), callee)
+	} else {
+		if hasCode := visitFunc(callee.fn, callee); hasCode {
+			caller.handleRetvals(call.Value(), callee)
+		} else {
+			caller.handleExtRetvals(call.Value(), callee)
+		}
+		fmt.Fprintf(caller.env.extract.Log, "-- return from %s (%d retvals)\n", orange(fn.String()), len(callee.retvals))
+	}
+}
+
+func (caller *frame) findMethod(prog *ssa.Program, meth *types.Func, typ types.Type) *ssa.Function {
+	if meth != nil {
+		fmt.Fprintf(caller.env.extract.Log, "     ^ finding method for type: %s pkg: %s name: %s\n", typ.String(), meth.Pkg().Name(), meth.Name())
+	}
+	return prog.LookupMethod(typ, meth.Pkg(), meth.Name())
+}
+
+func (caller *frame) callGo(g *ssa.Go) {
+	common := g.Common()
+	if caller.callStub(nil, common, true, g.Pos()) {
+		return
+	}
+	fns := []*ssa.Function{common.StaticCallee()}
+	if fns[0] == nil {
+		if fns, common = caller.dynamicCallees(g); len(fns) == 0 {
+			return
+		}
+	}
+	if !caller.enterGo(g.Pos()) {
+		return
+	}
+	goname := fmt.Sprintf("%s_%d", common.Value.Name(), int(g.Pos()))
+	gorole := caller.env.session.GetRole(goname)
+	gortn := &goroutine{
+		role:    gorole,
+		root:    sesstype.NewLabelNode(goname),
+		leaf:    nil,
+		visited: make(map[*ssa.BasicBlock]sesstype.Node),
+	}
+	gortn.leaf = &gortn.root
+
+	var goFrm *frame
+	for _, fn := range fns {
+		callee := &frame{
+			fn:      fn,
+			locals:  make(map[ssa.Value]*utils.Definition),
+			arrays:  make(map[*utils.Definition]Elems),
+			structs: make(map[*utils.Definition]Fields),
+			tuples:  make(map[ssa.Value]Tuples),
+			phi:     make(map[ssa.Value][]ssa.Value),
+			recvok:  make(map[ssa.Value]*sesstype.Chan),
+			retvals: make(Tuples, common.Signature().Results().Len()),
+			defers:  make([]*ssa.Defer, 0),
+			caller:  caller,
+			env:     caller.env, // Use the same env as caller
+			gortn:   gortn,      // Callees of a dynamic go share the goroutine
+		}
+
+		fmt.Fprintf(caller.env.extract.Log, "@@ queue go %s(", fn.String())
+		callee.translate(common)
+		fmt.Fprintf(caller.env.extract.Log, ")\n")
+
+		if goFrm == nil {
+			goFrm = callee
+		} else {
+			goFrm.alts = append(goFrm.alts, callee)
+		}
+	}
 
 	// TODO(nickng) Does not stop at recursive call.
-	caller.env.extract.goQueue = append(caller.env.extract.goQueue, callee)
+	caller.env.extract.goQueue = append(caller.env.extract.goQueue, goFrm)
 }
 
 func (callee *frame) translate(common *ssa.CallCommon) {
+	callee.common = common
 	for i, param := range callee.fn.Params {
 		argParent := common.Args[i]
 		if param != argParent {
@@ -317,26 +336,26 @@ func (callee *frame) translate(common *ssa.CallCommon) {
 		}
 
 		if i > 0 {
-			fmt.Fprintf(os.Stderr, ", ")
+			fmt.Fprintf(callee.env.extract.Log, ", ")
 		}
 
-		fmt.Fprintf(os.Stderr, "%s:caller[%s] = %s", orange(param.Name()), reg(common.Args[i]), callee.locals[param].String())
+		fmt.Fprintf(callee.env.extract.Log, "%s:caller[%s] = %s", orange(param.Name()), reg(common.Args[i]), callee.locals[param].String())
 		myVD := callee.locals[param] // VD of parameter (which are in callee.locals)
 
 		// if argument is a channel
 		if ch, ok := callee.env.chans[myVD]; ok {
-			fmt.Fprintf(os.Stderr, " channel %s", (*ch).Name())
+			fmt.Fprintf(callee.env.extract.Log, " channel %s", (*ch).Name())
 		} else if _, ok := callee.env.structs[myVD]; ok {
-			fmt.Fprintf(os.Stderr, " struct")
+			fmt.Fprintf(callee.env.extract.Log, " struct")
 		} else if _, ok := callee.env.arrays[myVD]; ok {
-			fmt.Fprintf(os.Stderr, " array")
+			fmt.Fprintf(callee.env.extract.Log, " array")
 		} else if fields, ok := callee.caller.structs[myVD]; ok {
 			// If param is local struct in caller, make local copy
-			fmt.Fprintf(os.Stderr, " lstruct")
+			fmt.Fprintf(callee.env.extract.Log, " lstruct")
 			callee.structs[myVD] = fields
 		} else if elems, ok := callee.caller.arrays[myVD]; ok {
 			// If param is local array in caller, make local copy
-			fmt.Fprintf(os.Stderr, " larray")
+			fmt.Fprintf(callee.env.extract.Log, " larray")
 			callee.arrays[myVD] = elems
 		}
 	}
@@ -345,11 +364,29 @@ func (callee *frame) translate(common *ssa.CallCommon) {
 	if captures, isClosure := callee.env.closures[common.Value]; isClosure {
 		for idx, fv := range callee.fn.FreeVars {
 			callee.locals[fv] = captures[idx]
-			fmt.Fprintf(os.Stderr, ", capture %s = %s", fv.Name(), captures[idx].String())
+			fmt.Fprintf(callee.env.extract.Log, ", capture %s = %s", fv.Name(), captures[idx].String())
 		}
 	}
 }
 
+// constInt resolves v to a constant integer by propagating constants through
+// the SSA, and through the constant arguments of the calls of the function.
+func (fr *frame) constInt(v ssa.Value) (int64, bool) {
+	return ssabuilder.ConstInt(v, func(v ssa.Value) *ssa.Const {
+		if fr.common == nil || fr.caller == nil {
+			return nil
+		}
+		for i, param := range fr.fn.Params {
+			if param == v && i < len(fr.common.Args) {
+				if n, ok := fr.caller.constInt(fr.common.Args[i]); ok {
+					return ssa.NewConst(constant.MakeInt64(n), param.Type())
+				}
+			}
+		}
+		return nil
+	})
+}
+
 // handleRetvals looks up and stores return value from function calls.
 // Nothing will be done if there are no return values from the function.
 func (caller *frame) handleRetvals(returned ssa.Value, callee *frame) {
@@ -403,15 +440,15 @@ func (caller *frame) handleExtRetvals(returned ssa.Value, callee *frame) {
 	if resultsLen > 0 {
 		caller.env.extern[returned] = callee.fn.Signature.Results()
 		if resultsLen == 1 {
-			fmt.Fprintf(os.Stderr, "-- Return from %s (builtin/ext) with a single value\n", callee.fn.String())
+			fmt.Fprintf(caller.env.extract.Log, "-- Return from %s (builtin/ext) with a single value\n", callee.fn.String())
 			if _, ok := callee.fn.Signature.Results().At(0).Type().(*types.Chan); ok {
-				vardef := utils.NewDef(returned)
+				vardef := caller.env.vers.NewDef(returned)
 				ch := caller.env.session.MakeExtChan(vardef, caller.gortn.role)
 				caller.env.chans[vardef] = &ch
-				fmt.Fprintf(os.Stderr, "-- Return value from %s (builtin/ext) is a channel %s (ext)\n", callee.fn.String(), (*caller.env.chans[vardef]).Name())
+				fmt.Fprintf(caller.env.extract.Log, "-- Return value from %s (builtin/ext) is a channel %s (ext)\n", callee.fn.String(), (*caller.env.chans[vardef]).Name())
 			}
 		} else {
-			fmt.Fprintf(os.Stderr, "-- Return from %s (builtin/ext) with %d-tuple\n", callee.fn.String(), resultsLen)
+			fmt.Fprintf(caller.env.extract.Log, "-- Return from %s (builtin/ext) with %d-tuple\n", callee.fn.String(), resultsLen)
 		}
 	}
 }
@@ -444,7 +481,7 @@ func (callee *frame) isRecursive() bool {
 func (callee *frame) printCallStack() {
 	curFr := callee
 	for curFr != nil && curFr.fn != nil {
-		fmt.Fprintf(os.Stderr, "Called by: %s()\n", curFr.fn.String())
+		fmt.Fprintf(callee.env.extract.Log, "Called by: %s()\n", curFr.fn.String())
 		curFr = curFr.caller
 	}
 }
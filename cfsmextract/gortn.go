@@ -2,6 +2,7 @@ package cfsmextract
 
 import (
 	"github.com/damifur/dingo-hunter/cfsmextract/sesstype"
+	"github.com/damifur/dingo-hunter/ssabuilder"
 	"golang.org/x/tools/go/ssa"
 )
 
@@ -10,14 +11,34 @@ type goroutine struct {
 	root    sesstype.Node
 	leaf    *sesstype.Node
 	visited map[*ssa.BasicBlock]sesstype.Node
+	labels  int // Labels made by stubs.
 }
 
-// Append a session type node to current goroutine.
+// Append a session type node to current goroutine. The current function is
+// skipped if the goroutine has no leaf to append to.
 func (gortn *goroutine) AddNode(node sesstype.Node) {
 	if gortn.leaf == nil {
-		panic("AddNode: leaf cannot be nil")
+		panic(ssabuilder.NewUnsupportedError(nil, nil, "%s: %s added to goroutine without leaf", gortn.role.Name(), node))
 	}
 
 	newLeaf := (*gortn.leaf).Append(node)
 	gortn.leaf = &newLeaf
 }
+
+// join joins leaves (Goto to a Label named label on the first leaf), and
+// returns the leaf to continue from. If there are no leaves, the goroutine
+// continues from a new node which is never reached.
+func (gortn *goroutine) join(leaves []*sesstype.Node, label string) *sesstype.Node {
+	switch len(leaves) {
+	case 0:
+		var n sesstype.Node = &sesstype.EmptyBodyNode{}
+		return &n
+	case 1:
+		return leaves[0]
+	}
+	n := (*leaves[0]).Append(sesstype.NewLabelNode(label))
+	for _, l := range leaves[1:] {
+		(*l).Append(sesstype.NewGotoNode(label))
+	}
+	return &n
+}
@@ -0,0 +1,371 @@
+package cfsmextract
+
+// Functions for analysing queued goroutines in parallel.
+//
+// The goroutines queued are analysed in waves: each goroutine of a wave is
+// analysed on a copy (fork) of the environment by one of Jobs workers, then
+// the changes of the copies (since the start of the wave) are merged in queue
+// order, so the session does not depend on the scheduling of the workers.
+// Definitions made by the copies are renumbered when merged, as if the
+// goroutines were analysed one after another.
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"go/types"
+	"sync"
+
+	"github.com/damifur/dingo-hunter/cfsmextract/sesstype"
+	"github.com/damifur/dingo-hunter/cfsmextract/utils"
+	"github.com/damifur/dingo-hunter/ssabuilder"
+	"golang.org/x/tools/go/ssa"
+)
+
+// runQueue analyses the queued goroutines (and the goroutines they spawn). A
+// panic of a worker is raised again by runQueue once the workers are done.
+func (extract *CFSMExtract) runQueue(env *environ) {
+	jobs := extract.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	for len(extract.goQueue) > 0 {
+		wave := extract.goQueue
+		extract.goQueue = nil
+		base := env.fork(extract.fork(new(bytes.Buffer))) // Unchanged copy.
+		forks := make([]*environ, len(wave))
+		logs := make([]bytes.Buffer, len(wave))
+		panics := make([]interface{}, len(wave))
+		sem := make(chan struct{}, jobs)
+		var wg sync.WaitGroup
+		for i, goFrm := range wave {
+			forks[i] = env.fork(extract.fork(&logs[i]))
+			goFrm.fork(forks[i])
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, goFrm *frame) {
+				defer func() { <-sem; wg.Done() }()
+				defer func() { panics[i] = recover() }()
+				if goFrm.env.extract.cancelled(goFrm.fn) {
+					return
+				}
+				fmt.Fprintf(goFrm.env.extract.Log, "\n%s\nLOCATION: %s%s\n", goFrm.fn.Name(), goFrm.gortn.role.Name(), loc(goFrm, goFrm.fn.Pos()))
+				visitGo(goFrm)
+				goFrm.env.session.Types[goFrm.gortn.role] = goFrm.gortn.root
+			}(i, goFrm)
+		}
+		wg.Wait()
+		for _, r := range panics {
+			if r != nil {
+				panic(r)
+			}
+		}
+		for i, f := range forks {
+			extract.Log.Write(logs[i].Bytes())
+			env.merge(f, base)
+		}
+	}
+}
+
+// fork returns a copy of the extraction for a goroutine, which logs to buf.
+func (extract *CFSMExtract) fork(buf *bytes.Buffer) *CFSMExtract {
+	f := *extract
+	f.Log = buf
+	f.Diagnostics, f.Skipped, f.Partial = nil, nil, nil
+	f.goQueue = nil
+	return &f
+}
+
+// fork returns a copy of the environment for extraction extract.
+func (env *environ) fork(extract *CFSMExtract) *environ {
+	f := *env
+	f.session = env.session.Fork()
+	f.extract = extract
+	f.globals = make(map[ssa.Value]*utils.Definition, len(env.globals))
+	for k, v := range env.globals {
+		f.globals[k] = v
+	}
+	f.arrays = copyArrays(env.arrays)
+	f.structs = copyStructs(env.structs)
+	f.chans = make(map[*utils.Definition]*sesstype.Chan, len(env.chans))
+	for k, v := range env.chans {
+		f.chans[k] = v
+	}
+	f.waitGroups = make(map[*utils.Definition]*waitGroup, len(env.waitGroups))
+	for k, v := range env.waitGroups {
+		wg := *v
+		f.waitGroups[k] = &wg
+	}
+	f.timers = make(map[*utils.Definition]*sesstype.Chan, len(env.timers))
+	for k, v := range env.timers {
+		f.timers[k] = v
+	}
+	f.extern = make(map[ssa.Value]types.Type, len(env.extern))
+	for k, v := range env.extern {
+		f.extern[k] = v
+	}
+	f.closures = make(map[ssa.Value]Captures, len(env.closures))
+	for k, v := range env.closures {
+		f.closures[k] = v
+	}
+	f.selNode = make(map[ssa.Value]struct {
+		parent   *sesstype.Node
+		blocking bool
+	}, len(env.selNode))
+	for k, v := range env.selNode {
+		f.selNode[k] = v
+	}
+	f.selIdx = make(map[ssa.Value]ssa.Value, len(env.selIdx))
+	for k, v := range env.selIdx {
+		f.selIdx[k] = v
+	}
+	f.selTest = make(map[ssa.Value]struct {
+		idx int
+		tpl ssa.Value
+	}, len(env.selTest))
+	for k, v := range env.selTest {
+		f.selTest[k] = v
+	}
+	f.recvTest = make(map[ssa.Value]*sesstype.Chan, len(env.recvTest))
+	for k, v := range env.recvTest {
+		f.recvTest[k] = v
+	}
+	f.ifparent = sesstype.NewNodeStack()
+	f.vers = make(utils.Versions, len(env.vers))
+	for k, v := range env.vers {
+		f.vers[k] = v
+	}
+	f.instances = make(map[*ssa.Function]int, len(env.instances))
+	for k, v := range env.instances {
+		f.instances[k] = v
+	}
+	return &f
+}
+
+// fork moves the goroutine to environment env, with copies of the local
+// arrays and structs (which may be shared with its caller).
+func (goFrm *frame) fork(env *environ) {
+	for _, fr := range goFrm.goFrames() {
+		fr.env = env
+		fr.arrays = copyArrays(fr.arrays)
+		fr.structs = copyStructs(fr.structs)
+	}
+}
+
+func copyArrays(arrays map[*utils.Definition]Elems) map[*utils.Definition]Elems {
+	c := make(map[*utils.Definition]Elems, len(arrays))
+	for k, v := range arrays {
+		elems := make(Elems, len(v))
+		for i, e := range v {
+			elems[i] = e
+		}
+		c[k] = elems
+	}
+	return c
+}
+
+func copyStructs(structs map[*utils.Definition]Fields) map[*utils.Definition]Fields {
+	c := make(map[*utils.Definition]Fields, len(structs))
+	for k, v := range structs {
+		fields := make(Fields, len(v))
+		for i, e := range v {
+			fields[i] = e
+		}
+		c[k] = fields
+	}
+	return c
+}
+
+// merge merges the changes of fork f of the environment since base, the
+// environment at the start of the wave. The goroutines queued by f beyond the
+// goroutine budget are dropped, as f only counts the goroutines it queued.
+func (env *environ) merge(f, base *environ) {
+	extract, fx := env.extract, f.extract
+	extract.Diagnostics = append(extract.Diagnostics, fx.Diagnostics...)
+	extract.Skipped = append(extract.Skipped, fx.Skipped...)
+	for _, err := range fx.Partial {
+		extract.Partial.Add(err)
+	}
+
+	f.renumber(env.vers, base.vers)
+	for k, v := range f.instances {
+		env.instances[k] += v - base.instances[k]
+	}
+	env.session.Merge(f.session, base.session)
+	for k, v := range f.globals {
+		if base.globals[k] != v {
+			env.globals[k] = v
+		}
+	}
+	mergeArrays(env.arrays, f.arrays, base.arrays)
+	mergeStructs(env.structs, f.structs, base.structs)
+	for k, v := range f.chans {
+		if base.chans[k] != v {
+			env.chans[k] = v
+		}
+	}
+	for k, v := range f.waitGroups {
+		wg, ok := env.waitGroups[k]
+		if !ok {
+			env.waitGroups[k] = v
+			continue
+		}
+		if b := base.waitGroups[k]; b == nil || b.pending != v.pending {
+			wg.pending = v.pending
+		}
+		if v.size > wg.size {
+			wg.size = v.size
+		}
+		env.session.SetChanSize(wg.def, wg.size)
+	}
+	for k, v := range f.timers {
+		if base.timers[k] != v {
+			env.timers[k] = v
+		}
+	}
+	for k, v := range f.extern {
+		if _, ok := base.extern[k]; !ok {
+			env.extern[k] = v
+		}
+	}
+	for k, v := range f.closures {
+		if _, ok := base.closures[k]; !ok {
+			env.closures[k] = v
+		}
+	}
+	for k, v := range f.selNode {
+		if base.selNode[k] != v {
+			env.selNode[k] = v
+		}
+	}
+	for k, v := range f.selIdx {
+		if base.selIdx[k] != v {
+			env.selIdx[k] = v
+		}
+	}
+	for k, v := range f.selTest {
+		if base.selTest[k] != v {
+			env.selTest[k] = v
+		}
+	}
+	for k, v := range f.recvTest {
+		if base.recvTest[k] != v {
+			env.recvTest[k] = v
+		}
+	}
+	for _, goFrm := range fx.goQueue {
+		// Each fork counts the goroutines queued from the start of the wave.
+		if max := extract.Budget.MaxGoroutines; max > 0 && extract.queued >= max {
+			extract.partial(goFrm.fn, token.NoPos, &ssabuilder.BudgetError{Budget: "max-goroutines", Limit: max})
+			continue
+		}
+		extract.queued++
+		for _, fr := range goFrm.goFrames() {
+			fr.env = env
+		}
+		goFrm.gortn.role = env.session.GetRole(goFrm.gortn.role.Name())
+		extract.goQueue = append(extract.goQueue, goFrm)
+	}
+}
+
+func mergeArrays(arrays, f, base map[*utils.Definition]Elems) {
+	for k, v := range f {
+		if _, ok := arrays[k]; !ok {
+			arrays[k] = make(Elems)
+		}
+		for i, e := range v {
+			if base[k][i] != e {
+				arrays[k][i] = e
+			}
+		}
+	}
+}
+
+func mergeStructs(structs, f, base map[*utils.Definition]Fields) {
+	for k, v := range f {
+		if _, ok := structs[k]; !ok {
+			structs[k] = make(Fields)
+		}
+		for i, e := range v {
+			if base[k][i] != e {
+				structs[k][i] = e
+			}
+		}
+	}
+}
+
+// renumber renumbers the definitions made in fork env since base after the
+// definitions of vers, and updates vers.
+func (env *environ) renumber(vers, base utils.Versions) {
+	latest := func(vers utils.Versions, v ssa.Value) int {
+		if ver, ok := vers[v]; ok {
+			return ver
+		}
+		return -1
+	}
+	seen := make(map[*utils.Definition]bool)
+	renumber := func(vd *utils.Definition) {
+		if vd == nil || vd.Var == nil || seen[vd] || vd.Ver <= latest(base, vd.Var) {
+			return
+		}
+		seen[vd] = true
+		vd.Ver += latest(vers, vd.Var) - latest(base, vd.Var)
+	}
+	for _, vd := range env.globals {
+		renumber(vd)
+	}
+	for vd, elems := range env.arrays {
+		renumber(vd)
+		for _, e := range elems {
+			renumber(e)
+		}
+	}
+	for vd, fields := range env.structs {
+		renumber(vd)
+		for _, e := range fields {
+			renumber(e)
+		}
+	}
+	for vd := range env.chans {
+		renumber(vd)
+	}
+	for vd, wg := range env.waitGroups {
+		renumber(vd)
+		renumber(wg.def)
+	}
+	for vd := range env.timers {
+		renumber(vd)
+	}
+	for _, captures := range env.closures {
+		for _, vd := range captures {
+			renumber(vd)
+		}
+	}
+	for vd := range env.session.Chans {
+		renumber(vd)
+	}
+	for _, goFrm := range env.extract.goQueue {
+		for _, fr := range goFrm.goFrames() {
+			for _, vd := range fr.locals {
+				renumber(vd)
+			}
+			for vd, elems := range fr.arrays {
+				renumber(vd)
+				for _, e := range elems {
+					renumber(e)
+				}
+			}
+			for vd, fields := range fr.structs {
+				renumber(vd)
+				for _, e := range fields {
+					renumber(e)
+				}
+			}
+		}
+	}
+	for v, ver := range env.vers {
+		if n := ver - latest(base, v); n > 0 {
+			vers[v] = latest(vers, v) + n
+		}
+	}
+}
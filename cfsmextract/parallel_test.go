@@ -0,0 +1,89 @@
+package cfsmextract
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/damifur/dingo-hunter/cfsmextract/sesstype"
+	"github.com/damifur/dingo-hunter/ssabuilder"
+)
+
+// TestJobs checks that the CFSMs of goroutines analysed in parallel are those
+// analysed sequentially.
+func TestJobs(t *testing.T) {
+	const src = `package main
+
+func relay(in, out chan int) { out <- <-in }
+
+func worker(n int, out chan int) {
+	ch := make(chan int)
+	go relay(ch, out)
+	ch <- n
+}
+
+func main() {
+	out := make(chan int)
+	go worker(1, out)
+	go worker(2, out)
+	go worker(3, out)
+	<-out
+	<-out
+	<-out
+}
+`
+	extract := func(jobs int) []string {
+		extract := New(build(t, src, ssabuilder.StaticDispatch), "", "")
+		extract.Jobs = jobs
+		cfsms := sesstype.NewCFSMs(run(t, extract))
+		var names []string
+		for ch := range cfsms.Chans {
+			names = append(names, ch.Name())
+		}
+		for role := range cfsms.Roles {
+			names = append(names, role.Name())
+		}
+		sort.Strings(names)
+		return names
+	}
+	want := extract(1)
+	for i := 0; i < 4; i++ {
+		if got := extract(4); !reflect.DeepEqual(got, want) {
+			t.Errorf("expecting CFSMs with 4 jobs as with 1 job: %v, got %v", want, got)
+		}
+	}
+}
+
+// TestJobsMaxGoroutines checks the goroutine budget of a wave, where each
+// goroutine queues goroutines within the budget but not the wave.
+func TestJobsMaxGoroutines(t *testing.T) {
+	const src = `package main
+
+func send(ch chan int) { ch <- 1 }
+
+func recv(ch chan int) { <-ch }
+
+func a(ch chan int) { go send(ch) }
+
+func b(ch chan int) { go recv(ch) }
+
+func main() {
+	ch := make(chan int)
+	go a(ch)
+	go b(ch)
+}
+`
+	for _, jobs := range []int{1, 2} {
+		extract := New(build(t, src, ssabuilder.StaticDispatch), "", "")
+		extract.Jobs = jobs
+		extract.Budget.MaxGoroutines = 3
+		run(t, extract)
+		if extract.queued != 3 {
+			t.Errorf("jobs=%d: expecting 3 goroutines queued, got %d", jobs, extract.queued)
+		}
+		if len(extract.Partial) != 1 || !strings.Contains(extract.Partial[0].Error(), "max-goroutines") {
+			t.Errorf("jobs=%d: expecting partial by max-goroutines, got %v", jobs, extract.Partial)
+		}
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"os"
 
+	"github.com/damifur/dingo-hunter/cfsmextract/utils"
 	"github.com/nickng/cfsm"
 )
 
@@ -17,20 +19,38 @@ type CFSMs struct {
 	Chans  map[Role]*cfsm.CFSM
 	Roles  map[Role]*cfsm.CFSM
 	States map[*cfsm.CFSM]map[string]*cfsm.State
+
+	defs      map[*utils.Definition]*cfsm.CFSM // Channel machines by definition.
+	branching map[*cfsm.State]bool             // States with several branches.
+	aliases   []alias                          // States joining labels.
+}
+
+// alias is a state which continues as the state of a label, i.e. a Goto to the
+// label is reached from the state without transition.
+type alias struct {
+	q     *cfsm.State
+	label string
 }
 
 func NewCFSMs(s *Session) *CFSMs {
 	sys := &CFSMs{
-		Sys:    cfsm.NewSystem(),
-		Chans:  make(map[Role]*cfsm.CFSM),
-		Roles:  make(map[Role]*cfsm.CFSM),
-		States: make(map[*cfsm.CFSM]map[string]*cfsm.State),
+		Sys:       cfsm.NewSystem(),
+		Chans:     make(map[Role]*cfsm.CFSM),
+		Roles:     make(map[Role]*cfsm.CFSM),
+		States:    make(map[*cfsm.CFSM]map[string]*cfsm.State),
+		defs:      make(map[*utils.Definition]*cfsm.CFSM),
+		branching: make(map[*cfsm.State]bool),
 	}
 	for _, c := range s.Chans {
 		m := sys.Sys.NewMachine()
 		m.Comment = c.Name()
 		sys.Chans[c] = m
-		defer sys.chanToMachine(c, c.Type().String(), m)
+		sys.defs[c.def] = m
+		if c.clock != notClock {
+			defer sys.clockToMachine(c.Type().String(), c.clock != timerClock, m)
+			continue
+		}
+		defer sys.chanToMachine(c, c.Type().String(), c.Size(), m)
 	}
 	for role, root := range s.Types {
 		m := sys.Sys.NewMachine()
@@ -56,13 +76,18 @@ func (sys *CFSMs) WriteTo(w io.Writer) (int64, error) {
 
 // PrintSummary shows the statistics of the CFSM syseration.
 func (sys *CFSMs) PrintSummary() {
-	fmt.Printf("Total of %d CFSMs (%d are channels)\n",
+	sys.WriteSummary(os.Stdout)
+}
+
+// WriteSummary writes the statistics of the CFSM syseration to w.
+func (sys *CFSMs) WriteSummary(w io.Writer) {
+	fmt.Fprintf(w, "Total of %d CFSMs (%d are channels)\n",
 		len(sys.Roles)+len(sys.Chans), len(sys.Chans))
 	for r, m := range sys.Chans {
-		fmt.Printf("\t%d\t= %s (channel)\n", m.ID, r.Name())
+		fmt.Fprintf(w, "\t%d\t= %s (channel)\n", m.ID, r.Name())
 	}
 	for r, m := range sys.Roles {
-		fmt.Printf("\t%d\t= %s\n", m.ID, r.Name())
+		fmt.Fprintf(w, "\t%d\t= %s\n", m.ID, r.Name())
 	}
 }
 
@@ -70,86 +95,216 @@ func (sys *CFSMs) rootToMachine(role Role, root Node, m *cfsm.CFSM) {
 	q0 := m.NewState()
 	sys.nodeToMachine(role, root, q0, m)
 	m.Start = q0
+	sys.joinAliases(m)
+}
+
+// joinAliases adds the transitions of the state of each label to the states
+// joining it, until all joins are complete (as labels may join labels).
+func (sys *CFSMs) joinAliases(m *cfsm.CFSM) {
+	for changed := true; changed; {
+		changed = false
+		for _, a := range sys.aliases {
+			q, ok := sys.States[m][a.label]
+			if !ok || q == a.q {
+				continue
+			}
+			n := len(a.q.Transitions())
+			for _, tr := range q.Transitions() {
+				a.q.AddTransition(tr)
+			}
+			if len(a.q.Transitions()) > n {
+				changed = true
+			}
+		}
+	}
+	sys.aliases = nil
+}
+
+// children translates the children of node from q0, which is a branching
+// state if node has several children.
+func (sys *CFSMs) children(role Role, node Node, q0 *cfsm.State, m *cfsm.CFSM) {
+	if len(node.Children()) > 1 {
+		sys.branching[q0] = true
+	}
+	for _, c := range node.Children() {
+		sys.nodeToMachine(role, c, q0, m)
+	}
 }
 
 func (sys *CFSMs) nodeToMachine(role Role, node Node, q0 *cfsm.State, m *cfsm.CFSM) {
 	switch node := node.(type) {
 	case *SendNode:
-		to, ok := sys.Chans[node.To()]
+		to, ok := sys.defs[node.To().def]
 		if !ok {
-			log.Fatal("Cannot Send to unknown channel", node.To().Name())
+			log.Printf("Cannot Send to unknown channel %s (skipped)", node.To().Name())
+			sys.skipToMachine(role, node, q0, m)
+			return
 		}
 		tr := cfsm.NewSend(to, node.To().Type().String())
-		var qSent *cfsm.State
-		if sys.isSelfLoop(m, q0, node) {
-			qSent = q0
-		} else {
+		qSent := sys.gotoState(m, node)
+		if qSent == nil {
 			qSent = m.NewState()
-			for _, c := range node.Children() {
-				sys.nodeToMachine(role, c, qSent, m)
-			}
+			sys.children(role, node, qSent, m)
 		}
 		tr.SetNext(qSent)
 		q0.AddTransition(tr)
 
 	case *RecvNode:
-		from, ok := sys.Chans[node.From()]
+		from, ok := sys.defs[node.From().def]
 		if !ok {
-			log.Fatal("Cannot Recv from unknown channel", node.From().Name())
+			log.Printf("Cannot Recv from unknown channel %s (skipped)", node.From().Name())
+			sys.skipToMachine(role, node, q0, m)
+			return
 		}
 		msg := node.From().Type().String()
 		if node.Stop() {
 			msg = STOP
 		}
 		tr := cfsm.NewRecv(from, msg)
-		var qRcvd *cfsm.State
-		if sys.isSelfLoop(m, q0, node) {
-			qRcvd = q0
-		} else {
+		qRcvd := sys.gotoState(m, node)
+		if qRcvd == nil {
 			qRcvd = m.NewState()
-			for _, c := range node.Children() {
-				sys.nodeToMachine(role, c, qRcvd, m)
-			}
+			sys.children(role, node, qRcvd, m)
 		}
 		tr.SetNext(qRcvd)
 		q0.AddTransition(tr)
 
 	case *EndNode:
-		ch, ok := sys.Chans[node.Chan()]
+		ch, ok := sys.defs[node.Chan().def]
 		if !ok {
-			log.Fatal("Cannot Close unknown channel", node.Chan().Name())
+			log.Printf("Cannot Close unknown channel %s (skipped)", node.Chan().Name())
+			sys.skipToMachine(role, node, q0, m)
+			return
 		}
 		tr := cfsm.NewSend(ch, STOP)
 		qEnd := m.NewState()
-		for _, c := range node.Children() {
-			sys.nodeToMachine(role, c, qEnd, m)
-		}
+		sys.children(role, node, qEnd, m)
 		tr.SetNext(qEnd)
 		q0.AddTransition(tr)
 
 	case *NewChanNode, *EmptyBodyNode: // Skip
-		for _, c := range node.Children() {
-			sys.nodeToMachine(role, c, q0, m)
-		}
+		sys.children(role, node, q0, m)
 
 	case *LabelNode:
-		sys.States[m][node.Name()] = q0
-		for _, c := range node.Children() {
-			sys.nodeToMachine(role, c, q0, m)
+		// The label of a branch from a branching state has a state of its own,
+		// so that a Goto to the label does not enter the other branches.
+		if sys.branching[q0] {
+			qLabel := m.NewState()
+			sys.aliases = append(sys.aliases, alias{q0, node.Name()})
+			q0 = qLabel
 		}
+		sys.States[m][node.Name()] = q0
+		sys.children(role, node, q0, m)
 
 	case *GotoNode:
-		qTarget := sys.States[m][node.Name()]
-		for _, c := range node.Children() {
-			sys.nodeToMachine(role, c, qTarget, m)
+		// A Goto reached without transition (e.g. from a branch without
+		// communication) continues as the label, which may not be visited yet.
+		sys.aliases = append(sys.aliases, alias{q0, node.Name()})
+		if qTarget, ok := sys.States[m][node.Name()]; ok {
+			sys.children(role, node, qTarget, m)
 		}
 
 	default:
-		log.Fatalf("Unhandled node type %T", node)
+		log.Printf("Unhandled node type %T (skipped)", node)
+		sys.skipToMachine(role, node, q0, m)
+	}
+}
+
+// skipToMachine skips node (without transition) and continues with its
+// children from q0.
+func (sys *CFSMs) skipToMachine(role Role, node Node, q0 *cfsm.State, m *cfsm.CFSM) {
+	sys.children(role, node, q0, m)
+}
+
+// MaxBufSize is the largest buffer size of channel machines, the queue of a
+// channel with a larger buffer (e.g. unbounded) holds MaxBufSize messages.
+const MaxBufSize int64 = 16
+
+// chanToMachine builds the machine of channel ch with payload type T.
+//
+// An unbuffered channel is a relay, which receives a message from a role and
+// forwards it to another role. A buffered channel of size k is a bounded queue,
+// where state qn holds n messages (0 <= n <= k), and k is at most MaxBufSize.
+// Closing the channel moves to the closed states, where the remaining messages
+// are delivered before STOP is sent to any role.
+func (sys *CFSMs) chanToMachine(ch Role, T string, size int64, m *cfsm.CFSM) {
+	if size <= 0 {
+		sys.relayToMachine(T, m)
+		return
+	}
+	if size > MaxBufSize {
+		size = MaxBufSize
+	}
+	open, closed := make([]*cfsm.State, size+1), make([]*cfsm.State, size+1)
+	for n := range open {
+		open[n], closed[n] = m.NewState(), m.NewState()
+	}
+	for n := int64(0); n <= size; n++ {
+		for _, machine := range sys.Roles {
+			// qn -- Recv --> qn+1
+			if n < size {
+				tr := cfsm.NewRecv(machine, T)
+				tr.SetNext(open[n+1])
+				open[n].AddTransition(tr)
+			}
+			// qn -- Send --> qn-1, also when closed
+			if n > 0 {
+				tr := cfsm.NewSend(machine, T)
+				tr.SetNext(open[n-1])
+				open[n].AddTransition(tr)
+				trClosed := cfsm.NewSend(machine, T)
+				trClosed.SetNext(closed[n-1])
+				closed[n].AddTransition(trClosed)
+			}
+			// qn -- STOP --> closed qn
+			trStop := cfsm.NewRecv(machine, STOP)
+			trStop.SetNext(closed[n])
+			open[n].AddTransition(trStop)
+		}
+	}
+	// closed q0 -- STOP --> closed q0
+	for _, machine := range sys.Roles {
+		tr := cfsm.NewSend(machine, STOP)
+		tr.SetNext(closed[0])
+		closed[0].AddTransition(tr)
+	}
+	m.Start = open[0]
+}
+
+// clockToMachine builds the machine of a timer (or a ticker if ticker is
+// true) with payload type T.
+//
+// A timer is armed until it sends to a role or is stopped (by a STOP message),
+// and a ticker (or a channel notified of signals) sends to roles until it is
+// stopped. Either is armed again when reset (by a message from a role).
+func (sys *CFSMs) clockToMachine(T string, ticker bool, m *cfsm.CFSM) {
+	armed, stopped := m.NewState(), m.NewState()
+	fired := stopped
+	if ticker {
+		fired = armed
+	}
+	for _, machine := range sys.Roles {
+		// armed -- Send --> fired
+		tr := cfsm.NewSend(machine, T)
+		tr.SetNext(fired)
+		armed.AddTransition(tr)
+		for _, q := range []*cfsm.State{armed, stopped} {
+			// q -- STOP --> stopped
+			trStop := cfsm.NewRecv(machine, STOP)
+			trStop.SetNext(stopped)
+			q.AddTransition(trStop)
+			// q -- Recv --> armed
+			trReset := cfsm.NewRecv(machine, T)
+			trReset.SetNext(armed)
+			q.AddTransition(trReset)
+		}
 	}
+	m.Start = armed
 }
 
-func (sys *CFSMs) chanToMachine(ch Role, T string, m *cfsm.CFSM) {
+// relayToMachine builds the machine of an unbuffered channel with payload
+// type T.
+func (sys *CFSMs) relayToMachine(T string, m *cfsm.CFSM) {
 	q0 := m.NewState()
 	qEnd := m.NewState()
 	for _, machine := range sys.Roles {
@@ -169,7 +324,7 @@ func (sys *CFSMs) chanToMachine(ch Role, T string, m *cfsm.CFSM) {
 		// q0 -- STOP --> qEnd (same qEnd)
 		tr2 := cfsm.NewRecv(machine, STOP)
 		tr2.SetNext(qEnd)
-		qEnd.AddTransition(tr2)
+		q0.AddTransition(tr2)
 		// qEnd -- STOP --> qEnd
 		for _, machine2 := range sys.Roles {
 			if machine.ID != machine2.ID {
@@ -182,15 +337,16 @@ func (sys *CFSMs) chanToMachine(ch Role, T string, m *cfsm.CFSM) {
 	m.Start = q0
 }
 
-// isSelfLoop returns true if the action of node is a self-loop
-// i.e. the state before and after the transition is the same.
-func (sys *CFSMs) isSelfLoop(m *cfsm.CFSM, q0 *cfsm.State, node Node) bool {
+// gotoState returns the state of the label if the action of node is followed
+// by a Goto to a visited label, i.e. the transition loops back (to the state
+// before the transition) or joins a branch, or nil otherwise.
+func (sys *CFSMs) gotoState(m *cfsm.CFSM, node Node) *cfsm.State {
 	if len(node.Children()) == 1 {
 		if gotoNode, ok := node.Child(0).(*GotoNode); ok {
-			if loopback, ok := sys.States[m][gotoNode.Name()]; ok {
-				return loopback == q0
+			if q, ok := sys.States[m][gotoNode.Name()]; ok {
+				return q
 			}
 		}
 	}
-	return false
+	return nil
 }
@@ -3,7 +3,6 @@ package sesstype
 import (
 	"fmt"
 	"io"
-	"log"
 
 	"github.com/awalterschulze/gographviz"
 )
@@ -13,6 +12,8 @@ type GraphvizDot struct {
 	Graph      *gographviz.Escape
 	Count      int
 	LabelNodes map[string]string
+
+	err error // First error of the graph, returned by WriteTo.
 }
 
 // NewGraphvizDot creates a new graphviz dot graph from a session.
@@ -35,13 +36,17 @@ func NewGraphvizDot(s *Session) *GraphvizDot {
 	return dot
 }
 
-// WriteTo implements io.WriterTo interface.
+// WriteTo implements io.WriterTo interface. The graph is not written if it
+// could not be created.
 func (dot *GraphvizDot) WriteTo(w io.Writer) (int64, error) {
+	if dot.err != nil {
+		return 0, dot.err
+	}
 	n, err := w.Write([]byte(dot.Graph.String()))
 	return int64(n), err
 }
 
-func (dot *GraphvizDot) nodeToDotNode(node Node) *gographviz.Node {
+func (dot *GraphvizDot) nodeToDotNode(node Node) (*gographviz.Node, error) {
 	switch node := node.(type) {
 	case *LabelNode:
 		defer func() { dot.Count++ }()
@@ -51,13 +56,13 @@ func (dot *GraphvizDot) nodeToDotNode(node Node) *gographviz.Node {
 			"shape": "plaintext,",
 		})
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		dotNode := gographviz.Node{
 			Name:  dot.LabelNodes[node.Name()],
 			Attrs: attrs,
 		}
-		return &dotNode
+		return &dotNode, nil
 
 	case *NewChanNode:
 		defer func() { dot.Count++ }()
@@ -67,12 +72,12 @@ func (dot *GraphvizDot) nodeToDotNode(node Node) *gographviz.Node {
 			"color": "red",
 		})
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		return &gographviz.Node{
 			Name:  fmt.Sprintf("%s%d", node.Kind(), dot.Count),
 			Attrs: attrs,
-		}
+		}, nil
 
 	case *SendNode:
 		defer func() { dot.Count++ }()
@@ -88,12 +93,12 @@ func (dot *GraphvizDot) nodeToDotNode(node Node) *gographviz.Node {
 			"style": style,
 		})
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		return &gographviz.Node{
 			Name:  fmt.Sprintf("%s%d", node.Kind(), dot.Count),
 			Attrs: attrs,
-		}
+		}, nil
 
 	case *RecvNode:
 		defer func() { dot.Count++ }()
@@ -109,15 +114,15 @@ func (dot *GraphvizDot) nodeToDotNode(node Node) *gographviz.Node {
 			"style": style,
 		})
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		return &gographviz.Node{
 			Name:  fmt.Sprintf("%s%d", node.Kind(), dot.Count),
 			Attrs: attrs,
-		}
+		}, nil
 
 	case *GotoNode:
-		return nil // No new node to create
+		return nil, nil // No new node to create
 
 	default:
 		defer func() { dot.Count++ }()
@@ -126,20 +131,26 @@ func (dot *GraphvizDot) nodeToDotNode(node Node) *gographviz.Node {
 			"shape": "rect",
 		})
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		dotNode := gographviz.Node{
 			Name:  fmt.Sprintf("%s%d", node.Kind(), dot.Count),
 			Attrs: attrs,
 		}
-		return &dotNode
+		return &dotNode, nil
 	}
 }
 
 // visitNode Creates a dot Node and from it create a subgraph of children.
 // Returns head of the subgraph.
 func (dot *GraphvizDot) visitNode(node Node, subgraph *gographviz.SubGraph, parent *gographviz.Node) *gographviz.Node {
-	dotNode := dot.nodeToDotNode(node)
+	dotNode, err := dot.nodeToDotNode(node)
+	if err != nil {
+		if dot.err == nil {
+			dot.err = err
+		}
+		return parent
+	}
 
 	if dotNode == nil { // GotoNode
 		gtn := node.(*GotoNode)
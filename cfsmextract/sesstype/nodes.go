@@ -2,6 +2,8 @@ package sesstype
 
 import (
 	"fmt"
+	"io"
+	"os"
 )
 
 func CountNodes(root Node) int {
@@ -21,9 +23,14 @@ func SessionCountNodes(session *Session) map[string]int {
 }
 
 func PrintNodeSummary(session *Session) {
+	WriteNodeSummary(os.Stdout, session)
+}
+
+// WriteNodeSummary writes the node summary of session to w.
+func WriteNodeSummary(w io.Writer, session *Session) {
 	counts := SessionCountNodes(session)
-	fmt.Printf("Total of nodes per role (%d roles)\n", len(counts))
+	fmt.Fprintf(w, "Total of nodes per role (%d roles)\n", len(counts))
 	for role, n := range counts {
-		fmt.Printf("\t%d\t: %s\n", n, role)
+		fmt.Fprintf(w, "\t%d\t: %s\n", n, role)
 	}
 }
@@ -20,8 +20,20 @@ type Chan struct {
 	def    *utils.Definition
 	role   Role
 	extern bool
+	size   int64
+	clock  clockKind
 }
 
+// clockKind is the kind of channels of package time (or os/signal).
+type clockKind int
+
+const (
+	notClock clockKind = iota
+	timerClock
+	tickerClock
+	signalClock
+)
+
 // Return a name of channel.
 func (ch Chan) Name() string {
 	fullname := fmt.Sprintf("%s", ch.def.String())
@@ -31,14 +43,18 @@ func (ch Chan) Name() string {
 	return fullname
 }
 
-// Return the payload type of channel.
+// Return the payload type of channel, or an invalid type if the channel is not
+// made from a channel value.
 func (ch Chan) Type() types.Type {
-	if c, ok := ch.def.Var.Type().(*types.Chan); ok {
-		return c.Elem()
+	if t := ch.def.Var.Type(); t != nil {
+		if c, ok := t.Underlying().(*types.Chan); ok {
+			return c.Elem()
+		}
 	}
-	panic("Not channel " + ch.def.Var.String())
+	return types.Typ[types.Invalid]
 }
 func (ch Chan) Role() Role       { return ch.role }
+func (ch Chan) Size() int64      { return ch.size } // Buffer size.
 func (ch Chan) Value() ssa.Value { return ch.def.Var }
 
 // Role in a session (main or goroutine).
@@ -95,6 +111,50 @@ func (s *Session) GetRole(name string) Role { // Get or create role
 	return s.Roles[name]
 }
 
+// Fork returns a copy of the session, which can be extended independently
+// then merged back with Merge.
+func (s *Session) Fork() *Session {
+	f := CreateSession()
+	for r, n := range s.Types {
+		f.Types[r] = n
+	}
+	for v, ch := range s.Chans {
+		f.Chans[v] = ch
+	}
+	for name, r := range s.Roles {
+		f.Roles[name] = r
+	}
+	return f
+}
+
+// Merge merges the changes of session f since it was forked from base, where
+// the roles of f are replaced by the roles of the same name in the session.
+func (s *Session) Merge(f, base *Session) {
+	for name := range f.Roles {
+		s.GetRole(name)
+	}
+	for r, n := range f.Types {
+		if m, ok := base.Types[r]; !ok || m != n {
+			s.Types[s.Roles[r.Name()]] = n
+		}
+	}
+	for v, ch := range f.Chans {
+		if c, ok := base.Chans[v]; ok && c == ch {
+			continue
+		}
+		ch.role = s.Roles[ch.role.Name()]
+		if c, ok := s.Chans[v]; ok {
+			if c.size > ch.size {
+				ch.size = c.size
+			}
+			if ch.clock == notClock { // Notified in another fork.
+				ch.clock = c.clock
+			}
+		}
+		s.Chans[v] = ch
+	}
+}
+
 // MakeChan creates and stores a new session channel created.
 func (s *Session) MakeChan(v *utils.Definition, r Role) Chan {
 	s.Chans[v] = Chan{
@@ -105,6 +165,55 @@ func (s *Session) MakeChan(v *utils.Definition, r Role) Chan {
 	return s.Chans[v]
 }
 
+// MakeBufChan creates and stores a new session channel with a buffer of the
+// given size.
+func (s *Session) MakeBufChan(v *utils.Definition, r Role, size int64) Chan {
+	s.Chans[v] = Chan{
+		def:    v,
+		role:   r,
+		extern: false,
+		size:   size,
+	}
+	return s.Chans[v]
+}
+
+// SetChanSize sets the buffer size of channel v, e.g. if the size is only known
+// after the channel is used.
+func (s *Session) SetChanSize(v *utils.Definition, size int64) {
+	if ch, ok := s.Chans[v]; ok {
+		ch.size = size
+		s.Chans[v] = ch
+	}
+}
+
+// MakeTimerChan creates and stores a new channel of a timer (or a ticker if
+// ticker is true) of package time, which sends to any role at any time.
+func (s *Session) MakeTimerChan(v *utils.Definition, r Role, ticker bool) Chan {
+	clock := timerClock
+	if ticker {
+		clock = tickerClock
+	}
+	s.Chans[v] = Chan{
+		def:   v,
+		role:  r,
+		clock: clock,
+	}
+	return s.Chans[v]
+}
+
+// NotifyChan marks channel v as notified of signals (see os/signal.Notify), so
+// it sends to any role at any time like the channel of a ticker. It returns
+// false if v is not a channel of the session.
+func (s *Session) NotifyChan(v *utils.Definition) (Chan, bool) {
+	ch, ok := s.Chans[v]
+	if !ok {
+		return Chan{}, false
+	}
+	ch.clock = signalClock
+	s.Chans[v] = ch
+	return ch, true
+}
+
 // MakeExtChan creates and stores a new channel and mark as externally created.
 func (s *Session) MakeExtChan(v *utils.Definition, r Role) Chan {
 	s.Chans[v] = Chan{
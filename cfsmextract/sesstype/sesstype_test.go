@@ -1,8 +1,10 @@
 package sesstype
 
 import (
+	"fmt"
 	"go/token"
 	"go/types"
+	"math"
 	"testing"
 
 	"github.com/damifur/dingo-hunter/cfsmextract/utils"
@@ -184,3 +186,233 @@ func TestSelfLoop(t *testing.T) {
 		t.Errorf("expecting self-loop but got %s", m.String())
 	}
 }
+
+// Tests buffered channel is a bounded queue machine.
+func TestBufferedChanMachine(t *testing.T) {
+	s := CreateSession()
+	r := s.GetRole("main")
+	c := s.MakeBufChan(utils.NewDef(mockChan{}), r, 2)
+	n0 := NewNewChanNode(c)
+	n0.Append(NewSendNode(r, c, nil)).Append(NewSendNode(r, c, nil))
+	s.Types[r] = n0
+
+	ms := NewCFSMs(s)
+	m := ms.Chans[c]
+	if want, got := 6, len(m.States()); want != got {
+		t.Fatalf("expecting %d states (open and closed, 0..2 messages) but got %d", want, got)
+	}
+	// Empty queue: receive message or STOP.
+	if want, got := 2, len(m.Start.Transitions()); want != got {
+		t.Errorf("expecting %d transitions from empty queue but got %d", want, got)
+	}
+	// Send twice without receiver fills the queue.
+	q := m.Start
+	for i := 0; i < 2; i++ {
+		var next *cfsm.State
+		for _, tr := range q.Transitions() {
+			if _, ok := tr.(*cfsm.Recv); ok && tr.State() != nil && tr.Label() != fmt.Sprintf("%d ? %s", ms.Roles[r].ID, STOP) {
+				next = tr.State()
+			}
+		}
+		if next == nil {
+			t.Fatalf("expecting queue to accept message %d", i+1)
+		}
+		q = next
+	}
+	for _, tr := range q.Transitions() {
+		if tr.Label() == fmt.Sprintf("%d ? %s", ms.Roles[r].ID, c.Type().String()) {
+			t.Errorf("expecting full queue to block receive but got %s", tr.Label())
+		}
+	}
+}
+
+// Tests forked sessions are merged with the roles of the session.
+func TestSessionMerge(t *testing.T) {
+	s := CreateSession()
+	r := s.GetRole("main")
+	s.Types[r] = NewLabelNode("main")
+	base, f1, f2 := s.Fork(), s.Fork(), s.Fork()
+
+	// Both forks make the role g, with channels of their own.
+	c1 := f1.MakeChan(utils.NewDef(mockChan{}), f1.GetRole("g"))
+	f1.Types[f1.GetRole("g")] = NewNewChanNode(c1)
+	c2 := f2.MakeBufChan(utils.NewDef(mockChan{}), f2.GetRole("g"), 1)
+	f2.Types[f2.GetRole("g")] = NewNewChanNode(c2)
+	s.Merge(f1, base)
+	s.Merge(f2, base)
+
+	if want, got := 2, len(s.Roles); want != got {
+		t.Fatalf("expecting %d roles but got %d", want, got)
+	}
+	if want, got := 2, len(s.Types); want != got {
+		t.Errorf("expecting %d session types but got %d", want, got)
+	}
+	if n := s.Types[s.Roles["g"]]; n == nil || n.(*NewChanNode).Chan().Size() != 1 {
+		t.Errorf("expecting session type of g from the last fork but got %v", n)
+	}
+	for _, ch := range s.Chans {
+		if ch.Role() != s.Roles["g"] {
+			t.Errorf("expecting channel %s of role g in the session", ch.Name())
+		}
+	}
+	if want, got := 2, len(s.Chans); want != got {
+		t.Errorf("expecting %d channels but got %d", want, got)
+	}
+}
+
+// Tests unbuffered channel is closed (receives STOP) from the initial state.
+func TestRelayMachineStop(t *testing.T) {
+	s := CreateSession()
+	r := s.GetRole("main")
+	c := s.MakeChan(utils.NewDef(mockChan{}), r)
+	n0 := NewNewChanNode(c)
+	n0.Append(NewEndNode(c))
+	s.Types[r] = n0
+
+	ms := NewCFSMs(s)
+	m := ms.Chans[c]
+	stop := fmt.Sprintf("%d ? %s", ms.Roles[r].ID, STOP)
+	var qEnd *cfsm.State
+	for _, tr := range m.Start.Transitions() {
+		if tr.Label() == stop {
+			qEnd = tr.State()
+		}
+	}
+	if qEnd == nil {
+		t.Fatalf("expecting %s from initial state but got %s", stop, m.String())
+	}
+	for _, tr := range qEnd.Transitions() {
+		if tr.Label() == stop {
+			t.Errorf("expecting closed channel not to receive %s again", stop)
+		}
+	}
+}
+
+// TestBufMachineSize checks that the queue of a channel machine holds at most
+// MaxBufSize messages.
+func TestBufMachineSize(t *testing.T) {
+	s := CreateSession()
+	r := s.GetRole("main")
+	c := s.MakeBufChan(utils.NewDef(mockChan{}), r, math.MaxInt32)
+	n0 := NewNewChanNode(c)
+	n0.Append(NewEndNode(c))
+	s.Types[r] = n0
+
+	ms := NewCFSMs(s)
+	if n, want := len(ms.Chans[c].States()), int(2*(MaxBufSize+1)); n != want {
+		t.Errorf("expecting %d states in channel machine but got %d", want, n)
+	}
+}
+
+// TestChanType checks the payload types of channels of named channel types and
+// of non-channel values, which are invalid.
+func TestChanType(t *testing.T) {
+	s := CreateSession()
+	r := s.GetRole("main")
+	named := types.NewNamed(types.NewTypeName(token.NoPos, nil, "C", nil), types.NewChan(types.SendRecv, types.Typ[types.Int]), nil)
+	if typ := s.MakeChan(utils.NewDef(utils.EmptyValue{T: named}), r).Type(); typ != types.Typ[types.Int] {
+		t.Errorf("expecting payload type int but got %v", typ)
+	}
+	if typ := s.MakeChan(utils.NewDef(utils.EmptyValue{T: types.Typ[types.Int]}), r).Type(); typ != types.Typ[types.Invalid] {
+		t.Errorf("expecting invalid payload type but got %v", typ)
+	}
+}
+
+// TestGotoState checks the state of the label a transition goes to when
+// followed by a Goto, which is only known once the label is visited.
+func TestGotoState(t *testing.T) {
+	s := CreateSession()
+	r := s.GetRole("main")
+	c := s.MakeChan(utils.NewDef(mockChan{}), r)
+	n0 := NewLabelNode("L")
+	n1 := n0.Append(NewSendNode(r, c, nil))
+	n1.Append(NewGotoNode("L"))
+	n2 := NewSendNode(r, c, nil)
+	n2.Append(NewGotoNode("M"))
+
+	ms := NewCFSMs(s)
+	m := ms.Sys.NewMachine()
+	ms.States[m] = make(map[string]*cfsm.State)
+	if q := ms.gotoState(m, n1); q != nil {
+		t.Errorf("expecting no state before label L is visited but got %s", q.Name())
+	}
+	ms.rootToMachine(r, n0, m)
+	if q := ms.gotoState(m, n1); q != ms.States[m]["L"] || q == nil {
+		t.Errorf("expecting state of label L but got %v", q)
+	}
+	if q := ms.gotoState(m, n0); q != nil {
+		t.Errorf("expecting no state for node without Goto but got %s", q.Name())
+	}
+	if q := ms.gotoState(m, n2); q != nil {
+		t.Errorf("expecting no state for Goto to unknown label but got %s", q.Name())
+	}
+}
+
+// TestDispatchEmptyBranch checks the branches of a dynamic call where a callee
+// does no communication, which continues after the call without transition,
+// whether it is the first branch (with the Label) or not (with a Goto).
+func TestDispatchEmptyBranch(t *testing.T) {
+	for _, empty := range []int{0, 1} {
+		s := CreateSession()
+		r := s.GetRole("main")
+		c := s.MakeChan(utils.NewDef(mockChan{}), r)
+		n0 := NewNewChanNode(c)
+		leaves := make([]Node, 2)
+		for i := range leaves {
+			leaves[i] = n0.Append(&EmptyBodyNode{})
+			if i != empty {
+				leaves[i] = leaves[i].Append(NewSendNode(r, c, nil))
+			}
+		}
+		leaves[0].Append(NewLabelNode("join")).Append(NewRecvNode(c, r, nil))
+		leaves[1].Append(NewGotoNode("join"))
+		s.Types[r] = n0
+
+		ms := NewCFSMs(s)
+		m := ms.Roles[r]
+		send := fmt.Sprintf("%d ! %s", ms.Chans[c].ID, c.Type())
+		recv := fmt.Sprintf("%d ? %s", ms.Chans[c].ID, c.Type())
+		next := make(map[string]*cfsm.State)
+		for _, tr := range m.Start.Transitions() {
+			next[tr.Label()] = tr.State()
+		}
+		if next[send] == nil || next[recv] == nil {
+			t.Fatalf("empty branch %d: expecting %s and %s from initial state but got %s", empty, send, recv, m.String())
+		}
+		if next[send] == m.Start {
+			t.Errorf("empty branch %d: expecting %s not to loop back but got %s", empty, send, m.String())
+		}
+		if trs := next[send].Transitions(); len(trs) != 1 || trs[0].Label() != recv {
+			t.Errorf("empty branch %d: expecting only %s after %s but got %s", empty, recv, send, m.String())
+		}
+	}
+}
+
+// TestClockMachine checks that the machine of a timer sends once until reset,
+// and the machine of a ticker sends until stopped.
+func TestClockMachine(t *testing.T) {
+	for _, ticker := range []bool{false, true} {
+		s := CreateSession()
+		r := s.GetRole("main")
+		c := s.MakeTimerChan(utils.NewDef(mockChan{}), r, ticker)
+		n0 := NewNewChanNode(c)
+		n0.Append(NewRecvNode(c, r, nil))
+		s.Types[r] = n0
+
+		ms := NewCFSMs(s)
+		m := ms.Chans[c]
+		send := fmt.Sprintf("%d ! %s", ms.Roles[r].ID, c.Type().String())
+		var fired *cfsm.State
+		for _, tr := range m.Start.Transitions() {
+			if tr.Label() == send {
+				fired = tr.State()
+			}
+		}
+		if fired == nil {
+			t.Fatalf("expecting %s from armed state but got %s", send, m.String())
+		}
+		if (fired == m.Start) != ticker {
+			t.Errorf("expecting ticker=%t armed after %s but got %s", ticker, send, m.String())
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package cfsmextract
+
+// Functions for handling package os/signal.
+//
+// The os/signal package is not built (see ssabuilder), so Notify marks the
+// channel as notified of signals, and the channel machine sends to any role at
+// any time like the channel of a ticker (see sesstype.NotifyChan). The other
+// functions of os/signal do not communicate.
+
+import (
+	"fmt"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// callSignal models a call of os/signal.Notify, returns false if common is
+// not such a call.
+func (caller *frame) callSignal(common *ssa.CallCommon) bool {
+	fn := common.StaticCallee()
+	if fn == nil || fn.Pkg == nil || fn.Pkg.Pkg.Path() != "os/signal" || fn.Name() != "Notify" {
+		return false
+	}
+	if vd, kind := caller.get(common.Args[0]); kind == Chan {
+		if ch, ok := caller.env.session.NotifyChan(vd); ok {
+			caller.env.chans[vd] = &ch
+			fmt.Fprintf(caller.env.extract.Log, "++ call %s(%s channel %s)\n", orange(fn.String()), green(common.Args[0].Name()), ch.Name())
+			return true
+		}
+	}
+	caller.env.extract.warn(caller.fn, common.Pos(), "%s: unknown channel %s", fn, common.Args[0].Name())
+	return true
+}
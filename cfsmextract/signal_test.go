@@ -0,0 +1,82 @@
+package cfsmextract
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/damifur/dingo-hunter/cfsmextract/sesstype"
+	"github.com/damifur/dingo-hunter/ssabuilder"
+	"github.com/nickng/cfsm"
+)
+
+// TestSignal checks that the machine of a channel notified of signals sends
+// to roles from its initial state, unlike the queue of a buffered channel.
+func TestSignal(t *testing.T) {
+	const src = `package main
+
+import (
+	"os"
+	"os/signal"
+)
+
+func main() {
+	sig := make(chan os.Signal, 1)
+	%s
+	<-sig
+}
+`
+	for _, tc := range []struct {
+		call     string
+		notified bool
+	}{
+		{"signal.Notify(sig, os.Interrupt)", true},
+		{"signal.Ignore(os.Interrupt); signal.Stop(sig)", false},
+	} {
+		extract := New(build(t, fmt.Sprintf(src, tc.call), ssabuilder.StaticDispatch), "", "")
+		session := run(t, extract)
+		if got, want := nodes(t, session, "main"), "label; newchan main.main.t0@0; recv main.main.t0@0"; got != want {
+			t.Errorf("%s: expecting main %q, got %q", tc.call, want, got)
+		}
+		ms := sesstype.NewCFSMs(session)
+		for _, ch := range session.Chans {
+			m := ms.Chans[ch]
+			sends := false
+			for _, tr := range m.Start.Transitions() {
+				if _, ok := tr.(*cfsm.Send); ok {
+					sends = true
+				}
+			}
+			if sends != tc.notified {
+				t.Errorf("%s: expecting notified=%t channel %s, got %s", tc.call, tc.notified, ch.Name(), m.String())
+			}
+		}
+	}
+}
+
+// TestSignalWorker checks that a worker waiting for a signal is live.
+func TestSignalWorker(t *testing.T) {
+	const src = `package main
+
+import (
+	"os"
+	"os/signal"
+)
+
+func worker(sig chan os.Signal, done chan int) {
+	<-sig
+	done <- 1
+}
+
+func main() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	done := make(chan int)
+	go worker(sig, done)
+	<-done
+}
+`
+	extract := New(build(t, src, ssabuilder.StaticDispatch), "", "")
+	if s := stuck(run(t, extract)); len(s) != 0 {
+		t.Errorf("expecting live CFSMs, got stuck %v", s)
+	}
+}
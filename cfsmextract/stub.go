@@ -0,0 +1,260 @@
+package cfsmextract
+
+// Functions for handling calls of functions with stub specifications.
+//
+// Functions which are not analysed (without body, or of packages which are
+// skipped, opaque or pure) may have a stub in the stub specifications (see
+// package stubspec). The MiGo statements of the stub are interpreted as
+// session nodes of the goroutine calling the function, with the channel
+// arguments of the call as parameters. Calls of definitions are inlined, where
+// recursive calls are loops (Goto to the Label of the call), if and select are
+// branches, and the paths falling through the stub are joined (Goto to a Label
+// on the first path) before the caller continues. Spawned definitions (and go
+// statements of a stubbed function) are new roles.
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+
+	"github.com/damifur/dingo-hunter/cfsmextract/sesstype"
+	"github.com/damifur/dingo-hunter/cfsmextract/utils"
+	"github.com/damifur/dingo-hunter/ssabuilder"
+	"github.com/damifur/migo"
+	"golang.org/x/tools/go/ssa"
+)
+
+// stubChanType is the type of channels made by stubs.
+var stubChanType = types.NewChan(types.SendRecv, types.NewStruct(nil, nil))
+
+// stubChanValue is a channel value made by a stub.
+type stubChanValue struct {
+	utils.EmptyValue
+	name   string        // Unique name of the channel.
+	parent *ssa.Function // Function calling the stub.
+}
+
+func (v stubChanValue) Name() string          { return v.name }
+func (v stubChanValue) String() string        { return v.name }
+func (v stubChanValue) Parent() *ssa.Function { return v.parent }
+
+// stubEnv is the channels bound to the names of a stub definition.
+type stubEnv map[string]*sesstype.Chan
+
+// stubber interprets the stub definitions of a call in a goroutine.
+type stubber struct {
+	caller *frame
+	defs   map[string]*migo.Function
+	pos    token.Pos
+	role   sesstype.Role
+	active map[string]string // Labels of the definitions being called.
+}
+
+// stubOf returns the name of the stub definition of fn, and false if fn is
+// analysed or has no stub.
+func (extract *CFSMExtract) stubOf(fn *ssa.Function) (string, bool) {
+	if fn == nil || extract.Stubs == nil {
+		return "", false
+	}
+	if fn.Blocks != nil {
+		if p, _ := extract.SSA.BuildConf.FuncPolicy(fn); p == ssabuilder.Analyse {
+			return "", false
+		}
+	}
+	return extract.Stubs.Lookup(fn.String())
+}
+
+// callStub models a call of a function with a stub (or spawns the stub if
+// spawn is true), returns false if common is not a static call of such a
+// function. The results are stored in call (nil if deferred or spawned).
+func (caller *frame) callStub(call *ssa.Call, common *ssa.CallCommon, spawn bool, pos token.Pos) bool {
+	fn := common.StaticCallee()
+	name, ok := caller.env.extract.stubOf(fn)
+	if !ok {
+		return false
+	}
+	if call != nil {
+		caller.handleExtRetvals(call.Value(), &frame{fn: fn})
+	}
+	fns, err := caller.env.extract.Stubs.Defs(name)
+	if err != nil {
+		caller.env.extract.warn(caller.fn, pos, "stub of %s: %v", fn, err)
+		return true
+	}
+	s := &stubber{caller: caller, defs: make(map[string]*migo.Function), pos: pos, role: caller.gortn.role, active: make(map[string]string)}
+	for _, def := range fns {
+		s.defs[def.Name] = def
+	}
+	var chans []*sesstype.Chan
+	for _, arg := range common.Args {
+		if _, ok := arg.Type().Underlying().(*types.Chan); !ok {
+			continue
+		}
+		vd, kind := caller.get(arg)
+		if kind != Chan {
+			caller.env.extract.warn(caller.fn, pos, "stub %s of %s: unknown channel %s", name, fn, arg.Name())
+			return true
+		}
+		chans = append(chans, caller.env.chans[vd])
+	}
+	def := s.defs[name]
+	if len(chans) != len(def.Params) {
+		caller.env.extract.warn(caller.fn, pos, "stub %s of %s takes %d channels, %d given", name, fn, len(def.Params), len(chans))
+		return true
+	}
+	env := make(stubEnv)
+	for i, ch := range chans {
+		env[def.Params[i].Callee.Name()] = ch
+	}
+	fmt.Fprintf(caller.env.extract.Log, "++ call %s (stub %s)\n", orange(fn.String()), name)
+	if spawn {
+		s.spawn(name, env)
+		return true
+	}
+	leaves := s.call(name, env, caller.gortn.leaf)
+	caller.gortn.leaf = s.join(leaves)
+	return true
+}
+
+// label returns a new label of the goroutine.
+func (s *stubber) label(name string) string {
+	s.caller.gortn.labels++
+	return fmt.Sprintf("%s_%d_%s_%d", s.role.Name(), int(s.pos), name, s.caller.gortn.labels)
+}
+
+// add appends node to leaf, and returns the new leaf.
+func (s *stubber) add(leaf *sesstype.Node, node sesstype.Node) *sesstype.Node {
+	n := (*leaf).Append(node)
+	return &n
+}
+
+// join joins the leaves of the paths falling through a stub, and returns the
+// leaf to continue from.
+func (s *stubber) join(leaves []*sesstype.Node) *sesstype.Node {
+	if len(leaves) < 2 {
+		return s.caller.gortn.join(leaves, "")
+	}
+	return s.caller.gortn.join(leaves, s.label("join"))
+}
+
+// call interprets a call of definition name from leaf, and returns the leaves
+// of the paths falling through.
+func (s *stubber) call(name string, env stubEnv, leaf *sesstype.Node) []*sesstype.Node {
+	if label, ok := s.active[name]; ok { // Recursive call.
+		s.add(leaf, sesstype.NewGotoNode(label))
+		return nil
+	}
+	label := s.label(name)
+	s.active[name] = label
+	defer delete(s.active, name)
+	return s.stmts(s.defs[name].Stmts, env, s.add(leaf, sesstype.NewLabelNode(label)))
+}
+
+// spawn interprets definition name as a new role.
+func (s *stubber) spawn(name string, env stubEnv) {
+	if !s.caller.enterGo(s.pos) {
+		return
+	}
+	role := s.caller.env.session.GetRole(s.label(name))
+	root := sesstype.NewLabelNode(role.Name())
+	spawned := &stubber{caller: s.caller, defs: s.defs, pos: s.pos, role: role, active: make(map[string]string)}
+	spawned.call(name, env, &root)
+	s.caller.env.session.Types[role] = root
+	fmt.Fprintf(s.caller.env.extract.Log, "@@ spawn %s as %s\n", name, role.Name())
+}
+
+// bind returns the environment of definition name called with params.
+func (s *stubber) bind(name string, params []*migo.Parameter, env stubEnv) stubEnv {
+	callee := make(stubEnv)
+	for i, p := range s.defs[name].Params {
+		if i < len(params) {
+			callee[p.Callee.Name()] = env[params[i].Caller.Name()]
+		}
+	}
+	return callee
+}
+
+// lookup returns the channel bound to name, or nil (with a warning) if unbound.
+func (s *stubber) lookup(name string, env stubEnv) *sesstype.Chan {
+	ch, ok := env[name]
+	if !ok || ch == nil {
+		s.caller.env.extract.warn(s.caller.fn, s.pos, "stub: unknown channel %s (ignored)", name)
+	}
+	return ch
+}
+
+// stmts interprets stmts from leaf, and returns the leaves of the paths
+// falling through.
+func (s *stubber) stmts(stmts []migo.Statement, env stubEnv, leaf *sesstype.Node) []*sesstype.Node {
+	for i, stmt := range stmts {
+		rest := stmts[i+1:]
+		switch stmt := stmt.(type) {
+		case *migo.SendStatement:
+			if ch := s.lookup(stmt.Chan, env); ch != nil {
+				leaf = s.add(leaf, sesstype.NewSendNode(s.role, *ch, ch.Type()))
+			}
+		case *migo.RecvStatement:
+			if ch := s.lookup(stmt.Chan, env); ch != nil {
+				leaf = s.add(leaf, sesstype.NewRecvNode(*ch, s.role, ch.Type()))
+			}
+		case *migo.CloseStatement:
+			if ch := s.lookup(stmt.Chan, env); ch != nil {
+				leaf = s.add(leaf, sesstype.NewEndNode(*ch))
+			}
+		case *migo.NewChanStatement:
+			vd := s.caller.env.vers.NewDef(stubChanValue{utils.EmptyValue{T: stubChanType}, s.label(stmt.Chan), s.caller.fn})
+			ch := s.caller.env.session.MakeBufChan(vd, s.role, stmt.Size)
+			s.caller.env.chans[vd] = &ch
+			scope := make(stubEnv, len(env)+1)
+			for k, v := range env {
+				scope[k] = v
+			}
+			scope[stmt.Name.Name()] = &ch
+			env = scope
+			leaf = s.add(leaf, sesstype.NewNewChanNode(ch))
+		case *migo.CallStatement:
+			var leaves []*sesstype.Node
+			for _, l := range s.call(stmt.Name, s.bind(stmt.Name, stmt.Params, env), leaf) {
+				leaves = append(leaves, s.stmts(rest, env, l)...)
+			}
+			return leaves
+		case *migo.SpawnStatement:
+			s.spawn(stmt.Name, s.bind(stmt.Name, stmt.Params, env))
+		case *migo.IfStatement:
+			var leaves []*sesstype.Node
+			for _, branch := range [][]migo.Statement{stmt.Then, stmt.Else} {
+				l := s.add(leaf, &sesstype.EmptyBodyNode{})
+				leaves = append(leaves, s.stmts(append(append([]migo.Statement{}, branch...), rest...), env, l)...)
+			}
+			return leaves
+		case *migo.SelectStatement:
+			var leaves []*sesstype.Node
+			for _, c := range stmt.Cases {
+				l, body := s.guard(c, env, leaf)
+				leaves = append(leaves, s.stmts(append(append([]migo.Statement{}, body...), rest...), env, l)...)
+			}
+			return leaves
+		}
+	}
+	return []*sesstype.Node{leaf}
+}
+
+// guard appends the node of the first statement of a select case to leaf, and
+// returns the new leaf and the rest of the case.
+func (s *stubber) guard(c []migo.Statement, env stubEnv, leaf *sesstype.Node) (*sesstype.Node, []migo.Statement) {
+	if len(c) > 0 {
+		switch stmt := c[0].(type) {
+		case *migo.SendStatement:
+			if ch := s.lookup(stmt.Chan, env); ch != nil {
+				return s.add(leaf, sesstype.NewSelectSendNode(s.role, *ch, ch.Type())), c[1:]
+			}
+		case *migo.RecvStatement:
+			if ch := s.lookup(stmt.Chan, env); ch != nil {
+				return s.add(leaf, sesstype.NewSelectRecvNode(*ch, s.role, ch.Type())), c[1:]
+			}
+		case *migo.TauStatement:
+			return s.add(leaf, &sesstype.EmptyBodyNode{}), c[1:]
+		}
+	}
+	return s.add(leaf, &sesstype.EmptyBodyNode{}), c
+}
@@ -0,0 +1,131 @@
+package cfsmextract
+
+// Functions for handling sync.WaitGroup.
+//
+// The sync package is not built (see ssabuilder), so a WaitGroup is encoded as
+// a buffered channel, where Done sends and Wait receives once for each pending
+// Add (the constant counts are resolved by constant propagation). The buffer
+// size is the maximum pending count, so Done never blocks, and Wait blocks
+// forever if a Done is missing.
+//
+// The pending counts are restored at the start of each branch, so an Add in a
+// branch is counted on the paths of the branch only. A block joining branches
+// is visited once, with the counts of the first branch.
+
+import (
+	"fmt"
+	"go/types"
+
+	"github.com/damifur/dingo-hunter/cfsmextract/sesstype"
+	"github.com/damifur/dingo-hunter/cfsmextract/utils"
+	"golang.org/x/tools/go/ssa"
+)
+
+// waitGroupChanType is the type of WaitGroup channels.
+var waitGroupChanType = types.NewChan(types.SendRecv, types.NewStruct(nil, nil))
+
+// waitGroupValue is the channel value of a WaitGroup.
+type waitGroupValue struct {
+	ssa.Value // Pointer to the WaitGroup.
+}
+
+func (v waitGroupValue) Type() types.Type { return waitGroupChanType }
+
+// waitGroup is the channel of a WaitGroup.
+type waitGroup struct {
+	def     *utils.Definition // Definition of the channel.
+	ch      *sesstype.Chan
+	pending int64 // Count of pending Add (since last Wait).
+	size    int64 // Maximum pending count.
+}
+
+// isWaitGroup returns true if t is a pointer to sync.WaitGroup.
+func isWaitGroup(t types.Type) bool {
+	ptr, ok := t.Underlying().(*types.Pointer)
+	if !ok {
+		return false
+	}
+	named, ok := ptr.Elem().(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == "sync" && named.Obj().Name() == "WaitGroup"
+}
+
+// callSync models a call of a sync.WaitGroup method, returns false if common
+// does not call such a method.
+func (caller *frame) callSync(common *ssa.CallCommon) bool {
+	fn := common.StaticCallee()
+	if fn == nil || fn.Signature.Recv() == nil || len(common.Args) == 0 || !isWaitGroup(fn.Signature.Recv().Type()) {
+		return false
+	}
+	switch fn.Name() {
+	case "Add", "Done", "Wait":
+	default:
+		return false
+	}
+	wg := caller.waitGroup(common.Args[0])
+	role := caller.gortn.role
+	switch fn.Name() {
+	case "Add":
+		n, ok := caller.constInt(common.Args[1])
+		if !ok {
+			n = 1
+			caller.env.extract.warn(caller.fn, common.Pos(), "Add: non-constant count %s (assuming 1)", common.Args[1].Name())
+		}
+		if n < 0 { // Add(-n) is n Done.
+			for i := int64(0); i < -n; i++ {
+				caller.gortn.AddNode(sesstype.NewSendNode(role, *wg.ch, waitGroupChanType))
+			}
+			break
+		}
+		wg.pending += n
+		if wg.pending > wg.size {
+			wg.size = wg.pending
+			caller.env.session.SetChanSize(wg.def, wg.size)
+		}
+	case "Done":
+		caller.gortn.AddNode(sesstype.NewSendNode(role, *wg.ch, waitGroupChanType))
+	case "Wait":
+		for i := int64(0); i < wg.pending; i++ {
+			caller.gortn.AddNode(sesstype.NewRecvNode(*wg.ch, role, waitGroupChanType))
+		}
+		wg.pending = 0
+	}
+	fmt.Fprintf(caller.env.extract.Log, "++ call %s(%s channel %s)\n", orange(fn.String()), green(common.Args[0].Name()), wg.ch.Name())
+	return true
+}
+
+// waitGroup returns the channel of WaitGroup v, the channel is created when
+// the WaitGroup is first used.
+func (caller *frame) waitGroup(v ssa.Value) *waitGroup {
+	vd, kind := caller.get(v)
+	if kind == Nothing {
+		vd = caller.env.vers.NewDef(v)
+		caller.locals[v] = vd
+	}
+	if wg, ok := caller.env.waitGroups[vd]; ok {
+		return wg
+	}
+	chVd := caller.env.vers.NewDef(waitGroupValue{v})
+	ch := caller.env.session.MakeBufChan(chVd, caller.gortn.role, 0)
+	wg := &waitGroup{def: chVd, ch: &ch}
+	caller.env.waitGroups[vd] = wg
+	caller.gortn.AddNode(sesstype.NewNewChanNode(ch))
+	fmt.Fprintf(caller.env.extract.Log, "   New channel %s for sync.WaitGroup %s by %s at %s\n", green(ch.Name()), reg(v), vd.String(), loc(caller, v.Pos()))
+	return wg
+}
+
+// pendingCounts returns the pending counts of the WaitGroups.
+func (env *environ) pendingCounts() map[*waitGroup]int64 {
+	counts := make(map[*waitGroup]int64, len(env.waitGroups))
+	for _, wg := range env.waitGroups {
+		counts[wg] = wg.pending
+	}
+	return counts
+}
+
+// restorePending restores the pending counts of the WaitGroups to counts, the
+// WaitGroups created since have no pending Add.
+func (env *environ) restorePending(counts map[*waitGroup]int64) {
+	for _, wg := range env.waitGroups {
+		wg.pending = counts[wg]
+	}
+}
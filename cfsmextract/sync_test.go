@@ -0,0 +1,64 @@
+package cfsmextract
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/damifur/dingo-hunter/ssabuilder"
+)
+
+// TestWaitGroup checks the session of WaitGroups, which are channels with a
+// slot per pending count (resolved by constant propagation), where Done sends
+// and Wait receives each count.
+func TestWaitGroup(t *testing.T) {
+	const src = `package main
+
+import "sync"
+
+func worker(wg *sync.WaitGroup) { wg.Done() }
+
+func add(wg *sync.WaitGroup, n int) { wg.Add(n) }
+
+func main() {
+	var wg sync.WaitGroup
+	%s
+}
+`
+	for _, tc := range []struct {
+		body string
+		role string
+		want string
+	}{
+		{"wg.Add(2); go worker(&wg); go worker(&wg); wg.Wait()", "main", "label; newchan main.main.t0@0; recv main.main.t0@0; recv main.main.t0@0"},
+		{"wg.Add(2); go worker(&wg); go worker(&wg); wg.Wait()", "worker_177", "label; send main.main.t0@0"},
+		{"add(&wg, 2); wg.Wait()", "main", "label; newchan main.add.wg@0; recv main.add.wg@0; recv main.add.wg@0"},
+	} {
+		extract := New(build(t, fmt.Sprintf(src, tc.body), ssabuilder.StaticDispatch), "", "")
+		session := run(t, extract)
+		if got := nodes(t, session, tc.role); got != tc.want {
+			t.Errorf("%s: expecting %s %q, got %q", tc.body, tc.role, tc.want, got)
+		}
+	}
+}
+
+// TestWaitGroupStuck checks that a Wait for more counts than are done is
+// stuck.
+func TestWaitGroupStuck(t *testing.T) {
+	const src = `package main
+
+import "sync"
+
+func worker(wg *sync.WaitGroup) { wg.Done() }
+
+func main() {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go worker(&wg)
+	wg.Wait()
+}
+`
+	extract := New(build(t, src, ssabuilder.StaticDispatch), "", "")
+	if s := stuck(run(t, extract)); len(s) == 0 {
+		t.Errorf("expecting stuck CFSMs")
+	}
+}
@@ -0,0 +1,100 @@
+package cfsmextract
+
+// Functions for handling timers and tickers of package time.
+//
+// The time package is not built (see ssabuilder), so the channel of a timer
+// (time.After or Timer.C) or a ticker (time.Tick or Ticker.C) is a channel
+// machine which sends to any role at any time (see sesstype.MakeTimerChan).
+// Stop sends STOP to the machine and Reset sends a message to it.
+
+import (
+	"fmt"
+	"go/types"
+
+	"github.com/damifur/dingo-hunter/cfsmextract/sesstype"
+	"github.com/damifur/dingo-hunter/cfsmextract/utils"
+	"golang.org/x/tools/go/ssa"
+)
+
+// clockValue is the channel value of a Timer or Ticker.
+type clockValue struct {
+	ssa.Value            // Call creating the Timer or Ticker.
+	typ       types.Type // <-chan time.Time.
+}
+
+func (v clockValue) Type() types.Type { return v.typ }
+
+// callTimer models a call of a timer or ticker function of package time, or
+// of a Timer or Ticker method, returns false if common is not such a call.
+// The results are stored in call (nil if the call is deferred).
+func (caller *frame) callTimer(call *ssa.Call, common *ssa.CallCommon) bool {
+	fn := common.StaticCallee()
+	if fn == nil || fn.Pkg == nil || fn.Pkg.Pkg.Path() != "time" {
+		return false
+	}
+	if fn.Signature.Recv() != nil {
+		return caller.callTimerMethod(fn, common)
+	}
+	if call == nil {
+		return false
+	}
+	switch fn.Name() {
+	case "After", "Tick":
+		vd := caller.makeClock(call, call.Type(), fn.Name() == "Tick")
+		caller.locals[call] = vd
+	case "NewTimer", "NewTicker":
+		stype, ok := deref(call.Type()).Underlying().(*types.Struct)
+		if !ok {
+			return false
+		}
+		for i := 0; i < stype.NumFields(); i++ {
+			if stype.Field(i).Name() != "C" {
+				continue
+			}
+			vd := caller.env.vers.NewDef(call)
+			chVd := caller.makeClock(call, stype.Field(i).Type(), fn.Name() == "NewTicker")
+			caller.env.structs[vd] = Fields{i: chVd}
+			caller.env.timers[vd] = caller.env.chans[chVd]
+			caller.locals[call] = vd
+		}
+		if _, ok := caller.locals[call]; !ok {
+			return false
+		}
+	default:
+		return false
+	}
+	fmt.Fprintf(caller.env.extract.Log, "++ call %s\n", orange(fn.String()))
+	return true
+}
+
+// callTimerMethod models a call of Stop or Reset of a Timer or Ticker.
+func (caller *frame) callTimerMethod(fn *ssa.Function, common *ssa.CallCommon) bool {
+	switch fn.Name() {
+	case "Stop", "Reset":
+	default:
+		return false
+	}
+	vd, _ := caller.get(common.Args[0])
+	ch, ok := caller.env.timers[vd]
+	if !ok {
+		return false
+	}
+	if fn.Name() == "Stop" {
+		caller.gortn.AddNode(sesstype.NewEndNode(*ch))
+	} else {
+		caller.gortn.AddNode(sesstype.NewSendNode(caller.gortn.role, *ch, ch.Type()))
+	}
+	fmt.Fprintf(caller.env.extract.Log, "++ call %s(%s channel %s)\n", orange(fn.String()), green(common.Args[0].Name()), ch.Name())
+	return true
+}
+
+// makeClock creates the channel of a Timer (or a Ticker if ticker is true)
+// made by v, where the channel is of type t.
+func (caller *frame) makeClock(v ssa.Value, t types.Type, ticker bool) *utils.Definition {
+	chVd := caller.env.vers.NewDef(clockValue{v, t})
+	ch := caller.env.session.MakeTimerChan(chVd, caller.gortn.role, ticker)
+	caller.env.chans[chVd] = &ch
+	caller.gortn.AddNode(sesstype.NewNewChanNode(ch))
+	fmt.Fprintf(caller.env.extract.Log, "   New channel %s for %s at %s\n", green(ch.Name()), reg(v), loc(caller, v.Pos()))
+	return chVd
+}
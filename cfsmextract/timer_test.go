@@ -0,0 +1,62 @@
+package cfsmextract
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/damifur/dingo-hunter/ssabuilder"
+)
+
+// TestTimer checks the session of timers and tickers, whose channels send to
+// any role at any time, where Stop closes the channel and Reset sends to it.
+func TestTimer(t *testing.T) {
+	const src = `package main
+
+import "time"
+
+func main() {
+	%s
+}
+`
+	for _, tc := range []struct {
+		body string
+		want string
+	}{
+		{"<-time.After(time.Second)", "label; newchan main.main.t0@0; recv main.main.t0@0"},
+		{"<-time.Tick(time.Second)", "label; newchan main.main.t0@0; recv main.main.t0@0"},
+		{"t := time.NewTimer(time.Second); t.Stop()", "label; newchan main.main.t0@0; close main.main.t0@0"},
+		{"t := time.NewTimer(time.Second); <-t.C; t.Reset(time.Second); t.Stop()", "label; newchan main.main.t0@0; recv main.main.t0@0; send main.main.t0@0; close main.main.t0@0"},
+		{"tick := time.NewTicker(time.Second); <-tick.C; tick.Stop()", "label; newchan main.main.t0@0; recv main.main.t0@0; close main.main.t0@0"},
+	} {
+		extract := New(build(t, fmt.Sprintf(src, tc.body), ssabuilder.StaticDispatch), "", "")
+		if got := nodes(t, run(t, extract), "main"); got != tc.want {
+			t.Errorf("%s: expecting main %q, got %q", tc.body, tc.want, got)
+		}
+	}
+}
+
+// TestTimerStuck checks that a receiver left blocked by a send protected by a
+// timeout which fires first is stuck.
+func TestTimerStuck(t *testing.T) {
+	const src = `package main
+
+import "time"
+
+func main() {
+	ch := make(chan int)
+	go func() {
+		<-ch
+	}()
+	t := time.NewTimer(time.Second)
+	select {
+	case ch <- 1:
+		t.Stop()
+	case <-t.C:
+	}
+}
+`
+	extract := New(build(t, src, ssabuilder.StaticDispatch), "", "")
+	if s := stuck(run(t, extract)); len(s) == 0 {
+		t.Errorf("expecting stuck CFSMs")
+	}
+}
@@ -3,12 +3,15 @@ package utils
 import (
 	"fmt"
 
+	"github.com/damifur/dingo-hunter/ssabuilder"
 	"golang.org/x/tools/go/ssa"
 )
 
-var (
-	VarVers = make(map[ssa.Value]int)
-)
+// Versions holds the latest version of the definitions of each variable.
+//
+// Each analysis has its own Versions, so definitions are numbered
+// independently of other analyses.
+type Versions map[ssa.Value]int
 
 // Variable definitions
 type Definition struct {
@@ -16,25 +19,34 @@ type Definition struct {
 	Ver int
 }
 
-// NewVarDef creates a new variable definition from an ssa.Value
-func NewDef(v ssa.Value) *Definition {
+// NewVarDef creates a new variable definition from an ssa.Value. The current
+// function is skipped (see ssabuilder.UnsupportedError) if v is nil.
+func (vers Versions) NewDef(v ssa.Value) *Definition {
 	if v == nil {
-		panic("NewVarDef: Cannot create new VarDef with nil")
+		panic(ssabuilder.NewUnsupportedError(nil, nil, "definition of nil value"))
 	}
-	if ver, ok := VarVers[v]; ok {
-		VarVers[v]++
+	if ver, ok := vers[v]; ok {
+		vers[v]++
 		return &Definition{
 			Var: v,
 			Ver: ver + 1,
 		}
 	}
-	VarVers[v] = 0
+	vers[v] = 0
 	return &Definition{
 		Var: v,
 		Ver: 0,
 	}
 }
 
+// NewDef creates a new (unversioned) variable definition from an ssa.Value.
+func NewDef(v ssa.Value) *Definition {
+	if v == nil {
+		panic(ssabuilder.NewUnsupportedError(nil, nil, "definition of nil value"))
+	}
+	return &Definition{Var: v}
+}
+
 func (vd *Definition) String() string {
 	if vd == nil || vd.Var == nil {
 		return "Undefined"
@@ -0,0 +1,68 @@
+package cfsmextract
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/damifur/dingo-hunter/ssabuilder"
+)
+
+// TestBufferSize checks that channel buffer sizes are resolved by constant
+// propagation, otherwise given by the BufferPolicy.
+func TestBufferSize(t *testing.T) {
+	const src = `package main
+
+var n int
+
+func send(size int) {
+	ch := make(chan int, size)
+	ch <- 1
+	<-ch
+}
+
+func main() {
+	%s
+}
+`
+	for _, tc := range []struct {
+		body   string
+		policy ssabuilder.BufferPolicy
+		size   int64
+	}{
+		{"send(1)", ssabuilder.BufferZero, 1},
+		{"send(0)", ssabuilder.BufferOne, 0},
+		{"send(n)", ssabuilder.BufferZero, 0},
+		{"send(n)", ssabuilder.BufferOne, 1},
+		{"send(n)", ssabuilder.BufferUnbounded, ssabuilder.UnboundedBuf},
+	} {
+		extract := New(build(t, fmt.Sprintf(src, tc.body), ssabuilder.StaticDispatch), "", "")
+		extract.BufferPolicy = tc.policy
+		session := run(t, extract)
+		if len(session.Chans) != 1 {
+			t.Fatalf("%s: expecting 1 channel, got %d", tc.body, len(session.Chans))
+		}
+		for _, ch := range session.Chans {
+			if ch.Size() != tc.size {
+				t.Errorf("%s (policy %s): expecting buffer size %d, got %d", tc.body, tc.policy, tc.size, ch.Size())
+			}
+		}
+	}
+}
+
+// TestBufferSizeStuck checks that a goroutine receiving its own message on an
+// unbuffered channel is stuck, whatever the BufferPolicy.
+func TestBufferSizeStuck(t *testing.T) {
+	const src = `package main
+
+func main() {
+	ch := make(chan int, 0)
+	ch <- 1
+	<-ch
+}
+`
+	extract := New(build(t, src, ssabuilder.StaticDispatch), "", "")
+	extract.BufferPolicy = ssabuilder.BufferOne
+	if s := stuck(run(t, extract)); len(s) == 0 {
+		t.Errorf("expecting stuck CFSMs")
+	}
+}
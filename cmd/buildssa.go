@@ -15,11 +15,12 @@
 package cmd
 
 import (
+	"go/token"
+	"io"
 	"log"
 	"os"
 
-	"github.com/damifur/dingo-hunter/logwriter"
-	"github.com/damifur/dingo-hunter/ssabuilder"
+	"github.com/damifur/dingo-hunter/diagnostics"
 	"github.com/spf13/cobra"
 )
 
@@ -50,41 +51,40 @@ func init() {
 
 func Build(files []string) {
 
-	logFile, err := RootCmd.PersistentFlags().GetString("log")
+	l, err := newLogWriter()
 	if err != nil {
 		log.Fatal(err)
 	}
-	noLogging, err := RootCmd.PersistentFlags().GetBool("no-logging")
-	if err != nil {
-		log.Fatal(err)
-	}
-	noColour, err := RootCmd.PersistentFlags().GetBool("no-colour")
-	if err != nil {
-		log.Fatal(err)
-	}
-	l := logwriter.NewFile(logFile, !noLogging, !noColour)
-	if err := l.Create(); err != nil {
-		log.Fatal(err)
-	}
 	defer l.Cleanup()
 
-	conf, err := ssabuilder.NewConfig(files)
+	conf, err := newBuildConfig(files)
 	if err != nil {
 		log.Fatal(err)
 	}
 	conf.BuildLog = l.Writer
 	ssainfo, err := conf.Build()
 	if err != nil {
-		log.Fatal(err)
+		fatal("build", err)
+	}
+	var diags []diagnostics.Diagnostic
+	for _, path := range ssainfo.IgnoredPkgs {
+		_, reason := conf.PackagePolicy(ssainfo.Prog.ImportedPackage(path).Pkg)
+		diags = append(diags, diagnostics.New(diagnostics.Info, "build", token.Position{}, "package %s not analysed: %s", path, reason))
+	}
+	// Keep stdout for the diagnostics if they are not written as text.
+	var out io.Writer = os.Stdout
+	if outFormat != formatText {
+		out = os.Stderr
 	}
 	if dumpSSA {
-		if _, err := ssainfo.WriteTo(os.Stdout); err != nil {
+		if _, err := ssainfo.WriteTo(out); err != nil {
 			log.Fatal(err)
 		}
 	}
 	if dumpAll {
-		if _, err := ssainfo.WriteAll(os.Stdout); err != nil {
+		if _, err := ssainfo.WriteAll(out); err != nil {
 			log.Fatal(err)
 		}
 	}
+	writeDiagnostics(diags)
 }
@@ -15,17 +15,30 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"log"
+	"strconv"
 
+	"github.com/damifur/dingo-hunter/analyser"
+	"github.com/damifur/dingo-hunter/cfsmcheck"
 	"github.com/damifur/dingo-hunter/cfsmextract"
-	"github.com/damifur/dingo-hunter/logwriter"
+	"github.com/damifur/dingo-hunter/cfsmextract/sesstype"
+	"github.com/damifur/dingo-hunter/diagnostics"
 	"github.com/damifur/dingo-hunter/ssabuilder"
+	"github.com/damifur/dingo-hunter/stubspec"
 	"github.com/spf13/cobra"
+	"golang.org/x/tools/go/ssa"
 )
 
 var (
-	prefix string // Output files prefix
-	outdir string // CFMSs output directory
+	prefix    string // Output files prefix
+	outdir    string // CFMSs output directory
+	gmcPath   string // Path to GMC executable
+	cfsmCheck bool   // Check the CFSMs with the built-in GMC check
+
+	cfsmStubs *stubspec.Spec // Stub specifications
 )
 
 // cfsmsCmd represents the analyse command
@@ -35,7 +48,14 @@ var cfsmsCmd = &cobra.Command{
 	Long: `Extract CFSMs from source code
 
 The inputs should be a list of .go files in the same directory (of package main)
-One of the .go file should contain the main function.`,
+One of the .go file should contain the main function.
+
+Alternatively, the inputs can be package patterns (e.g. ./cmd/server/...)
+which are loaded with the go command, so Go modules are supported.
+
+To analyse packages without main function, use --root or --lib to select the
+analysis roots. One set of CFSMs is extracted for each root, the name of the
+root is appended to the output files prefix.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		extractCFSMs(args)
 	},
@@ -44,46 +64,99 @@ One of the .go file should contain the main function.`,
 func init() {
 	cfsmsCmd.Flags().StringVar(&prefix, "prefix", "output", "Output files prefix")
 	cfsmsCmd.Flags().StringVar(&outdir, "outdir", "third_party/gmc-synthesis/inputs", "Output directory for CFSMs")
+	cfsmsCmd.Flags().StringVar(&gmcPath, "gmc", "", "path to GMC executable to check the extracted CFSMs")
+	cfsmsCmd.Flags().BoolVar(&cfsmCheck, "check", false, "check the extracted CFSMs with the built-in GMC check")
+	cfsmsCmd.Flags().StringVar(&unknownBuf, "unknown-buffer", "0", "buffer size of channels with non-constant size (0, 1 or unbounded)")
+	addRootFlags(cfsmsCmd)
+	addBudgetFlags(cfsmsCmd)
+	addStubFlags(cfsmsCmd)
 
 	RootCmd.AddCommand(cfsmsCmd)
 }
 
 func extractCFSMs(files []string) {
-	logFile, err := RootCmd.PersistentFlags().GetString("log")
-	if err != nil {
-		log.Fatal(err)
-	}
-	noLogging, err := RootCmd.PersistentFlags().GetBool("no-logging")
-	if err != nil {
-		log.Fatal(err)
-	}
-	noColour, err := RootCmd.PersistentFlags().GetBool("no-colour")
+	l, err := newLogWriter()
 	if err != nil {
 		log.Fatal(err)
 	}
-	l := logwriter.NewFile(logFile, !noLogging, !noColour)
-	if err := l.Create(); err != nil {
-		log.Fatal(err)
-	}
 	defer l.Cleanup()
 
-	conf, err := ssabuilder.NewConfig(files)
+	conf, err := newBuildConfig(files)
 	conf.BuildLog = l.Writer
 	if err != nil {
 		log.Fatal(err)
 	}
 	ssainfo, err := conf.Build()
 	if err != nil {
-		log.Fatal(err)
+		fatal("build", err)
+	}
+	roots, err := analysisRoots(ssainfo)
+	if err != nil {
+		fatal("cfsms", err)
 	}
+	cfsmStubs = loadStubs()
+	ctx, cancel := budgetContext()
+	defer cancel()
+	if len(roots) == 0 {
+		writeDiagnostics(runCFSMs(ctx, ssainfo, nil, prefix, l.Writer))
+		return
+	}
+	var diags []diagnostics.Diagnostic
+	for _, root := range roots {
+		diags = append(diags, runCFSMs(ctx, ssainfo, root, prefix+"_"+rootName(root), l.Writer)...)
+	}
+	writeDiagnostics(diags)
+}
+
+// runCFSMs extracts CFSMs from root (main.main if nil) and writes them to
+// files with the given prefix. Returns the diagnostics of the extraction, which
+// stops early if ctx is done.
+func runCFSMs(ctx context.Context, ssainfo *ssabuilder.SSAInfo, root *ssa.Function, prefix string, logw io.Writer) []diagnostics.Diagnostic {
 	extract := cfsmextract.New(ssainfo, prefix, outdir)
-	go extract.Run()
+	extract.Root = root
+	if outFormat != formatText {
+		extract.Output = logw
+	}
+	extract.Budget = extractBudget()
+	extract.BufferPolicy = bufferPolicy("cfsms")
+	extract.Jobs = jobs
+	extract.Stubs = cfsmStubs
+	go extract.RunContext(ctx)
 
 	select {
-	case <-extract.Error:
-		log.Fatal(err)
+	case err := <-extract.Error:
+		fatal("cfsms", err)
 	case <-extract.Done:
 		log.Println("Analysis finished in", extract.Time)
-		extract.WriteOutput()
+		if err := extract.WriteOutput(); err != nil {
+			fatal("cfsms", err)
+		}
+	}
+	if root == nil {
+		root = ssabuilder.MainPkg(ssainfo.Prog).Func("main")
+	}
+	diags := extract.Diagnostics
+	if len(extract.Partial) > 0 && (cfsmCheck || gmcPath != "") {
+		return append(diags, partialResult("cfsms", root))
+	}
+	if cfsmCheck {
+		diags = append(diags, checkCFSMs(extract.CFSMs, root)...)
+	}
+	if gmcPath != "" {
+		diags = append(diags, runChecker("synthesis", root, gmcPath, extract.CFSMPath, strconv.Itoa(extract.NumChans))...)
+	}
+	return diags
+}
+
+// checkCFSMs runs the built-in GMC check on the CFSMs and returns the results
+// as diagnostics positioned at root.
+func checkCFSMs(cfsms *sesstype.CFSMs, root *ssa.Function) []diagnostics.Diagnostic {
+	res := analyser.CheckCFSMs(cfsms, cfsmcheck.Options{})
+	diags := analyser.CFSMDiagnostics(root, res)
+	if outFormat == formatText {
+		for _, d := range diags {
+			fmt.Println(d.Message)
+		}
 	}
+	return diags
 }
@@ -18,8 +18,6 @@ import (
 	"log"
 
 	"github.com/damifur/dingo-hunter/fairness"
-	"github.com/damifur/dingo-hunter/logwriter"
-	"github.com/damifur/dingo-hunter/ssabuilder"
 	"github.com/spf13/cobra"
 )
 
@@ -41,32 +39,24 @@ func init() {
 }
 
 func check(files []string) {
-	logFile, err := RootCmd.PersistentFlags().GetString("log")
+	l, err := newLogWriter()
 	if err != nil {
 		log.Fatal(err)
 	}
-	noLogging, err := RootCmd.PersistentFlags().GetBool("no-logging")
-	if err != nil {
-		log.Fatal(err)
-	}
-	noColour, err := RootCmd.PersistentFlags().GetBool("no-colour")
-	if err != nil {
-		log.Fatal(err)
-	}
-	l := logwriter.NewFile(logFile, !noLogging, !noColour)
-	if err := l.Create(); err != nil {
-		log.Fatal(err)
-	}
 	defer l.Cleanup()
 
-	conf, err := ssabuilder.NewConfig(files)
+	conf, err := newBuildConfig(files)
 	if err != nil {
 		log.Fatal(err)
 	}
 	conf.BuildLog = l.Writer
 	ssainfo, err := conf.Build()
 	if err != nil {
-		log.Fatal(err)
+		fatal("build", err)
+	}
+	if outFormat == formatText {
+		fairness.Check(ssainfo)
+		return
 	}
-	fairness.Check(ssainfo)
+	writeDiagnostics(fairness.CheckLog(ssainfo, l.Writer))
 }
@@ -0,0 +1,168 @@
+// Copyright © 2016 Nicholas Ng <nickng@projectfate.org>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/damifur/dingo-hunter/ssabuilder"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Show the effective configuration",
+	Long: `Show the effective configuration
+
+The policies of packages are the defaults updated by the "packages" entries of
+the config file (.dingo-hunter.yaml in the current or home directory), e.g.
+
+  packages:
+    - package: math/rand  # Package name or import path.
+      policy: pure        # analyse, skip, opaque or pure.
+      reason: No channels
+
+The "stubs" entries are stub specification files (or directories of *.migo
+files) of functions which are not analysed, relative to the config file, e.g.
+
+  stubs:
+    - stubs/acme.migo
+
+The output is a config file with the effective policies and stubs (or JSON
+with --format=json).`,
+	Run: func(cmd *cobra.Command, args []string) {
+		showConfig()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(configCmd)
+}
+
+// packageConfig is a "packages" entry of the config file.
+type packageConfig struct {
+	Package string `mapstructure:"package" json:"package"`
+	Policy  string `mapstructure:"policy" json:"policy"`
+	Reason  string `mapstructure:"reason" json:"reason,omitempty"`
+}
+
+// packagePolicies returns the policies of packages of the config file.
+func packagePolicies() ([]ssabuilder.PackagePolicy, error) {
+	var entries []packageConfig
+	if err := viper.UnmarshalKey("packages", &entries); err != nil {
+		return nil, fmt.Errorf("config file %s: packages: %v", viper.ConfigFileUsed(), err)
+	}
+	policies := make([]ssabuilder.PackagePolicy, len(entries))
+	for i, e := range entries {
+		if e.Package == "" {
+			return nil, fmt.Errorf("config file %s: packages[%d]: no package given", viper.ConfigFileUsed(), i)
+		}
+		p, err := ssabuilder.ParsePolicy(e.Policy)
+		if err != nil {
+			return nil, fmt.Errorf("config file %s: package %s: %v", viper.ConfigFileUsed(), e.Package, err)
+		}
+		policies[i] = ssabuilder.PackagePolicy{Package: e.Package, Policy: p, Reason: e.Reason}
+	}
+	return policies, nil
+}
+
+// setPolicies sets the policies of packages of the config file in conf.
+func setPolicies(conf *ssabuilder.Config) error {
+	policies, err := packagePolicies()
+	if err != nil {
+		return err
+	}
+	for _, p := range policies {
+		conf.SetPolicy(p.Package, p.Policy, p.Reason)
+	}
+	return nil
+}
+
+// stubFiles returns the stub specification files of the config file, where
+// relative paths are relative to the directory of the config file.
+func stubFiles() []string {
+	files := viper.GetStringSlice("stubs")
+	for i, file := range files {
+		if !filepath.IsAbs(file) && viper.ConfigFileUsed() != "" {
+			files[i] = filepath.Join(filepath.Dir(viper.ConfigFileUsed()), file)
+		}
+	}
+	return files
+}
+
+func showConfig() {
+	conf, err := ssabuilder.NewConfigFromString("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := setPolicies(conf); err != nil {
+		log.Fatal(err)
+	}
+	if outFormat == formatJSON {
+		err = writeConfigJSON(os.Stdout, conf)
+	} else {
+		err = writeConfig(os.Stdout, conf)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeConfig writes the effective configuration of conf to w as a config
+// file.
+func writeConfig(w io.Writer, conf *ssabuilder.Config) error {
+	file := viper.ConfigFileUsed()
+	if file == "" {
+		file = "none (defaults)"
+	}
+	fmt.Fprintf(w, "# Config file: %s\n", file)
+	fmt.Fprintln(w, "packages:")
+	for _, p := range conf.Policies() {
+		fmt.Fprintf(w, "  - package: %s\n    policy: %s\n", p.Package, p.Policy)
+		if p.Reason != "" {
+			fmt.Fprintf(w, "    reason: %s\n", strconv.Quote(p.Reason))
+		}
+	}
+	if files := stubFiles(); len(files) > 0 {
+		fmt.Fprintln(w, "stubs:")
+		for _, file := range files {
+			fmt.Fprintf(w, "  - %s\n", file)
+		}
+	}
+	return nil
+}
+
+// writeConfigJSON writes the effective configuration of conf to w as JSON.
+func writeConfigJSON(w io.Writer, conf *ssabuilder.Config) error {
+	entries := []packageConfig{}
+	for _, p := range conf.Policies() {
+		entries = append(entries, packageConfig{Package: p.Package, Policy: string(p.Policy), Reason: p.Reason})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		ConfigFile string          `json:"configFile,omitempty"`
+		Packages   []packageConfig `json:"packages"`
+		Stubs      []string        `json:"stubs,omitempty"`
+	}{viper.ConfigFileUsed(), entries, stubFiles()})
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/damifur/dingo-hunter/ssabuilder"
+	"github.com/spf13/viper"
+)
+
+// readConfig reads config file src (in a temporary directory) with viper, and
+// returns the directory of the config file.
+func readConfig(t *testing.T, src string) string {
+	dir, err := ioutil.TempDir("", "dingo-hunter-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, ".dingo-hunter.yaml")
+	if err := ioutil.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	viper.Reset()
+	viper.SetConfigFile(file)
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// TestConfigPackages checks the policies of the "packages" entries of the
+// config file.
+func TestConfigPackages(t *testing.T) {
+	dir := readConfig(t, `packages:
+  - package: math/rand
+    policy: analyse
+  - package: example.com/acme
+    policy: pure
+    reason: No channels
+`)
+	defer os.RemoveAll(dir)
+	defer viper.Reset()
+
+	conf, err := ssabuilder.NewConfigFromString("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := setPolicies(conf); err != nil {
+		t.Fatal(err)
+	}
+	acme := ssabuilder.PackagePolicy{Package: "example.com/acme", Policy: ssabuilder.Pure, Reason: "No channels"}
+	var found bool
+	for _, p := range conf.Policies() {
+		if p.Package == "math/rand" {
+			t.Errorf("expecting math/rand to be analysed but got %+v", p)
+		}
+		found = found || p == acme
+	}
+	if !found {
+		t.Errorf("expecting policy %+v but got %+v", acme, conf.Policies())
+	}
+}
+
+// TestConfigPackagesError checks that invalid "packages" entries are errors.
+func TestConfigPackagesError(t *testing.T) {
+	for _, src := range []string{
+		"packages:\n  - policy: skip\n",
+		"packages:\n  - package: fmt\n    policy: ignore\n",
+	} {
+		dir := readConfig(t, src)
+		if _, err := packagePolicies(); err == nil {
+			t.Errorf("expecting error for config %q", src)
+		}
+		os.RemoveAll(dir)
+	}
+	viper.Reset()
+}
+
+// TestConfigCommand checks the output of the config command, which is a
+// config file (or JSON) of the effective policies and stubs.
+func TestConfigCommand(t *testing.T) {
+	dir := readConfig(t, `packages:
+  - package: example.com/acme
+    policy: opaque
+    reason: Vendor
+stubs:
+  - stubs/acme.migo
+`)
+	defer os.RemoveAll(dir)
+	defer viper.Reset()
+
+	conf, err := ssabuilder.NewConfigFromString("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := setPolicies(conf); err != nil {
+		t.Fatal(err)
+	}
+	stub := filepath.Join(dir, "stubs", "acme.migo")
+
+	var text bytes.Buffer
+	if err := writeConfig(&text, conf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"  - package: example.com/acme\n    policy: opaque\n    reason: \"Vendor\"\n",
+		"  - package: math/rand\n    policy: skip\n",
+		"stubs:\n  - " + stub + "\n",
+	} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("expecting %q in config but got\n%s", want, text.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := writeConfigJSON(&buf, conf); err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		ConfigFile string
+		Packages   []packageConfig
+		Stubs      []string
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.ConfigFile != viper.ConfigFileUsed() || len(out.Stubs) != 1 || out.Stubs[0] != stub {
+		t.Errorf("expecting config file %s and stubs [%s] but got %+v", viper.ConfigFileUsed(), stub, out)
+	}
+	want := packageConfig{Package: "example.com/acme", Policy: "opaque", Reason: "Vendor"}
+	for _, p := range out.Packages {
+		if p == want {
+			return
+		}
+	}
+	t.Errorf("expecting package %+v but got %+v", want, out.Packages)
+}
@@ -15,17 +15,39 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"go/token"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 
-	"github.com/damifur/dingo-hunter/logwriter"
+	"github.com/damifur/dingo-hunter/analyser"
+	"github.com/damifur/dingo-hunter/diagnostics"
+	"github.com/damifur/dingo-hunter/migocheck"
 	"github.com/damifur/dingo-hunter/migoextract"
 	"github.com/damifur/dingo-hunter/ssabuilder"
+	"github.com/damifur/dingo-hunter/stubspec"
+	"github.com/damifur/dingo-hunter/trace"
+	"github.com/damifur/migo"
 	"github.com/spf13/cobra"
+	"golang.org/x/tools/go/ssa"
 )
 
 var (
-	outfile string // Path to output file
+	outfile     string // Path to output file
+	gongPath    string // Path to Gong executable
+	migoCheck   bool   // Verify MiGo types with the built-in checker
+	migoListing bool   // Show counterexamples as annotated source listing
+	unknownBuf  string // Buffer size of channels with non-constant size
+	rwReaders   int    // Maximum readers holding a RWMutex
+	summaries   bool   // Show the function summary cache
+	cacheDir    string // Directory of the on-disk summary cache
+
+	migoCache *migoextract.Cache // Summary cache of --cache-dir (nil if disabled)
+	migoStubs *stubspec.Spec     // Stub specifications
 )
 
 // migoCmd represents the analyse command
@@ -35,39 +57,44 @@ var migoCmd = &cobra.Command{
 	Long: `Extract MiGo types from source code
 
 The inputs should be a list of .go files in the same directory (of package main)
-One of the .go file should contain the main function.`,
+One of the .go file should contain the main function.
+
+Alternatively, the inputs can be package patterns (e.g. ./cmd/server/...)
+which are loaded with the go command, so Go modules are supported.
+
+To analyse packages without main function, use --root or --lib to select the
+analysis roots. One MiGo program is extracted for each root, if --output is
+given, the name of the root is appended to the output filename.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		extractMigo(args)
 	},
 }
 
 func init() {
-	migoCmd.Flags().StringVar(&outfile, "output", "", "output migo file")
+	migoCmd.Flags().StringVar(&outfile, "output", "", "output migo file (required to write MiGo types with --format=json)")
+	migoCmd.Flags().StringVar(&gongPath, "gong", "", "path to Gong executable to verify the extracted MiGo types")
+	migoCmd.Flags().BoolVar(&migoCheck, "check", false, "verify the extracted MiGo types with the built-in checker")
+	migoCmd.Flags().StringVar(&unknownBuf, "unknown-buffer", "0", "buffer size of channels with non-constant size (0, 1 or unbounded)")
+	migoCmd.Flags().IntVar(&rwReaders, "rw-readers", migoextract.DefaultRWReaders, "maximum number of readers holding a sync.RWMutex at the same time")
+	migoCmd.Flags().BoolVar(&migoListing, "listing", false, "show counterexamples of --check as annotated source listing")
+	migoCmd.Flags().BoolVar(&summaries, "summaries", false, "show the function summary cache (on stderr)")
+	migoCmd.Flags().StringVar(&cacheDir, "cache-dir", "", "directory of the on-disk cache of function summaries reused across runs (disabled if empty)")
+	addRootFlags(migoCmd)
+	addBudgetFlags(migoCmd)
+	addStubFlags(migoCmd)
 
 	RootCmd.AddCommand(migoCmd)
 }
 
 func extractMigo(files []string) {
 
-	logFile, err := RootCmd.PersistentFlags().GetString("log")
-	if err != nil {
-		log.Fatal(err)
-	}
-	noLogging, err := RootCmd.PersistentFlags().GetBool("no-logging")
+	l, err := newLogWriter()
 	if err != nil {
 		log.Fatal(err)
 	}
-	noColour, err := RootCmd.PersistentFlags().GetBool("no-colour")
-	if err != nil {
-		log.Fatal(err)
-	}
-	l := logwriter.NewFile(logFile, !noLogging, !noColour)
-	if err := l.Create(); err != nil {
-		log.Fatal(err)
-	}
 	defer l.Cleanup()
 
-	conf, err := ssabuilder.NewConfig(files)
+	conf, err := newBuildConfig(files)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -84,22 +111,81 @@ func extractMigo(files []string) {
 	//fmt.Println(f.Prog.Fset.Position(f.Pos()))
 	//fmt.Println(children.Prog.Fset.Position(children.Pos())) //f.Prog.Fset.File(f.Pos()))
 	if err != nil {
-		log.Fatal(err)
+		fatal("build", err)
 	}
-	extract, err := migoextract.New(ssainfo, l.Writer)
+	roots, err := analysisRoots(ssainfo)
 	if err != nil {
-		log.Fatal(err)
+		fatal("migo", err)
+	}
+	if cacheDir != "" {
+		if migoCache, err = migoextract.OpenCache(cacheDir); err != nil {
+			fatal("migo", err)
+		}
+	}
+	migoStubs = loadStubs()
+	ctx, cancel := budgetContext()
+	defer cancel()
+	if len(roots) == 0 {
+		writeDiagnostics(runMigo(ctx, ssainfo, nil, l.Writer, outfile))
+		return
+	}
+	var diags []diagnostics.Diagnostic
+	for _, root := range roots {
+		rootOutfile := outfile
+		if outfile != "" {
+			ext := filepath.Ext(outfile)
+			rootOutfile = outfile[:len(outfile)-len(ext)] + "_" + rootName(root) + ext
+		}
+		diags = append(diags, runMigo(ctx, ssainfo, root, l.Writer, rootOutfile)...)
+	}
+	writeDiagnostics(diags)
+}
+
+// bufferPolicy returns the policy of --unknown-buffer, or exits with an error
+// of command cmd if unknown.
+func bufferPolicy(cmd string) ssabuilder.BufferPolicy {
+	policy, ok := ssabuilder.ParseBufferPolicy(unknownBuf)
+	if !ok {
+		fatal(cmd, fmt.Errorf("unknown buffer policy %q (expects 0, 1 or unbounded)", unknownBuf))
+	}
+	return policy
+}
+
+// runMigo extracts MiGo types from root (main.main if nil) and writes them to
+// outfile (stdout if empty and results are written as text). Returns the
+// diagnostics of the extraction, which stops early if ctx is done.
+func runMigo(ctx context.Context, ssainfo *ssabuilder.SSAInfo, root *ssa.Function, inferlog io.Writer, outfile string) []diagnostics.Diagnostic {
+	extract, err := migoextract.New(ssainfo, inferlog)
+	if err != nil {
+		fatal("migo", err)
 	}
-	go extract.Run()
+	extract.Root = root
+	extract.BufferPolicy = bufferPolicy("migo")
+	if rwReaders < 1 {
+		fatal("migo", fmt.Errorf("invalid --rw-readers %d (expects at least 1)", rwReaders))
+	}
+	extract.RWReaders = rwReaders
+	extract.Budget = extractBudget()
+	extract.Jobs = jobs
+	extract.Cache = migoCache
+	extract.Stubs = migoStubs
+	go extract.RunContext(ctx)
 
 	select {
-	case <-extract.Error:
-		log.Fatal(err)
+	case err := <-extract.Error:
+		fatal("migo", err)
 	case <-extract.Done:
 		extract.Logger.Println("Analysis finished in", extract.Time)
 	}
+	if summaries {
+		fmt.Fprint(os.Stderr, extract.Env.Summaries)
+	}
 
 	extract.Env.MigoProg.CleanUp()
+	d := diagnostics.New(diagnostics.Info, "migo", token.Position{}, "MiGo types extracted (%d definitions)", len(extract.Env.MigoProg.Funcs))
+	if root != nil {
+		d.Function = root.String()
+	}
 	if outfile != "" {
 		f, err := os.Create(outfile)
 		if err != nil {
@@ -107,7 +193,57 @@ func extractMigo(files []string) {
 		}
 		f.WriteString(extract.Env.MigoProg.String())
 		defer f.Close()
-	} else {
+		d.File = outfile
+	} else if outFormat == formatText {
 		os.Stdout.WriteString(extract.Env.MigoProg.String())
 	}
+	diags := append(extract.Diagnostics, d)
+	if root == nil {
+		root = ssabuilder.MainPkg(ssainfo.Prog).Func("main")
+	}
+	if len(extract.Partial) > 0 && (migoCheck || gongPath != "") {
+		return append(diags, partialResult("migo", root))
+	}
+	if migoCheck {
+		diags = append(diags, checkMigo(ssainfo, extract.Env.MigoProg, root)...)
+	}
+	if gongPath != "" {
+		migoFile := outfile
+		if migoFile == "" {
+			f, err := ioutil.TempFile("", "migo")
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer os.Remove(f.Name())
+			f.WriteString(extract.Env.MigoProg.String())
+			f.Close()
+			migoFile = f.Name()
+		}
+		diags = append(diags, runChecker("gong", root, gongPath, migoFile)...)
+	}
+	return diags
+}
+
+// checkMigo verifies prog with the built-in checker and returns the violations
+// as diagnostics, located in the Go source with their counterexample traces.
+func checkMigo(ssainfo *ssabuilder.SSAInfo, prog *migo.Program, root *ssa.Function) []diagnostics.Diagnostic {
+	res, err := migocheck.Check(prog, "main.main", migocheck.DefaultOptions)
+	if err != nil {
+		return []diagnostics.Diagnostic{diagnostics.New(diagnostics.Error, "migocheck", token.Position{}, "%v", err)}
+	}
+	diags := analyser.MiGoDiagnostics(ssainfo, root, res)
+	if outFormat == formatText {
+		reporter := trace.NewReporter(ssainfo, root)
+		fmt.Printf("Liveness: %t\nSafety: %t\n", res.Live(), res.Safe())
+		for _, v := range res.Violations {
+			if migoListing {
+				if err := reporter.WriteListing(os.Stdout, v); err != nil {
+					log.Print(err)
+				}
+				continue
+			}
+			reporter.WriteTrace(os.Stdout, v)
+		}
+	}
+	return diags
 }
@@ -15,11 +15,22 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"go/token"
+	"log"
 	"os"
+	"os/exec"
+	"strings"
+	"unicode"
 
+	"github.com/damifur/dingo-hunter/diagnostics"
+	"github.com/damifur/dingo-hunter/logwriter"
+	"github.com/damifur/dingo-hunter/ssabuilder"
+	"github.com/damifur/dingo-hunter/stubspec"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/tools/go/ssa"
 )
 
 var (
@@ -27,6 +38,14 @@ var (
 	logFile   string // Path to log file
 	noLogging bool   // Turn off logging
 	noColour  bool   // Turn of colour output
+	outFormat string // Output format of results
+	dispatch  string // Resolution of dynamic calls
+)
+
+const (
+	formatText  = "text"  // Human readable output (default)
+	formatJSON  = "json"  // JSON diagnostics
+	formatSARIF = "sarif" // SARIF log of diagnostics
 )
 
 // RootCmd represents the base command when called without any subcommands
@@ -36,7 +55,20 @@ var RootCmd = &cobra.Command{
 	Long: `dingo-hunter is a static deadlock detector for Go
 
 This is the toplevel command.
-Use "dingo-hunter [command] sources.go..." to analyse source files`,
+Use "dingo-hunter [command] sources.go..." to analyse source files, or
+"dingo-hunter [command] ./path/to/pkg/..." to analyse packages of a module`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		switch outFormat {
+		case formatText, formatJSON, formatSARIF:
+		default:
+			return fmt.Errorf("unknown output format %q", outFormat)
+		}
+		if _, err := ssabuilder.ParseDispatch(dispatch); err != nil {
+			return err
+		}
+		_, err := packagePolicies()
+		return err
+	},
 }
 
 // Execute adds all child commands to the root command sets flags appropriately.
@@ -51,10 +83,12 @@ func Execute() {
 func init() {
 	cobra.OnInitialize(initConfig)
 
-	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.dingo-hunter.yaml)")
+	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is .dingo-hunter.yaml in the current or $HOME directory)")
 	RootCmd.PersistentFlags().StringVar(&logFile, "log", "", "path to log file (default is stdout)")
 	RootCmd.PersistentFlags().BoolVar(&noLogging, "no-logging", false, "disable logging")
 	RootCmd.PersistentFlags().BoolVar(&noColour, "no-colour", false, "disable colour output")
+	RootCmd.PersistentFlags().StringVar(&outFormat, "format", formatText, "output format of results (text, json or sarif)")
+	RootCmd.PersistentFlags().StringVar(&dispatch, "dispatch", string(ssabuilder.StaticDispatch), "resolution of calls through interfaces and function values (static, cha, vta or pta)")
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -64,11 +98,198 @@ func initConfig() {
 	}
 
 	viper.SetConfigName(".dingo-hunter") // name of config file (without extension)
-	viper.AddConfigPath("$HOME")         // adding home directory as first search path
+	viper.AddConfigPath(".")             // adding project directory as first search path
+	viper.AddConfigPath("$HOME")         // then the home directory
 	viper.AutomaticEnv()                 // read in environment variables that match
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+	}
+}
+
+// newLogWriter creates a log writer using the logging flags. If results are
+// not written as text, logs go to stderr instead of stdout.
+func newLogWriter() (*logwriter.Writer, error) {
+	l := logwriter.NewFile(logFile, !noLogging, !noColour)
+	if logFile == "" && outFormat != formatText {
+		l = logwriter.New(os.Stderr, !noLogging, !noColour)
+	}
+	if err := l.Create(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// writeDiagnostics writes diags to stdout if results are not written as text.
+//
+// In text mode the results are already logged by the analyses.
+func writeDiagnostics(diags []diagnostics.Diagnostic) {
+	var err error
+	switch outFormat {
+	case formatJSON:
+		err = diagnostics.WriteJSON(os.Stdout, diags)
+	case formatSARIF:
+		err = diagnostics.WriteSARIF(os.Stdout, diags)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// fatal reports err of check and exits. If results are not written as text,
+// err is written as an error diagnostic.
+func fatal(check string, err error) {
+	if outFormat != formatText {
+		writeDiagnostics([]diagnostics.Diagnostic{diagnostics.New(diagnostics.Error, check, token.Position{}, "%v", err)})
+		os.Exit(1)
+	}
+	log.Fatal(err)
+}
+
+// runChecker runs an external checker and returns its verdicts as diagnostics
+// at the position of fn (if not nil).
+func runChecker(check string, fn *ssa.Function, name string, args ...string) []diagnostics.Diagnostic {
+	var pos token.Position
+	if fn != nil {
+		pos = fn.Prog.Fset.Position(fn.Pos())
+	}
+	out, err := exec.Command(name, args...).CombinedOutput()
+	diags := diagnostics.ParseVerdicts(check, out)
+	if err != nil && len(diags) == 0 {
+		diags = append(diags, diagnostics.New(diagnostics.Error, check, token.Position{}, "%s failed: %v", name, err))
+	}
+	for i := range diags {
+		diags[i].File, diags[i].Line, diags[i].Column = pos.Filename, pos.Line, pos.Column
+		if fn != nil {
+			diags[i].Function = fn.String()
+		}
+	}
+	if outFormat == formatText {
+		os.Stdout.Write(out)
+	}
+	return diags
+}
+
+// newBuildConfig creates a build configuration for the given command line
+// arguments. Arguments are treated as source files if they all end with .go,
+// otherwise they are treated as (module-aware) package patterns. Test files
+// are loaded if --tests is given, dynamic calls are resolved by --dispatch, and
+// the policies of packages are set by the config file.
+func newBuildConfig(args []string) (*ssabuilder.Config, error) {
+	var (
+		conf *ssabuilder.Config
+		err  error
+	)
+	if allGoFiles(args) {
+		conf, err = ssabuilder.NewConfig(args)
+	} else {
+		conf, err = ssabuilder.NewConfigFromPackages(args)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conf.Tests = testMode
+	if conf.Dispatch, err = ssabuilder.ParseDispatch(dispatch); err != nil {
+		return nil, err
+	}
+	if err := setPolicies(conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+func allGoFiles(args []string) bool {
+	for _, arg := range args {
+		if !strings.HasSuffix(arg, ".go") {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	rootFuncs []string // Functions to use as analysis roots
+	libMode   bool     // Use exported functions as analysis roots
+	testMode  bool     // Use test functions as analysis roots
+)
+
+// addRootFlags adds the flags for selecting analysis roots to cmd.
+func addRootFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&rootFuncs, "root", nil, "function to use as analysis root instead of main.main (e.g. pkg.Func or pkg.(*T).Method)")
+	cmd.Flags().BoolVar(&libMode, "lib", false, "use every exported function of the packages as analysis root")
+	cmd.Flags().BoolVar(&testMode, "tests", false, "load _test.go files and use every TestXxx and ExampleXxx function as analysis root (unless --root is given)")
+}
+
+// analysisRoots returns the analysis roots selected by the --root, --lib and
+// --tests flags, or nil if main.main should be used.
+func analysisRoots(ssainfo *ssabuilder.SSAInfo) ([]*ssa.Function, error) {
+	return ssainfo.Roots(rootFuncs, libMode, testMode)
+}
+
+// rootName returns a name of root function fn usable in filenames.
+func rootName(fn *ssa.Function) string {
+	name := fn.Pkg.Pkg.Name() + "." + fn.RelString(fn.Pkg.Pkg)
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+var (
+	budget ssabuilder.Budget // Resource budget of analyses
+	jobs   int               // Goroutines analysed concurrently
+)
+
+// addBudgetFlags adds the flags of the resource budget of analyses to cmd.
+func addBudgetFlags(cmd *cobra.Command) {
+	cmd.Flags().DurationVar(&budget.Timeout, "timeout", 0, "wall time of the analysis after the build, e.g. 30s (0 is unlimited)")
+	cmd.Flags().IntVar(&budget.MaxBlocks, "max-blocks", 0, "max basic blocks visited per function instance (0 is unlimited)")
+	cmd.Flags().IntVar(&budget.MaxInstances, "max-instances", 0, "max instances analysed per function (0 is unlimited)")
+	cmd.Flags().IntVar(&budget.MaxGoroutines, "max-goroutines", 0, "max goroutines queued for analysis (0 is unlimited)")
+	cmd.Flags().IntVar(&jobs, "jobs", 1, "number of queued goroutines analysed concurrently")
+}
+
+// budgetContext returns the context of all analyses of a command, which is
+// done after --timeout.
+func budgetContext() (context.Context, context.CancelFunc) {
+	return budget.Context(context.Background())
+}
+
+// extractBudget returns the budget of an extraction, where the timeout is
+// applied by the context of the command.
+func extractBudget() ssabuilder.Budget {
+	b := budget
+	b.Timeout = 0
+	return b
+}
+
+var stubPaths []string // Stub specification files (or directories)
+
+// addStubFlags adds the flags of stub specifications to cmd.
+func addStubFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&stubPaths, "stubs", nil, "stub specification files (or directories of *.migo files) of functions which are not analysed")
+}
+
+// loadStubs returns the standard stub specifications updated by those of the
+// config file and of --stubs.
+func loadStubs() *stubspec.Spec {
+	spec, err := stubspec.Load(append(stubFiles(), stubPaths...)...)
+	if err != nil {
+		fatal("stubs", err)
+	}
+	return spec
+}
+
+// partialResult returns a warning that the checks of root are skipped as the
+// extraction is partial.
+func partialResult(check string, root *ssa.Function) diagnostics.Diagnostic {
+	d := diagnostics.New(diagnostics.Warning, check, root.Prog.Fset.Position(root.Pos()), "partial result, checks skipped")
+	d.Function = root.String()
+	if outFormat == formatText {
+		fmt.Println("Partial result, checks skipped")
 	}
+	return d
 }
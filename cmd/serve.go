@@ -16,7 +16,6 @@ package cmd
 
 import (
 	"go/build"
-	"log"
 	"path"
 
 	"github.com/damifur/dingo-hunter/webservice"
@@ -40,29 +39,34 @@ Each example is a Go command (i.e. package main) in a directory, under the examp
 const basePkg = "github.com/damifur/dingo-hunter"
 
 var (
-	addr string // Listen interface.
-	port string // Listen port.
+	addr        string // Listen interface.
+	port        string // Listen port.
+	examplesDir string // Path to examples directory.
+	templateDir string // Path to templates directory.
+	staticDir   string // Path to static files directory.
 )
 
 func init() {
 	RootCmd.AddCommand(serveCmd)
 
-	p, err := build.Default.Import(basePkg, "", build.FindOnly)
-	if err != nil {
-		log.Fatal("Could not find base path")
+	// Outside of GOPATH (e.g. when installed as a module) the base path
+	// cannot be found, default to current directory instead.
+	basePath := "."
+	if p, err := build.Default.Import(basePkg, "", build.FindOnly); err == nil {
+		basePath = p.Dir
 	}
-	basePath := p.Dir
 
 	serveCmd.Flags().StringVar(&addr, "bind", "127.0.0.1", "Bind address. Defaults to 127.0.0.1.")
 	serveCmd.Flags().StringVar(&port, "port", "6060", "Listen port. Defaults to 6060.")
-	serveCmd.Flags().StringVar(&webservice.ExamplesDir, "examples", path.Join(basePath, "examples", "popl17"), "Path to examples directory")
-	serveCmd.Flags().StringVar(&webservice.TemplateDir, "templates", path.Join(basePath, "templates"), "Path to templates directory")
-	serveCmd.Flags().StringVar(&webservice.StaticDir, "static", path.Join(basePath, "static"), "Path to static files directory")
+	serveCmd.Flags().StringVar(&examplesDir, "examples", path.Join(basePath, "examples", "popl17"), "Path to examples directory")
+	serveCmd.Flags().StringVar(&templateDir, "templates", path.Join(basePath, "templates"), "Path to templates directory")
+	serveCmd.Flags().StringVar(&staticDir, "static", path.Join(basePath, "static"), "Path to static files directory")
 }
 
 // Serve starts the HTTP server.
 func Serve() {
 	server := webservice.NewServer(addr, port)
+	server.ExamplesDir, server.TemplateDir, server.StaticDir = examplesDir, templateDir, staticDir
 	server.Start()
 	server.Close()
 }
@@ -0,0 +1,116 @@
+// Package diagnostics provides a machine-readable model of analysis results.
+//
+// Each subcommand reports its findings (e.g. unfair loops, unsupported code,
+// extracted models) as a list of Diagnostic, which can be written as JSON
+// instead of coloured log lines.
+package diagnostics // import "github.com/damifur/dingo-hunter/diagnostics"
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io"
+)
+
+// Severity is the severity of a Diagnostic.
+type Severity int
+
+const (
+	// Info is for informational results, e.g. a model is extracted.
+	Info Severity = iota
+
+	// Warning is for potential problems, or imprecision in the analysis.
+	Warning
+
+	// Error is for problems found, or analysis failures.
+	Error
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Info:
+		return "info"
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// MarshalJSON encodes Severity as its name.
+func (s Severity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON decodes Severity from its name.
+func (s *Severity) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		return err
+	}
+	for _, sev := range []Severity{Info, Warning, Error} {
+		if sev.String() == name {
+			*s = sev
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown severity %q", name)
+}
+
+// Diagnostic is a single result of an analysis.
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Check    string   `json:"check"`              // Name of check, e.g. "fairness".
+	File     string   `json:"file,omitempty"`     // Source file.
+	Line     int      `json:"line,omitempty"`     // Source line (1-based).
+	Column   int      `json:"column,omitempty"`   // Source column (1-based).
+	Function string   `json:"function,omitempty"` // Enclosing function.
+	Channel  string   `json:"channel,omitempty"`  // Channel involved.
+	Message  string   `json:"message"`
+	Trace    []Step   `json:"trace,omitempty"` // Counterexample trace.
+}
+
+// Step is a step of a counterexample trace in a goroutine.
+type Step struct {
+	Goroutine int    `json:"goroutine"`
+	File      string `json:"file,omitempty"`
+	Line      int    `json:"line,omitempty"`
+	Function  string `json:"function,omitempty"`
+	Channel   string `json:"channel,omitempty"`
+	Message   string `json:"message"`
+}
+
+// New creates a new Diagnostic at the given source position.
+func New(sev Severity, check string, pos token.Position, format string, args ...interface{}) Diagnostic {
+	return Diagnostic{
+		Severity: sev,
+		Check:    check,
+		File:     pos.Filename,
+		Line:     pos.Line,
+		Column:   pos.Column,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+// Pos returns the source position of the Diagnostic.
+func (d Diagnostic) Pos() token.Position {
+	return token.Position{Filename: d.File, Line: d.Line, Column: d.Column}
+}
+
+func (d Diagnostic) String() string {
+	if pos := d.Pos(); pos.IsValid() {
+		return fmt.Sprintf("%s: %s: %s: %s", pos, d.Severity, d.Check, d.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", d.Severity, d.Check, d.Message)
+}
+
+// WriteJSON writes the diagnostics to w as a JSON array.
+func WriteJSON(w io.Writer, diags []Diagnostic) error {
+	if diags == nil {
+		diags = []Diagnostic{} // Write [] instead of null.
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(diags)
+}
@@ -0,0 +1,53 @@
+package diagnostics
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/token"
+	"reflect"
+	"testing"
+)
+
+func TestWriteJSON(t *testing.T) {
+	d := New(Warning, "fairness", token.Position{Filename: "main.go", Line: 10, Column: 2}, "loop %d", 1)
+	d.Function = "main.main"
+	d.Trace = []Step{{Goroutine: 1, File: "main.go", Line: 12, Message: "send t0"}}
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, []Diagnostic{d}); err != nil {
+		t.Fatal(err)
+	}
+	var diags []Diagnostic
+	if err := json.Unmarshal(buf.Bytes(), &diags); err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) != 1 || !reflect.DeepEqual(diags[0], d) {
+		t.Errorf("expects %v but got %v", d, diags)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(`"severity": "warning"`)) {
+		t.Errorf("expects severity as name but got %s", buf.String())
+	}
+}
+
+func TestWriteJSONEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "[]\n" {
+		t.Errorf("expects empty array but got %q", got)
+	}
+}
+
+func TestParseVerdicts(t *testing.T) {
+	out := []byte("Bound (k): 1\nLiveness: \x1b[91mFalse\x1b[0m\nSafety: \x1b[92mTrue\x1b[0m\n")
+	diags := ParseVerdicts("gong", out)
+	if len(diags) != 2 {
+		t.Fatalf("expects 2 verdicts but got %d: %v", len(diags), diags)
+	}
+	if diags[0].Severity != Error || diags[0].Message != "Liveness check failed" {
+		t.Errorf("expects failed liveness but got %v", diags[0])
+	}
+	if diags[1].Severity != Info || diags[1].Message != "Safety check passed" {
+		t.Errorf("expects passed safety but got %v", diags[1])
+	}
+}
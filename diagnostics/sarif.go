@@ -0,0 +1,209 @@
+package diagnostics
+
+// SARIF (Static Analysis Results Interchange Format) output, e.g. for GitHub
+// code scanning.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	toolName     = "dingo-hunter"
+	toolURI      = "https://github.com/damifur/dingo-hunter"
+)
+
+// Rules is the description of each check, used as SARIF rules.
+var Rules = map[string]string{
+	"build":     "Loading and building SSA of the program",
+	"fairness":  "Potentially unfair loops or recursion",
+	"migo":      "MiGo types extraction",
+	"cfsms":     "CFSMs extraction",
+	"gong":      "MiGo types liveness and safety verification (Gong)",
+	"migocheck": "MiGo types liveness and safety verification (built-in)",
+	"synthesis": "CFSMs global graph synthesis (GMC)",
+	"cfsmcheck": "CFSMs multiparty compatibility check (built-in)",
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+	CodeFlows []sarifCodeFlow `json:"codeFlows,omitempty"`
+}
+
+type sarifCodeFlow struct {
+	ThreadFlows []sarifThreadFlow `json:"threadFlows"`
+}
+
+type sarifThreadFlow struct {
+	ID        string                    `json:"id"`
+	Locations []sarifThreadFlowLocation `json:"locations"`
+}
+
+type sarifThreadFlowLocation struct {
+	Location       sarifThreadLocation `json:"location"`
+	ExecutionOrder int                 `json:"executionOrder"`
+}
+
+type sarifThreadLocation struct {
+	PhysicalLocation *sarifPhysicalLocation `json:"physicalLocation,omitempty"`
+	Message          sarifMessage           `json:"message"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	LogicalLocations []sarifLogicalLoc     `json:"logicalLocations,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+type sarifLogicalLoc struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+// level returns the SARIF level of the severity.
+func (s Severity) level() string {
+	switch s {
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	}
+	return "note"
+}
+
+// WriteSARIF writes the diagnostics to w as a SARIF log.
+//
+// Each check of the diagnostics is a SARIF rule, and source files are written
+// relative to the current directory if possible.
+func WriteSARIF(w io.Writer, diags []Diagnostic) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           toolName,
+			InformationURI: toolURI,
+			Rules:          []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+	checks := make(map[string]bool)
+	for _, d := range diags {
+		if !checks[d.Check] {
+			checks[d.Check] = true
+			desc, ok := Rules[d.Check]
+			if !ok {
+				desc = d.Check
+			}
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: d.Check, ShortDescription: sarifMessage{desc}})
+		}
+		res := sarifResult{RuleID: d.Check, Level: d.Severity.level(), Message: sarifMessage{d.Message}}
+		if d.File != "" {
+			loc := sarifLocation{PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: sarifURI(d.File)}}}
+			if d.Line > 0 {
+				loc.PhysicalLocation.Region = &sarifRegion{StartLine: d.Line, StartColumn: d.Column}
+			}
+			if d.Function != "" {
+				loc.LogicalLocations = []sarifLogicalLoc{{FullyQualifiedName: d.Function, Kind: "function"}}
+			}
+			res.Locations = []sarifLocation{loc}
+		}
+		if len(d.Trace) > 0 {
+			res.CodeFlows = []sarifCodeFlow{sarifTrace(d.Trace)}
+		}
+		run.Results = append(run.Results, res)
+	}
+	sort.Slice(run.Tool.Driver.Rules, func(i, j int) bool {
+		return run.Tool.Driver.Rules[i].ID < run.Tool.Driver.Rules[j].ID
+	})
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{Schema: sarifSchema, Version: sarifVersion, Runs: []sarifRun{run}})
+}
+
+// sarifTrace converts a trace to a SARIF code flow with a thread flow for each
+// goroutine, the steps are ordered by their position in the trace.
+func sarifTrace(trace []Step) sarifCodeFlow {
+	var flow sarifCodeFlow
+	threads := make(map[int]int) // goroutine -> index of thread flow
+	for i, step := range trace {
+		t, ok := threads[step.Goroutine]
+		if !ok {
+			t = len(flow.ThreadFlows)
+			threads[step.Goroutine] = t
+			flow.ThreadFlows = append(flow.ThreadFlows, sarifThreadFlow{ID: fmt.Sprintf("goroutine %d", step.Goroutine)})
+		}
+		loc := sarifThreadLocation{Message: sarifMessage{step.Message}}
+		if step.File != "" {
+			loc.PhysicalLocation = &sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: sarifURI(step.File)}}
+			if step.Line > 0 {
+				loc.PhysicalLocation.Region = &sarifRegion{StartLine: step.Line}
+			}
+		}
+		flow.ThreadFlows[t].Locations = append(flow.ThreadFlows[t].Locations, sarifThreadFlowLocation{Location: loc, ExecutionOrder: i + 1})
+	}
+	return flow
+}
+
+// sarifURI converts a filename to a URI relative to the current directory, or
+// leaves it as an absolute path if it is outside of the current directory.
+func sarifURI(file string) string {
+	if filepath.IsAbs(file) {
+		if wd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(wd, file); err == nil && !strings.HasPrefix(rel, "..") {
+				file = rel
+			}
+		}
+	}
+	return filepath.ToSlash(file)
+}
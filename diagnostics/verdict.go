@@ -0,0 +1,38 @@
+package diagnostics
+
+// Verdicts of external checkers.
+
+import (
+	"bufio"
+	"bytes"
+	"go/token"
+	"regexp"
+	"strings"
+)
+
+var (
+	ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+	verdictRe  = regexp.MustCompile(`^\s*([A-Za-z][A-Za-z ()-]*?)\s*:\s*(True|False)\s*$`)
+)
+
+// ParseVerdicts parses the output of an external checker (e.g. Gong or GMC),
+// where each verdict is a line of the form "Property: True" or
+// "Property: False", and returns a diagnostic for each verdict.
+//
+// Verdicts which are False are errors, and the others are informational.
+func ParseVerdicts(check string, out []byte) []Diagnostic {
+	var diags []Diagnostic
+	scanner := bufio.NewScanner(bytes.NewReader(ansiEscape.ReplaceAll(out, nil)))
+	for scanner.Scan() {
+		m := verdictRe.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		if m[2] == "False" {
+			diags = append(diags, New(Error, check, token.Position{}, "%s check failed", strings.TrimSpace(m[1])))
+		} else {
+			diags = append(diags, New(Info, check, token.Position{}, "%s check passed", strings.TrimSpace(m[1])))
+		}
+	}
+	return diags
+}
@@ -10,9 +10,12 @@
 package fairness
 
 import (
+	"go/token"
+	"io"
 	"log"
 	"os"
 
+	"github.com/damifur/dingo-hunter/diagnostics"
 	"github.com/damifur/dingo-hunter/logwriter"
 	"github.com/damifur/dingo-hunter/ssabuilder"
 	"github.com/fatih/color"
@@ -25,6 +28,7 @@ type FairnessAnalysis struct {
 	total  int
 	info   *ssabuilder.SSAInfo
 	logger *log.Logger
+	diags  []diagnostics.Diagnostic
 }
 
 // NewFairnessAnalysis starts a new analysis.
@@ -55,12 +59,14 @@ func (fa *FairnessAnalysis) Visit(fn *ssa.Function) {
 				if !hasClose {
 					fa.logger.Println(color.RedString("❌ range over channel w/o close() likely unfair (%s)", fa.info.FSet.Position(blk.Instrs[0].Pos())))
 					fa.unsafe++
+					fa.report(fn, blk.Instrs[0].(*ssa.UnOp).X.Name(), blockPos(blk), "range over channel without close() likely unfair")
 				}
 			} else if blk.Comment == "for.loop" {
 				fa.total++
 				if fa.isLikelyUnsafe(blk) {
 					fa.logger.Println(color.RedString("❌ for.loop maybe bad"))
 					fa.unsafe++
+					fa.report(fn, "", blockPos(blk), "for loop condition likely unfair")
 				} else {
 					fa.logger.Println(color.GreenString("✓ for.loop is ok"))
 				}
@@ -74,6 +80,7 @@ func (fa *FairnessAnalysis) Visit(fn *ssa.Function) {
 							if !fa.isCondFair(ifInst.Cond) {
 								fa.logger.Println(color.YellowString("Warning: recurring block condition probably unfair"))
 								fa.unsafe++
+								fa.report(fn, "", ifInst.Pos(), "recurring block condition probably unfair")
 							} else {
 								fa.logger.Println(color.GreenString("✓ recurring block is ok"))
 							}
@@ -83,6 +90,7 @@ func (fa *FairnessAnalysis) Visit(fn *ssa.Function) {
 							fa.total++
 							fa.unsafe++
 							fa.logger.Println(color.RedString("❌ infinite loop or recurring block, probably bad (%s)", fa.info.FSet.Position(blk.Instrs[0].Pos())))
+							fa.report(fn, "", blockPos(blk), "infinite loop or recurring block, probably unfair")
 						}
 					}
 				}
@@ -91,6 +99,28 @@ func (fa *FairnessAnalysis) Visit(fn *ssa.Function) {
 	}
 }
 
+// report records a potentially unfair loop as a diagnostic.
+func (fa *FairnessAnalysis) report(fn *ssa.Function, ch string, pos token.Pos, msg string) {
+	if pos == token.NoPos {
+		pos = fn.Pos()
+	}
+	d := diagnostics.New(diagnostics.Warning, "fairness", fa.info.FSet.Position(pos), "%s", msg)
+	d.Function = fn.String()
+	d.Channel = ch
+	fa.diags = append(fa.diags, d)
+}
+
+// blockPos returns the position of the first instruction with a position in
+// blk, or token.NoPos if there are none.
+func blockPos(blk *ssa.BasicBlock) token.Pos {
+	for _, instr := range blk.Instrs {
+		if pos := instr.Pos(); pos.IsValid() {
+			return pos
+		}
+	}
+	return token.NoPos
+}
+
 // isLikelyUnsafe checks if a given "for.loop" block has non-static index and
 // non-static loop condition.
 func (fa *FairnessAnalysis) isLikelyUnsafe(blk *ssa.BasicBlock) bool {
@@ -161,17 +191,26 @@ func (fa *FairnessAnalysis) isIndexStatic(blk *ssa.BasicBlock) bool {
 	return true
 }
 
-// Check for fairness on a built SSA
-func Check(info *ssabuilder.SSAInfo) {
+// Check for fairness on a built SSA, and returns the potentially unfair loops
+// found as diagnostics.
+func Check(info *ssabuilder.SSAInfo) []diagnostics.Diagnostic {
+	return CheckLog(info, logwriter.New(os.Stdout, true, true))
+}
+
+// CheckLog is Check but logs the analysis to w instead of stdout.
+func CheckLog(info *ssabuilder.SSAInfo, w io.Writer) []diagnostics.Diagnostic {
 	if cgRoot := info.CallGraph(); cgRoot != nil {
 		fa := NewFairnessAnalysis()
 		fa.info = info
-		fa.logger = log.New(logwriter.New(os.Stdout, true, true), "fairness: ", log.LstdFlags)
+		fa.logger = log.New(w, "fairness: ", log.LstdFlags)
 		cgRoot.Traverse(fa)
 		if fa.unsafe <= 0 {
-			fa.logger.Printf(color.GreenString("Result: %d/%d is likely unsafe", fa.unsafe, fa.total))
+			fa.logger.Print(color.GreenString("Result: %d/%d is likely unsafe", fa.unsafe, fa.total))
 		} else {
-			fa.logger.Printf(color.RedString("Result: %d/%d is likely unsafe", fa.unsafe, fa.total))
+			fa.logger.Print(color.RedString("Result: %d/%d is likely unsafe", fa.unsafe, fa.total))
 		}
+		d := diagnostics.New(diagnostics.Info, "fairness", token.Position{}, "%d/%d loops likely unsafe", fa.unsafe, fa.total)
+		return append(fa.diags, d)
 	}
+	return nil
 }
@@ -0,0 +1,369 @@
+// Package migocheck provides a liveness and safety checker of MiGo types.
+//
+// The checker explores the (bounded) transition system of a MiGo program,
+// starting from the root definition (main.main by default), and reports
+//   - global deadlocks: some goroutines are not terminated but none can move
+//   - channel safety violations: send or close on a closed channel
+//   - liveness failures: a goroutine waits on a channel operation which can
+//     never be fired in any continuation
+//
+// Each violation comes with a counterexample trace from the initial state.
+//
+// Channels without binding in scope are treated as nil channels, i.e. send
+// and receive on them block forever.
+package migocheck // import "github.com/damifur/dingo-hunter/migocheck"
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/damifur/migo"
+)
+
+var (
+	// ErrNoRoot is returned if the root definition is not in the program.
+	ErrNoRoot = errors.New("root definition not found")
+)
+
+// Kind is the kind of a violation.
+type Kind int
+
+const (
+	Deadlock      Kind = iota // Global deadlock.
+	SendOnClosed              // Send on closed channel.
+	CloseOnClosed             // Close of closed (or nil) channel.
+	Liveness                  // Channel operation which never fires.
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Deadlock:
+		return "deadlock"
+	case SendOnClosed:
+		return "send-on-closed"
+	case CloseOnClosed:
+		return "close-on-closed"
+	case Liveness:
+		return "liveness"
+	}
+	return "Kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Options are the bounds of the exploration.
+type Options struct {
+	MaxStates int // Maximum number of states explored.
+	MaxProcs  int // Maximum number of live goroutines in a state.
+	MaxDepth  int // Maximum call depth of a goroutine.
+
+	// MaxBuffer is the maximum number of buffered messages counted exactly,
+	// larger (e.g. unbounded) buffers are over-approximated: receiving from a
+	// buffer holding MaxBuffer messages may leave it with MaxBuffer messages.
+	MaxBuffer int64
+}
+
+// DefaultOptions are the default exploration bounds.
+var DefaultOptions = Options{MaxStates: 100000, MaxProcs: 16, MaxDepth: 64, MaxBuffer: 8}
+
+// Action is a statement executed (or pending) in a goroutine.
+type Action struct {
+	Proc  int            // Goroutine identifier (0 is the root).
+	Spawn string         // Definition the goroutine is spawned with.
+	Func  string         // Definition the statement belongs to.
+	Stmt  migo.Statement // The statement.
+}
+
+func (a Action) String() string {
+	return fmt.Sprintf("[%d %s] %s: %s", a.Proc, a.Spawn, a.Func, a.Stmt.String())
+}
+
+// Step is a transition of the program, a single action or two synchronising
+// actions (sender first).
+type Step []Action
+
+// Violation is a liveness or safety violation found by the checker.
+type Violation struct {
+	Kind    Kind
+	Message string
+	At      Action   // Offending action, or first pending action of deadlocks.
+	Trace   []Step   // Transitions from the initial state to the violation.
+	Blocked []Action // Pending actions of the goroutines at the violation.
+}
+
+// Result is the result of a check.
+type Result struct {
+	States     int         // Number of states explored.
+	Complete   bool        // False if any bound is reached.
+	Violations []Violation // Violations found.
+}
+
+// Safe returns true if no channel safety violation is found.
+func (r *Result) Safe() bool {
+	for _, v := range r.Violations {
+		if v.Kind == SendOnClosed || v.Kind == CloseOnClosed {
+			return false
+		}
+	}
+	return true
+}
+
+// Live returns true if no deadlock or liveness violation is found.
+func (r *Result) Live() bool {
+	for _, v := range r.Violations {
+		if v.Kind == Deadlock || v.Kind == Liveness {
+			return false
+		}
+	}
+	return true
+}
+
+// Line returns the source line number of a statement, 0 if unknown.
+func Line(stmt migo.Statement) int {
+	var line string
+	switch s := stmt.(type) {
+	case *migo.CallStatement:
+		line = s.LineNum
+	case *migo.CloseStatement:
+		line = s.LineNum
+	case *migo.IfStatement:
+		line = s.LineNum
+	case *migo.NewChanStatement:
+		line = s.LineNum
+	case *migo.RecvStatement:
+		line = s.LineNum
+	case *migo.SelectStatement:
+		line = s.LineNum
+	case *migo.SendStatement:
+		line = s.LineNum
+	case *migo.SpawnStatement:
+		line = s.LineNum
+	case *migo.TauStatement:
+		line = s.LineNum
+	}
+	n, err := strconv.Atoi(line)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// Check explores the MiGo program from the root definition (main.main if
+// empty) within the bounds of opts.
+func Check(prog *migo.Program, root string, opts Options) (*Result, error) {
+	if root == "" {
+		root = "main.main"
+	}
+	c := newChecker(prog, opts)
+	if _, ok := c.defs[root]; !ok {
+		return nil, fmt.Errorf("%v: %s", ErrNoRoot, root)
+	}
+	c.explore(root)
+	c.checkLiveness()
+	return &c.result, nil
+}
+
+// edge is a transition between explored states.
+type edge struct {
+	to    int
+	fired []int // Index of goroutines (in source state) fired.
+	from  []int // Index of goroutines in source state, per target goroutine.
+}
+
+// node is an explored state.
+type node struct {
+	st     *state
+	parent int
+	step   Step
+	edges  []edge
+}
+
+type checker struct {
+	opts   Options
+	defs   map[string]*migo.Function
+	blocks []block                  // Statement lists.
+	body   map[string]int           // Definition name -> block.
+	sub    map[migo.Statement][]int // If/Select statement -> blocks.
+	seen   map[string]int           // State key -> node.
+	nodes  []node                   // Explored states.
+	found  map[migo.Statement]bool  // Reported statements.
+	unsafe bool                     // Safety violation in current node.
+	result Result
+}
+
+func newChecker(prog *migo.Program, opts Options) *checker {
+	c := &checker{
+		opts:  opts,
+		defs:  make(map[string]*migo.Function),
+		body:  make(map[string]int),
+		sub:   make(map[migo.Statement][]int),
+		seen:  make(map[string]int),
+		found: make(map[migo.Statement]bool),
+	}
+	for _, fn := range prog.Funcs {
+		c.defs[fn.Name] = fn
+		c.body[fn.Name] = c.compile(fn.Name, fn.Stmts)
+	}
+	c.result.Complete = true
+	return c
+}
+
+// compile registers a statement list (and nested lists) as blocks.
+func (c *checker) compile(fn string, stmts []migo.Statement) int {
+	id := len(c.blocks)
+	c.blocks = append(c.blocks, block{fn: fn, stmts: stmts})
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *migo.IfStatement:
+			c.sub[s] = []int{c.compile(fn, s.Then), c.compile(fn, s.Else)}
+		case *migo.SelectStatement:
+			for _, cas := range s.Cases {
+				var rest []migo.Statement
+				if len(cas) > 0 {
+					rest = cas[1:]
+				}
+				c.sub[s] = append(c.sub[s], c.compile(fn, rest))
+			}
+		}
+	}
+	return id
+}
+
+// explore runs a breadth-first search of the state space from root.
+func (c *checker) explore(root string) {
+	init := &state{nextID: 1}
+	init.procs = []proc{{id: 0, spawn: root, stack: []frame{{blk: c.body[root], env: map[string]int{}}}}}
+	init.settle(c, 0)
+	init.gc()
+	c.visit(init, -1, nil)
+	for i := 0; i < len(c.nodes); i++ {
+		if len(c.nodes) >= c.opts.MaxStates {
+			c.result.Complete = false
+			break
+		}
+		c.unsafe = false
+		succs, cut := c.successors(i)
+		if cut {
+			c.result.Complete = false
+		}
+		for _, s := range succs {
+			to := c.visit(s.st, i, s.step)
+			c.nodes[i].edges = append(c.nodes[i].edges, edge{to: to, fired: s.fired, from: s.from})
+		}
+		if len(succs) == 0 && !cut && !c.unsafe && len(c.nodes[i].st.procs) > 0 {
+			c.report(i, Deadlock, nil, nil, "global deadlock: all goroutines are blocked")
+		}
+	}
+	c.result.States = len(c.nodes)
+}
+
+// visit adds st to the explored states if it is new and returns its index.
+func (c *checker) visit(st *state, parent int, step Step) int {
+	key := st.key()
+	if n, ok := c.seen[key]; ok {
+		return n
+	}
+	c.seen[key] = len(c.nodes)
+	c.nodes = append(c.nodes, node{st: st, parent: parent, step: step})
+	return len(c.nodes) - 1
+}
+
+// trace returns the transitions from the initial state to node n.
+func (c *checker) trace(n int) []Step {
+	var steps []Step
+	for ; c.nodes[n].parent >= 0; n = c.nodes[n].parent {
+		steps = append(steps, c.nodes[n].step)
+	}
+	for i, j := 0, len(steps)-1; i < j; i, j = i+1, j-1 {
+		steps[i], steps[j] = steps[j], steps[i]
+	}
+	return steps
+}
+
+// report records a violation at node n. If last is given, it is the final
+// (offending) step of the trace. Violations are only reported once for the
+// statements of key (the pending statements if nil).
+func (c *checker) report(n int, kind Kind, key []Action, last Step, format string, args ...interface{}) {
+	if kind == SendOnClosed || kind == CloseOnClosed {
+		c.unsafe = true
+	}
+	st := c.nodes[n].st
+	blocked := make([]Action, len(st.procs))
+	for i := range st.procs {
+		blocked[i] = st.action(c, i)
+	}
+	if key == nil {
+		key = blocked
+	}
+	isNew := false
+	for _, a := range key {
+		if !c.found[a.Stmt] {
+			isNew = true
+		}
+		c.found[a.Stmt] = true
+	}
+	if !isNew {
+		return
+	}
+	trace := c.trace(n)
+	if last != nil {
+		trace = append(trace, last)
+	}
+	c.result.Violations = append(c.result.Violations, Violation{
+		Kind:    kind,
+		Message: fmt.Sprintf(format, args...),
+		At:      key[0],
+		Trace:   trace,
+		Blocked: blocked,
+	})
+}
+
+// checkLiveness finds goroutines waiting on channel operations which are
+// never fired in any path from the state. The check is only sound if the
+// exploration is complete.
+func (c *checker) checkLiveness() {
+	if !c.result.Complete {
+		return
+	}
+	type pair struct{ n, p int }
+	good := make([][]bool, len(c.nodes))
+	preds := make([][]pair, len(c.nodes)) // node -> (source node, edge index)
+	var work []pair
+	for n := range c.nodes {
+		good[n] = make([]bool, len(c.nodes[n].st.procs))
+	}
+	for n := range c.nodes {
+		for i, e := range c.nodes[n].edges {
+			preds[e.to] = append(preds[e.to], pair{n, i})
+			for _, p := range e.fired {
+				if !good[n][p] {
+					good[n][p] = true
+					work = append(work, pair{n, p})
+				}
+			}
+		}
+	}
+	for len(work) > 0 {
+		w := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, pred := range preds[w.n] {
+			src := c.nodes[pred.n].edges[pred.p].from[w.p]
+			if src >= 0 && !good[pred.n][src] {
+				good[pred.n][src] = true
+				work = append(work, pair{pred.n, src})
+			}
+		}
+	}
+	for n := range c.nodes {
+		if len(c.nodes[n].edges) == 0 {
+			continue // Global deadlock or terminated.
+		}
+		for p := range c.nodes[n].st.procs {
+			if !good[n][p] && c.nodes[n].st.waiting(c, p) {
+				a := c.nodes[n].st.action(c, p)
+				if !c.found[a.Stmt] {
+					c.report(n, Liveness, []Action{a}, nil, "%s in goroutine %d (%s) can never proceed", a.Stmt.String(), a.Proc, a.Spawn)
+				}
+			}
+		}
+	}
+}
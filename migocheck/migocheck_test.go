@@ -0,0 +1,127 @@
+package migocheck
+
+import (
+	"testing"
+
+	"github.com/damifur/migo"
+)
+
+// name is a channel name for MiGo statements.
+type name string
+
+func (n name) Name() string   { return string(n) }
+func (n name) String() string { return string(n) }
+
+func param(n string) *migo.Parameter { return &migo.Parameter{Caller: name(n), Callee: name(n)} }
+
+// newProg returns a program where main.main creates channel ch (of size sz),
+// spawns worker and runs mainStmts.
+func newProg(sz int64, worker []migo.Statement, mainStmts ...migo.Statement) *migo.Program {
+	prog := migo.NewProgram()
+	main := migo.NewFunction("main.main")
+	main.AddStmts(&migo.NewChanStatement{Name: name("ch"), Chan: "ch", Size: sz})
+	spawn := &migo.SpawnStatement{Name: "main.worker"}
+	spawn.AddParams(param("ch"))
+	main.AddStmts(spawn)
+	main.AddStmts(mainStmts...)
+	prog.AddFunction(main)
+	w := migo.NewFunction("main.worker")
+	w.AddParams(param("ch"))
+	w.AddStmts(worker...)
+	prog.AddFunction(w)
+	return prog
+}
+
+func TestCheckLiveSafe(t *testing.T) {
+	prog := newProg(0, []migo.Statement{&migo.SendStatement{Chan: "ch"}}, &migo.RecvStatement{Chan: "ch"})
+	res, err := Check(prog, "", DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Complete || !res.Live() || !res.Safe() {
+		t.Errorf("expecting live and safe, got %+v", res)
+	}
+}
+
+func TestCheckDeadlock(t *testing.T) {
+	prog := newProg(0, []migo.Statement{&migo.RecvStatement{Chan: "ch"}}, &migo.RecvStatement{Chan: "ch"})
+	res, err := Check(prog, "", DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Live() {
+		t.Fatalf("expecting deadlock, got %+v", res)
+	}
+	if v := res.Violations[0]; v.Kind != Deadlock || len(v.Blocked) != 2 || len(v.Trace) != 2 {
+		t.Errorf("expecting deadlock with 2 blocked goroutines after 2 steps, got %+v", v)
+	}
+}
+
+func TestCheckLiveness(t *testing.T) {
+	// main loops forever, worker waits for a message never sent.
+	prog := newProg(0, []migo.Statement{&migo.RecvStatement{Chan: "ch"}}, &migo.CallStatement{Name: "main.loop"})
+	loop := migo.NewFunction("main.loop")
+	loop.AddStmts(&migo.TauStatement{}, &migo.CallStatement{Name: "main.loop"})
+	prog.AddFunction(loop)
+	res, err := Check(prog, "", DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Complete || res.Live() || len(res.Violations) != 1 || res.Violations[0].Kind != Liveness {
+		t.Errorf("expecting liveness violation, got %+v", res)
+	}
+}
+
+func TestCheckSendOnClosed(t *testing.T) {
+	prog := newProg(1, []migo.Statement{&migo.SendStatement{Chan: "ch"}}, &migo.CloseStatement{Chan: "ch"})
+	res, err := Check(prog, "", DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Safe() {
+		t.Fatalf("expecting send on closed channel, got %+v", res)
+	}
+	for _, v := range res.Violations {
+		if v.Kind == SendOnClosed && v.At.Spawn != "main.worker" {
+			t.Errorf("expecting send in main.worker, got %v", v.At)
+		}
+	}
+}
+
+func TestCheckUnboundedBuffer(t *testing.T) {
+	// worker sends forever to an unbounded buffer, main receives once.
+	prog := newProg(1<<31, []migo.Statement{&migo.CallStatement{Name: "main.produce"}}, &migo.RecvStatement{Chan: "ch"})
+	produce := migo.NewFunction("main.produce")
+	produce.AddParams(param("ch"))
+	call := &migo.CallStatement{Name: "main.produce"}
+	call.AddParams(param("ch"))
+	produce.AddStmts(&migo.SendStatement{Chan: "ch"}, call)
+	prog.AddFunction(produce)
+	prog.Funcs[1].Stmts[0].(*migo.CallStatement).AddParams(param("ch"))
+	res, err := Check(prog, "", DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Complete || !res.Live() || !res.Safe() {
+		t.Errorf("expecting complete, live and safe, got %+v", res)
+	}
+}
+
+// TestCheckBranchNewChan checks that channels created in the branches of an if
+// or a select are bound after the branch.
+func TestCheckBranchNewChan(t *testing.T) {
+	newChan := func() migo.Statement { return &migo.NewChanStatement{Name: name("d"), Chan: "d", Size: 1} }
+	for _, branch := range []migo.Statement{
+		&migo.IfStatement{Then: []migo.Statement{newChan()}, Else: []migo.Statement{newChan()}},
+		&migo.SelectStatement{Cases: [][]migo.Statement{{&migo.RecvStatement{Chan: "ch"}, newChan()}}},
+	} {
+		prog := newProg(1, []migo.Statement{&migo.SendStatement{Chan: "ch"}}, branch, &migo.SendStatement{Chan: "d"}, &migo.RecvStatement{Chan: "d"})
+		res, err := Check(prog, "", DefaultOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Complete || !res.Live() || !res.Safe() {
+			t.Errorf("%s: expecting live and safe, got %+v", branch, res)
+		}
+	}
+}
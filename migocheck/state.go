@@ -0,0 +1,490 @@
+package migocheck
+
+// State and transitions of MiGo programs.
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/damifur/migo"
+)
+
+const nilChan = -1 // Channel of unbound names.
+
+// block is a statement list, i.e. body of a definition or a branch.
+type block struct {
+	fn    string // Definition the block belongs to.
+	stmts []migo.Statement
+}
+
+// frame is an activation of a block.
+type frame struct {
+	blk    int
+	pc     int
+	env    map[string]int // Name -> channel (shared, copy on write).
+	branch bool           // Branch of an if or select in the frame below.
+}
+
+// proc is a goroutine.
+type proc struct {
+	id    int    // Goroutine identifier (not part of state).
+	spawn string // Definition spawned with (not part of state).
+	from  int    // Index of goroutine in predecessor state, -1 if new.
+	stack []frame
+}
+
+// channel is the state of a channel, only the number of buffered messages is
+// tracked as values are abstracted away.
+type channel struct {
+	cap    int64
+	count  int64
+	closed bool
+}
+
+// state is a global state of the program.
+type state struct {
+	procs  []proc
+	chans  []channel
+	nextID int // Next goroutine identifier (not part of state).
+}
+
+// clone returns a copy of st for a successor. Stacks are shared and copied on
+// write (see mut).
+func (st *state) clone() *state {
+	next := &state{
+		procs:  make([]proc, len(st.procs)),
+		chans:  make([]channel, len(st.chans)),
+		nextID: st.nextID,
+	}
+	copy(next.procs, st.procs)
+	copy(next.chans, st.chans)
+	for i := range next.procs {
+		next.procs[i].from = i
+	}
+	return next
+}
+
+// mut makes the stack of goroutine i writable.
+func (st *state) mut(i int) *proc {
+	p := &st.procs[i]
+	p.stack = append([]frame(nil), p.stack...)
+	return p
+}
+
+// top returns the next statement of goroutine i.
+func (st *state) top(c *checker, i int) (migo.Statement, *frame) {
+	p := &st.procs[i]
+	f := &p.stack[len(p.stack)-1]
+	return c.blocks[f.blk].stmts[f.pc], f
+}
+
+// action returns the pending action of goroutine i.
+func (st *state) action(c *checker, i int) Action {
+	stmt, f := st.top(c, i)
+	return Action{Proc: st.procs[i].id, Spawn: st.procs[i].spawn, Func: c.blocks[f.blk].fn, Stmt: stmt}
+}
+
+// lookup returns the channel bound to name in the current frame of goroutine i.
+func (st *state) lookup(i int, name string) int {
+	p := &st.procs[i]
+	if ch, ok := p.stack[len(p.stack)-1].env[name]; ok {
+		return ch
+	}
+	return nilChan
+}
+
+// advance moves goroutine i to its next statement.
+func (st *state) advance(i int) {
+	p := st.mut(i)
+	p.stack[len(p.stack)-1].pc++
+}
+
+// push enters block blk with environment env in goroutine i.
+func (st *state) push(i, blk int, env map[string]int) {
+	p := st.mut(i)
+	p.stack = append(p.stack, frame{blk: blk, env: env})
+}
+
+// pushBranch enters branch blk of the current statement of goroutine i, in the
+// environment env of the current frame.
+func (st *state) pushBranch(i, blk int, env map[string]int) {
+	p := st.mut(i)
+	p.stack = append(p.stack, frame{blk: blk, env: env, branch: true})
+}
+
+// bind sets the environment of the current frame of goroutine i to env, and of
+// the frames enclosing it if it is a branch, so that names bound in a branch
+// are bound after the branch.
+func (st *state) bind(i int, env map[string]int) {
+	p := st.mut(i)
+	for k := len(p.stack) - 1; k >= 0; k-- {
+		p.stack[k].env = env
+		if !p.stack[k].branch {
+			return
+		}
+	}
+}
+
+// settle pops finished frames of goroutine i.
+func (st *state) settle(c *checker, i int) {
+	p := &st.procs[i]
+	for len(p.stack) > 0 {
+		f := p.stack[len(p.stack)-1]
+		if f.pc < len(c.blocks[f.blk].stmts) {
+			return
+		}
+		p.stack = p.stack[:len(p.stack)-1]
+	}
+}
+
+// finish settles all goroutines and removes the terminated ones.
+func (st *state) finish(c *checker) {
+	procs := st.procs[:0]
+	for i := range st.procs {
+		st.settle(c, i)
+		if len(st.procs[i].stack) > 0 {
+			procs = append(procs, st.procs[i])
+		}
+	}
+	st.procs = procs
+}
+
+// gc renumbers the channels in order of appearance and drops unreachable
+// channels, so that equivalent states have the same representation.
+func (st *state) gc() {
+	renum := make(map[int]int)
+	var chans []channel
+	identity := true
+	for i := range st.procs {
+		for _, f := range st.procs[i].stack {
+			for _, name := range sortedNames(f.env) {
+				ch := f.env[name]
+				if _, ok := renum[ch]; !ok && ch != nilChan {
+					renum[ch] = len(chans)
+					if ch != len(chans) {
+						identity = false
+					}
+					chans = append(chans, st.chans[ch])
+				}
+			}
+		}
+	}
+	if identity && len(chans) == len(st.chans) {
+		return
+	}
+	for i := range st.procs {
+		p := st.mut(i)
+		for k, f := range p.stack {
+			env := make(map[string]int, len(f.env))
+			for name, ch := range f.env {
+				if ch == nilChan {
+					env[name] = nilChan
+				} else {
+					env[name] = renum[ch]
+				}
+			}
+			p.stack[k].env = env
+		}
+	}
+	st.chans = chans
+}
+
+// key returns the canonical representation of st (after gc).
+func (st *state) key() string {
+	var buf bytes.Buffer
+	for _, p := range st.procs {
+		buf.WriteString("|")
+		for _, f := range p.stack {
+			fmt.Fprintf(&buf, "%d.%d(", f.blk, f.pc)
+			for _, name := range sortedNames(f.env) {
+				fmt.Fprintf(&buf, "%s=%d,", name, f.env[name])
+			}
+			buf.WriteString(")")
+		}
+	}
+	for _, ch := range st.chans {
+		fmt.Fprintf(&buf, "#%d/%d/%t", ch.count, ch.cap, ch.closed)
+	}
+	return buf.String()
+}
+
+func sortedNames(env map[string]int) []string {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// waiting returns true if goroutine i is waiting on a channel operation, i.e.
+// a send, receive or select without default (tau) case.
+func (st *state) waiting(c *checker, i int) bool {
+	stmt, _ := st.top(c, i)
+	switch s := stmt.(type) {
+	case *migo.SendStatement, *migo.RecvStatement:
+		return true
+	case *migo.SelectStatement:
+		for _, cas := range s.Cases {
+			if len(cas) == 0 {
+				return false
+			}
+			switch cas[0].(type) {
+			case *migo.SendStatement, *migo.RecvStatement:
+			default:
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// succ is a successor state.
+type succ struct {
+	st    *state
+	step  Step
+	fired []int
+	from  []int
+}
+
+// successors returns the successor states of node n. cut is true if a bound
+// is reached so some successors are not included.
+func (c *checker) successors(n int) (succs []succ, cut bool) {
+	st := c.nodes[n].st
+	emit := func(next *state, fired []int, step Step) {
+		next.finish(c)
+		next.gc()
+		from := make([]int, len(next.procs))
+		for i, p := range next.procs {
+			from[i] = p.from
+		}
+		succs = append(succs, succ{st: next, step: step, fired: fired, from: from})
+	}
+	for i := range st.procs {
+		stmt, f := st.top(c, i)
+		act := st.action(c, i)
+		switch s := stmt.(type) {
+		case *migo.NewChanStatement:
+			next := st.clone()
+			next.chans = append(next.chans, channel{cap: s.Size})
+			env := copyEnv(f.env)
+			env[s.Name.Name()] = len(next.chans) - 1
+			next.advance(i)
+			next.bind(i, env)
+			emit(next, []int{i}, Step{act})
+
+		case *migo.CallStatement:
+			next := st.clone()
+			next.advance(i)
+			def, ok := c.defs[s.Name]
+			if ok {
+				env := bindParams(st, i, def, s.Params)
+				next.settle(c, i) // Tail call.
+				if len(next.procs[i].stack) >= c.opts.MaxDepth {
+					cut = true
+					continue
+				}
+				next.push(i, c.body[s.Name], env)
+			}
+			emit(next, []int{i}, Step{act})
+
+		case *migo.SpawnStatement:
+			next := st.clone()
+			next.advance(i)
+			if def, ok := c.defs[s.Name]; ok {
+				if len(st.procs) >= c.opts.MaxProcs {
+					cut = true
+					continue
+				}
+				env := bindParams(st, i, def, s.Params)
+				next.procs = append(next.procs, proc{
+					id:    next.nextID,
+					spawn: s.Name,
+					from:  -1,
+					stack: []frame{{blk: c.body[s.Name], env: env}},
+				})
+				next.nextID++
+			}
+			emit(next, []int{i}, Step{act})
+
+		case *migo.IfStatement:
+			for _, blk := range c.sub[s] {
+				next := st.clone()
+				next.advance(i)
+				next.pushBranch(i, blk, f.env)
+				emit(next, []int{i}, Step{act})
+			}
+
+		case *migo.CloseStatement:
+			ch := st.lookup(i, s.Chan)
+			if ch == nilChan {
+				c.report(n, CloseOnClosed, []Action{act}, Step{act}, "close of nil channel %s", s.Chan)
+				continue
+			}
+			if st.chans[ch].closed {
+				c.report(n, CloseOnClosed, []Action{act}, Step{act}, "close of closed channel %s", s.Chan)
+				continue
+			}
+			next := st.clone()
+			next.chans[ch].closed = true
+			next.advance(i)
+			emit(next, []int{i}, Step{act})
+
+		case *migo.SendStatement:
+			c.send(n, i, s, -1, act, emit)
+
+		case *migo.RecvStatement:
+			c.recv(n, i, s, -1, act, emit)
+
+		case *migo.SelectStatement:
+			for k, cas := range s.Cases {
+				var guard migo.Statement
+				if len(cas) > 0 {
+					guard = cas[0]
+				}
+				caseAct := act
+				caseAct.Stmt = guard
+				switch g := guard.(type) {
+				case *migo.SendStatement:
+					c.send(n, i, g, k, caseAct, emit)
+				case *migo.RecvStatement:
+					c.recv(n, i, g, k, caseAct, emit)
+				default: // tau or empty case.
+					if guard == nil {
+						caseAct = act
+					}
+					next := st.clone()
+					next.advance(i)
+					next.pushBranch(i, c.sub[s][k], f.env)
+					emit(next, []int{i}, Step{caseAct})
+				}
+			}
+
+		default: // tau and unknown statements.
+			next := st.clone()
+			next.advance(i)
+			emit(next, []int{i}, Step{act})
+		}
+	}
+	return succs, cut
+}
+
+// send generates the transitions of goroutine i sending with s, which is the
+// guard of case k of a select statement if k >= 0.
+func (c *checker) send(n, i int, s *migo.SendStatement, k int, act Action, emit func(*state, []int, Step)) {
+	st := c.nodes[n].st
+	ch := st.lookup(i, s.Chan)
+	if ch == nilChan {
+		return
+	}
+	if st.chans[ch].closed {
+		c.report(n, SendOnClosed, []Action{act}, Step{act}, "send on closed channel %s", s.Chan)
+		return
+	}
+	// sent moves goroutine i past the send.
+	sent := func(next *state) {
+		next.advance(i)
+		if k >= 0 {
+			stmt, f := st.top(c, i)
+			next.pushBranch(i, c.sub[stmt][k], f.env)
+		}
+	}
+	if st.chans[ch].count < st.chans[ch].cap {
+		next := st.clone()
+		if !c.unbounded(st.chans[ch]) || st.chans[ch].count < c.opts.MaxBuffer {
+			next.chans[ch].count++
+		}
+		sent(next)
+		emit(next, []int{i}, Step{act})
+		return
+	}
+	if st.chans[ch].cap > 0 {
+		return // Buffer full.
+	}
+	for j := range st.procs {
+		if j == i {
+			continue
+		}
+		stmt, f := st.top(c, j)
+		recvAct := st.action(c, j)
+		switch r := stmt.(type) {
+		case *migo.RecvStatement:
+			if st.lookup(j, r.Chan) == ch {
+				next := st.clone()
+				sent(next)
+				next.advance(j)
+				emit(next, []int{i, j}, Step{act, recvAct})
+			}
+		case *migo.SelectStatement:
+			for l, cas := range r.Cases {
+				if len(cas) == 0 {
+					continue
+				}
+				if g, ok := cas[0].(*migo.RecvStatement); ok && st.lookup(j, g.Chan) == ch {
+					next := st.clone()
+					sent(next)
+					next.advance(j)
+					next.pushBranch(j, c.sub[r][l], f.env)
+					recvAct.Stmt = g
+					emit(next, []int{i, j}, Step{act, recvAct})
+				}
+			}
+		}
+	}
+}
+
+// recv generates the transitions of goroutine i receiving with s from a buffer
+// or a closed channel, s is the guard of case k of a select statement if
+// k >= 0. Synchronous receives are generated by the sender.
+func (c *checker) recv(n, i int, s *migo.RecvStatement, k int, act Action, emit func(*state, []int, Step)) {
+	st := c.nodes[n].st
+	ch := st.lookup(i, s.Chan)
+	if ch == nilChan || (st.chans[ch].count == 0 && !st.chans[ch].closed) {
+		return
+	}
+	counts := []int64{st.chans[ch].count}
+	if st.chans[ch].count > 0 {
+		counts[0]--
+		if c.unbounded(st.chans[ch]) && st.chans[ch].count == c.opts.MaxBuffer {
+			// Saturated: the buffer may hold more messages.
+			counts = append(counts, st.chans[ch].count)
+		}
+	}
+	for _, count := range counts {
+		next := st.clone()
+		next.chans[ch].count = count
+		next.advance(i)
+		if k >= 0 {
+			stmt, f := st.top(c, i)
+			next.pushBranch(i, c.sub[stmt][k], f.env)
+		}
+		emit(next, []int{i}, Step{act})
+	}
+}
+
+// unbounded returns true if the buffer of ch is larger than the bound, the
+// buffered messages are then counted up to the bound only.
+func (c *checker) unbounded(ch channel) bool { return ch.cap > c.opts.MaxBuffer }
+
+// bindParams returns the environment of def called from goroutine i, with
+// parameters bound by position.
+func bindParams(st *state, i int, def *migo.Function, params []*migo.Parameter) map[string]int {
+	env := make(map[string]int)
+	for k, param := range params {
+		if k >= len(def.Params) {
+			break
+		}
+		env[def.Params[k].Callee.Name()] = st.lookup(i, param.Caller.Name())
+	}
+	return env
+}
+
+func copyEnv(env map[string]int) map[string]int {
+	m := make(map[string]int, len(env)+1)
+	for name, ch := range env {
+		m[name] = ch
+	}
+	return m
+}
@@ -0,0 +1,42 @@
+package migoextract
+
+import (
+	"go/token"
+
+	"github.com/damifur/dingo-hunter/ssabuilder"
+	"golang.org/x/tools/go/ssa"
+)
+
+// partial records err as a reason of the types being partial, with a warning
+// at pos in function fn.
+func (infer *TypeInfer) partial(fn *ssa.Function, pos token.Pos, err error) {
+	if infer.Partial.Add(err) {
+		infer.warn(fn, pos, "partial result: %v", err)
+	}
+}
+
+// cancelled returns true if the analysis is cancelled or out of time.
+func (infer *TypeInfer) cancelled(fn *ssa.Function) bool {
+	if infer.ctx == nil {
+		return false
+	}
+	if err := infer.ctx.Err(); err != nil {
+		infer.partial(fn, token.NoPos, err)
+		return true
+	}
+	return false
+}
+
+// enterBlock counts a visit of blk in f, returns false if the analysis should
+// not continue with blk.
+func (infer *TypeInfer) enterBlock(f *Function, blk *ssa.BasicBlock) bool {
+	if infer.cancelled(f.Fn) {
+		return false
+	}
+	f.blocks++
+	if max := infer.Budget.MaxBlocks; max > 0 && f.blocks > max {
+		infer.partial(f.Fn, token.NoPos, &ssabuilder.BudgetError{Budget: "max-blocks", Limit: max})
+		return false
+	}
+	return true
+}
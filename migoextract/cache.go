@@ -0,0 +1,594 @@
+package migoextract
+
+// Functions for the on-disk cache of function summaries.
+//
+// A summary which can be reused by calls (see Summary.reusable) is stored in
+// the cache at the end of an analysis, with the MiGo definitions it calls or
+// spawns and the loop bounds found in its function. The entry is keyed by the
+// shape of the summary and a fingerprint of the SSA of the function and of the
+// functions it may reach, so the entry is not found after any of them changes,
+// and only the functions changed (and their callers) are analysed again.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/damifur/dingo-hunter/ssabuilder"
+	"github.com/damifur/migo"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// CacheVersion is the version of the extraction in cache keys, which must be
+// changed when the definitions extracted change.
+const CacheVersion = "4"
+
+// modelFuncs are the definitions of models of the standard library, which are
+// not summaries but may be called by summaries.
+var modelFuncs = map[string]bool{
+	"context.cancel":    true,
+	"context.propagate": true,
+	"time.timer":        true,
+	"time.stop":         true,
+	"time.ticker":       true,
+	"signal.notify":     true,
+}
+
+// Cache is an on-disk cache of function summaries, which can be shared by
+// analyses (of the same program) running concurrently.
+type Cache struct {
+	Dir string // Directory of the cache entries.
+
+	Hits   int // Summaries loaded.
+	Misses int // Summaries not found.
+	Stores int // Summaries stored.
+
+	mu     sync.Mutex
+	own    map[*ssa.Function]string                    // Hash of the SSA of functions.
+	prints map[*ssa.Function]string                    // Fingerprints of functions.
+	funcs  map[*ssa.Program]map[string]*ssa.Function   // Functions by name.
+	meths  map[*ssa.Program]map[string][]*ssa.Function // Methods by name.
+}
+
+// OpenCache returns the cache in directory dir, which is created if it does
+// not exist.
+func OpenCache(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Cache{
+		Dir:    dir,
+		own:    make(map[*ssa.Function]string),
+		prints: make(map[*ssa.Function]string),
+		funcs:  make(map[*ssa.Program]map[string]*ssa.Function),
+		meths:  make(map[*ssa.Program]map[string][]*ssa.Function),
+	}, nil
+}
+
+func (c *Cache) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return fmt.Sprintf("%d hits, %d misses, %d stored", c.Hits, c.Misses, c.Stores)
+}
+
+// A LoopFact is the bound of a loop found by the analysis of a function.
+type LoopFact struct {
+	Block int       `json:"block"` // Block of the loop (for.loop).
+	Bound LoopBound `json:"bound"`
+	Start int64     `json:"start"`
+	End   int64     `json:"end"`
+	Step  int64     `json:"step"`
+}
+
+func (l LoopFact) String() string {
+	if l.Bound == Static {
+		return fmt.Sprintf("loop#%d: bound %s [%d..%d] Step:%d", l.Block, l.Bound, l.Start, l.End, l.Step)
+	}
+	return fmt.Sprintf("loop#%d: bound %s", l.Block, l.Bound)
+}
+
+// addLoopFact records the bound of loop l of the function, once per loop.
+func (caller *Function) addLoopFact(l *Loop) {
+	for _, fact := range caller.loops {
+		if fact.Block == l.LoopBlock {
+			return
+		}
+	}
+	caller.loops = append(caller.loops, LoopFact{Block: l.LoopBlock, Bound: l.Bound, Start: l.Start, End: l.End, Step: l.Step})
+}
+
+// cacheEntry is the entry of a summary in the cache.
+type cacheEntry struct {
+	Version   string         `json:"version"`
+	Summaries []cacheSummary `json:"summaries"` // The summary of the entry first.
+	Defs      []cacheDef     `json:"defs"`
+}
+
+// cacheSummary is a summary defined by the definitions of an entry.
+type cacheSummary struct {
+	Func  string     `json:"func"`
+	Shape string     `json:"shape"`
+	Name  string     `json:"name"` // Name of the definition when stored.
+	Loops []LoopFact `json:"loops,omitempty"`
+}
+
+type cacheDef struct {
+	Name    string      `json:"name"`
+	Params  [][2]string `json:"params,omitempty"` // Caller and callee names.
+	HasComm bool        `json:"hascomm,omitempty"`
+	Stmts   []cacheStmt `json:"stmts"`
+}
+
+type cacheStmt struct {
+	Op     string        `json:"op"`
+	Name   string        `json:"name,omitempty"`
+	Chan   string        `json:"chan,omitempty"`
+	Size   int64         `json:"size,omitempty"`
+	Line   string        `json:"line,omitempty"`
+	Params [][2]string   `json:"params,omitempty"`
+	Then   []cacheStmt   `json:"then,omitempty"`
+	Else   []cacheStmt   `json:"else,omitempty"`
+	Cases  [][]cacheStmt `json:"cases,omitempty"`
+}
+
+// cachedVar is a name of a variable loaded from the cache.
+type cachedVar string
+
+func (v cachedVar) Name() string   { return string(v) }
+func (v cachedVar) String() string { return string(v) }
+
+// key returns the key of the entry of summary sum, or an empty string if the
+// summary cannot be cached (its shape has instances of the analysis).
+func (c *Cache) key(sum *Summary, infer *TypeInfer) string {
+	if sum.key != sum.Shape {
+		return ""
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00", CacheVersion, infer.options(), c.fingerprint(sum.Fn, infer.SSA))
+	fmt.Fprintf(h, "%s\x00%s", sum.Fn.String(), sum.Shape)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// options returns the options of the analysis changing the definitions.
+func (infer *TypeInfer) options() string {
+	b := infer.Budget
+	return fmt.Sprintf("buffer=%s readers=%d dispatch=%s blocks=%d instances=%d goroutines=%d policies=%v stubs=%s",
+		infer.BufferPolicy, infer.RWReaders, infer.SSA.BuildConf.Dispatch, b.MaxBlocks, b.MaxInstances, b.MaxGoroutines, infer.SSA.BuildConf.Policies(), infer.Stubs.Hash())
+}
+
+// fingerprint returns the hash of the SSA of fn and of the functions fn may
+// reach (by calls, go statements and function values, and the initialisation
+// of their packages). Functions of the standard library are identified by
+// name only.
+func (c *Cache) fingerprint(fn *ssa.Function, info *ssabuilder.SSAInfo) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if fp, ok := c.prints[fn]; ok {
+		return fp
+	}
+	reached := make(map[*ssa.Function]bool)
+	var reach func(*ssa.Function)
+	reach = func(fn *ssa.Function) {
+		if fn == nil || reached[fn] {
+			return
+		}
+		reached[fn] = true
+		if isGoroot(fn) {
+			return
+		}
+		if fn.Pkg != nil {
+			reach(fn.Pkg.Func("init"))
+		}
+		for _, anon := range fn.AnonFuncs {
+			reach(anon)
+		}
+		for _, b := range fn.Blocks {
+			for _, instr := range b.Instrs {
+				for _, op := range instr.Operands(nil) {
+					if f, ok := (*op).(*ssa.Function); ok {
+						reach(f)
+					}
+				}
+				if site, ok := instr.(ssa.CallInstruction); ok && site.Common().StaticCallee() == nil {
+					for _, f := range info.Callees(site) {
+						reach(f)
+					}
+					if common := site.Common(); common.IsInvoke() { // May be resolved by concrete type.
+						c.index(fn.Prog)
+						for _, f := range c.meths[fn.Prog][common.Method.Name()] {
+							reach(f)
+						}
+					}
+				}
+			}
+		}
+	}
+	reach(fn)
+	hashes := make([]string, 0, len(reached))
+	for f := range reached {
+		hashes = append(hashes, c.ownHash(f))
+	}
+	sort.Strings(hashes)
+	sum := sha256.Sum256([]byte(strings.Join(hashes, "\n")))
+	c.prints[fn] = hex.EncodeToString(sum[:])
+	return c.prints[fn]
+}
+
+// ownHash returns the hash of the SSA of fn (of its name if it is in the
+// standard library).
+func (c *Cache) ownHash(fn *ssa.Function) string {
+	if h, ok := c.own[fn]; ok {
+		return h
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\n", fn.String())
+	if !isGoroot(fn) {
+		ssa.WriteFunction(&buf, fn)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	c.own[fn] = hex.EncodeToString(sum[:])
+	return c.own[fn]
+}
+
+// isGoroot returns true if fn is in a package of the standard library.
+func isGoroot(fn *ssa.Function) bool {
+	if f := fn.Prog.Fset.File(fn.Pos()); f != nil {
+		return strings.HasPrefix(f.Name(), filepath.Clean(build.Default.GOROOT)+string(filepath.Separator))
+	}
+	return false
+}
+
+// function returns the function named name in prog.
+func (c *Cache) function(prog *ssa.Program, name string) *ssa.Function {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.index(prog)
+	return c.funcs[prog][name]
+}
+
+// index indexes the functions of prog by name (if not indexed already).
+func (c *Cache) index(prog *ssa.Program) {
+	if _, ok := c.funcs[prog]; ok {
+		return
+	}
+	c.funcs[prog] = make(map[string]*ssa.Function)
+	c.meths[prog] = make(map[string][]*ssa.Function)
+	for fn := range ssautil.AllFunctions(prog) {
+		c.funcs[prog][fn.String()] = fn
+		if fn.Signature.Recv() != nil {
+			c.meths[prog][fn.Name()] = append(c.meths[prog][fn.Name()], fn)
+		}
+	}
+}
+
+// loadSummary loads summary sum (if not defined) from the cache (if enabled),
+// and returns true if it is loaded. The summaries defined by the entry are
+// defined with a placeholder instance, and their definitions are added to the
+// program.
+func (infer *TypeInfer) loadSummary(sum *Summary) bool {
+	c := infer.Cache
+	if c == nil || sum == nil || sum.callee != nil {
+		return false
+	}
+	key := c.key(sum, infer)
+	if key == "" {
+		return false
+	}
+	var entry cacheEntry
+	if err := c.read(key, &entry); err != nil || entry.Version != CacheVersion || len(entry.Summaries) == 0 {
+		c.count(&c.Misses)
+		return false
+	}
+	sums := make([]*Summary, len(entry.Summaries))
+	for i, cs := range entry.Summaries {
+		fn := c.function(sum.Fn.Prog, cs.Func)
+		if fn == nil {
+			c.count(&c.Misses)
+			return false
+		}
+		sums[i] = infer.Env.Summaries.Get(fn, cs.Shape, cs.Shape)
+	}
+	renames := make(map[string]string)
+	for i, cs := range entry.Summaries {
+		if name := sums[i].name(); name != cs.Name {
+			renames[cs.Name] = name
+		}
+	}
+	defs := make([]*migo.Function, len(entry.Defs))
+	for i, cd := range entry.Defs {
+		defs[i] = cd.decode(renames)
+	}
+	infer.Env.addDefs(defs)
+	for i, cs := range entry.Summaries {
+		if sums[i].callee != nil {
+			continue
+		}
+		sums[i].callee = &Function{
+			Fn:      sums[i].Fn,
+			hasBody: true,
+			retvals: make([]Instance, sums[i].Fn.Signature.Results().Len()),
+			loops:   cs.Loops,
+		}
+		sums[i].cached = true
+		for _, l := range cs.Loops {
+			infer.Logger.Printf("  cached %s %s", sums[i].name(), l)
+		}
+	}
+	c.count(&c.Hits)
+	infer.Logger.Printf("  cached summary %s (%s)", sum.name(), sum.Shape)
+	return sum.reusable()
+}
+
+// storeSummaries stores the summaries defined (and reusable) in the analysis
+// in the cache (if enabled), unless the analysis is partial.
+func (infer *TypeInfer) storeSummaries() {
+	c := infer.Cache
+	if c == nil || len(infer.Partial) > 0 {
+		return
+	}
+	defs := make(map[string]*migo.Function)
+	for _, def := range infer.Env.MigoProg.Funcs {
+		defs[def.Name] = def
+	}
+	byName := make(map[string]*Summary)
+	for _, sum := range infer.Env.Summaries.all {
+		if sum.Name != "" {
+			byName[sum.Name] = sum
+		}
+	}
+	for _, sum := range infer.Env.Summaries.all {
+		if sum.Name == "" || sum.cached || !sum.storable() {
+			continue
+		}
+		entry, err := newCacheEntry(sum, defs, byName)
+		if err != nil {
+			infer.Logger.Printf("Summary cache: %s: %v", sum.Name, err)
+			continue
+		}
+		if entry == nil {
+			continue
+		}
+		key := c.key(sum, infer)
+		if key == "" {
+			continue
+		}
+		if err := c.write(key, entry); err != nil {
+			infer.Logger.Printf("Summary cache: %v", err)
+			return
+		}
+		c.count(&c.Stores)
+	}
+}
+
+// storable returns true if the summary can be stored in the cache.
+func (s *Summary) storable() bool {
+	return s.key == s.Shape && !s.dirty && s.reusable()
+}
+
+// newCacheEntry returns the entry of summary sum, with the definitions (in
+// defs) reachable from the definition of sum. Returns a nil entry if a
+// definition reachable is not of a summary which can be stored or of a model,
+// or an error if a definition cannot be encoded.
+func newCacheEntry(sum *Summary, defs map[string]*migo.Function, byName map[string]*Summary) (*cacheEntry, error) {
+	entry := &cacheEntry{Version: CacheVersion}
+	seen := make(map[string]bool)
+	queue := []string{sum.Name}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		def, ok := defs[name]
+		if !ok {
+			return nil, nil
+		}
+		owner, _ := splitDef(name)
+		if s, ok := byName[owner]; ok {
+			if !s.storable() {
+				return nil, nil
+			}
+			if owner == name {
+				entry.Summaries = append(entry.Summaries, cacheSummary{Func: s.Fn.String(), Shape: s.Shape, Name: s.Name, Loops: s.callee.loops})
+			}
+		} else if !modelFuncs[owner] {
+			return nil, nil
+		}
+		cd, err := encodeDef(def)
+		if err != nil {
+			return nil, err
+		}
+		entry.Defs = append(entry.Defs, cd)
+		queue = append(queue, calledDefs(def.Stmts)...)
+	}
+	return entry, nil
+}
+
+// calledDefs returns the names of the definitions called or spawned in stmts.
+func calledDefs(stmts []migo.Statement) []string {
+	var names []string
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *migo.CallStatement:
+			names = append(names, s.Name)
+		case *migo.SpawnStatement:
+			names = append(names, s.Name)
+		case *migo.IfStatement:
+			names = append(names, calledDefs(s.Then)...)
+			names = append(names, calledDefs(s.Else)...)
+		case *migo.SelectStatement:
+			for _, c := range s.Cases {
+				names = append(names, calledDefs(c)...)
+			}
+		}
+	}
+	return names
+}
+
+func (c *Cache) count(n *int) {
+	c.mu.Lock()
+	*n++
+	c.mu.Unlock()
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.Dir, key[:2], key+".json")
+}
+
+func (c *Cache) read(key string, entry *cacheEntry) error {
+	b, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, entry)
+}
+
+// write writes entry of key, atomically so concurrent analyses do not read
+// partial entries.
+func (c *Cache) write(key string, entry *cacheEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), key)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func encodeDef(def *migo.Function) (cacheDef, error) {
+	stmts, err := encodeStmts(def.Stmts)
+	if err != nil {
+		return cacheDef{}, fmt.Errorf("definition %s: %v", def.Name, err)
+	}
+	return cacheDef{Name: def.Name, Params: encodeParams(def.Params), HasComm: def.HasComm, Stmts: stmts}, nil
+}
+
+func encodeParams(params []*migo.Parameter) [][2]string {
+	var ps [][2]string
+	for _, p := range params {
+		ps = append(ps, [2]string{p.Caller.Name(), p.Callee.Name()})
+	}
+	return ps
+}
+
+// encodeStmts returns the cache statements of stmts, or an error if a
+// statement is unknown.
+func encodeStmts(stmts []migo.Statement) ([]cacheStmt, error) {
+	cs := make([]cacheStmt, 0, len(stmts))
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *migo.CallStatement:
+			cs = append(cs, cacheStmt{Op: "call", Name: s.Name, Params: encodeParams(s.Params), Line: s.LineNum})
+		case *migo.SpawnStatement:
+			cs = append(cs, cacheStmt{Op: "spawn", Name: s.Name, Params: encodeParams(s.Params), Line: s.LineNum})
+		case *migo.NewChanStatement:
+			cs = append(cs, cacheStmt{Op: "newchan", Name: s.Name.Name(), Chan: s.Chan, Size: s.Size, Line: s.LineNum})
+		case *migo.CloseStatement:
+			cs = append(cs, cacheStmt{Op: "close", Chan: s.Chan, Line: s.LineNum})
+		case *migo.SendStatement:
+			cs = append(cs, cacheStmt{Op: "send", Chan: s.Chan, Line: s.LineNum})
+		case *migo.RecvStatement:
+			cs = append(cs, cacheStmt{Op: "recv", Chan: s.Chan, Line: s.LineNum})
+		case *migo.TauStatement:
+			cs = append(cs, cacheStmt{Op: "tau", Line: s.LineNum})
+		case *migo.IfStatement:
+			then, err := encodeStmts(s.Then)
+			if err != nil {
+				return nil, err
+			}
+			els, err := encodeStmts(s.Else)
+			if err != nil {
+				return nil, err
+			}
+			cs = append(cs, cacheStmt{Op: "if", Then: then, Else: els, Line: s.LineNum})
+		case *migo.SelectStatement:
+			sel := cacheStmt{Op: "select", Line: s.LineNum}
+			for _, c := range s.Cases {
+				stmts, err := encodeStmts(c)
+				if err != nil {
+					return nil, err
+				}
+				sel.Cases = append(sel.Cases, stmts)
+			}
+			cs = append(cs, sel)
+		default:
+			return nil, fmt.Errorf("unknown MiGo statement %T", stmt)
+		}
+	}
+	return cs, nil
+}
+
+// decode returns the definition, where the definitions are renamed by
+// renames.
+func (cd cacheDef) decode(renames map[string]string) *migo.Function {
+	def := migo.NewFunction(rename(cd.Name, renames))
+	def.AddParams(decodeParams(cd.Params)...)
+	def.HasComm = cd.HasComm
+	def.AddStmts(decodeStmts(cd.Stmts, renames)...)
+	return def
+}
+
+func decodeParams(ps [][2]string) []*migo.Parameter {
+	var params []*migo.Parameter
+	for _, p := range ps {
+		params = append(params, &migo.Parameter{Caller: cachedVar(p[0]), Callee: cachedVar(p[1])})
+	}
+	return params
+}
+
+func decodeStmts(cs []cacheStmt, renames map[string]string) []migo.Statement {
+	stmts := make([]migo.Statement, 0, len(cs))
+	for _, c := range cs {
+		switch c.Op {
+		case "call":
+			stmts = append(stmts, &migo.CallStatement{Name: rename(c.Name, renames), Params: decodeParams(c.Params), LineNum: c.Line})
+		case "spawn":
+			stmts = append(stmts, &migo.SpawnStatement{Name: rename(c.Name, renames), Params: decodeParams(c.Params), LineNum: c.Line})
+		case "newchan":
+			stmts = append(stmts, &migo.NewChanStatement{Name: cachedVar(c.Name), Chan: c.Chan, Size: c.Size, LineNum: c.Line})
+		case "close":
+			stmts = append(stmts, &migo.CloseStatement{Chan: c.Chan, LineNum: c.Line})
+		case "send":
+			stmts = append(stmts, &migo.SendStatement{Chan: c.Chan, LineNum: c.Line})
+		case "recv":
+			stmts = append(stmts, &migo.RecvStatement{Chan: c.Chan, LineNum: c.Line})
+		case "tau":
+			stmts = append(stmts, &migo.TauStatement{LineNum: c.Line})
+		case "if":
+			stmts = append(stmts, &migo.IfStatement{Then: decodeStmts(c.Then, renames), Else: decodeStmts(c.Else, renames), LineNum: c.Line})
+		case "select":
+			sel := &migo.SelectStatement{LineNum: c.Line}
+			for _, cc := range c.Cases {
+				sel.Cases = append(sel.Cases, decodeStmts(cc, renames))
+			}
+			stmts = append(stmts, sel)
+		}
+	}
+	return stmts
+}
@@ -0,0 +1,98 @@
+package migoextract
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/damifur/migo"
+)
+
+// unknownStmt is a MiGo statement unknown to the cache.
+type unknownStmt struct{}
+
+func (unknownStmt) String() string { return "unknown" }
+
+// TestEncodeStmts checks that statements decode to the statements encoded, and
+// that unknown statements are errors.
+func TestEncodeStmts(t *testing.T) {
+	stmts := []migo.Statement{
+		&migo.NewChanStatement{Name: cachedVar("t0"), Chan: "t0_chan0", Size: 1},
+		&migo.SpawnStatement{Name: "main.send", Params: []*migo.Parameter{{Caller: cachedVar("t0_chan0"), Callee: cachedVar("ch")}}},
+		&migo.IfStatement{
+			Then: []migo.Statement{&migo.SendStatement{Chan: "t0_chan0"}},
+			Else: []migo.Statement{&migo.SelectStatement{Cases: [][]migo.Statement{
+				{&migo.RecvStatement{Chan: "t0_chan0"}},
+				{&migo.TauStatement{}},
+			}}},
+		},
+		&migo.CloseStatement{Chan: "t0_chan0"},
+		&migo.CallStatement{Name: "main.recv"},
+	}
+	cs, err := encodeStmts(stmts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decodeStmts(cs, map[string]string{"main.recv": "main.recv#1"}); len(got) != len(stmts) {
+		t.Errorf("expecting %d statements decoded but got %d", len(stmts), len(got))
+	} else if call := got[4].(*migo.CallStatement); call.Name != "main.recv#1" {
+		t.Errorf("expecting call renamed to main.recv#1 but got %s", call.Name)
+	} else if !reflect.DeepEqual(got[:4], stmts[:4]) {
+		t.Errorf("expecting statements decoded as encoded:\n%v\ngot:\n%v", stmts[:4], got[:4])
+	}
+
+	nested := []migo.Statement{&migo.IfStatement{Then: []migo.Statement{unknownStmt{}}}}
+	if _, err := encodeStmts(nested); err == nil {
+		t.Errorf("expecting error for unknown statement")
+	}
+	def := migo.NewFunction("main.f")
+	def.AddStmts(nested...)
+	if _, err := encodeDef(def); err == nil {
+		t.Errorf("expecting error for definition with unknown statement")
+	}
+}
+
+// TestCacheModelFuncs checks that the model definitions of a cached summary,
+// which are only those it reaches, are completed when other models are needed.
+func TestCacheModelFuncs(t *testing.T) {
+	const src = `package main
+
+import "time"
+
+func wait() { <-time.After(time.Second) }
+
+func main() {
+	wait()
+	t := time.NewTicker(time.Second)
+	<-t.C
+	t.Stop()
+}
+`
+	dir, err := ioutil.TempDir("", "dingo-hunter-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var want map[string]string
+	for i := 0; i < 2; i++ { // Stores then loads the summary of wait.
+		c, err := OpenCache(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		infer := newInfer(t, buildSSA(t, src))
+		infer.Cache = c
+		run(t, infer)
+		defs := make(map[string]string)
+		for _, def := range infer.Env.MigoProg.Funcs {
+			defs[def.Name] = block(def.Stmts)
+		}
+		if i == 0 {
+			want = defs
+		} else if c.Hits == 0 {
+			t.Errorf("expecting summary of main.wait cached, got %s", c)
+		} else if !reflect.DeepEqual(defs, want) {
+			t.Errorf("expecting definitions with cached summary as without:\n%v\ngot:\n%v", want, defs)
+		}
+	}
+}
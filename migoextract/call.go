@@ -8,6 +8,7 @@ import (
 
 	"strings"
 
+	"github.com/damifur/dingo-hunter/ssabuilder"
 	"github.com/damifur/migo"
 	"golang.org/x/tools/go/ssa"
 )
@@ -15,17 +16,26 @@ import (
 // Call performs call on a given unprepared call context.
 func (caller *Function) Call(call *ssa.Call, infer *TypeInfer, b *Block, l *Loop) {
 	if call == nil {
-		infer.Logger.Fatal("Call is nil")
+		infer.unsupported(nil, "Call is nil")
 		return
 	}
 	common := call.Common()
+	if caller.callContext(common, call, call.Pos(), infer, l) {
+		return
+	}
+	if caller.callTimer(common, call, call.Pos(), infer, l) {
+		return
+	}
+	if caller.callSignal(common, call.Pos(), infer) {
+		return
+	}
 	switch fn := common.Value.(type) {
 	case *ssa.Builtin:
 		switch fn.Name() {
 		case "close":
 			ch, ok := caller.locals[common.Args[0]]
 			if !ok {
-				infer.Logger.Fatalf("call close: %s: %s", common.Args[0].Name(), ErrUnknownValue)
+				infer.unsupported(call, "call close: %s: %s", common.Args[0].Name(), ErrUnknownValue)
 				return
 			}
 			if paramName, ok := caller.revlookup[ch.String()]; ok {
@@ -59,64 +69,122 @@ func (caller *Function) Call(call *ssa.Call, infer *TypeInfer, b *Block, l *Loop
 		caller.callClosure(common, fn, infer, b, l)
 	case *ssa.Function:
 		if common.StaticCallee() == nil {
-			infer.Logger.Fatal("Call with nil CallCommon")
+			infer.unsupported(call, "Call with nil CallCommon")
+		}
+		if caller.callSync(common, call.Pos(), infer) {
+			return
+		}
+		if caller.callStub(common, call, false, call.Pos(), infer) {
+			return
 		}
 		callee := caller.callFn(common, infer, b, l)
 		if callee != nil {
 			caller.storeRetvals(infer, call.Value(), callee)
 		}
 	default:
+		if _, ok := caller.locals[common.Value].(*Value); !ok || !common.IsInvoke() {
+			// Not a method of a known instance: follow the resolved callees.
+			if fns := caller.callees(call, infer); len(fns) > 0 {
+				caller.callDynamic(call, fns, infer, b, l)
+				return
+			}
+		}
 		if !common.IsInvoke() {
-			infer.Logger.Print("Unknown call type", common.String(), common.Description())
+			infer.warn(caller.Fn, call.Pos(), "Unknown call type %s %s", common.String(), common.Description())
 			return
 		}
 		callee := caller.invoke(common, infer, b, l)
 		if callee != nil {
 			caller.storeRetvals(infer, call.Value(), callee)
 		} else {
-			// Mock out the return values.
-			switch common.Signature().Results().Len() {
-			case 0:
-			case 1:
-				caller.locals[call.Value()] = &External{
-					parent: caller.Fn,
-					typ:    call.Value().Type().Underlying(),
-				}
-			case 2:
-				caller.locals[call.Value()] = &External{typ: call.Value().Type().Underlying()}
-				caller.tuples[caller.locals[call.Value()]] = make(Tuples, common.Signature().Results().Len())
-			}
+			caller.mockRetvals(call)
+		}
+	}
+}
+
+// mockRetvals mocks out the return values of call.
+func (caller *Function) mockRetvals(call *ssa.Call) {
+	switch call.Common().Signature().Results().Len() {
+	case 0:
+	case 1:
+		caller.locals[call.Value()] = &External{
+			parent: caller.Fn,
+			typ:    call.Value().Type().Underlying(),
 		}
+	case 2:
+		caller.locals[call.Value()] = &External{typ: call.Value().Type().Underlying()}
+		caller.tuples[caller.locals[call.Value()]] = make(Tuples, call.Common().Signature().Results().Len())
 	}
 }
 
 // Go handles Go statements.
 func (caller *Function) Go(instr *ssa.Go, infer *TypeInfer) {
 	common := instr.Common()
-	callee := caller.prepareCallFn(common, common.StaticCallee(), nil)
-	// TODO: Aca tenés un ejemplo de cómo pasa de TypeInfer a Stmt. En el type infer tenés el número de linea y lo tenés que meter en el stms para que después lo imprima en el archivo MIGO
-	// 	fmt.Println("Estoy en visit Go: ", strings.Split(fmtPos(infer.SSA.FSet.Position(instr.Pos()).String()), ":")[1])
-	spawnStmt := &migo.SpawnStatement{Name: callee.Fn.String(), Params: []*migo.Parameter{}, LineNum: strings.Split(fmtPos(infer.SSA.FSet.Position(instr.Pos()).String()), ":")[1]}
-	for i, c := range common.Args {
-		if _, ok := c.Type().(*types.Chan); ok {
-			ch := getChan(c, infer)
-			spawnStmt.AddParams(&migo.Parameter{Caller: ch, Callee: callee.Fn.Params[i]})
+	if max := infer.Budget.MaxGoroutines; max > 0 && len(infer.GQueue) >= max {
+		infer.partial(caller.Fn, instr.Pos(), &ssabuilder.BudgetError{Budget: "max-goroutines", Limit: max})
+		return
+	}
+	if caller.callStub(common, nil, true, instr.Pos(), infer) {
+		return
+	}
+	fns := caller.callees(instr, infer)
+	if len(fns) == 0 {
+		infer.warn(caller.Fn, instr.Pos(), "go %s: no callee found", common.String())
+		return
+	}
+	var rcvr ssa.Value
+	if common.IsInvoke() {
+		rcvr = common.Value
+	}
+	spawns := make([][]migo.Statement, len(fns))
+	for i, fn := range fns {
+		callee := caller.prepareCallFn(common, fn, rcvr)
+		// TODO: Aca tenés un ejemplo de cómo pasa de TypeInfer a Stmt. En el type infer tenés el número de linea y lo tenés que meter en el stms para que después lo imprima en el archivo MIGO
+		// 	fmt.Println("Estoy en visit Go: ", strings.Split(fmtPos(infer.SSA.FSet.Position(instr.Pos()).String()), ":")[1])
+		if sum := callee.summary; sum.callee != nil || sum.queued || infer.loadSummary(sum) {
+			sum.Uses++ // Definition reused.
+		} else {
+			// Don't actually call/visit the function but enqueue it.
+			sum.queued = true
+			callee.newInstance()
+			callee.FuncDef.Name = callee.DefName()
+			infer.GQueue = append(infer.GQueue, callee)
 		}
+		spawnStmt := &migo.SpawnStatement{Name: callee.DefName(), Params: caller.callParams(common, callee, rcvr, infer), LineNum: strings.Split(fmtPos(infer.SSA.FSet.Position(instr.Pos()).String()), ":")[1]}
+		spawns[i] = []migo.Statement{spawnStmt}
+	}
+	caller.FuncDef.AddStmts(choice(spawns)...)
+}
+
+// callParams returns the parameters passing the channels and sync values of
+// the arguments of common (with receiver rcvr if invoke) to callee.
+func (caller *Function) callParams(common *ssa.CallCommon, callee *Function, rcvr ssa.Value, infer *TypeInfer) []*migo.Parameter {
+	params := []*migo.Parameter{}
+	for i, param := range callee.Fn.Params {
+		arg := rcvr
+		if rcvr == nil {
+			arg = common.Args[i]
+		} else if i > 0 {
+			arg = common.Args[i-1]
+		}
+		if _, ok := arg.Type().(*types.Chan); ok {
+			params = append(params, &migo.Parameter{Caller: getChan(arg, infer), Callee: param})
+		}
+		params = append(params, syncParams(caller.syncArg(arg), param, arg.Type())...)
 	}
 	if inst, ok := caller.locals[common.Value]; ok {
 		if bindings, ok := caller.Prog.closures[inst]; ok {
 			for _, b := range bindings {
 				if v, ok := b.(*Value); ok {
 					if _, ok := derefType(v.Type()).(*types.Chan); ok {
-						spawnStmt.AddParams(&migo.Parameter{Caller: v, Callee: v})
+						params = append(params, &migo.Parameter{Caller: v, Callee: v})
 					}
+					params = append(params, syncParams(v, v, v.Type())...)
 				}
 			}
 		}
 	}
-	caller.FuncDef.AddStmts(spawnStmt)
-	// Don't actually call/visit the function but enqueue it.
-	infer.GQueue = append(infer.GQueue, callee)
+	return append(params, callee.syncArgs...)
 }
 
 // callLen computes the length of a given data structure (if statically known).
@@ -188,7 +256,7 @@ func (caller *Function) storeRetvals(infer *TypeInfer, retval ssa.Value, callee
 			infer.Logger.Print(caller.Sprintf(ExitSymbol+"[1] constant %s", inst))
 			return
 		default:
-			infer.Logger.Fatalf("return[1]: %s: not an instance %+v", ErrUnknownValue, retval)
+			infer.unsupported(nil, "return[1]: %s: not an instance %+v", ErrUnknownValue, retval)
 		}
 	default:
 		caller.locals[retval] = &Value{retval, caller.InstanceID(), int64(0), 0}
@@ -254,12 +322,12 @@ func (caller *Function) IsRecursiveCall() bool {
 func (caller *Function) invoke(common *ssa.CallCommon, infer *TypeInfer, b *Block, l *Loop) *Function {
 	iface, ok := common.Value.Type().Underlying().(*types.Interface)
 	if !ok {
-		infer.Logger.Fatalf("invoke: %s is not an interface", common.String())
+		infer.unsupported(nil, "invoke: %s is not an interface", common.String())
 		return nil
 	}
 	ifaceInst, ok := caller.locals[common.Value] // SSA value initialised
 	if !ok {
-		infer.Logger.Fatalf("invoke: %s: %s", common.Value.Name(), ErrUnknownValue)
+		infer.unsupported(nil, "invoke: %s: %s", common.Value.Name(), ErrUnknownValue)
 		return nil
 	}
 	switch inst := ifaceInst.(type) {
@@ -268,7 +336,7 @@ func (caller *Function) invoke(common *ssa.CallCommon, infer *TypeInfer, b *Bloc
 		if inst.Const.IsNil() {
 			return nil
 		}
-		infer.Logger.Fatalf("invoke: %+v is not nil nor concrete", ifaceInst)
+		infer.unsupported(nil, "invoke: %+v is not nil nor concrete", ifaceInst)
 	case *External:
 		infer.Logger.Printf(caller.Sprintf("invoke: %+v external", ifaceInst))
 		return nil
@@ -280,16 +348,16 @@ func (caller *Function) invoke(common *ssa.CallCommon, infer *TypeInfer, b *Bloc
 	if meth != nil {
 		meth, _ = types.MissingMethod(ifaceInst.(*Value).Type(), iface, false) // non-static
 		if meth != nil {
-			infer.Logger.Printf("invoke: missing method %s: %s", meth.String(), ErrIfaceIncomplete)
+			infer.warn(caller.Fn, common.Pos(), "invoke: missing method %s: %s", meth.String(), ErrIfaceIncomplete)
 			return nil
 		}
 	}
 	fn := findMethod(common.Value.Parent().Prog, common.Method, ifaceInst.(*Value).Type(), infer)
 	if fn == nil {
 		if meth == nil {
-			infer.Logger.Printf("invoke: cannot locate concrete method")
+			infer.warn(caller.Fn, common.Pos(), "invoke: cannot locate concrete method")
 		} else {
-			infer.Logger.Printf("invoke: cannot locate concrete method: %s", meth.String())
+			infer.warn(caller.Fn, common.Pos(), "invoke: cannot locate concrete method: %s", meth.String())
 		}
 		return nil
 	}
@@ -305,26 +373,11 @@ func (caller *Function) call(common *ssa.CallCommon, fn *ssa.Function, rcvr ssa.
 	if callee.IsRecursiveCall() {
 		return callee
 	}
-	visitFunc(callee.Fn, infer, callee)
+	if !caller.visitCallee(callee, common.Pos(), infer) {
+		return callee
+	}
 	if callee.HasBody() {
-		callStmt := &migo.CallStatement{Name: callee.Fn.String(), Params: []*migo.Parameter{}, LineNum: strings.Split(fmtPos(infer.SSA.FSet.Position(common.Pos()).String()), ":")[1]}
-		for i, c := range common.Args {
-			if _, ok := c.Type().(*types.Chan); ok {
-				ch := getChan(c, infer)
-				callStmt.AddParams(&migo.Parameter{Caller: ch, Callee: callee.Fn.Params[i]})
-			}
-		}
-		if inst, ok := caller.locals[common.Value]; ok {
-			if bindings, ok := caller.Prog.closures[inst]; ok {
-				for _, b := range bindings {
-					if v, ok := b.(*Value); ok {
-						if _, ok := derefType(v.Type()).(*types.Chan); ok {
-							callStmt.AddParams(&migo.Parameter{Caller: v, Callee: v})
-						}
-					}
-				}
-			}
-		}
+		callStmt := &migo.CallStatement{Name: callee.DefName(), Params: caller.callParams(common, callee, rcvr, infer), LineNum: strings.Split(fmtPos(infer.SSA.FSet.Position(common.Pos()).String()), ":")[1]}
 		caller.FuncDef.AddStmts(callStmt)
 	}
 	return callee
@@ -344,6 +397,6 @@ func findMethod(prog *ssa.Program, meth *types.Func, typ types.Type, infer *Type
 	if meth != nil {
 		return prog.LookupMethod(typ, meth.Pkg(), meth.Name())
 	}
-	infer.Logger.Fatal(ErrMethodNotFound)
+	infer.unsupported(nil, "%v", ErrMethodNotFound)
 	return nil
 }
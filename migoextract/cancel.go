@@ -0,0 +1,188 @@
+package migoextract
+
+// Functions for handling package context.
+//
+// The context package is not built (see ssabuilder), so contexts are encoded
+// with channels instead. The done channel of a context is an unbuffered
+// channel which is closed when the context is cancelled, so ctx.Done() is the
+// done channel and receives from it only proceed after cancellation. Each
+// context also has a once channel with buffer size 1 holding a single message,
+// so the cancel function closes the done channel only once:
+//
+//   def context.cancel(once, done): select case recv once; close done; case recv done; endselect;
+//
+// WithTimeout and WithDeadline spawn context.cancel as the timer, which may
+// fire at any time. A context derived from a cancellable parent spawns
+// context.propagate, which cancels the context when the parent is done:
+//
+//   def context.propagate(parent, once, done): select case recv parent; call context.cancel(once, done); case recv done; endselect;
+//
+// Background and TODO contexts are never cancelled. Contexts are passed to
+// functions like sync values (see sync.go), with their two channels.
+
+import (
+	"go/token"
+	"go/types"
+	"strconv"
+
+	"github.com/damifur/migo"
+	"golang.org/x/tools/go/ssa"
+)
+
+// modelVar is a parameter of a model function (e.g. context.cancel).
+type modelVar string
+
+func (p modelVar) Name() string   { return string(p) }
+func (p modelVar) String() string { return string(p) }
+
+// onceChan is the once channel of a context.
+type onceChan struct {
+	ssa.Value
+}
+
+func (o onceChan) Name() string   { return o.Value.Name() + "_once" }
+func (o onceChan) String() string { return o.Value.String() + "_once" }
+
+// contextVar is the done channel of a context.
+type contextVar struct {
+	ssa.Value            // Call creating the context (or root parameter).
+	typ       types.Type // context.Context.
+}
+
+func (v contextVar) Type() types.Type { return v.typ }
+
+// isContextFunc returns true if fn is a function of package context.
+func isContextFunc(fn *ssa.Function) bool {
+	return fn != nil && fn.Pkg != nil && fn.Pkg.Pkg.Path() == "context" && fn.Signature.Recv() == nil
+}
+
+// callContext models a call of a function of package context, of a method of
+// a context or of a cancel function, returns false if common is not such a
+// call. The results are stored in value (nil if the call is deferred).
+func (caller *Function) callContext(common *ssa.CallCommon, value ssa.Value, pos token.Pos, infer *TypeInfer, l *Loop) bool {
+	if fn := common.StaticCallee(); isContextFunc(fn) {
+		if value == nil {
+			return false
+		}
+		switch fn.Name() {
+		case "Background", "TODO":
+			caller.locals[value] = caller.makeContext(value, value.Type(), l.Index, infer)
+		case "WithCancel", "WithTimeout", "WithDeadline":
+			inst := caller.makeContext(value, value.Type().(*types.Tuple).At(0).Type(), l.Index, infer)
+			caller.locals[value] = inst
+			caller.tuples[inst] = Tuples{inst, inst} // Context and cancel function.
+			caller.cancellable(inst, common.Args[0], fn.Name() != "WithCancel", pos, infer)
+		case "WithValue":
+			parent, ok := caller.locals[common.Args[0]]
+			if !ok {
+				return false
+			}
+			caller.locals[value] = parent
+		default:
+			return false
+		}
+		infer.Logger.Print(caller.Sprintf("context.%s @ %s", fn.Name(), fmtPos(infer.SSA.FSet.Position(pos).String())))
+		return true
+	}
+	inst, ok := caller.locals[common.Value]
+	if !ok || syncOf(common.Value.Type()) != cancelCtx {
+		return false
+	}
+	if _, ok := caller.Prog.contexts[inst]; !ok {
+		return false
+	}
+	name := caller.syncName(common.Value, inst)
+	if !common.IsInvoke() { // Cancel function.
+		caller.FuncDef.AddStmts(&migo.CallStatement{
+			Name:    "context.cancel",
+			Params:  []*migo.Parameter{modelArg(name+"_once", "once"), modelArg(name, "done")},
+			LineNum: strconv.Itoa(infer.SSA.FSet.Position(pos).Line),
+		})
+		infer.Logger.Print(caller.Sprintf("%s cancel @ %s", inst, fmtPos(infer.SSA.FSet.Position(pos).String())))
+		return true
+	}
+	if value == nil {
+		return true
+	}
+	switch common.Method.Name() {
+	case "Done":
+		caller.locals[value] = inst
+	default: // Err, Deadline and Value do not communicate.
+		caller.locals[value] = &External{parent: caller.Fn, typ: value.Type().Underlying()}
+		if tuple, ok := value.Type().(*types.Tuple); ok {
+			caller.tuples[caller.locals[value]] = make(Tuples, tuple.Len())
+		}
+	}
+	return true
+}
+
+// modelArg returns the parameter passing channel name to param of a model
+// function.
+func modelArg(name, param string) *migo.Parameter {
+	return &migo.Parameter{Caller: modelVar(name), Callee: modelVar(param)}
+}
+
+// makeContext creates the channels of a context made by v (of type typ).
+func (caller *Function) makeContext(v ssa.Value, typ types.Type, loopIdx int64, infer *TypeInfer) *Value {
+	done := contextVar{Value: v, typ: typ}
+	inst := &Value{done, caller.InstanceID(), loopIdx, 0}
+	line := strconv.Itoa(infer.SSA.FSet.Position(v.Pos()).Line)
+	caller.FuncDef.AddStmts(
+		&migo.NewChanStatement{Name: done, Chan: inst.String(), Size: 0, LineNum: line},
+		&migo.NewChanStatement{Name: onceChan{done}, Chan: inst.String() + "_once", Size: 1, LineNum: line})
+	caller.extraargs = append(caller.extraargs, done, onceChan{done})
+	caller.Prog.contexts[inst] = false
+	caller.Prog.effects++
+	infer.Logger.Print(caller.Sprintf(ChanSymbol+"%s = %s", inst, fmtChan(typ.String())))
+	return inst
+}
+
+// cancellable makes context inst cancellable, where the context is derived
+// from parent and cancelled by a timer if timer is true.
+func (caller *Function) cancellable(inst *Value, parent ssa.Value, timer bool, pos token.Pos, infer *TypeInfer) {
+	caller.Prog.contexts[inst] = true
+	caller.Prog.effects++
+	caller.defineContextFuncs(infer)
+	name, line := inst.Name(), strconv.Itoa(infer.SSA.FSet.Position(pos).Line)
+	caller.FuncDef.AddStmts(&migo.SendStatement{Chan: name + "_once", LineNum: line})
+	if parentInst, ok := caller.locals[parent]; ok && caller.Prog.contexts[parentInst] {
+		caller.FuncDef.AddStmts(&migo.SpawnStatement{
+			Name:    "context.propagate",
+			Params:  []*migo.Parameter{modelArg(caller.syncName(parent, parentInst), "parent"), modelArg(name+"_once", "once"), modelArg(name, "done")},
+			LineNum: line,
+		})
+	}
+	if timer {
+		caller.FuncDef.AddStmts(&migo.SpawnStatement{
+			Name:    "context.cancel",
+			Params:  []*migo.Parameter{modelArg(name+"_once", "once"), modelArg(name, "done")},
+			LineNum: line,
+		})
+	}
+}
+
+// defineContextFuncs adds the context model functions to the program, if
+// they are not added already.
+func (caller *Function) defineContextFuncs(infer *TypeInfer) {
+	if caller.Prog.contextFuncs {
+		return
+	}
+	caller.Prog.contextFuncs = true
+	cancel := migo.NewFunction("context.cancel")
+	cancel.AddParams(modelArg("once", "once"), modelArg("done", "done"))
+	cancel.AddStmts(&migo.SelectStatement{Cases: [][]migo.Statement{
+		{&migo.RecvStatement{Chan: "once", LineNum: "0"}, &migo.CloseStatement{Chan: "done", LineNum: "0"}},
+		{&migo.RecvStatement{Chan: "done", LineNum: "0"}},
+	}})
+	propagate := migo.NewFunction("context.propagate")
+	propagate.AddParams(modelArg("parent", "parent"), modelArg("once", "once"), modelArg("done", "done"))
+	propagate.AddStmts(&migo.SelectStatement{Cases: [][]migo.Statement{
+		{&migo.RecvStatement{Chan: "parent", LineNum: "0"}, &migo.CallStatement{
+			Name:    "context.cancel",
+			Params:  []*migo.Parameter{modelArg("once", "once"), modelArg("done", "done")},
+			LineNum: "0",
+		}},
+		{&migo.RecvStatement{Chan: "done", LineNum: "0"}},
+	}})
+	infer.Env.addDefs([]*migo.Function{cancel, propagate})
+}
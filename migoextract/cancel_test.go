@@ -0,0 +1,82 @@
+package migoextract
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestCancel checks the statements of contexts, which are done channels closed
+// once by context.cancel, from their cancel function, their timer or
+// context.propagate of the cancellation of their parent.
+func TestCancel(t *testing.T) {
+	const src = `package main
+
+import (
+	"context"
+	"time"
+)
+
+var timeout = time.Second
+
+func worker(ctx context.Context, done chan struct{}) {
+	<-ctx.Done()
+	close(done)
+}
+
+func main() {
+	done := make(chan struct{})
+	%s
+}
+`
+	const (
+		withCancel  = "ctx, cancel := context.WithCancel(context.Background()); go worker(ctx, done); cancel(); <-done; cancel()"
+		withTimeout = "ctx, cancel := context.WithTimeout(context.Background(), timeout); go worker(ctx, done); <-done; cancel()"
+		withParent  = "parent, cancel := context.WithCancel(context.Background()); ctx, _ := context.WithCancel(parent); go worker(ctx, done); cancel(); <-done"
+	)
+	for _, tc := range []struct {
+		body string
+		def  string
+		want string
+	}{
+		{withCancel, "main.main", "let t0 = newchan 0; let t1 = newchan 0; let t1_once = newchan 1; let t2 = newchan 0; let t2_once = newchan 1; send t2_once; spawn main.worker(t2, t2_once, t0); call context.cancel(t2_once, t2); recv t0; call context.cancel(t2_once, t2)"},
+		{withCancel, "main.worker", "recv ctx; close done"},
+		{withCancel, "context.cancel", "select { case recv once; close done case recv done }"},
+		{withTimeout, "main.main", "let t0 = newchan 0; let t1 = newchan 0; let t1_once = newchan 1; let t3 = newchan 0; let t3_once = newchan 1; send t3_once; spawn context.cancel(t3_once, t3); spawn main.worker(t3, t3_once, t0); recv t0; call context.cancel(t3_once, t3)"},
+		{withParent, "main.main", "let t0 = newchan 0; let t1 = newchan 0; let t1_once = newchan 1; let t2 = newchan 0; let t2_once = newchan 1; send t2_once; let t5 = newchan 0; let t5_once = newchan 1; send t5_once; spawn context.propagate(t2, t5_once, t5); spawn main.worker(t5, t5_once, t0); call context.cancel(t2_once, t2); recv t0"},
+		{withParent, "context.propagate", "select { case recv parent; call context.cancel(once, done) case recv done }"},
+		{"go worker(context.Background(), done); <-done", "main.main", "let t0 = newchan 0; let t1 = newchan 0; let t1_once = newchan 1; spawn main.worker(t1, t1_once, t0); recv t0"},
+	} {
+		infer := newInfer(t, buildSSA(t, fmt.Sprintf(src, tc.body)))
+		run(t, infer)
+		if got := stmts(t, infer, tc.def); got != tc.want {
+			t.Errorf("%s: expecting %s %q, got %q", tc.body, tc.def, tc.want, got)
+		}
+	}
+}
+
+// TestCancelDeadlock checks the deadlock of a goroutine waiting for a context
+// cancelled only after it is waited for.
+func TestCancelDeadlock(t *testing.T) {
+	const src = `package main
+
+import "context"
+
+func worker(ctx context.Context, done chan struct{}) {
+	<-ctx.Done()
+	close(done)
+}
+
+func main() {
+	done := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go worker(ctx, done)
+	<-done
+	cancel()
+}
+`
+	infer := newInfer(t, buildSSA(t, src))
+	run(t, infer)
+	if res := check(t, infer); res.Live() || !res.Safe() {
+		t.Errorf("expecting deadlock and safe, got %+v", res)
+	}
+}
@@ -21,6 +21,6 @@ func getChan(val ssa.Value, infer *TypeInfer) ssa.Value {
 			return val
 		}
 	}
-	infer.Logger.Print("Don't know where this chan comes from:", val.String())
+	infer.warn(val.Parent(), val.Pos(), "Don't know where this chan comes from: %s", val.String())
 	return val
 }
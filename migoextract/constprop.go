@@ -0,0 +1,19 @@
+package migoextract
+
+// Constant propagation of integer values, e.g. for channel buffer sizes.
+
+import (
+	"github.com/damifur/dingo-hunter/ssabuilder"
+	"golang.org/x/tools/go/ssa"
+)
+
+// constInt resolves v to a constant integer by propagating constants through
+// the SSA, and through the constant arguments of the function context.
+func constInt(v ssa.Value, ctx *Function) (int64, bool) {
+	return ssabuilder.ConstInt(v, func(v ssa.Value) *ssa.Const {
+		if c, ok := ctx.locals[v].(*Const); ok {
+			return c.Const
+		}
+		return nil
+	})
+}
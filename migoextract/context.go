@@ -7,8 +7,8 @@ import (
 	"bytes"
 	"fmt"
 	"go/types"
-	"log"
 
+	"github.com/damifur/dingo-hunter/ssabuilder"
 	"github.com/damifur/migo"
 	"golang.org/x/tools/go/ssa"
 )
@@ -39,13 +39,20 @@ const (
 // A single inference has exactly one Program, and it contains all global
 // data (and metadata) in the program.
 type Program struct {
-	FuncInstance map[*ssa.Function]int  // Count number of function instances.
-	InitPkgs     map[*ssa.Package]bool  // Initialised packages.
-	Infer        *TypeInfer             // Reference to inference.
-	MigoProg     *migo.Program          // Core calculus of program.
-	closures     map[Instance]Captures  // Closures.
-	globals      map[ssa.Value]Instance // Global variables.
-	*Storage                            // Storage.
+	FuncInstance map[*ssa.Function]int       // Count number of function instances.
+	InitPkgs     map[*ssa.Package]bool       // Initialised packages.
+	Infer        *TypeInfer                  // Reference to inference.
+	MigoProg     *migo.Program               // Core calculus of program.
+	closures     map[Instance]Captures       // Closures.
+	globals      map[ssa.Value]Instance      // Global variables.
+	waitGroups   map[Instance]*waitGroupChan // Channels of WaitGroups.
+	contexts     map[Instance]bool           // Contexts (true if cancellable).
+	contextFuncs bool                        // Context model functions defined.
+	timerFuncs   bool                        // Timer model functions defined.
+	signalFuncs  bool                        // Signal model functions defined.
+	effects      int                         // Changes of sync and context state (see Summary).
+	Summaries    *Summaries                  // Function summaries.
+	*Storage                                 // Storage.
 }
 
 // NewProgram creates a program for a type inference.
@@ -56,6 +63,9 @@ func NewProgram(infer *TypeInfer) *Program {
 		Infer:        infer,
 		closures:     make(map[Instance]Captures),
 		globals:      make(map[ssa.Value]Instance),
+		waitGroups:   make(map[Instance]*waitGroupChan),
+		contexts:     make(map[Instance]bool),
+		Summaries:    NewSummaries(),
 		Storage:      NewStorage(),
 	}
 }
@@ -74,7 +84,10 @@ type Function struct {
 	ChildBlocks map[int]*Block          // Map from index -> child SSA blocks.
 
 	id        int                    // Instance identifier.
+	summary   *Summary               // Summary defined or reused (nil for root).
 	hasBody   bool                   // True if function has body.
+	blocks    int                    // Number of blocks visited.
+	syncArgs  []*migo.Parameter      // Sync fields of struct arguments.
 	commaok   map[Instance]*CommaOk  // CommaOK statements.
 	defers    []*ssa.Defer           // Deferred calls.
 	locals    map[ssa.Value]Instance // Local variable instances.
@@ -84,6 +97,7 @@ type Function struct {
 	selects   map[Instance]*Select // Select cases mapping.
 	tuples    map[Instance]Tuples  // Tuples.
 	loopstack *LoopStack           // Stack of Loop.
+	loops     []LoopFact           // Bounds of loops found.
 	*Storage                       // Storage.
 }
 
@@ -142,14 +156,7 @@ func (caller *Function) HasBody() bool { return caller.hasBody }
 func (caller *Function) prepareCallFn(common *ssa.CallCommon, fn *ssa.Function, rcvr ssa.Value) *Function {
 	callee := NewFunction(caller)
 	callee.Fn = fn
-	// This function was called before
-	if _, ok := callee.Prog.FuncInstance[callee.Fn]; ok {
-		callee.Prog.FuncInstance[callee.Fn]++
-	} else {
-		callee.Prog.FuncInstance[callee.Fn] = 0
-	}
-	callee.FuncDef.Name = fn.String()
-	callee.id = callee.Prog.FuncInstance[callee.Fn]
+	args := make([]ssa.Value, len(callee.Fn.Params))
 	for i, param := range callee.Fn.Params {
 		var argCaller ssa.Value
 		if rcvr != nil {
@@ -161,10 +168,13 @@ func (caller *Function) prepareCallFn(common *ssa.CallCommon, fn *ssa.Function,
 		} else {
 			argCaller = common.Args[i]
 		}
+		args[i] = argCaller
 		if _, ok := argCaller.Type().(*types.Chan); ok {
 			callee.FuncDef.AddParams(&migo.Parameter{Caller: argCaller, Callee: param})
 		}
-		if inst, ok := caller.locals[argCaller]; ok {
+		callee.FuncDef.AddParams(syncParams(caller.syncArg(argCaller), param, argCaller.Type())...)
+		callee.syncArgs = append(callee.syncArgs, caller.structSyncParams(argCaller, param, callee)...)
+		if inst, ok := caller.locals[argCaller]; ok && inst != nil {
 			callee.locals[param] = inst
 			callee.revlookup[argCaller.Name()] = param.Name()
 
@@ -190,17 +200,34 @@ func (caller *Function) prepareCallFn(common *ssa.CallCommon, fn *ssa.Function,
 				if _, ok := derefType(fv.Type()).(*types.Chan); ok {
 					callee.FuncDef.AddParams(&migo.Parameter{Caller: fv, Callee: fv})
 				}
+				callee.FuncDef.AddParams(syncParams(fv, fv, fv.Type())...)
 			}
 		}
 	}
+	callee.FuncDef.AddParams(callee.syncArgs...)
+	shape, key := caller.shapeOf(common, callee, args)
+	callee.summary = caller.Prog.Summaries.Get(fn, shape, key)
+	callee.FuncDef.Name = callee.DefName()
 	return callee
 }
 
+// newInstance numbers the callee as a new instance of its function, before
+// the function is visited.
+func (callee *Function) newInstance() {
+	// This function was called before
+	if _, ok := callee.Prog.FuncInstance[callee.Fn]; ok {
+		callee.Prog.FuncInstance[callee.Fn]++
+	} else {
+		callee.Prog.FuncInstance[callee.Fn] = 0
+	}
+	callee.id = callee.Prog.FuncInstance[callee.Fn]
+}
+
 // InstanceID returns the current function instance number (numbers of times
 // function called).
 func (caller *Function) InstanceID() int {
 	if caller.id < 0 {
-		log.Fatal(ErrUnitialisedFunc)
+		panic(ssabuilder.NewUnsupportedError(caller.Fn, nil, "%v", ErrUnitialisedFunc))
 	}
 	return caller.id
 }
@@ -209,7 +236,7 @@ func (caller *Function) String() string {
 	var buf bytes.Buffer
 	buf.WriteString("--- Context ---\n")
 	if caller.Fn == nil {
-		log.Fatal(ErrUnitialisedFunc)
+		panic(ssabuilder.NewUnsupportedError(nil, nil, "%v", ErrUnitialisedFunc))
 	}
 	buf.WriteString(fmt.Sprintf("\t- Fn:\t%s_%d\n", caller.Fn, caller.id))
 	if caller.Caller != nil {
@@ -272,7 +299,7 @@ type Block struct {
 
 // NewBlock creates a new block enclosed by the given function.
 func NewBlock(parent *Function, block *ssa.BasicBlock, curr int) *Block {
-	blockFn := fmt.Sprintf("%s#%d", parent.Fn.String(), block.Index)
+	blockFn := fmt.Sprintf("%s#%d", parent.DefName(), block.Index)
 	parent.ChildBlocks[block.Index] = &Block{
 		Function: parent,
 		MigoDef:  migo.NewFunction(blockFn),
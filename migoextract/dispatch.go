@@ -0,0 +1,75 @@
+package migoextract
+
+// Functions for handling dynamic calls (through interfaces and function
+// values).
+//
+// A method invoked on an instance of known concrete type is called directly,
+// otherwise the callees are resolved by the call graph of the SSA program
+// (see ssabuilder.Dispatch), and one of them is called nondeterministically:
+//
+//   if call f1(...) else if call f2(...) else call f3(...) endif endif
+
+import (
+	"go/types"
+
+	"github.com/damifur/migo"
+	"golang.org/x/tools/go/ssa"
+)
+
+// callees returns the functions possibly called (or spawned) at site.
+func (caller *Function) callees(site ssa.CallInstruction, infer *TypeInfer) []*ssa.Function {
+	common := site.Common()
+	if fn := common.StaticCallee(); fn != nil {
+		return []*ssa.Function{fn}
+	}
+	if inst, ok := caller.locals[common.Value].(*Value); ok && common.IsInvoke() && !types.IsInterface(inst.Type()) {
+		if fn := findMethod(common.Value.Parent().Prog, common.Method, inst.Type(), infer); fn != nil {
+			return []*ssa.Function{fn}
+		}
+	}
+	return infer.SSA.Callees(site)
+}
+
+// callDynamic calls one of fns at call, the return values are those of the
+// callee if there is only one.
+func (caller *Function) callDynamic(call *ssa.Call, fns []*ssa.Function, infer *TypeInfer, b *Block, l *Loop) {
+	common := call.Common()
+	var rcvr ssa.Value
+	if common.IsInvoke() {
+		rcvr = common.Value
+	}
+	infer.Logger.Printf(caller.Sprintf("  dynamic call %s: %d callee(s)", common.String(), len(fns)))
+	var callee *Function
+	branches := make([][]migo.Statement, len(fns))
+	caller.FuncDef.PutAway() // Parent.
+	for _, fn := range fns {
+		callee = caller.call(common, fn, rcvr, infer, b, l)
+		caller.FuncDef.PutAway()
+	}
+	for i := len(fns) - 1; i >= 0; i-- {
+		stmts, err := caller.FuncDef.Restore()
+		if err != nil {
+			infer.unsupported(call, "restore callee: %v", err)
+		}
+		branches[i] = stmts
+	}
+	parentStmts, err := caller.FuncDef.Restore()
+	if err != nil {
+		infer.unsupported(call, "restore dynamic call parent: %v", err)
+	}
+	caller.FuncDef.AddStmts(parentStmts...)
+	caller.FuncDef.AddStmts(choice(branches)...)
+	if len(fns) == 1 && callee != nil {
+		caller.storeRetvals(infer, call.Value(), callee)
+		return
+	}
+	caller.mockRetvals(call)
+}
+
+// choice returns the statements choosing one of branches nondeterministically.
+func choice(branches [][]migo.Statement) []migo.Statement {
+	if len(branches) == 1 {
+		return branches[0]
+	}
+	return []migo.Statement{&migo.IfStatement{Then: branches[0], Else: choice(branches[1:])}}
+}
@@ -0,0 +1,120 @@
+package migoextract
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/damifur/dingo-hunter/ssabuilder"
+)
+
+// TestDispatch checks that the callees of a call through a function value are
+// only followed if resolved by the dispatch.
+func TestDispatch(t *testing.T) {
+	const src = `package main
+
+type job struct{ f func(chan int) }
+
+func send(ch chan int) { ch <- 1 }
+
+func main() {
+	ch := make(chan int)
+	j := job{f: send}
+	go j.f(ch)
+	<-ch
+}
+`
+	for _, tc := range []struct {
+		dispatch ssabuilder.Dispatch
+		want     string
+	}{
+		{ssabuilder.StaticDispatch, "let t0 = newchan 0; recv t0"},
+		{ssabuilder.CHADispatch, "let t0 = newchan 0; spawn main.send(t0); recv t0"},
+		{ssabuilder.VTADispatch, "let t0 = newchan 0; spawn main.send(t0); recv t0"},
+	} {
+		info := buildSSA(t, src)
+		info.BuildConf.Dispatch = tc.dispatch
+		infer := newInfer(t, info)
+		run(t, infer)
+		if got := stmts(t, infer, "main.main"); got != tc.want {
+			t.Errorf("%s: expecting main.main %q, got %q", tc.dispatch, tc.want, got)
+		}
+	}
+}
+
+// TestDynamicCallees checks that every callee of a dynamic call (or go
+// statement) is followed, in a branch of the definition.
+func TestDynamicCallees(t *testing.T) {
+	const src = `package main
+
+var flag bool
+
+func one(ch chan int) { ch <- 1 }
+func two(ch chan int) { ch <- 2 }
+
+func relay(f func(chan int), ch chan int) {
+	f(ch)
+	ch <- 3
+}
+
+func main() {
+	ch := make(chan int)
+	f := one
+	if flag {
+		f = two
+	}
+	%s
+}
+`
+	for _, tc := range []struct {
+		body string
+		def  string
+		want string
+	}{
+		{"go f(ch); <-ch", "main.main#2", "if { spawn main.one(t0) } else { spawn main.two(t0) }; recv t0"},
+		{"go relay(f, ch); <-ch; <-ch", "main.relay", "if { call main.one(ch) } else { call main.two(ch) }; send ch"},
+	} {
+		info := buildSSA(t, fmt.Sprintf(src, tc.body))
+		info.BuildConf.Dispatch = ssabuilder.VTADispatch
+		infer := newInfer(t, info)
+		run(t, infer)
+		if got := stmts(t, infer, tc.def); got != tc.want {
+			t.Errorf("%s: expecting %s %q, got %q", tc.body, tc.def, tc.want, got)
+		}
+	}
+}
+
+// TestDynamicCalleesDeadlock checks the deadlock of a receive with no sender
+// left in any callee of a dynamic call.
+func TestDynamicCalleesDeadlock(t *testing.T) {
+	const src = `package main
+
+var flag bool
+
+func one(ch chan int) { ch <- 1 }
+func two(ch chan int) { ch <- 2 }
+
+func relay(f func(chan int), ch chan int) {
+	f(ch)
+	ch <- 3
+}
+
+func main() {
+	ch := make(chan int)
+	f := one
+	if flag {
+		f = two
+	}
+	go relay(f, ch)
+	<-ch
+	<-ch
+	<-ch
+}
+`
+	info := buildSSA(t, src)
+	info.BuildConf.Dispatch = ssabuilder.VTADispatch
+	infer := newInfer(t, info)
+	run(t, infer)
+	if res := check(t, infer); res.Live() {
+		t.Errorf("expecting deadlock, got %+v", res)
+	}
+}
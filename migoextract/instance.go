@@ -103,6 +103,6 @@ func (c *Const) String() string {
 	case constant.String:
 		return fmt.Sprintf("%s", c.Const.String())
 	default:
-		panic("unknown constant type")
+		return c.Const.String()
 	}
 }
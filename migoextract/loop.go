@@ -141,6 +141,7 @@ func loopStateTransition(blk *ssa.BasicBlock, infer *TypeInfer, f *Function, l *
 	case Enter:
 		if blk.Comment == "for.body" {
 			(*l).State = Body
+			f.addLoopFact(*l)
 		}
 		if blk.Comment == "for.done" {
 			(*l).State = Exit
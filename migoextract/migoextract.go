@@ -3,12 +3,19 @@
 package migoextract // import "github.com/damifur/dingo-hunter/migoextract"
 
 import (
+	"context"
+	"fmt"
+	"go/token"
 	"go/types"
 	"io"
 	"log"
+	"strings"
 	"time"
+	"unicode"
 
+	"github.com/damifur/dingo-hunter/diagnostics"
 	"github.com/damifur/dingo-hunter/ssabuilder"
+	"github.com/damifur/dingo-hunter/stubspec"
 	"github.com/damifur/migo"
 	"golang.org/x/tools/go/ssa"
 )
@@ -18,18 +25,47 @@ type TypeInfer struct {
 	SSA    *ssabuilder.SSAInfo // SSA IR of program.
 	Env    *Program            // Analysed program.
 	GQueue []*Function         // Goroutines to be analysed.
+	Root   *ssa.Function       // Analysis root (main.main if nil).
+
+	// BufferPolicy is the buffer size of channels created with sizes which
+	// cannot be resolved by constant propagation.
+	BufferPolicy ssabuilder.BufferPolicy
+
+	// RWReaders is the maximum number of readers holding a RWMutex at the same
+	// time (see sync.go), a further RLock blocks until a reader unlocks.
+	RWReaders int
+
+	Diagnostics []diagnostics.Diagnostic       // Warnings found in analysis.
+	Skipped     []*ssabuilder.UnsupportedError // Functions skipped in analysis.
+
+	// Budget is the resource budget of the analysis. If any budget is
+	// exhausted (or the analysis is cancelled), the reasons are recorded in
+	// Partial and the extracted types are partial.
+	Budget  ssabuilder.Budget
+	Partial ssabuilder.Partial
+
+	Jobs int // Goroutines analysed concurrently (see RunQueue).
+
+	Cache *Cache // On-disk cache of function summaries (nil if disabled).
+
+	// Stubs are the stub specifications of functions which are not analysed
+	// (nil if none).
+	Stubs *stubspec.Spec
 
 	Time   time.Duration
 	Logger *log.Logger
 	Done   chan struct{}
 	Error  chan error
+
+	ctx context.Context // Context of the running analysis.
 }
 
 // New creates a new session type infer analysis.
 func New(ssainfo *ssabuilder.SSAInfo, inferlog io.Writer) (*TypeInfer, error) {
 	infer := &TypeInfer{
-		SSA:    ssainfo,
-		Logger: log.New(inferlog, "migoextract: ", ssainfo.BuildConf.LogFlags),
+		SSA:       ssainfo,
+		RWReaders: DefaultRWReaders,
+		Logger:    log.New(inferlog, "migoextract: ", ssainfo.BuildConf.LogFlags),
 
 		Done:  make(chan struct{}),
 		Error: make(chan error, 1),
@@ -39,23 +75,45 @@ func New(ssainfo *ssabuilder.SSAInfo, inferlog io.Writer) (*TypeInfer, error) {
 }
 
 // Run executes the analysis.
+//
+// The analysis starts from main.main, or from Root if it is set, e.g. to
+// analyse a function of a non-main package.
 func (infer *TypeInfer) Run() {
+	infer.RunContext(context.Background())
+}
+
+// RunContext executes the analysis until ctx is done or the Budget runs out,
+// in which case the analysis stops early and the types are partial.
+func (infer *TypeInfer) RunContext(ctx context.Context) {
+	ctx, cancel := infer.Budget.Context(ctx)
+	defer cancel()
+	infer.ctx = ctx
+	infer.run()
+}
+
+func (infer *TypeInfer) run() {
 	infer.Logger.Println("---- Start Analysis ----")
 	// Initialise session.
 	infer.Env = NewProgram(infer)
 	infer.Env.MigoProg = migo.NewProgram()
 
 	startTime := time.Now()
-	mainPkg := ssabuilder.MainPkg(infer.SSA.Prog)
-	if mainPkg == nil {
-		infer.Error <- ErrNoMainPkg
+	var initFn, rootFn *ssa.Function
+	if infer.Root != nil {
+		initFn = infer.Root.Pkg.Func("init")
+		rootFn = infer.Root
+	} else {
+		mainPkg := ssabuilder.MainPkg(infer.SSA.Prog)
+		if mainPkg == nil {
+			infer.Error <- ErrNoMainPkg
+			return
+		}
+		initFn = mainPkg.Func("init")
+		rootFn = mainPkg.Func("main")
 	}
 	defer close(infer.Done)
 
-	initFn := mainPkg.Func("init")
-	mainFn := mainPkg.Func("main")
-
-	ctx := NewMainFunction(infer.Env, mainFn)
+	ctx := NewMainFunction(infer.Env, rootFn)
 	// TODO(nickng): inline initialisation of var declarations
 	for _, pkg := range infer.SSA.Prog.AllPackages() {
 		for _, memb := range pkg.Members {
@@ -75,16 +133,75 @@ func (infer *TypeInfer) Run() {
 		}
 	}
 	visitFunc(initFn, infer, ctx)
-	visitFunc(mainFn, infer, ctx)
+	if infer.Root != nil {
+		ctx.makeRootChans(infer)
+	}
+	visitFunc(rootFn, infer, ctx)
 
 	infer.RunQueue()
+	infer.storeSummaries()
 	infer.Time = time.Now().Sub(startTime)
+	infer.Logger.Printf("Function summaries:\n%s", infer.Env.Summaries)
+	if infer.Cache != nil {
+		infer.Logger.Printf("Summary cache: %s", infer.Cache)
+	}
+}
+
+// issues returns the number of diagnostics, skipped functions and reasons
+// of partial results found.
+func (infer *TypeInfer) issues() int {
+	return len(infer.Diagnostics) + len(infer.Skipped) + len(infer.Partial)
+}
+
+// warn records a warning diagnostic (e.g. imprecision of the inference) at pos
+// in function fn.
+func (infer *TypeInfer) warn(fn *ssa.Function, pos token.Pos, format string, args ...interface{}) {
+	if pos == token.NoPos && fn != nil {
+		pos = fn.Pos()
+	}
+	d := diagnostics.New(diagnostics.Warning, "migo", infer.SSA.FSet.Position(pos), format, args...)
+	if fn != nil {
+		d.Function = fn.String()
+	}
+	infer.Diagnostics = append(infer.Diagnostics, d)
+	infer.Logger.Print(fmt.Sprintf(format, args...))
+}
+
+// unsupported aborts the analysis of the current function on an unsupported
+// construct, the function is then skipped by visitFunc.
+func (infer *TypeInfer) unsupported(instr ssa.Instruction, format string, args ...interface{}) {
+	panic(ssabuilder.NewUnsupportedError(nil, instr, format, args...))
+}
+
+// clearDefs replaces def by an empty definition with the same parameters, so
+// that spawns and calls of def stay defined, and removes the definitions of its
+// blocks (name#N) from the program. Returns the empty definition.
+func (infer *TypeInfer) clearDefs(def *migo.Function) *migo.Function {
+	empty := migo.NewFunction(def.Name)
+	empty.AddParams(def.Params...)
+	prog := migo.NewProgram()
+	for _, fn := range infer.Env.MigoProg.Funcs {
+		if fn.Name == def.Name {
+			prog.AddFunction(empty)
+			continue
+		}
+		// Blocks are numbered, unlike the summaries of def (name#sN).
+		if block := strings.TrimPrefix(fn.Name, def.Name+"#"); block != fn.Name && block != "" && unicode.IsDigit(rune(block[0])) {
+			continue
+		}
+		prog.AddFunction(fn)
+	}
+	infer.Env.MigoProg = prog
+	return empty
 }
 
-// RunQueue executes the analysis on spawned (queued) goroutines.
-func (infer *TypeInfer) RunQueue() {
-	for _, ctx := range infer.GQueue {
-		infer.Logger.Printf("----- Goroutine %s -----", ctx.Fn.String())
-		visitFunc(ctx.Fn, infer, ctx)
+// skip records a function skipped on an unsupported construct.
+func (infer *TypeInfer) skip(err *ssabuilder.UnsupportedError) {
+	infer.Skipped = append(infer.Skipped, err)
+	d := diagnostics.New(diagnostics.Warning, "migo", err.Pos, "skipped: unsupported: %s", err.Reason)
+	if err.Func != nil {
+		d.Function = err.Func.String()
 	}
+	infer.Diagnostics = append(infer.Diagnostics, d)
+	infer.Logger.Print(err.Error())
 }
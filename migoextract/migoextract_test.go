@@ -0,0 +1,115 @@
+package migoextract
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/damifur/dingo-hunter/migocheck"
+	"github.com/damifur/dingo-hunter/ssabuilder"
+	"github.com/damifur/migo"
+)
+
+// buildSSA returns the SSA IR of main package src.
+func buildSSA(t *testing.T, src string) *ssabuilder.SSAInfo {
+	conf, err := ssabuilder.NewConfigFromString(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := conf.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+// newInfer returns a type inference of the program of info.
+func newInfer(t *testing.T, info *ssabuilder.SSAInfo) *TypeInfer {
+	infer, err := New(info, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return infer
+}
+
+// run runs the type inference, and waits for the inference.
+func run(t *testing.T, infer *TypeInfer) {
+	go infer.Run()
+	select {
+	case err := <-infer.Error:
+		t.Fatal(err)
+	case <-infer.Done:
+	}
+	infer.Env.MigoProg.CleanUp()
+}
+
+// check returns the result of the MiGo check of the types of infer.
+func check(t *testing.T, infer *TypeInfer) *migocheck.Result {
+	res, err := migocheck.Check(infer.Env.MigoProg, "main.main", migocheck.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// stmts returns the statements of definition name in the types of infer, each
+// as its kind and channels (or callee and arguments), separated by semicolons.
+func stmts(t *testing.T, infer *TypeInfer, name string) string {
+	for _, def := range infer.Env.MigoProg.Funcs {
+		if def.Name != name {
+			continue
+		}
+		return block(def.Stmts)
+	}
+	t.Fatalf("expecting definition of %s, got:\n%s", name, infer.Env.MigoProg)
+	return ""
+}
+
+// stmtString returns statement stmt as its kind and channel (or callee and
+// arguments).
+func stmtString(stmt migo.Statement) string {
+	switch stmt := stmt.(type) {
+	case *migo.NewChanStatement:
+		return fmt.Sprintf("let %s = newchan %d", stmt.Name.Name(), stmt.Size)
+	case *migo.SendStatement:
+		return "send " + stmt.Chan
+	case *migo.RecvStatement:
+		return "recv " + stmt.Chan
+	case *migo.CloseStatement:
+		return "close " + stmt.Chan
+	case *migo.SpawnStatement:
+		return fmt.Sprintf("spawn %s(%s)", stmt.Name, args(stmt.Params))
+	case *migo.CallStatement:
+		return fmt.Sprintf("call %s(%s)", stmt.Name, args(stmt.Params))
+	case *migo.TauStatement:
+		return "tau"
+	case *migo.SelectStatement:
+		cases := make([]string, len(stmt.Cases))
+		for i, c := range stmt.Cases {
+			cases[i] = "case " + block(c)
+		}
+		return "select { " + strings.Join(cases, " ") + " }"
+	case *migo.IfStatement:
+		return fmt.Sprintf("if { %s } else { %s }", block(stmt.Then), block(stmt.Else))
+	}
+	return fmt.Sprintf("%T", stmt)
+}
+
+// block returns statements stmts, separated by semicolons.
+func block(stmts []migo.Statement) string {
+	ss := make([]string, len(stmts))
+	for i, stmt := range stmts {
+		ss[i] = stmtString(stmt)
+	}
+	return strings.Join(ss, "; ")
+}
+
+// args returns the channels passed by params.
+func args(params []*migo.Parameter) string {
+	names := make([]string, len(params))
+	for i, p := range params {
+		names[i] = p.Caller.Name()
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,353 @@
+package migoextract
+
+// Functions for analysing queued goroutines in parallel.
+//
+// The goroutines queued are analysed in waves: each goroutine of a wave is
+// analysed on a copy (fork) of the program environment by one of Jobs
+// workers, then the copies are merged in queue order, so the types do not
+// depend on the scheduling of the workers. The copies are made one at a time
+// by RunQueue before starting each worker, as forking is not thread-safe. The
+// goroutines spawned by a wave are the next wave. Summaries (see summary.go)
+// made by different copies are named when merged, and definitions made more
+// than once are merged once.
+
+import (
+	"bytes"
+	"go/token"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/damifur/dingo-hunter/ssabuilder"
+	"github.com/damifur/migo"
+	"golang.org/x/tools/go/ssa"
+)
+
+// RunQueue executes the analysis on spawned (queued) goroutines. A panic of a
+// worker is raised again by RunQueue once the workers are done.
+func (infer *TypeInfer) RunQueue() {
+	jobs := infer.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	wave := infer.GQueue
+	for len(wave) > 0 {
+		forks := make([]*TypeInfer, len(wave))
+		logs := make([]bytes.Buffer, len(wave))
+		panics := make([]interface{}, len(wave))
+		sem := make(chan struct{}, jobs)
+		var wg sync.WaitGroup
+		for i, ctx := range wave {
+			if ctx.summary != nil && ctx.summary.callee != nil {
+				continue // Defined by a call.
+			}
+			wg.Add(1)
+			sem <- struct{}{}
+			forks[i] = infer.fork(ctx, &logs[i])
+			go func(i int, f *TypeInfer, ctx *Function) {
+				defer func() { <-sem; wg.Done() }()
+				defer func() { panics[i] = recover() }()
+				if f.cancelled(ctx.Fn) {
+					return
+				}
+				f.Logger.Printf("----- Goroutine %s -----", ctx.Fn.String())
+				visitFunc(ctx.Fn, f, ctx)
+				ctx.summary.define(ctx, f.issues() > 0, f.Env.effects > 0)
+			}(i, forks[i], ctx)
+		}
+		wg.Wait()
+		for _, r := range panics {
+			if r != nil {
+				panic(r)
+			}
+		}
+		wave = nil
+		base := len(infer.GQueue) // Goroutines queued when forked.
+		for i, f := range forks {
+			if f == nil {
+				continue
+			}
+			infer.Logger.Writer().Write(logs[i].Bytes())
+			wave = append(wave, infer.merge(f, base)...)
+		}
+	}
+}
+
+// fork returns a copy of the inference to analyse goroutine ctx, where the
+// program environment and the storage of ctx are copied, and logs are written
+// to buf.
+func (infer *TypeInfer) fork(ctx *Function, buf *bytes.Buffer) *TypeInfer {
+	f := *infer
+	f.Env = infer.Env.fork()
+	f.Env.Infer = &f
+	f.GQueue = infer.GQueue[:len(infer.GQueue):len(infer.GQueue)]
+	f.Diagnostics, f.Skipped, f.Partial = nil, nil, nil
+	f.Logger = log.New(buf, infer.Logger.Prefix(), infer.Logger.Flags())
+	ctx.Prog = f.Env
+	ctx.Storage = ctx.Storage.fork()
+	if ctx.summary != nil {
+		ctx.summary = f.Env.Summaries.forked[ctx.summary]
+	}
+	return &f
+}
+
+// merge merges fork f of the inference, made when base goroutines were queued,
+// and returns the goroutines queued by f which are not queued already. The
+// goroutines beyond the goroutine budget are dropped, as f only counts the
+// goroutines it queued.
+func (infer *TypeInfer) merge(f *TypeInfer, base int) []*Function {
+	infer.Diagnostics = append(infer.Diagnostics, f.Diagnostics...)
+	infer.Skipped = append(infer.Skipped, f.Skipped...)
+	for _, err := range f.Partial {
+		infer.Partial.Add(err)
+	}
+	renames, known := infer.Env.Summaries.merge(f.Env.Summaries)
+	infer.Env.merge(f.Env, renames)
+	var queued []*Function
+	for _, ctx := range f.GQueue[base:] {
+		if ctx.summary != nil {
+			sum := f.Env.Summaries.origin[ctx.summary]
+			ctx.summary = sum
+			ctx.FuncDef.Name = sum.name()
+			if known[sum] {
+				continue // Queued or defined by another goroutine.
+			}
+			known[sum] = true
+		}
+		// Each fork counts the goroutines queued from the start of the wave.
+		if max := infer.Budget.MaxGoroutines; max > 0 && len(infer.GQueue)+len(queued) >= max {
+			infer.partial(ctx.Fn, token.NoPos, &ssabuilder.BudgetError{Budget: "max-goroutines", Limit: max})
+			infer.Env.MigoProg.AddFunction(ctx.FuncDef) // Empty, for its spawns.
+			continue
+		}
+		queued = append(queued, ctx)
+	}
+	infer.GQueue = append(infer.GQueue, queued...)
+	return queued
+}
+
+// fork returns a copy of the program environment.
+func (prog *Program) fork() *Program {
+	f := &Program{
+		FuncInstance: make(map[*ssa.Function]int, len(prog.FuncInstance)),
+		InitPkgs:     make(map[*ssa.Package]bool, len(prog.InitPkgs)),
+		MigoProg:     migo.NewProgram(),
+		closures:     make(map[Instance]Captures, len(prog.closures)),
+		globals:      make(map[ssa.Value]Instance, len(prog.globals)),
+		waitGroups:   make(map[Instance]*waitGroupChan, len(prog.waitGroups)),
+		contexts:     make(map[Instance]bool, len(prog.contexts)),
+		contextFuncs: prog.contextFuncs,
+		timerFuncs:   prog.timerFuncs,
+		signalFuncs:  prog.signalFuncs,
+		Summaries:    prog.Summaries.fork(),
+		Storage:      prog.Storage.fork(),
+	}
+	for k, v := range prog.FuncInstance {
+		f.FuncInstance[k] = v
+	}
+	for k, v := range prog.InitPkgs {
+		f.InitPkgs[k] = v
+	}
+	for k, v := range prog.closures {
+		f.closures[k] = append(Captures(nil), v...)
+	}
+	for k, v := range prog.globals {
+		f.globals[k] = v
+	}
+	for k, v := range prog.waitGroups {
+		decl := *v.decl
+		f.waitGroups[k] = &waitGroupChan{decl: &decl, pending: v.pending}
+	}
+	for k, v := range prog.contexts {
+		f.contexts[k] = v
+	}
+	return f
+}
+
+// merge merges fork f of the program environment, where the definitions of f
+// are renamed by renames.
+func (prog *Program) merge(f *Program, renames map[string]string) {
+	for k, v := range f.FuncInstance {
+		if v > prog.FuncInstance[k] {
+			prog.FuncInstance[k] = v
+		}
+	}
+	for k, v := range f.InitPkgs {
+		prog.InitPkgs[k] = v
+	}
+	for k, v := range f.closures {
+		prog.closures[k] = v
+	}
+	for k, v := range f.globals {
+		prog.globals[k] = v
+	}
+	for k, v := range f.waitGroups {
+		wg, ok := prog.waitGroups[k]
+		if !ok {
+			prog.waitGroups[k] = v
+			continue
+		}
+		if v.decl.Size > wg.decl.Size {
+			wg.decl.Size = v.decl.Size
+		}
+		wg.pending = v.pending
+	}
+	for k, v := range f.contexts {
+		prog.contexts[k] = prog.contexts[k] || v
+	}
+	prog.Storage.merge(f.Storage)
+	for _, fn := range f.MigoProg.Funcs {
+		fn.Name = rename(fn.Name, renames)
+		renameStmts(fn.Stmts, renames)
+	}
+	prog.addDefs(f.MigoProg.Funcs)
+}
+
+// addDefs adds the definitions defs to the program, except those defined
+// already (e.g. the model definitions of a cached summary).
+func (prog *Program) addDefs(defs []*migo.Function) {
+	defined := make(map[string]bool)
+	for _, fn := range prog.MigoProg.Funcs {
+		defined[fn.Name] = true
+	}
+	for _, fn := range defs {
+		if defined[fn.Name] {
+			continue
+		}
+		defined[fn.Name] = true
+		prog.MigoProg.AddFunction(fn)
+	}
+}
+
+// fork returns a copy of the storage.
+func (s *Storage) fork() *Storage {
+	f := &Storage{
+		arrays:  make(map[Instance]Elems, len(s.arrays)),
+		maps:    make(map[Instance]map[Instance]Instance, len(s.maps)),
+		structs: make(map[Instance]Fields, len(s.structs)),
+	}
+	for k, v := range s.arrays {
+		elems := make(Elems, len(v))
+		for i, e := range v {
+			elems[i] = e
+		}
+		f.arrays[k] = elems
+	}
+	for k, v := range s.maps {
+		m := make(map[Instance]Instance, len(v))
+		for i, e := range v {
+			m[i] = e
+		}
+		f.maps[k] = m
+	}
+	for k, v := range s.structs {
+		f.structs[k] = append(Fields(nil), v...)
+	}
+	return f
+}
+
+// merge merges fork f of the storage.
+func (s *Storage) merge(f *Storage) {
+	for k, v := range f.arrays {
+		s.arrays[k] = v
+	}
+	for k, v := range f.maps {
+		s.maps[k] = v
+	}
+	for k, v := range f.structs {
+		s.structs[k] = v
+	}
+}
+
+// fork returns a copy of the summary cache, where the summaries are copied.
+func (s *Summaries) fork() *Summaries {
+	f := NewSummaries()
+	f.forked = make(map[*Summary]*Summary, len(s.all))
+	f.origin = make(map[*Summary]*Summary, len(s.all))
+	for fn, n := range s.shapes {
+		f.shapes[fn] = n
+	}
+	for _, sum := range s.all {
+		c := *sum
+		c.cache, c.Uses = f, 0 // Uses are added when merged.
+		f.add(&c)
+		f.forked[sum], f.origin[&c] = &c, sum
+	}
+	return f
+}
+
+// merge merges fork f of the summary cache, the summaries made by f are named
+// in this cache. Returns the renames of the definitions of f, and the
+// summaries which were queued or defined before the merge.
+func (s *Summaries) merge(f *Summaries) (map[string]string, map[*Summary]bool) {
+	renames := make(map[string]string)
+	known := make(map[*Summary]bool)
+	for _, fs := range f.all {
+		sum, ok := f.origin[fs]
+		if !ok {
+			if sum, ok = s.byKey[fs.Fn][fs.key]; !ok {
+				sum = &Summary{Fn: fs.Fn, Shape: fs.Shape, key: fs.key, cache: s}
+				s.add(sum)
+			}
+			f.origin[fs] = sum
+		}
+		known[sum] = sum.callee != nil || sum.queued
+		if fs.Name != "" && fs.Name != sum.name() {
+			renames[fs.Name] = sum.Name
+		}
+		sum.Uses += fs.Uses
+		if sum.callee == nil {
+			sum.callee, sum.cached, sum.dirty, sum.effects = fs.callee, fs.cached, fs.dirty, fs.effects
+		}
+		sum.queued = sum.queued || fs.queued
+	}
+	return renames, known
+}
+
+// rename returns the name of definition def renamed by renames.
+func rename(def string, renames map[string]string) string {
+	name, block := splitDef(def)
+	if r, ok := renames[name]; ok {
+		return r + block
+	}
+	return def
+}
+
+// splitDef splits the name of definition def into the name of the summary
+// and of the block of the summary, e.g. main.f#s1#2 is main.f#s1 and #2.
+func splitDef(def string) (name, block string) {
+	name, block = def, ""
+	if i := strings.Index(def, "#"); i >= 0 {
+		name, block = def[:i], def[i:]
+		if strings.HasPrefix(block, "#s") { // Numbered summary, e.g. main.f#s1.
+			n := len(block)
+			if j := strings.Index(block[1:], "#"); j >= 0 {
+				n = j + 1
+			}
+			name, block = def[:i+n], def[i+n:]
+		}
+	}
+	return name, block
+}
+
+// renameStmts renames the definitions called or spawned in stmts.
+func renameStmts(stmts []migo.Statement, renames map[string]string) {
+	if len(renames) == 0 {
+		return
+	}
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *migo.CallStatement:
+			s.Name = rename(s.Name, renames)
+		case *migo.SpawnStatement:
+			s.Name = rename(s.Name, renames)
+		case *migo.IfStatement:
+			renameStmts(s.Then, renames)
+			renameStmts(s.Else, renames)
+		case *migo.SelectStatement:
+			for _, c := range s.Cases {
+				renameStmts(c, renames)
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package migoextract
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestJobs checks that the types of goroutines analysed in parallel are those
+// analysed sequentially.
+func TestJobs(t *testing.T) {
+	const src = `package main
+
+func relay(in, out chan int) { out <- <-in }
+
+func worker(n int, out chan int) {
+	ch := make(chan int)
+	go relay(ch, out)
+	ch <- n
+}
+
+func main() {
+	out := make(chan int)
+	go worker(1, out)
+	go worker(2, out)
+	go worker(3, out)
+	<-out
+	<-out
+	<-out
+}
+`
+	infer := func(jobs int) string {
+		infer := newInfer(t, buildSSA(t, src))
+		infer.Jobs = jobs
+		run(t, infer)
+		if res := check(t, infer); !res.Live() {
+			t.Errorf("jobs=%d: expecting live, got %+v", jobs, res)
+		}
+		return infer.Env.MigoProg.String()
+	}
+	want := infer(1)
+	for i := 0; i < 4; i++ {
+		if got := infer(4); got != want {
+			t.Errorf("expecting MiGo types with 4 jobs as with 1 job:\n%s\ngot:\n%s", want, got)
+		}
+	}
+}
+
+// TestJobsSpawns checks that the goroutines spawned by each goroutine of a wave
+// are queued and defined.
+func TestJobsSpawns(t *testing.T) {
+	const src = `package main
+
+func send(ch chan int) { ch <- 1 }
+
+func recv(ch chan int) { <-ch }
+
+func a(ch chan int) { go send(ch) }
+
+func b(ch chan int) { go recv(ch) }
+
+func main() {
+	ch := make(chan int)
+	go a(ch)
+	go b(ch)
+}
+`
+	infer := newInfer(t, buildSSA(t, src))
+	run(t, infer)
+	if len(infer.GQueue) != 4 {
+		t.Errorf("expecting 4 goroutines queued, got %d", len(infer.GQueue))
+	}
+	defined := make(map[string]bool)
+	for _, def := range infer.Env.MigoProg.Funcs {
+		defined[def.Name] = true
+	}
+	if !defined["main.send"] || !defined["main.recv"] {
+		t.Errorf("expecting main.send and main.recv defined, got:\n%s", infer.Env.MigoProg)
+	}
+	if res := check(t, infer); !res.Live() {
+		t.Errorf("expecting live, got %+v", res)
+	}
+}
+
+// TestJobsMaxGoroutines checks the goroutine budget of a wave, where each
+// goroutine queues goroutines within the budget but not the wave.
+func TestJobsMaxGoroutines(t *testing.T) {
+	const src = `package main
+
+func send(ch chan int) { ch <- 1 }
+
+func recv(ch chan int) { <-ch }
+
+func a(ch chan int) { go send(ch) }
+
+func b(ch chan int) { go recv(ch) }
+
+func main() {
+	ch := make(chan int)
+	go a(ch)
+	go b(ch)
+}
+`
+	for _, jobs := range []int{1, 2} {
+		infer := newInfer(t, buildSSA(t, src))
+		infer.Jobs = jobs
+		infer.Budget.MaxGoroutines = 3
+		run(t, infer)
+		if len(infer.GQueue) != 3 {
+			t.Errorf("jobs=%d: expecting 3 goroutines queued, got %d", jobs, len(infer.GQueue))
+		}
+		if len(infer.Partial) != 1 || !strings.Contains(infer.Partial[0].Error(), "max-goroutines") {
+			t.Errorf("jobs=%d: expecting partial by max-goroutines, got %v", jobs, infer.Partial)
+		}
+	}
+}
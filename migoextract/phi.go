@@ -30,7 +30,7 @@ func phiSelectEdge(instr *ssa.Phi, infer *TypeInfer, ctx *Context) (edge int) {
 					e, edge = ctx.F.locals[instr.Edges[i]], pred.Index
 					infer.Logger.Printf(ctx.F.Sprintf(PhiSymbol+"%s/%s = %s, selected UnOp from block %d", instr.Name(), e, instr.String(), edge))
 				default:
-					infer.Logger.Fatalf("phi: create instance Edge[%d]=%#v: %s", i, instr.Edges[i], ErrUnknownValue)
+					infer.unsupported(instr, "phi: create instance Edge[%d]=%#v: %s", i, instr.Edges[i], ErrUnknownValue)
 					return
 				}
 			}
@@ -46,6 +46,6 @@ func phiSelectEdge(instr *ssa.Phi, infer *TypeInfer, ctx *Context) (edge int) {
 			return
 		}
 	}
-	infer.Logger.Fatalf("phi: %d->%d: %s", ctx.B.Pred, instr.Block().Index, ErrPhiUnknownEdge)
+	infer.unsupported(instr, "phi: %d->%d: %s", ctx.B.Pred, instr.Block().Index, ErrPhiUnknownEdge)
 	return
 }
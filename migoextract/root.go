@@ -0,0 +1,36 @@
+package migoextract
+
+// Functions for using a non-main function as the analysis root.
+
+import (
+	"go/types"
+	"strconv"
+
+	"github.com/damifur/migo"
+)
+
+// makeRootChans creates fresh channels for the channel parameters of the root
+// function, as the root has no caller to supply them.
+//
+// The channels are unbuffered and created at the start of the root function.
+// Context parameters are contexts which the caller may cancel at any time.
+func (ctx *Function) makeRootChans(infer *TypeInfer) {
+	for _, param := range ctx.Fn.Params {
+		if named, ok := param.Type().(*types.Named); ok && syncOf(named) == cancelCtx && named.Obj().Name() == "Context" {
+			inst := ctx.makeContext(param, param.Type(), 0, infer)
+			ctx.locals[param] = inst
+			ctx.cancellable(inst, nil, true, param.Pos(), infer)
+			continue
+		}
+		if _, ok := param.Type().Underlying().(*types.Chan); !ok {
+			continue
+		}
+		line := infer.SSA.FSet.Position(param.Pos()).Line
+		newch := &Value{param, ctx.InstanceID(), 0, line}
+		ctx.locals[param] = newch
+		infer.Logger.Printf(ctx.Sprintf(ChanSymbol+"%s = %s {t:%s, buf:0} (root parameter)",
+			newch, fmtChan("chan"), param.Type()))
+		ctx.FuncDef.AddStmts(&migo.NewChanStatement{Name: param, Chan: newch.String(), Size: 0, LineNum: strconv.Itoa(line)})
+		ctx.extraargs = append(ctx.extraargs, param)
+	}
+}
@@ -0,0 +1,56 @@
+package migoextract
+
+// Functions for handling package os/signal.
+//
+// The os/signal package is not built (see ssabuilder), so the delivery of
+// signals is encoded with a process of the environment. Notify spawns
+// signal.notify with the channel, which may send a signal to it at any time,
+// and never blocks as signals are dropped when the channel is not ready:
+//
+//   def signal.notify(c): select case send c; case tau; endselect; call signal.notify(c);
+//
+// The other functions of os/signal do not communicate, so signals may still
+// be sent to the channel after Stop.
+
+import (
+	"go/token"
+	"strconv"
+
+	"github.com/damifur/migo"
+	"golang.org/x/tools/go/ssa"
+)
+
+// callSignal models a call of os/signal.Notify, returns false if common is
+// not such a call.
+func (caller *Function) callSignal(common *ssa.CallCommon, pos token.Pos, infer *TypeInfer) bool {
+	fn := common.StaticCallee()
+	if fn == nil || fn.Pkg == nil || fn.Pkg.Pkg.Path() != "os/signal" || fn.Name() != "Notify" {
+		return false
+	}
+	caller.defineSignalFuncs(infer)
+	caller.FuncDef.AddStmts(&migo.SpawnStatement{
+		Name:    "signal.notify",
+		Params:  []*migo.Parameter{{Caller: getChan(common.Args[0], infer), Callee: modelVar("c")}},
+		LineNum: strconv.Itoa(infer.SSA.FSet.Position(pos).Line),
+	})
+	infer.Logger.Print(caller.Sprintf("signal.Notify(%s) @ %s", common.Args[0].Name(), fmtPos(infer.SSA.FSet.Position(pos).String())))
+	return true
+}
+
+// defineSignalFuncs adds the signal model function to the program, if it is
+// not added already.
+func (caller *Function) defineSignalFuncs(infer *TypeInfer) {
+	if caller.Prog.signalFuncs {
+		return
+	}
+	caller.Prog.signalFuncs = true
+	notify := migo.NewFunction("signal.notify")
+	notify.AddParams(modelArg("c", "c"))
+	notify.AddStmts(
+		&migo.SelectStatement{Cases: [][]migo.Statement{
+			{&migo.SendStatement{Chan: "c", LineNum: "0"}},
+			{&migo.TauStatement{LineNum: "0"}},
+		}},
+		&migo.CallStatement{Name: "signal.notify", Params: []*migo.Parameter{modelArg("c", "c")}, LineNum: "0"})
+	infer.Env.addDefs([]*migo.Function{notify})
+}
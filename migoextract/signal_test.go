@@ -0,0 +1,69 @@
+package migoextract
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestSignal checks the statements of channels notified of signals, which are
+// sent to by a signal.notify process, unlike channels of other os/signal calls.
+func TestSignal(t *testing.T) {
+	const src = `package main
+
+import (
+	"os"
+	"os/signal"
+)
+
+func main() {
+	sig := make(chan os.Signal, 1)
+	%s
+	<-sig
+}
+`
+	for _, tc := range []struct {
+		call string
+		def  string
+		want string
+	}{
+		{"signal.Notify(sig, os.Interrupt)", "main.main", "let t0 = newchan 1; spawn signal.notify(t0); recv t0"},
+		{"signal.Notify(sig, os.Interrupt)", "signal.notify", "select { case send c case tau }; call signal.notify(c)"},
+		{"signal.Ignore(os.Interrupt); signal.Stop(sig)", "main.main", "let t0 = newchan 1; recv t0"},
+	} {
+		infer := newInfer(t, buildSSA(t, fmt.Sprintf(src, tc.call)))
+		run(t, infer)
+		if got := stmts(t, infer, tc.def); got != tc.want {
+			t.Errorf("%s: expecting %s %q, got %q", tc.call, tc.def, tc.want, got)
+		}
+	}
+}
+
+// TestSignalShutdown checks a shutdown waiting for a signal, which is live
+// and safe.
+func TestSignalShutdown(t *testing.T) {
+	const src = `package main
+
+import (
+	"os"
+	"os/signal"
+)
+
+func main() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	quit, done := make(chan int), make(chan int)
+	go func() {
+		<-quit
+		done <- 1
+	}()
+	<-sig
+	quit <- 1
+	<-done
+}
+`
+	infer := newInfer(t, buildSSA(t, src))
+	run(t, infer)
+	if res := check(t, infer); !res.Live() || !res.Safe() {
+		t.Errorf("expecting live and safe, got %+v", res)
+	}
+}
@@ -0,0 +1,84 @@
+package migoextract
+
+// Functions for handling calls of functions with stub specifications.
+//
+// Functions which are not analysed (without body, or of packages which are
+// skipped, opaque or pure) may have a stub in the stub specifications (see
+// package stubspec). A call (or go statement) of such a function calls (or
+// spawns) the stub definition instead, with the channel arguments of the call
+// as parameters, and the definitions used by the stub are added to the
+// program.
+
+import (
+	"go/token"
+	"go/types"
+	"strconv"
+
+	"github.com/damifur/dingo-hunter/ssabuilder"
+	"github.com/damifur/migo"
+	"golang.org/x/tools/go/ssa"
+)
+
+// stubOf returns the name of the stub definition of fn, and false if fn is
+// analysed or has no stub.
+func (infer *TypeInfer) stubOf(fn *ssa.Function) (string, bool) {
+	if fn == nil || infer.Stubs == nil {
+		return "", false
+	}
+	if fn.Blocks != nil {
+		if p, _ := infer.SSA.BuildConf.FuncPolicy(fn); p == ssabuilder.Analyse {
+			return "", false
+		}
+	}
+	return infer.Stubs.Lookup(fn.String())
+}
+
+// callStub models a call of a function with a stub, or spawns the stub if
+// spawn is true, returns false if common is not a static call of such a
+// function. The results are stored in value (nil if the call is deferred or
+// spawned).
+func (caller *Function) callStub(common *ssa.CallCommon, value ssa.Value, spawn bool, pos token.Pos, infer *TypeInfer) bool {
+	fn := common.StaticCallee()
+	name, ok := infer.stubOf(fn)
+	if !ok {
+		return false
+	}
+	if value != nil {
+		switch fn.Signature.Results().Len() {
+		case 0:
+		case 1:
+			caller.locals[value] = &External{parent: caller.Fn, typ: value.Type().Underlying()}
+		default:
+			caller.locals[value] = &External{typ: value.Type().Underlying()}
+			caller.tuples[caller.locals[value]] = make(Tuples, fn.Signature.Results().Len())
+		}
+	}
+	defs, err := infer.Stubs.Defs(name)
+	if err != nil {
+		infer.warn(caller.Fn, pos, "stub of %s: %v", fn, err)
+		return true
+	}
+	var chans []ssa.Value
+	for _, arg := range common.Args {
+		if _, ok := arg.Type().Underlying().(*types.Chan); ok {
+			chans = append(chans, arg)
+		}
+	}
+	if len(chans) != len(defs[0].Params) {
+		infer.warn(caller.Fn, pos, "stub %s of %s takes %d channels, %d given", name, fn, len(defs[0].Params), len(chans))
+		return true
+	}
+	params := make([]*migo.Parameter, len(chans))
+	for i, ch := range chans {
+		params[i] = &migo.Parameter{Caller: getChan(ch, infer), Callee: defs[0].Params[i].Callee}
+	}
+	infer.Env.addDefs(defs)
+	line := strconv.Itoa(infer.SSA.FSet.Position(pos).Line)
+	if spawn {
+		caller.FuncDef.AddStmts(&migo.SpawnStatement{Name: name, Params: params, LineNum: line})
+	} else {
+		caller.FuncDef.AddStmts(&migo.CallStatement{Name: name, Params: params, LineNum: line})
+	}
+	infer.Logger.Print(caller.Sprintf("stub %s = %s @ %s", fn, name, fmtPos(infer.SSA.FSet.Position(pos).String())))
+	return true
+}
@@ -0,0 +1,217 @@
+package migoextract
+
+// Functions for handling function summaries.
+//
+// A function is visited once for each shape of its arguments (see shapeOf),
+// and the MiGo definition is reused at every call or go statement with the
+// same shape, which passes the arguments only. The definition of the first
+// shape of a function is named after the function, the definitions of other
+// shapes are numbered, e.g. main.f#s1.
+//
+// A summary is not reused by calls if the visit of the function changed the
+// state of sync values or contexts shared with its caller (e.g. the pending
+// count of a WaitGroup), as reusing the definition would not repeat the change.
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"go/types"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/damifur/dingo-hunter/ssabuilder"
+	"golang.org/x/tools/go/ssa"
+)
+
+// A Summary is the MiGo definition of a function for a shape of arguments.
+type Summary struct {
+	Fn    *ssa.Function // Function summarised.
+	Shape string        // Shape of arguments.
+	Name  string        // Name of MiGo definition (empty until used).
+	Uses  int           // Calls and go statements reusing the summary.
+
+	key     string // Identity of the shape.
+	cache   *Summaries
+	callee  *Function // Instance defining the summary (nil until visited).
+	queued  bool      // Instance queued as goroutine.
+	cached  bool      // Loaded from the on-disk cache (see cache.go).
+	dirty   bool      // Diagnostics found in the visit (not stored in the cache).
+	effects bool      // Sync or context state changed by the visit.
+}
+
+// name returns the name of the MiGo definition of the summary, which is
+// numbered by the shapes of the function used before.
+func (s *Summary) name() string {
+	if s.Name == "" {
+		s.Name = s.Fn.String()
+		if n := s.cache.shapes[s.Fn]; n > 0 {
+			s.Name = fmt.Sprintf("%s#s%d", s.Fn.String(), n)
+		}
+		s.cache.shapes[s.Fn]++
+	}
+	return s.Name
+}
+
+// reusable returns true if the summary can be reused by calls, i.e. the
+// function is visited without effects on sync or context state, and the
+// return values do not depend on the arguments.
+func (s *Summary) reusable() bool {
+	if s.callee == nil || s.effects {
+		return false
+	}
+	for _, retval := range s.callee.retvals {
+		switch retval.(type) {
+		case nil, *Const, *External:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// define makes callee the instance defining the summary if there is none,
+// where dirty is true if diagnostics were found in the visit of callee, and
+// effects is true if the visit changed sync or context state.
+func (s *Summary) define(callee *Function, dirty, effects bool) {
+	if s != nil && s.callee == nil {
+		s.callee, s.dirty, s.effects = callee, dirty, effects
+	}
+}
+
+// Summaries is the summary cache of a program.
+type Summaries struct {
+	all    []*Summary
+	byKey  map[*ssa.Function]map[string]*Summary
+	shapes map[*ssa.Function]int // Number of shapes named.
+
+	forked map[*Summary]*Summary // Copies of summaries (if forked).
+	origin map[*Summary]*Summary // Summaries of copies (if forked).
+}
+
+// NewSummaries returns an empty summary cache.
+func NewSummaries() *Summaries {
+	return &Summaries{
+		byKey:  make(map[*ssa.Function]map[string]*Summary),
+		shapes: make(map[*ssa.Function]int),
+	}
+}
+
+// Get returns the summary of fn for a shape (with identity key), which is
+// added to the cache if it is not cached already.
+func (s *Summaries) Get(fn *ssa.Function, shape, key string) *Summary {
+	if sum, ok := s.byKey[fn][key]; ok {
+		return sum
+	}
+	sum := &Summary{Fn: fn, Shape: shape, key: key, cache: s}
+	s.add(sum)
+	return sum
+}
+
+func (s *Summaries) add(sum *Summary) {
+	if _, ok := s.byKey[sum.Fn]; !ok {
+		s.byKey[sum.Fn] = make(map[string]*Summary)
+	}
+	s.byKey[sum.Fn][sum.key] = sum
+	s.all = append(s.all, sum)
+}
+
+// All returns the cached summaries (in order of lookup).
+func (s *Summaries) All() []*Summary { return s.all }
+
+func (s *Summaries) String() string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SUMMARY\tSHAPE\tUSES\tREUSABLE")
+	for _, sum := range s.all {
+		if sum.Name == "" {
+			continue // Not used (e.g. closure wrappers).
+		}
+		fmt.Fprintf(w, "%s\t(%s)\t%d\t%t\n", sum.Name, sum.Shape, sum.Uses, sum.reusable())
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// shapeOf returns the shape of the arguments of callee at call common, where
+// args are the arguments in the caller (with the receiver first), and the
+// identity of the shape. Channels and sync values are parameters of the
+// definition so their instances are not in the shape, but constants and
+// instances of structures (whose fields are accessed directly) are.
+func (caller *Function) shapeOf(common *ssa.CallCommon, callee *Function, args []ssa.Value) (shape, key string) {
+	var parts, keys []string
+	add := func(part string, inst Instance) {
+		parts = append(parts, part)
+		if inst != nil { // Instances of different forks may have the same name.
+			part = fmt.Sprintf("%s@%p", part, inst)
+		}
+		keys = append(keys, part)
+	}
+	for _, arg := range args {
+		if _, ok := arg.Type().Underlying().(*types.Chan); ok {
+			add("chan", nil)
+		} else if c, ok := arg.(*ssa.Const); ok {
+			add(c.String(), nil)
+		} else if inst, ok := caller.locals[arg]; ok && inst != nil && caller.isStorage(inst) {
+			add(inst.String(), inst)
+		} else if c, ok := inst.(*Const); ok {
+			add(c.String(), nil)
+		} else {
+			add("_", nil)
+		}
+	}
+	if inst, ok := caller.locals[common.Value]; ok && inst != nil {
+		if _, ok := caller.Prog.closures[inst]; ok {
+			add("closure "+inst.String(), inst)
+		}
+	}
+	for _, param := range callee.syncArgs {
+		add(param.Callee.Name(), nil)
+	}
+	return strings.Join(parts, ", "), strings.Join(keys, ", ")
+}
+
+// isStorage returns true if inst is a structure, an array or a map.
+func (caller *Function) isStorage(inst Instance) bool {
+	if _, ok := caller.structs[inst]; ok {
+		return true
+	}
+	if _, ok := caller.Prog.structs[inst]; ok {
+		return true
+	}
+	if _, ok := caller.arrays[inst]; ok {
+		return true
+	}
+	_, ok := caller.maps[inst]
+	return ok
+}
+
+// DefName returns the name of the MiGo definition of the function.
+func (caller *Function) DefName() string {
+	if caller.summary != nil {
+		return caller.summary.name()
+	}
+	return caller.Fn.String()
+}
+
+// visitCallee visits the function of callee, unless the summary of callee is
+// reusable, in which case the return values of the summary are used. Returns
+// false if the function is not visited as the instance budget is exhausted.
+func (caller *Function) visitCallee(callee *Function, pos token.Pos, infer *TypeInfer) bool {
+	if sum := callee.summary; sum != nil && (sum.reusable() || infer.loadSummary(sum)) {
+		sum.Uses++
+		callee.hasBody, callee.retvals = sum.callee.hasBody, sum.callee.retvals
+		infer.Logger.Print(caller.Sprintf("  summary %s (%s)", sum.Name, sum.Shape))
+		return true
+	}
+	callee.newInstance()
+	if max := infer.Budget.MaxInstances; max > 0 && callee.id >= max {
+		infer.partial(caller.Fn, pos, &ssabuilder.BudgetError{Budget: "max-instances", Limit: max})
+		return false
+	}
+	callee.FuncDef.Name = callee.DefName()
+	issues, effects := infer.issues(), infer.Env.effects
+	visitFunc(callee.Fn, infer, callee)
+	callee.summary.define(callee, infer.issues() > issues, infer.Env.effects > effects)
+	return true
+}
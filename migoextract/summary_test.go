@@ -0,0 +1,92 @@
+package migoextract
+
+import "testing"
+
+// TestSummaryEffects checks that a function adding to a WaitGroup is visited
+// at each call in a loop, so the WaitGroup holds every count, as reusing the
+// summary of the first call would not repeat the Add, and Wait would expect a
+// single Done.
+func TestSummaryEffects(t *testing.T) {
+	const src = `package main
+
+import "sync"
+
+func worker(wg *sync.WaitGroup) { wg.Done() }
+
+func spawn(wg *sync.WaitGroup) {
+	wg.Add(1)
+	go worker(wg)
+}
+
+func main() {
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		spawn(&wg)
+	}
+	wg.Wait()
+}
+`
+	infer := newInfer(t, buildSSA(t, src))
+	run(t, infer)
+	if got, want := stmts(t, infer, "main.main"), "let t0 = newchan 3; call main.main#3(t0)"; got != want {
+		t.Errorf("expecting main.main %q, got %q", want, got)
+	}
+	if res := check(t, infer); !res.Live() || !res.Safe() {
+		t.Errorf("expecting live and safe, got %+v", res)
+	}
+	for _, sum := range infer.Env.Summaries.All() {
+		if sum.Fn.Name() == "spawn" && (sum.Uses > 0 || sum.reusable()) {
+			t.Errorf("expecting summary %s not reused, got %d uses", sum.Name, sum.Uses)
+		}
+	}
+}
+
+// TestSummaryUses checks that the summary of a function is reused at each call
+// with the same shape, and defined once, with a definition per shape.
+func TestSummaryUses(t *testing.T) {
+	const src = `package main
+
+func send(ch chan int) { ch <- 1 }
+
+func recv(ch chan int) { <-ch }
+
+func pair(n int) {
+	ch := make(chan int, n)
+	go send(ch)
+	recv(ch)
+}
+
+func main() {
+	pair(0)
+	pair(0)
+	pair(1)
+}
+`
+	infer := newInfer(t, buildSSA(t, src))
+	run(t, infer)
+	for def, want := range map[string]string{
+		"main.main":    "call main.pair(); call main.pair(); call main.pair#s1()",
+		"main.pair":    "let t0 = newchan 0; spawn main.send(t0); call main.recv(t0)",
+		"main.pair#s1": "let t0 = newchan 1; spawn main.send(t0); call main.recv(t0)",
+	} {
+		if got := stmts(t, infer, def); got != want {
+			t.Errorf("expecting %s %q, got %q", def, want, got)
+		}
+	}
+	uses := make(map[string]int)
+	for _, sum := range infer.Env.Summaries.All() {
+		uses[sum.Name] = sum.Uses
+	}
+	for name, n := range map[string]int{"main.pair": 1, "main.pair#s1": 0, "main.send": 1, "main.recv": 1} {
+		if uses[name] != n {
+			t.Errorf("expecting summary %s used %d times, got %d\n%s", name, n, uses[name], infer.Env.Summaries)
+		}
+	}
+	defs := make(map[string]bool)
+	for _, fn := range infer.Env.MigoProg.Funcs {
+		if fn.Name != "main.main" && defs[fn.Name] {
+			t.Errorf("expecting one definition of %s", fn.Name)
+		}
+		defs[fn.Name] = true
+	}
+}
@@ -0,0 +1,350 @@
+package migoextract
+
+// Functions for handling sync.Mutex, sync.RWMutex and sync.WaitGroup.
+//
+// The sync package is not built (see ssabuilder), so these are encoded with
+// buffered channels instead. A Mutex is a channel with buffer size 1, where
+// Lock sends and Unlock receives, so a second Lock blocks until Unlock.
+//
+// A RWMutex is a readers channel with buffer size TypeInfer.RWReaders and a
+// writers channel with buffer size 1. RLock sends to (and RUnlock receives
+// from) the readers channel, Lock sends to the writers channel then fills the
+// readers channel, so a pending writer blocks new readers as in Go. Unlike Go,
+// an RLock blocks while RWReaders readers hold the lock.
+//
+// A WaitGroup is a channel where Done sends and Wait receives once for each
+// pending Add (the constant counts are resolved by constant propagation). The
+// buffer size is the maximum pending count, so Done never blocks, and Wait
+// blocks forever if a Done is missing. The pending counts are restored at the
+// start of each branch, so an Add in a branch is counted on the paths of the
+// branch only. A block joining branches is visited once, with the counts of
+// the first branch.
+//
+// These values are passed to functions like channels, including the fields of
+// struct arguments (e.g. the receiver of a method), which are created with the
+// struct.
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"strconv"
+
+	"github.com/damifur/migo"
+	"golang.org/x/tools/go/ssa"
+)
+
+// DefaultRWReaders is the default maximum number of readers holding a RWMutex.
+const DefaultRWReaders = 2
+
+// syncKind is the kind of sync value.
+type syncKind int
+
+const (
+	notSync syncKind = iota
+	mutexLock
+	rwMutexLock
+	waitGroup
+	cancelCtx // context.Context or context.CancelFunc (see cancel.go).
+	timer     // time.Timer (see timer.go).
+	ticker    // time.Ticker (see timer.go).
+)
+
+// waitGroupChan is the channel of a WaitGroup.
+type waitGroupChan struct {
+	decl    *migo.NewChanStatement // Declaration, the size is updated by Add.
+	pending int64                  // Count of pending Add (since last Wait).
+}
+
+// syncOf returns the kind of sync (or timer) value pointed to by values of
+// type t, or the kind of context values of type t.
+func syncOf(t types.Type) syncKind {
+	if named, ok := t.(*types.Named); ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == "context" {
+		switch named.Obj().Name() {
+		case "Context", "CancelFunc":
+			return cancelCtx
+		}
+		return notSync
+	}
+	ptr, ok := t.Underlying().(*types.Pointer)
+	if !ok {
+		return notSync
+	}
+	named, ok := ptr.Elem().(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return notSync
+	}
+	switch named.Obj().Pkg().Path() + "." + named.Obj().Name() {
+	case "time.Timer":
+		return timer
+	case "time.Ticker":
+		return ticker
+	}
+	if named.Obj().Pkg().Path() != "sync" {
+		return notSync
+	}
+	switch named.Obj().Name() {
+	case "Mutex":
+		return mutexLock
+	case "RWMutex":
+		return rwMutexLock
+	case "WaitGroup":
+		return waitGroup
+	}
+	return notSync
+}
+
+// writerChan is the writers channel of a RWMutex.
+type writerChan struct {
+	ssa.Value
+}
+
+func (w writerChan) Name() string   { return w.Value.Name() + "_w" }
+func (w writerChan) String() string { return w.Value.String() + "_w" }
+
+// syncVar is a sync value as named in the scope of a function.
+type syncVar struct {
+	ssa.Value
+	name string
+}
+
+func (v syncVar) Name() string   { return v.name }
+func (v syncVar) String() string { return v.name }
+
+// syncField is a sync field (e.g. a lock) of an allocated struct.
+type syncField struct {
+	ssa.Value // Struct allocation.
+	field     int
+	typ       types.Type // Pointer to sync value.
+}
+
+func (f syncField) Name() string     { return fmt.Sprintf("%s_%d", f.Value.Name(), f.field) }
+func (f syncField) String() string   { return f.Name() }
+func (f syncField) Type() types.Type { return f.typ }
+
+// syncParams returns the parameters of the channels of a sync value passed
+// from caller to callee, nil if values of type t are not sync values.
+func syncParams(caller, callee ssa.Value, t types.Type) []*migo.Parameter {
+	switch syncOf(t) {
+	case mutexLock, waitGroup:
+		return []*migo.Parameter{{Caller: caller, Callee: callee}}
+	case rwMutexLock:
+		return []*migo.Parameter{
+			{Caller: caller, Callee: callee},
+			{Caller: writerChan{caller}, Callee: writerChan{callee}},
+		}
+	case cancelCtx:
+		return []*migo.Parameter{
+			{Caller: caller, Callee: callee},
+			{Caller: onceChan{caller}, Callee: onceChan{callee}},
+		}
+	case timer, ticker:
+		return []*migo.Parameter{
+			{Caller: caller, Callee: callee},
+			{Caller: timerChan{caller, "_stop"}, Callee: timerChan{callee, "_stop"}},
+			{Caller: timerChan{caller, "_done"}, Callee: timerChan{callee, "_done"}},
+		}
+	}
+	return nil
+}
+
+// syncName returns the name of sync value v (with instance inst) in the scope of
+// caller.
+func (caller *Function) syncName(v ssa.Value, inst Instance) string {
+	if paramName, ok := caller.revlookup[inst.String()]; ok {
+		return paramName
+	}
+	if _, ok := v.(*ssa.Phi); ok {
+		return v.Name()
+	}
+	return inst.(*Value).Name()
+}
+
+// syncArg returns argument v as named in the scope of caller if v is a sync
+// value.
+func (caller *Function) syncArg(v ssa.Value) ssa.Value {
+	if inst, ok := caller.locals[v]; ok {
+		if _, ok := inst.(*Value); ok {
+			return syncVar{v, caller.syncName(v, inst)}
+		}
+	}
+	return v
+}
+
+// makeSyncFields creates the sync fields of struct alloc (with instance inst),
+// so the fields are shared by the functions the struct is passed to.
+func (caller *Function) makeSyncFields(alloc *ssa.Alloc, inst Instance, t *types.Struct, fields Fields, infer *TypeInfer) {
+	for i := 0; i < t.NumFields(); i++ {
+		ptr := types.NewPointer(t.Field(i).Type())
+		switch syncOf(ptr) {
+		case mutexLock, rwMutexLock, waitGroup:
+		default: // Timers are only made by package time.
+			continue
+		}
+		v := syncField{Value: alloc, field: i, typ: ptr}
+		fields[i] = &Value{v, caller.InstanceID(), inst.(*Value).loopIdx, 0}
+		caller.makeSync(v, fields[i].(*Value), infer)
+	}
+}
+
+// structSyncParams returns the parameters of the sync fields of struct arg
+// passed from caller to param of callee, the fields are named after param.
+func (caller *Function) structSyncParams(arg ssa.Value, param *ssa.Parameter, callee *Function) []*migo.Parameter {
+	inst, ok := caller.locals[arg]
+	if !ok {
+		return nil
+	}
+	fields, ok := caller.structs[inst]
+	if !ok {
+		if fields, ok = caller.Prog.structs[inst]; !ok {
+			return nil
+		}
+	}
+	var params []*migo.Parameter
+	for i, field := range fields {
+		v, ok := field.(*Value)
+		if !ok || syncOf(v.Type()) == notSync {
+			continue
+		}
+		name := fmt.Sprintf("%s_%d", param.Name(), i)
+		callee.revlookup[v.String()] = name
+		params = append(params, syncParams(syncVar{v, caller.syncName(arg, v)}, syncVar{v, name}, v.Type())...)
+	}
+	return params
+}
+
+// makeSync creates the channels of sync value v (with instance inst) if v is
+// a sync value, e.g. allocated or accessed as a struct field for the first time.
+// The channels are passed to the successor blocks like channels made by
+// make(chan).
+func (caller *Function) makeSync(v ssa.Value, inst *Value, infer *TypeInfer) {
+	line := strconv.Itoa(infer.SSA.FSet.Position(v.Pos()).Line)
+	switch syncOf(v.Type()) {
+	case mutexLock:
+		caller.FuncDef.AddStmts(&migo.NewChanStatement{Name: v, Chan: inst.String(), Size: 1, LineNum: line})
+		caller.extraargs = append(caller.extraargs, v)
+	case rwMutexLock:
+		caller.FuncDef.AddStmts(
+			&migo.NewChanStatement{Name: v, Chan: inst.String(), Size: int64(infer.RWReaders), LineNum: line},
+			&migo.NewChanStatement{Name: writerChan{v}, Chan: inst.String() + "_w", Size: 1, LineNum: line})
+		caller.extraargs = append(caller.extraargs, v, writerChan{v})
+	case waitGroup:
+		wg := &waitGroupChan{decl: &migo.NewChanStatement{Name: v, Chan: inst.String(), Size: 0, LineNum: line}}
+		caller.Prog.waitGroups[inst] = wg
+		caller.FuncDef.AddStmts(wg.decl)
+		caller.extraargs = append(caller.extraargs, v)
+	default:
+		return
+	}
+	infer.Logger.Print(caller.Sprintf(ChanSymbol+"%s = %s", inst, fmtChan(v.Type().(*types.Pointer).Elem().String())))
+}
+
+// callSync models a call of a sync.Mutex, sync.RWMutex or sync.WaitGroup
+// method at pos, returns false if common does not call such a method.
+func (caller *Function) callSync(common *ssa.CallCommon, pos token.Pos, infer *TypeInfer) bool {
+	fn := common.StaticCallee()
+	if fn == nil || fn.Signature.Recv() == nil || len(common.Args) == 0 {
+		return false
+	}
+	kind := syncOf(fn.Signature.Recv().Type())
+	if kind == notSync {
+		return false
+	}
+	switch fn.Name() {
+	case "Lock", "Unlock", "RLock", "RUnlock", "Add", "Done", "Wait":
+	default:
+		return false
+	}
+	inst, ok := caller.locals[common.Args[0]]
+	if !ok {
+		infer.warn(caller.Fn, pos, "%s: unknown %s %s", fn.Name(), fn.Signature.Recv().Type(), common.Args[0].Name())
+		return true
+	}
+	if _, ok := inst.(*Value); !ok {
+		infer.warn(caller.Fn, pos, "%s: %s is not an instance", fn.Name(), inst)
+		return true
+	}
+	name := caller.syncName(common.Args[0], inst)
+	line := strconv.Itoa(infer.SSA.FSet.Position(pos).Line)
+	send := func(ch string, n int) {
+		for i := 0; i < n; i++ {
+			caller.FuncDef.AddStmts(&migo.SendStatement{Chan: ch, LineNum: line})
+		}
+	}
+	recv := func(ch string, n int) {
+		for i := 0; i < n; i++ {
+			caller.FuncDef.AddStmts(&migo.RecvStatement{Chan: ch, LineNum: line})
+		}
+	}
+	switch {
+	case kind == waitGroup:
+		caller.callWaitGroup(common, fn.Name(), inst, pos, send, recv, infer)
+	case kind == mutexLock && fn.Name() == "Lock":
+		send(name, 1)
+	case kind == mutexLock && fn.Name() == "Unlock":
+		recv(name, 1)
+	case fn.Name() == "RLock":
+		send(name, 1)
+	case fn.Name() == "RUnlock":
+		recv(name, 1)
+	case fn.Name() == "Lock":
+		send(name+"_w", 1)
+		send(name, infer.RWReaders)
+	case fn.Name() == "Unlock":
+		recv(name, infer.RWReaders)
+		recv(name+"_w", 1)
+	}
+	infer.Logger.Print(caller.Sprintf("%s.%s @ %s", inst, fn.Name(), fmtPos(infer.SSA.FSet.Position(pos).String())))
+	return true
+}
+
+// callWaitGroup models a call of method of a WaitGroup (with instance inst),
+// where send and recv add the statements of the WaitGroup channel.
+func (caller *Function) callWaitGroup(common *ssa.CallCommon, method string, inst Instance, pos token.Pos, send, recv func(string, int), infer *TypeInfer) {
+	wg, ok := caller.Prog.waitGroups[inst]
+	if !ok {
+		infer.warn(caller.Fn, pos, "%s: unknown sync.WaitGroup %s", method, inst)
+		return
+	}
+	name := caller.syncName(common.Args[0], inst)
+	switch method {
+	case "Add":
+		n, ok := constInt(common.Args[1], caller)
+		if !ok {
+			n = 1
+			infer.warn(caller.Fn, pos, "Add: non-constant count %s (assuming 1)", common.Args[1].Name())
+		}
+		if n < 0 { // Add(-n) is n Done.
+			send(name, int(-n))
+			return
+		}
+		wg.pending += n
+		caller.Prog.effects++
+		if wg.pending > wg.decl.Size {
+			wg.decl.Size = wg.pending
+		}
+	case "Done":
+		send(name, 1)
+	case "Wait":
+		recv(name, int(wg.pending))
+		wg.pending = 0
+		caller.Prog.effects++
+	}
+}
+
+// pendingCounts returns the pending counts of the WaitGroups.
+func (prog *Program) pendingCounts() map[*waitGroupChan]int64 {
+	counts := make(map[*waitGroupChan]int64, len(prog.waitGroups))
+	for _, wg := range prog.waitGroups {
+		counts[wg] = wg.pending
+	}
+	return counts
+}
+
+// restorePending restores the pending counts of the WaitGroups to counts, the
+// WaitGroups created since have no pending Add.
+func (prog *Program) restorePending(counts map[*waitGroupChan]int64) {
+	for _, wg := range prog.waitGroups {
+		wg.pending = counts[wg]
+	}
+}
@@ -0,0 +1,157 @@
+package migoextract
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestRWReaders checks that a RWMutex is a channel with RWReaders slots, which
+// RLock takes one of and Lock takes all of.
+func TestRWReaders(t *testing.T) {
+	const src = `package main
+
+import "sync"
+
+func main() {
+	var mu sync.RWMutex
+	mu.Lock()
+	mu.Unlock()
+}
+`
+	for _, readers := range []int{DefaultRWReaders, 3} {
+		infer := newInfer(t, buildSSA(t, src))
+		infer.RWReaders = readers
+		run(t, infer)
+		want := fmt.Sprintf("let t0 = newchan %d; let t0_w = newchan 1; let t0_0 = newchan 1; send t0_w%s%s; recv t0_w",
+			readers, strings.Repeat("; send t0", readers), strings.Repeat("; recv t0", readers))
+		if got := stmts(t, infer, "main.main"); got != want {
+			t.Errorf("%d readers: expecting %q, got %q", readers, want, got)
+		}
+	}
+}
+
+// TestMutex checks the statements of locks, which are channels locked by a
+// send and unlocked by a receive, including deferred unlocks.
+func TestMutex(t *testing.T) {
+	const src = `package main
+
+import "sync"
+
+func inc(mu *sync.Mutex, n *int) {
+	mu.Lock()
+	defer mu.Unlock()
+	*n++
+}
+
+func main() {
+	%s
+}
+`
+	for _, tc := range []struct {
+		body string
+		def  string
+		want string
+	}{
+		{"var mu sync.Mutex; mu.Lock(); mu.Unlock()", "main.main", "let t0 = newchan 1; send t0; recv t0"},
+		{"var mu sync.Mutex; n := 0; inc(&mu, &n)", "main.main", "let t0 = newchan 1; call main.inc(t0)"},
+		{"var mu sync.Mutex; n := 0; inc(&mu, &n)", "main.inc", "send mu; recv mu"},
+		{"var rw sync.RWMutex; rw.RLock(); rw.RUnlock()", "main.main", "let t0 = newchan 2; let t0_w = newchan 1; let t0_0 = newchan 1; send t0; recv t0"},
+	} {
+		infer := newInfer(t, buildSSA(t, fmt.Sprintf(src, tc.body)))
+		run(t, infer)
+		if got := stmts(t, infer, tc.def); got != tc.want {
+			t.Errorf("%s: expecting %s %q, got %q", tc.body, tc.def, tc.want, got)
+		}
+	}
+}
+
+// TestMutexDeadlock checks the deadlock of a lock held across a receive from
+// a goroutine waiting for the lock.
+func TestMutexDeadlock(t *testing.T) {
+	const src = `package main
+
+import "sync"
+
+func main() {
+	var mu sync.Mutex
+	ch := make(chan int)
+	go func() {
+		mu.Lock()
+		ch <- 1
+		mu.Unlock()
+	}()
+	mu.Lock()
+	<-ch
+	mu.Unlock()
+}
+`
+	infer := newInfer(t, buildSSA(t, src))
+	run(t, infer)
+	if res := check(t, infer); res.Live() {
+		t.Errorf("expecting deadlock, got %+v", res)
+	}
+}
+
+// TestWaitGroup checks the statements of WaitGroups, which are channels with
+// a slot per pending count, resolved by constant propagation and counted on
+// the paths of the Add only, where Done sends and Wait receives each count.
+func TestWaitGroup(t *testing.T) {
+	const src = `package main
+
+import "sync"
+
+var flag bool
+
+func worker(wg *sync.WaitGroup) { wg.Done() }
+
+func add(wg *sync.WaitGroup, n int) { wg.Add(n) }
+
+func count() int { return 2 }
+
+func main() {
+	var wg sync.WaitGroup
+	%s
+}
+`
+	for _, tc := range []struct {
+		body string
+		def  string
+		want string
+	}{
+		{"wg.Add(2); go worker(&wg); go worker(&wg); wg.Wait()", "main.main", "let t0 = newchan 2; spawn main.worker(t0); spawn main.worker(t0); recv t0; recv t0"},
+		{"wg.Add(2); go worker(&wg); go worker(&wg); wg.Wait()", "main.worker", "send wg"},
+		{"add(&wg, 2); wg.Wait()", "main.main", "let t0 = newchan 2; call main.add(t0); recv t0; recv t0"},
+		{"wg.Add(count()); go worker(&wg); wg.Wait()", "main.main", "let t0 = newchan 1; call main.count(); spawn main.worker(t0); recv t0"},
+		{"if flag { wg.Add(1); return }; wg.Add(1); go worker(&wg); wg.Wait()", "main.main", "let t0 = newchan 1; if {  } else { spawn main.worker(t0); recv t0 }"},
+	} {
+		infer := newInfer(t, buildSSA(t, fmt.Sprintf(src, tc.body)))
+		run(t, infer)
+		if got := stmts(t, infer, tc.def); got != tc.want {
+			t.Errorf("%s: expecting %s %q, got %q", tc.body, tc.def, tc.want, got)
+		}
+	}
+}
+
+// TestWaitGroupDeadlock checks the deadlock of a Wait for more counts than
+// are done.
+func TestWaitGroupDeadlock(t *testing.T) {
+	const src = `package main
+
+import "sync"
+
+func worker(wg *sync.WaitGroup) { wg.Done() }
+
+func main() {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go worker(&wg)
+	wg.Wait()
+}
+`
+	infer := newInfer(t, buildSSA(t, src))
+	run(t, infer)
+	if res := check(t, infer); res.Live() {
+		t.Errorf("expecting deadlock, got %+v", res)
+	}
+}
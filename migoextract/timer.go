@@ -0,0 +1,194 @@
+package migoextract
+
+// Functions for handling timers and tickers of package time.
+//
+// The time package is not built (see ssabuilder), so timers and tickers are
+// encoded with processes which may fire at any time. The channel of a timer
+// (time.After or Timer.C) or a ticker (time.Tick or Ticker.C) has buffer size
+// 1, and each timer spawns time.timer, which either fires or is stopped, then
+// closes the done channel of the timer:
+//
+//   def time.timer(c, stop, done): select case send c; close done; case recv stop; close done; endselect;
+//
+// Each ticker spawns time.ticker, which fires until it is stopped, and never
+// blocks as ticks are dropped when the channel is not ready:
+//
+//   def time.ticker(c, stop, done): select case send c; call time.ticker(c, stop, done); case tau; call time.ticker(c, stop, done); case recv stop; close done; endselect;
+//
+// Stop never blocks, whether or not the timer has fired:
+//
+//   def time.stop(stop, done): select case send stop; case recv done; endselect;
+//
+// Reset stops the timer (or ticker) and spawns a new one with new stop and
+// done channels, so that the new one is stopped by a later Stop.
+
+import (
+	"go/token"
+	"go/types"
+	"strconv"
+
+	"github.com/damifur/migo"
+	"golang.org/x/tools/go/ssa"
+)
+
+// timerChan is the stop or done channel of a timer.
+type timerChan struct {
+	ssa.Value
+	suffix string // "_stop" or "_done".
+}
+
+func (t timerChan) Name() string   { return t.Value.Name() + t.suffix }
+func (t timerChan) String() string { return t.Value.String() + t.suffix }
+
+// clockVar is the channel of a timer or a ticker.
+type clockVar struct {
+	ssa.Value            // Call creating the timer or ticker.
+	typ       types.Type // <-chan time.Time.
+}
+
+func (v clockVar) Type() types.Type { return v.typ }
+
+// callTimer models a call of a timer or ticker function of package time, or
+// of a method of a timer or ticker, returns false if common is not such a
+// call. The results are stored in value (nil if the call is deferred).
+func (caller *Function) callTimer(common *ssa.CallCommon, value ssa.Value, pos token.Pos, infer *TypeInfer, l *Loop) bool {
+	fn := common.StaticCallee()
+	if fn == nil || fn.Pkg == nil || fn.Pkg.Pkg.Path() != "time" {
+		return false
+	}
+	if recv := fn.Signature.Recv(); recv != nil {
+		return caller.callTimerMethod(common, fn, syncOf(recv.Type()), value, pos, infer)
+	}
+	if value == nil {
+		return false
+	}
+	switch fn.Name() {
+	case "After":
+		caller.locals[value] = caller.makeClock(value, value.Type(), false, l.Index, infer)
+	case "Tick":
+		caller.locals[value] = caller.makeClock(value, value.Type(), true, l.Index, infer)
+	case "NewTimer", "NewTicker":
+		sType, ok := derefType(value.Type()).Underlying().(*types.Struct)
+		if !ok {
+			return false
+		}
+		fields := make(Fields, sType.NumFields())
+		for i := range fields {
+			if sType.Field(i).Name() != "C" {
+				continue
+			}
+			inst := caller.makeClock(value, sType.Field(i).Type(), fn.Name() == "NewTicker", l.Index, infer)
+			caller.locals[value] = inst
+			caller.structs[inst] = fields
+			fields[i] = inst
+		}
+		if _, ok := caller.locals[value]; !ok {
+			return false
+		}
+	default:
+		return false
+	}
+	infer.Logger.Print(caller.Sprintf("time.%s @ %s", fn.Name(), fmtPos(infer.SSA.FSet.Position(pos).String())))
+	return true
+}
+
+// callTimerMethod models a call of method fn of a timer or ticker (of the
+// given kind).
+func (caller *Function) callTimerMethod(common *ssa.CallCommon, fn *ssa.Function, kind syncKind, value ssa.Value, pos token.Pos, infer *TypeInfer) bool {
+	if kind != timer && kind != ticker {
+		return false
+	}
+	switch fn.Name() {
+	case "Stop", "Reset":
+	default:
+		return false
+	}
+	if value != nil { // Stop and Reset results do not communicate.
+		caller.locals[value] = &External{parent: caller.Fn, typ: value.Type().Underlying()}
+	}
+	inst, ok := caller.locals[common.Args[0]]
+	if !ok {
+		infer.warn(caller.Fn, pos, "%s: unknown %s %s", fn.Name(), fn.Signature.Recv().Type(), common.Args[0].Name())
+		return true
+	}
+	if _, ok := inst.(*Value); !ok {
+		infer.warn(caller.Fn, pos, "%s: %s is not an instance", fn.Name(), inst)
+		return true
+	}
+	name, line := caller.syncName(common.Args[0], inst), strconv.Itoa(infer.SSA.FSet.Position(pos).Line)
+	caller.FuncDef.AddStmts(&migo.CallStatement{
+		Name:    "time.stop",
+		Params:  []*migo.Parameter{modelArg(name+"_stop", "stop"), modelArg(name+"_done", "done")},
+		LineNum: line,
+	})
+	if fn.Name() == "Reset" {
+		caller.FuncDef.AddStmts(
+			&migo.NewChanStatement{Name: modelVar(name + "_stop"), Chan: inst.String() + "_stop", Size: 0, LineNum: line},
+			&migo.NewChanStatement{Name: modelVar(name + "_done"), Chan: inst.String() + "_done", Size: 0, LineNum: line},
+			spawnClock(name, kind == ticker, line))
+	}
+	infer.Logger.Print(caller.Sprintf("%s.%s @ %s", inst, fn.Name(), fmtPos(infer.SSA.FSet.Position(pos).String())))
+	return true
+}
+
+// makeClock creates the channels of a timer (or a ticker if ticker is true)
+// made by v, where the channel is of type typ.
+func (caller *Function) makeClock(v ssa.Value, typ types.Type, ticker bool, loopIdx int64, infer *TypeInfer) *Value {
+	c := clockVar{Value: v, typ: typ}
+	inst := &Value{c, caller.InstanceID(), loopIdx, 0}
+	line := strconv.Itoa(infer.SSA.FSet.Position(v.Pos()).Line)
+	stop, done := timerChan{c, "_stop"}, timerChan{c, "_done"}
+	caller.defineTimerFuncs(infer)
+	caller.FuncDef.AddStmts(
+		&migo.NewChanStatement{Name: c, Chan: inst.String(), Size: 1, LineNum: line},
+		&migo.NewChanStatement{Name: stop, Chan: inst.String() + "_stop", Size: 0, LineNum: line},
+		&migo.NewChanStatement{Name: done, Chan: inst.String() + "_done", Size: 0, LineNum: line},
+		spawnClock(c.Name(), ticker, line))
+	caller.extraargs = append(caller.extraargs, c, stop, done)
+	infer.Logger.Print(caller.Sprintf(ChanSymbol+"%s = %s", inst, fmtChan(typ.String())))
+	return inst
+}
+
+// spawnClock returns the spawn of the process of the timer (or the ticker if
+// ticker is true) with channel name.
+func spawnClock(name string, ticker bool, line string) *migo.SpawnStatement {
+	fn := "time.timer"
+	if ticker {
+		fn = "time.ticker"
+	}
+	return &migo.SpawnStatement{
+		Name:    fn,
+		Params:  []*migo.Parameter{modelArg(name, "c"), modelArg(name+"_stop", "stop"), modelArg(name+"_done", "done")},
+		LineNum: line,
+	}
+}
+
+// defineTimerFuncs adds the timer model functions to the program, if they are
+// not added already.
+func (caller *Function) defineTimerFuncs(infer *TypeInfer) {
+	if caller.Prog.timerFuncs {
+		return
+	}
+	caller.Prog.timerFuncs = true
+	params := []*migo.Parameter{modelArg("c", "c"), modelArg("stop", "stop"), modelArg("done", "done")}
+	timer := migo.NewFunction("time.timer")
+	timer.AddParams(params...)
+	timer.AddStmts(&migo.SelectStatement{Cases: [][]migo.Statement{
+		{&migo.SendStatement{Chan: "c", LineNum: "0"}, &migo.CloseStatement{Chan: "done", LineNum: "0"}},
+		{&migo.RecvStatement{Chan: "stop", LineNum: "0"}, &migo.CloseStatement{Chan: "done", LineNum: "0"}},
+	}})
+	ticker := migo.NewFunction("time.ticker")
+	ticker.AddParams(params...)
+	ticker.AddStmts(&migo.SelectStatement{Cases: [][]migo.Statement{
+		{&migo.SendStatement{Chan: "c", LineNum: "0"}, &migo.CallStatement{Name: "time.ticker", Params: params, LineNum: "0"}},
+		{&migo.TauStatement{LineNum: "0"}, &migo.CallStatement{Name: "time.ticker", Params: params, LineNum: "0"}},
+		{&migo.RecvStatement{Chan: "stop", LineNum: "0"}, &migo.CloseStatement{Chan: "done", LineNum: "0"}},
+	}})
+	stop := migo.NewFunction("time.stop")
+	stop.AddParams(modelArg("stop", "stop"), modelArg("done", "done"))
+	stop.AddStmts(&migo.SelectStatement{Cases: [][]migo.Statement{
+		{&migo.SendStatement{Chan: "stop", LineNum: "0"}},
+		{&migo.RecvStatement{Chan: "done", LineNum: "0"}},
+	}})
+	infer.Env.addDefs([]*migo.Function{timer, ticker, stop})
+}
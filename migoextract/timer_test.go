@@ -0,0 +1,67 @@
+package migoextract
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestTimer checks the statements of timers and tickers, which are processes
+// sending to their buffered channel once (or until stopped for tickers), where
+// Stop stops the process and Reset replaces it by a new one.
+func TestTimer(t *testing.T) {
+	const src = `package main
+
+import "time"
+
+func main() {
+	%s
+}
+`
+	for _, tc := range []struct {
+		body string
+		def  string
+		want string
+	}{
+		{"<-time.After(time.Second)", "main.main", "let t0 = newchan 1; let t0_stop = newchan 0; let t0_done = newchan 0; spawn time.timer(t0, t0_stop, t0_done); recv t0"},
+		{"<-time.Tick(time.Second)", "main.main", "let t0 = newchan 1; let t0_stop = newchan 0; let t0_done = newchan 0; spawn time.ticker(t0, t0_stop, t0_done); recv t0"},
+		{"t := time.NewTimer(time.Second); t.Stop()", "main.main", "let t0 = newchan 1; let t0_stop = newchan 0; let t0_done = newchan 0; spawn time.timer(t0, t0_stop, t0_done); call time.stop(t0_stop, t0_done)"},
+		{"t := time.NewTimer(time.Second); <-t.C; t.Reset(time.Second); t.Stop()", "main.main", "let t0 = newchan 1; let t0_stop = newchan 0; let t0_done = newchan 0; spawn time.timer(t0, t0_stop, t0_done); recv t0; call time.stop(t0_stop, t0_done); let t0_stop = newchan 0; let t0_done = newchan 0; spawn time.timer(t0, t0_stop, t0_done); call time.stop(t0_stop, t0_done)"},
+		{"tick := time.NewTicker(time.Second); <-tick.C; tick.Stop()", "main.main", "let t0 = newchan 1; let t0_stop = newchan 0; let t0_done = newchan 0; spawn time.ticker(t0, t0_stop, t0_done); recv t0; call time.stop(t0_stop, t0_done)"},
+		{"<-time.After(time.Second)", "time.timer", "select { case send c; close done case recv stop; close done }"},
+		{"<-time.After(time.Second)", "time.ticker", "select { case send c; call time.ticker(c, stop, done) case tau; call time.ticker(c, stop, done) case recv stop; close done }"},
+		{"<-time.After(time.Second)", "time.stop", "select { case send stop case recv done }"},
+	} {
+		infer := newInfer(t, buildSSA(t, fmt.Sprintf(src, tc.body)))
+		run(t, infer)
+		if got := stmts(t, infer, tc.def); got != tc.want {
+			t.Errorf("%s: expecting %s %q, got %q", tc.body, tc.def, tc.want, got)
+		}
+	}
+}
+
+// TestTimerDeadlock checks the deadlock of a receiver left blocked by a send
+// protected by a timeout which fires first.
+func TestTimerDeadlock(t *testing.T) {
+	const src = `package main
+
+import "time"
+
+func main() {
+	ch := make(chan int)
+	go func() {
+		<-ch
+	}()
+	t := time.NewTimer(time.Second)
+	select {
+	case ch <- 1:
+		t.Stop()
+	case <-t.C:
+	}
+}
+`
+	infer := newInfer(t, buildSSA(t, src))
+	run(t, infer)
+	if res := check(t, infer); res.Live() || !res.Safe() {
+		t.Errorf("expecting deadlock and safe, got %+v", res)
+	}
+}
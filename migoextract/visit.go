@@ -9,12 +9,31 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/damifur/dingo-hunter/ssabuilder"
 	"github.com/damifur/migo"
 	"golang.org/x/tools/go/ssa"
 )
 
 // visitFunc analyses function body.
+//
+// A function with unsupported constructs is skipped: the analysis of its body
+// is abandoned, its definition is emptied and the definitions of its blocks are
+// removed from the program, and the function is treated as having no body by
+// its caller.
 func visitFunc(fn *ssa.Function, infer *TypeInfer, f *Function) {
+	def := f.FuncDef
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(*ssabuilder.UnsupportedError)
+			if !ok {
+				panic(r)
+			}
+			err.At(fn)
+			infer.skip(err)
+			f.FuncDef = infer.clearDefs(def)
+			f.hasBody = false
+		}
+	}()
 	infer.Env.MigoProg.AddFunction(f.FuncDef)
 
 	infer.Logger.Printf(f.Sprintf(FuncEnterSymbol+"───── func %s ─────", fn.Name()))
@@ -30,6 +49,14 @@ func visitFunc(fn *ssa.Function, infer *TypeInfer, f *Function) {
 		infer.Logger.Printf(f.Sprintf(ParamSymbol+"%s = %s", val.Name(), instance))
 		f.revlookup[instance.String()] = val.Name() // If it comes from params..
 	}
+	if p, reason := infer.SSA.BuildConf.FuncPolicy(fn); p == ssabuilder.Opaque || p == ssabuilder.Pure {
+		infer.Logger.Print(f.Sprintf(MoreSymbol+"« %s package: %s »", p, reason))
+		if p == ssabuilder.Opaque && ssabuilder.HasChanParams(fn) {
+			infer.warn(fn, token.NoPos, "opaque function %s not analysed, channel parameters may be used", fn.String())
+		}
+		f.hasBody = false // Treated as having no body
+		return
+	}
 
 	if fn.Blocks == nil {
 		infer.Logger.Print(f.Sprintf(MoreSymbol + "« no function body »"))
@@ -43,6 +70,9 @@ func visitFunc(fn *ssa.Function, infer *TypeInfer, f *Function) {
 }
 
 func visitBasicBlock(blk *ssa.BasicBlock, infer *TypeInfer, f *Function, bPrev *Block, l *Loop) {
+	if !infer.enterBlock(f, blk) {
+		return
+	}
 	loopStateTransition(blk, infer, f, &l)
 	if l.Bound == Static && l.HasNext() {
 		//fmt.Println("This has next: ", l)
@@ -175,12 +205,15 @@ func visitAlloc(instr *ssa.Alloc, infer *TypeInfer, ctx *Context) {
 		}
 	case *types.Struct:
 		ctx.F.locals[instr] = &Value{instr, ctx.F.InstanceID(), ctx.L.Index, 0}
+		ctx.F.makeSync(instr, ctx.F.locals[instr].(*Value), infer)
 		if instr.Heap {
 			ctx.F.Prog.structs[ctx.F.locals[instr]] = make(Fields, t.NumFields())
 			infer.Logger.Print(ctx.F.Sprintf(NewSymbol+"%s = alloc (struct@heap) of type %s (%d fields)", ctx.F.locals[instr], instr.Type(), t.NumFields()))
+			ctx.F.makeSyncFields(instr, ctx.F.locals[instr], t, ctx.F.Prog.structs[ctx.F.locals[instr]], infer)
 		} else {
 			ctx.F.structs[ctx.F.locals[instr]] = make(Fields, t.NumFields())
 			infer.Logger.Print(ctx.F.Sprintf(NewSymbol+"%s = alloc (struct@local) of type %s (%d fields)", ctx.F.locals[instr], instr.Type(), t.NumFields()))
+			ctx.F.makeSyncFields(instr, ctx.F.locals[instr], t, ctx.F.structs[ctx.F.locals[instr]], infer)
 		}
 	case *types.Pointer:
 		switch pt := t.Elem().Underlying().(type) {
@@ -277,7 +310,7 @@ func visitCall(instr *ssa.Call, infer *TypeInfer, ctx *Context) {
 func visitChangeType(instr *ssa.ChangeType, infer *TypeInfer, ctx *Context) {
 	inst, ok := ctx.F.locals[instr.X]
 	if !ok {
-		infer.Logger.Fatalf("changetype: %s: %v → %v", ErrUnknownValue, instr.X, instr)
+		infer.unsupported(instr, "changetype: %s: %v → %v", ErrUnknownValue, instr.X, instr)
 		return
 	}
 	ctx.F.locals[instr] = inst
@@ -301,7 +334,7 @@ func visitChangeType(instr *ssa.ChangeType, infer *TypeInfer, ctx *Context) {
 func visitChangeInterface(instr *ssa.ChangeInterface, infer *TypeInfer, ctx *Context) {
 	inst, ok := ctx.F.locals[instr.X]
 	if !ok {
-		infer.Logger.Fatalf("changeiface: %s: %v → %v", ErrUnknownValue, instr.X, instr)
+		infer.unsupported(instr, "changeiface: %s: %v → %v", ErrUnknownValue, instr.X, instr)
 	}
 	ctx.F.locals[instr] = inst
 }
@@ -314,13 +347,13 @@ func visitConvert(instr *ssa.Convert, infer *TypeInfer, ctx *Context) {
 		} else if _, ok := instr.X.(*ssa.Global); ok {
 			inst, ok := ctx.F.Prog.globals[instr.X]
 			if !ok {
-				infer.Logger.Fatalf("convert (global): %s: %+v", ErrUnknownValue, instr.X)
+				infer.unsupported(instr, "convert (global): %s: %+v", ErrUnknownValue, instr.X)
 			}
 			ctx.F.locals[instr.X] = inst
 			infer.Logger.Print(ctx.F.Sprintf(SkipSymbol+"%s convert= %s (global)", ctx.F.locals[instr], instr.X.Name()))
 			return
 		} else {
-			infer.Logger.Fatalf("convert: %s: %+v", ErrUnknownValue, instr.X)
+			infer.unsupported(instr, "convert: %s: %+v", ErrUnknownValue, instr.X)
 			return
 		}
 	}
@@ -338,7 +371,7 @@ func visitDeref(instr *ssa.UnOp, infer *TypeInfer, ctx *Context) {
 	if _, ok := ptr.(*ssa.Global); ok {
 		inst, ok := ctx.F.Prog.globals[ptr]
 		if !ok {
-			infer.Logger.Fatalf("deref (global): %s: %+v", ErrUnknownValue, ptr)
+			infer.unsupported(instr, "deref (global): %s: %+v", ErrUnknownValue, ptr)
 			return
 		}
 		ctx.F.locals[ptr], ctx.F.locals[val] = inst, inst
@@ -355,7 +388,7 @@ func visitDeref(instr *ssa.UnOp, infer *TypeInfer, ctx *Context) {
 	// Locactx.L.
 	inst, ok := ctx.F.locals[ptr]
 	if !ok {
-		infer.Logger.Fatalf("deref: %s: %+v", ErrUnknownValue, ptr)
+		infer.unsupported(instr, "deref: %s: %+v", ErrUnknownValue, ptr)
 		return
 	}
 	ctx.F.locals[ptr], ctx.F.locals[val] = inst, inst
@@ -368,7 +401,7 @@ func visitDeref(instr *ssa.UnOp, infer *TypeInfer, ctx *Context) {
 func visitExtract(instr *ssa.Extract, infer *TypeInfer, ctx *Context) {
 	if tupleInst, ok := ctx.F.locals[instr.Tuple]; ok {
 		if _, ok := ctx.F.tuples[tupleInst]; !ok { // Tuple uninitialised
-			infer.Logger.Fatalf("extract: %s: Unexpected tuple: %+v", ErrUnknownValue, instr)
+			infer.unsupported(instr, "extract: %s: Unexpected tuple: %+v", ErrUnknownValue, instr)
 			return
 		}
 		if inst := ctx.F.tuples[tupleInst][instr.Index]; inst == nil {
@@ -410,14 +443,14 @@ func visitField(instr *ssa.Field, infer *TypeInfer, ctx *Context) {
 	if sType, ok := struc.Type().Underlying().(*types.Struct); ok {
 		sInst, ok := ctx.F.locals[struc]
 		if !ok {
-			infer.Logger.Fatalf("field: %s :%+v", ErrUnknownValue, struc)
+			infer.unsupported(instr, "field: %s :%+v", ErrUnknownValue, struc)
 			return
 		}
 		fields, ok := ctx.F.structs[sInst]
 		if !ok {
 			fields, ok = ctx.F.Prog.structs[sInst]
 			if !ok {
-				infer.Logger.Fatalf("field: %s: struct uninitialised %+v", ErrUnknownValue, sInst)
+				infer.unsupported(instr, "field: %s: struct uninitialised %+v", ErrUnknownValue, sInst)
 				return
 			}
 		}
@@ -432,7 +465,7 @@ func visitField(instr *ssa.Field, infer *TypeInfer, ctx *Context) {
 		ctx.F.locals[field] = fields[index]
 		return
 	}
-	infer.Logger.Fatalf("field: %s: field is not struct: %+v", ErrInvalidVarRead, struc)
+	infer.unsupported(instr, "field: %s: field is not struct: %+v", ErrInvalidVarRead, struc)
 }
 
 func visitFieldAddr(instr *ssa.FieldAddr, infer *TypeInfer, ctx *Context) {
@@ -442,7 +475,7 @@ func visitFieldAddr(instr *ssa.FieldAddr, infer *TypeInfer, ctx *Context) {
 		if !ok {
 			sInst, ok = ctx.F.Prog.globals[struc]
 			if !ok {
-				infer.Logger.Fatalf("field-addr: %s: %+v", ErrUnknownValue, struc)
+				infer.unsupported(instr, "field-addr: %s: %+v", ErrUnknownValue, struc)
 				return
 			}
 		}
@@ -460,7 +493,7 @@ func visitFieldAddr(instr *ssa.FieldAddr, infer *TypeInfer, ctx *Context) {
 			}
 			return
 		default:
-			infer.Logger.Fatalf("field-addr: %s: not instance %+v", ErrUnknownValue, sInst)
+			infer.unsupported(instr, "field-addr: %s: not instance %+v", ErrUnknownValue, sInst)
 			return
 		}
 		// Find the struct.
@@ -468,7 +501,7 @@ func visitFieldAddr(instr *ssa.FieldAddr, infer *TypeInfer, ctx *Context) {
 		if !ok {
 			fields, ok = ctx.F.Prog.structs[sInst]
 			if !ok {
-				infer.Logger.Fatalf("field-addr: %s: struct uninitialised %+v", ErrUnknownValue, sInst)
+				infer.unsupported(instr, "field-addr: %s: struct uninitialised %+v", ErrUnknownValue, sInst)
 				return
 			}
 		}
@@ -478,12 +511,13 @@ func visitFieldAddr(instr *ssa.FieldAddr, infer *TypeInfer, ctx *Context) {
 		} else {
 			fields[index] = &Value{field, ctx.F.InstanceID(), ctx.L.Index, 0}
 			infer.Logger.Print(ctx.F.Sprintf(SubSymbol+"field uninitialised, set to %s", field.Name()))
+			ctx.F.makeSync(field, fields[index].(*Value), infer)
 		}
 		initNestedRefVar(infer, ctx, fields[index], false)
 		ctx.F.locals[field] = fields[index]
 		return
 	}
-	infer.Logger.Fatalf("field-addr: %s: field is not struct: %+v", ErrInvalidVarRead, struc)
+	infer.unsupported(instr, "field-addr: %s: field is not struct: %+v", ErrInvalidVarRead, struc)
 }
 
 func visitGo(instr *ssa.Go, infer *TypeInfer, ctx *Context) {
@@ -494,7 +528,7 @@ func visitGo(instr *ssa.Go, infer *TypeInfer, ctx *Context) {
 
 func visitIf(instr *ssa.If, infer *TypeInfer, ctx *Context) {
 	if len(instr.Block().Succs) != 2 {
-		infer.Logger.Fatal(ErrInvalidIfSucc)
+		infer.unsupported(instr, "%v", ErrInvalidIfSucc)
 	}
 	// Detect and unroll ctx.L.
 	if ctx.L.State != NonLoop && ctx.L.Bound == Static && instr.Cond == ctx.L.CondVar {
@@ -515,16 +549,18 @@ func visitIf(instr *ssa.If, infer *TypeInfer, ctx *Context) {
 					//infer.Logger.Print(fmt.Sprintf("[select-%d]", i.Int64()), ctx.F.FuncDef.String())
 					parDef := ctx.F.FuncDef
 					parDef.PutAway() // Save select
+					pending := ctx.F.Prog.pendingCounts()
 					visitBasicBlock(instr.Block().Succs[0], infer, ctx.F, NewBlock(ctx.F, instr.Block().Succs[0], ctx.B.Index), ctx.L)
+					ctx.F.Prog.restorePending(pending)
 					ctx.F.FuncDef.PutAway() // Save case
 					selCase, err := ctx.F.FuncDef.Restore()
 					if err != nil {
-						infer.Logger.Fatal("select-case:", err)
+						infer.unsupported(instr, "select-case: %v", err)
 					}
 					sel.MigoStmt.Cases[i.Int64()] = append(sel.MigoStmt.Cases[i.Int64()], selCase...)
 					selParent, err := parDef.Restore()
 					if err != nil {
-						infer.Logger.Fatal("select-parent:", err)
+						infer.unsupported(instr, "select-parent: %v", err)
 					}
 					parDef.AddStmts(selParent...)
 
@@ -534,7 +570,7 @@ func visitIf(instr *ssa.If, infer *TypeInfer, ctx *Context) {
 						if instr.Block().Succs[1].Comment == "select.done" {
 							// Looks like it's empty
 							infer.Logger.Printf(SplitSymbol+"Empty default branch (%d ⇾ %d)", instr.Block().Index, instr.Block().Succs[1].Index)
-							selDefault := &migo.CallStatement{Name: fmt.Sprintf("%s#%d", ctx.F.Fn.String(), instr.Block().Succs[1].Index), LineNum: strings.Split(fmtPos(infer.SSA.FSet.Position(instr.Pos()).String()), ":")[1]}
+							selDefault := &migo.CallStatement{Name: fmt.Sprintf("%s#%d", ctx.F.DefName(), instr.Block().Succs[1].Index), LineNum: strings.Split(fmtPos(infer.SSA.FSet.Position(instr.Pos()).String()), ":")[1]}
 							for i := 0; i < len(ctx.F.FuncDef.Params); i++ {
 								for k, ea := range ctx.F.extraargs {
 									if phi, ok := ea.(*ssa.Phi); ok {
@@ -573,7 +609,7 @@ func visitIf(instr *ssa.If, infer *TypeInfer, ctx *Context) {
 							sel.MigoStmt.Cases[len(sel.MigoStmt.Cases)-1] = append(sel.MigoStmt.Cases[len(sel.MigoStmt.Cases)-1], selDefault)
 							selParent, err := parDef.Restore()
 							if err != nil {
-								infer.Logger.Fatal("select-parent:", err)
+								infer.unsupported(instr, "select-parent: %v", err)
 							}
 							parDef.AddStmts(selParent...)
 						} else {
@@ -583,12 +619,12 @@ func visitIf(instr *ssa.If, infer *TypeInfer, ctx *Context) {
 							ctx.F.FuncDef.PutAway() // Save case
 							selDefault, err := ctx.F.FuncDef.Restore()
 							if err != nil {
-								infer.Logger.Fatal("select-default:", err)
+								infer.unsupported(instr, "select-default: %v", err)
 							}
 							sel.MigoStmt.Cases[len(sel.MigoStmt.Cases)-1] = append(sel.MigoStmt.Cases[len(sel.MigoStmt.Cases)-1], selDefault...)
 							selParent, err := parDef.Restore()
 							if err != nil {
-								infer.Logger.Fatal("select-parent:", err)
+								infer.unsupported(instr, "select-parent: %v", err)
 							}
 							parDef.AddStmts(selParent...)
 						}
@@ -611,15 +647,17 @@ func visitIf(instr *ssa.If, infer *TypeInfer, ctx *Context) {
 
 	// Save parent.
 	ctx.F.FuncDef.PutAway()
+	pending := ctx.F.Prog.pendingCounts()
 	infer.Logger.Printf(ctx.F.Sprintf(IfSymbol+"if %s then"+JumpSymbol+"%d", cond, instr.Block().Succs[0].Index))
 	visitBasicBlock(instr.Block().Succs[0], infer, ctx.F, NewBlock(ctx.F, instr.Block().Succs[0], ctx.B.Index), ctx.L)
 	// Save then.
 	ctx.F.FuncDef.PutAway()
+	ctx.F.Prog.restorePending(pending)
 	infer.Logger.Printf(ctx.F.Sprintf(IfSymbol+"if %s else"+JumpSymbol+"%d", cond, instr.Block().Succs[1].Index))
 	if ctx.L.State == Body && ctx.L.LoopBlock == ctx.B.Index {
 		// Infinite loop.
 		infer.Logger.Printf(ctx.F.Sprintf(LoopSymbol + " infinite loop"))
-		stmt := &migo.CallStatement{Name: fmt.Sprintf("%s#%d", ctx.F.Fn.String(), ctx.B.Index), LineNum: "0"}
+		stmt := &migo.CallStatement{Name: fmt.Sprintf("%s#%d", ctx.F.DefName(), ctx.B.Index), LineNum: "0"}
 		for _, p := range ctx.F.FuncDef.Params {
 			stmt.AddParams(&migo.Parameter{Caller: p.Callee, Callee: p.Callee})
 		}
@@ -631,15 +669,15 @@ func visitIf(instr *ssa.If, infer *TypeInfer, ctx *Context) {
 	ctx.F.FuncDef.PutAway()
 	elseStmts, err := ctx.F.FuncDef.Restore() // Else
 	if err != nil {
-		infer.Logger.Fatal("restore else:", err)
+		infer.unsupported(instr, "restore else: %v", err)
 	}
 	thenStmts, err := ctx.F.FuncDef.Restore() // Then
 	if err != nil {
-		infer.Logger.Fatal("restore then:", err)
+		infer.unsupported(instr, "restore then: %v", err)
 	}
 	parentStmts, err := ctx.F.FuncDef.Restore() // Parent
 	if err != nil {
-		infer.Logger.Fatal("restore if-then-else parent:", err)
+		infer.unsupported(instr, "restore if-then-else parent: %v", err)
 	}
 	ctx.F.FuncDef.AddStmts(parentStmts...)
 	ctx.F.FuncDef.AddStmts(&migo.IfStatement{Then: thenStmts, Else: elseStmts})
@@ -653,7 +691,7 @@ func visitIndex(instr *ssa.Index, infer *TypeInfer, ctx *Context) {
 		if !ok {
 			aInst, ok = ctx.F.Prog.globals[array]
 			if !ok {
-				infer.Logger.Fatalf("index: %s: array %+v", ErrUnknownValue, array)
+				infer.unsupported(instr, "index: %s: array %+v", ErrUnknownValue, array)
 				return
 			}
 		}
@@ -661,7 +699,7 @@ func visitIndex(instr *ssa.Index, infer *TypeInfer, ctx *Context) {
 		if !ok {
 			elems, ok = ctx.F.Prog.arrays[aInst]
 			if !ok {
-				infer.Logger.Fatalf("index: %s: not an array %+v", ErrUnknownValue, aInst)
+				infer.unsupported(instr, "index: %s: not an array %+v", ErrUnknownValue, aInst)
 				return
 			}
 		}
@@ -686,7 +724,7 @@ func visitIndexAddr(instr *ssa.IndexAddr, infer *TypeInfer, ctx *Context) {
 		if !ok {
 			aInst, ok = ctx.F.Prog.globals[array]
 			if !ok {
-				infer.Logger.Fatalf("index-addr: %s: array %+v", ErrUnknownValue, array)
+				infer.unsupported(instr, "index-addr: %s: array %+v", ErrUnknownValue, array)
 				return
 			}
 		}
@@ -703,7 +741,7 @@ func visitIndexAddr(instr *ssa.IndexAddr, infer *TypeInfer, ctx *Context) {
 			}
 			return
 		default:
-			infer.Logger.Fatalf("index-addr: %s: array is not instance %+v", ErrUnknownValue, aInst)
+			infer.unsupported(instr, "index-addr: %s: array is not instance %+v", ErrUnknownValue, aInst)
 			return
 		}
 		// Find the array.
@@ -711,7 +749,7 @@ func visitIndexAddr(instr *ssa.IndexAddr, infer *TypeInfer, ctx *Context) {
 		if !ok {
 			elems, ok = ctx.F.Prog.arrays[aInst]
 			if !ok {
-				infer.Logger.Fatalf("index-addr: %s: array uninitialised %s", ErrUnknownValue, aInst)
+				infer.unsupported(instr, "index-addr: %s: array uninitialised %s", ErrUnknownValue, aInst)
 				return
 			}
 		}
@@ -732,7 +770,7 @@ func visitIndexAddr(instr *ssa.IndexAddr, infer *TypeInfer, ctx *Context) {
 		if !ok {
 			sInst, ok = ctx.F.Prog.globals[array]
 			if !ok {
-				infer.Logger.Fatalf("index-addr: %s: slice %+v", ErrUnknownValue, array)
+				infer.unsupported(instr, "index-addr: %s: slice %+v", ErrUnknownValue, array)
 				return
 			}
 		}
@@ -754,7 +792,7 @@ func visitIndexAddr(instr *ssa.IndexAddr, infer *TypeInfer, ctx *Context) {
 			}
 			return
 		default:
-			infer.Logger.Fatalf("index-addr: %s: slice is not instance %+v", ErrUnknownValue, sInst)
+			infer.unsupported(instr, "index-addr: %s: slice is not instance %+v", ErrUnknownValue, sInst)
 			return
 		}
 		// Find the slice.
@@ -762,7 +800,7 @@ func visitIndexAddr(instr *ssa.IndexAddr, infer *TypeInfer, ctx *Context) {
 		if !ok {
 			elems, ok = ctx.F.Prog.arrays[sInst]
 			if !ok {
-				infer.Logger.Fatalf("index-addr: %s: slice uninitialised %+v", ErrUnknownValue, sInst)
+				infer.unsupported(instr, "index-addr: %s: slice uninitialised %+v", ErrUnknownValue, sInst)
 				return
 			}
 		}
@@ -777,12 +815,12 @@ func visitIndexAddr(instr *ssa.IndexAddr, infer *TypeInfer, ctx *Context) {
 		ctx.F.locals[elem] = elems[index]
 		return
 	}
-	infer.Logger.Fatalf("index-addr: %s: not array/slice %+v", ErrInvalidVarRead, array)
+	infer.unsupported(instr, "index-addr: %s: not array/slice %+v", ErrInvalidVarRead, array)
 }
 
 func visitJump(jump *ssa.Jump, infer *TypeInfer, ctx *Context) {
 	if len(jump.Block().Succs) != 1 {
-		infer.Logger.Fatal(ErrInvalidJumpSucc)
+		infer.unsupported(jump, "%v", ErrInvalidJumpSucc)
 	}
 	curr, next := jump.Block(), jump.Block().Succs[0]
 	infer.Logger.Printf(ctx.F.Sprintf(SkipSymbol+"block %d%s%d", curr.Index, fmtLoopHL(JumpSymbol), next.Index))
@@ -796,7 +834,7 @@ func visitJump(jump *ssa.Jump, infer *TypeInfer, ctx *Context) {
 		//if ctx.L.Bound == Static && ctx.L.HasNext() {
 		//stmt = &migo.CallStatement{Name: fmt.Sprintf("%s#%d_loop%d", ctx.F.Fn.String(), next.Index, ctx.L.Index), Params: []*migo.Parameter{}}
 		//} else {
-		stmt = &migo.CallStatement{Name: fmt.Sprintf("%s#%d", ctx.F.Fn.String(), next.Index), LineNum: strconv.Itoa(int(jump.Pos()))}
+		stmt = &migo.CallStatement{Name: fmt.Sprintf("%s#%d", ctx.F.DefName(), next.Index), LineNum: strconv.Itoa(int(jump.Pos()))}
 		for i := 0; i < len(ctx.F.FuncDef.Params); i++ {
 			for k, ea := range ctx.F.extraargs {
 				if phi, ok := ea.(*ssa.Phi); ok {
@@ -836,7 +874,7 @@ func visitJump(jump *ssa.Jump, infer *TypeInfer, ctx *Context) {
 			newBlock := NewBlock(ctx.F, next, ctx.B.Index)
 			oldFunc, newFunc := ctx.F.FuncDef, newBlock.MigoDef
 			if ctx.L.Bound == Static && ctx.L.HasNext() {
-				newFunc = migo.NewFunction(fmt.Sprintf("%s#%d_loop%d", ctx.F.Fn.String(), next.Index, ctx.L.Index))
+				newFunc = migo.NewFunction(fmt.Sprintf("%s#%d_loop%d", ctx.F.DefName(), next.Index, ctx.L.Index))
 			}
 			for _, p := range stmt.Params {
 				newFunc.AddParams(&migo.Parameter{Caller: p.Callee, Callee: p.Callee})
@@ -858,7 +896,7 @@ func visitLookup(instr *ssa.Lookup, infer *TypeInfer, ctx *Context) {
 			ctx.F.locals[instr.X] = &Const{c}
 			v = ctx.F.locals[instr.X]
 		} else {
-			infer.Logger.Fatalf("lookup: %s: %+v", ErrUnknownValue, instr.X)
+			infer.unsupported(instr, "lookup: %s: %+v", ErrUnknownValue, instr.X)
 			return
 		}
 	}
@@ -882,28 +920,28 @@ func visitLookup(instr *ssa.Lookup, infer *TypeInfer, ctx *Context) {
 }
 
 func visitMakeChan(instr *ssa.MakeChan, infer *TypeInfer, ctx *Context) {
-	fmt.Println("Estoy en visit Chan: ", strings.Split(fmtPos(infer.SSA.FSet.Position(instr.Pos()).String()), ":")[1])
 	line, err := strconv.Atoi(strings.Split(fmtPos(infer.SSA.FSet.Position(instr.Pos()).String()), ":")[1])
 	if err != nil {
-		fmt.Println("There was an error parsing line number")
+		infer.Logger.Print("There was an error parsing line number")
 	}
 	newch := &Value{instr, ctx.F.InstanceID(), ctx.L.Index, line}
 	ctx.F.locals[instr] = newch
 	chType, ok := instr.Type().(*types.Chan)
 	if !ok {
-		infer.Logger.Fatal(ErrMakeChanNonChan)
+		infer.unsupported(instr, "%v", ErrMakeChanNonChan)
 	}
-	bufSz, ok := instr.Size.(*ssa.Const)
+	bufSz, ok := constInt(instr.Size, ctx.F)
 	if !ok {
-		infer.Logger.Fatal(ErrNonConstChanBuf)
+		bufSz = infer.BufferPolicy.Size()
+		infer.warn(ctx.F.Fn, instr.Pos(), "%v: %s (assuming buffer size %s)", ErrNonConstChanBuf, instr.Size.String(), infer.BufferPolicy)
 	}
 	infer.Logger.Printf(ctx.F.Sprintf(ChanSymbol+"%s = %s {t:%s, buf:%d} @ %s",
 		newch,
 		fmtChan("chan"),
 		chType.Elem(),
-		bufSz.Int64(),
+		bufSz,
 		fmtPos(infer.SSA.FSet.Position(instr.Pos()).String())))
-	ctx.F.FuncDef.AddStmts(&migo.NewChanStatement{Name: instr, Chan: newch.String(), Size: bufSz.Int64(), LineNum: strings.Split(fmtPos(infer.SSA.FSet.Position(instr.Pos()).String()), ":")[1]})
+	ctx.F.FuncDef.AddStmts(&migo.NewChanStatement{Name: instr, Chan: newch.String(), Size: bufSz, LineNum: strings.Split(fmtPos(infer.SSA.FSet.Position(instr.Pos()).String()), ":")[1]})
 	// Make sure it is not a duplicated extraargs
 	var found bool
 	for _, ea := range ctx.F.extraargs {
@@ -935,7 +973,7 @@ func visitMakeInterface(instr *ssa.MakeInterface, infer *TypeInfer, ctx *Context
 		if c, ok := instr.X.(*ssa.Const); ok {
 			ctx.F.locals[instr.X] = &Const{c}
 		} else {
-			infer.Logger.Fatalf("make-iface: %s: %s", ErrUnknownValue, instr.X)
+			infer.unsupported(instr, "make-iface: %s: %s", ErrUnknownValue, instr.X)
 			return
 		}
 	}
@@ -958,7 +996,7 @@ func visitMakeSlice(instr *ssa.MakeSlice, infer *TypeInfer, ctx *Context) {
 func visitMapUpdate(instr *ssa.MapUpdate, infer *TypeInfer, ctx *Context) {
 	inst, ok := ctx.F.locals[instr.Map]
 	if !ok {
-		infer.Logger.Fatalf("map-update: %s: %s", ErrUnknownValue, instr.Map)
+		infer.unsupported(instr, "map-update: %s: %s", ErrUnknownValue, instr.Map)
 		return
 	}
 	m, ok := ctx.F.maps[inst]
@@ -1012,7 +1050,7 @@ func visitRecv(instr *ssa.UnOp, infer *TypeInfer, ctx *Context) {
 	ctx.F.locals[instr] = &Value{instr, ctx.F.InstanceID(), ctx.L.Index, 0} // received value
 	ch, ok := ctx.F.locals[instr.X]
 	if !ok { // Channel does not exist
-		infer.Logger.Fatalf("recv: %s: %+v", ErrUnknownValue, instr.X)
+		infer.unsupported(instr, "recv: %s: %+v", ErrUnknownValue, instr.X)
 		return
 	}
 	// Receive test.
@@ -1063,14 +1101,28 @@ func visitReturn(ret *ssa.Return, infer *TypeInfer, ctx *Context) {
 func visitRunDefers(instr *ssa.RunDefers, infer *TypeInfer, ctx *Context) {
 	for i := len(ctx.F.defers) - 1; i >= 0; i-- {
 		common := ctx.F.defers[i].Common()
+		if ctx.F.callSync(common, ctx.F.defers[i].Pos(), infer) {
+			continue
+		}
+		if ctx.F.callContext(common, nil, ctx.F.defers[i].Pos(), infer, ctx.L) {
+			continue
+		}
+		if ctx.F.callTimer(common, nil, ctx.F.defers[i].Pos(), infer, ctx.L) {
+			continue
+		}
+		if ctx.F.callStub(common, nil, false, ctx.F.defers[i].Pos(), infer) {
+			continue
+		}
 		if common.StaticCallee() != nil {
 			callee := ctx.F.prepareCallFn(common, common.StaticCallee(), nil)
-			visitFunc(callee.Fn, infer, callee)
+			if !ctx.F.visitCallee(callee, ctx.F.defers[i].Pos(), infer) {
+				continue
+			}
 			if callee.HasBody() {
-				callStmt := &migo.CallStatement{Name: callee.Fn.String(), Params: []*migo.Parameter{}, LineNum: strings.Split(fmtPos(infer.SSA.FSet.Position(instr.Pos()).String()), ":")[1]}
+				callStmt := &migo.CallStatement{Name: callee.DefName(), Params: []*migo.Parameter{}, LineNum: strings.Split(fmtPos(infer.SSA.FSet.Position(ctx.F.defers[i].Pos()).String()), ":")[1]}
 				for _, c := range common.Args {
 					if _, ok := c.Type().(*types.Chan); ok {
-						infer.Logger.Fatalf("channel in defer: %s", ErrUnimplemented)
+						infer.unsupported(ctx.F.defers[i], "channel in defer: %s", ErrUnimplemented)
 					}
 				}
 				callee.FuncDef.AddStmts(callStmt)
@@ -1135,7 +1187,7 @@ func visitSelect(instr *ssa.Select, infer *TypeInfer, ctx *Context) {
 func visitSend(instr *ssa.Send, infer *TypeInfer, ctx *Context) {
 	ch, ok := ctx.F.locals[instr.Chan]
 	if !ok {
-		infer.Logger.Fatalf("send: %s: %+v", ErrUnknownValue, instr.Chan)
+		infer.unsupported(instr, "send: %s: %+v", ErrUnknownValue, instr.Chan)
 	}
 	pos := infer.SSA.DecodePos(ch.(*Value).Pos())
 	infer.Logger.Printf(ctx.F.Sprintf(SendSymbol+"%s @ %s", ch, fmtPos(pos)))
@@ -1161,7 +1213,7 @@ func visitSkip(instr ssa.Instruction, infer *TypeInfer, ctx *Context) {
 func visitSlice(instr *ssa.Slice, infer *TypeInfer, ctx *Context) {
 	ctx.F.locals[instr] = &Value{instr, ctx.F.InstanceID(), ctx.L.Index, 0}
 	if _, ok := ctx.F.locals[instr.X]; !ok {
-		infer.Logger.Fatalf("slice: %s: %+v", ErrUnknownValue, instr.X)
+		infer.unsupported(instr, "slice: %s: %+v", ErrUnknownValue, instr.X)
 		return
 	}
 	if basic, ok := instr.Type().Underlying().(*types.Basic); ok && basic.Kind() == types.String {
@@ -1180,7 +1232,7 @@ func visitSlice(instr *ssa.Slice, infer *TypeInfer, ctx *Context) {
 		if !ok {
 			switch ctx.F.locals[instr.X].(type) {
 			case *Value: // Continue
-				infer.Logger.Fatalf("slice: %s: non-slice %+v", ErrUnknownValue, instr.X)
+				infer.unsupported(instr, "slice: %s: non-slice %+v", ErrUnknownValue, instr.X)
 				return
 			case *Const:
 				ctx.F.arrays[ctx.F.locals[instr.X]] = make(Elems)
@@ -1203,7 +1255,7 @@ func visitStore(instr *ssa.Store, infer *TypeInfer, ctx *Context) {
 	if _, ok := dstPtr.(*ssa.Global); ok {
 		dstInst, ok := ctx.F.Prog.globals[dstPtr]
 		if !ok {
-			infer.Logger.Fatalf("store (global): %s: %+v", ErrUnknownValue, dstPtr)
+			infer.unsupported(instr, "store (global): %s: %+v", ErrUnknownValue, dstPtr)
 		}
 		inst, ok := ctx.F.locals[source]
 		if !ok {
@@ -1212,7 +1264,7 @@ func visitStore(instr *ssa.Store, infer *TypeInfer, ctx *Context) {
 				if c, ok := source.(*ssa.Const); ok {
 					inst = &Const{c}
 				} else {
-					infer.Logger.Fatalf("store (global): %s: %+v", ErrUnknownValue, source)
+					infer.unsupported(instr, "store (global): %s: %+v", ErrUnknownValue, source)
 				}
 			}
 		}
@@ -1239,7 +1291,7 @@ func visitStore(instr *ssa.Store, infer *TypeInfer, ctx *Context) {
 	// Locactx.L.
 	dstInst, ok := ctx.F.locals[dstPtr]
 	if !ok {
-		infer.Logger.Fatalf("store: addr %s: %+v", ErrUnknownValue, dstPtr)
+		infer.unsupported(instr, "store: addr %s: %+v", ErrUnknownValue, dstPtr)
 	}
 	inst, ok := ctx.F.locals[source]
 	if !ok {
@@ -1271,7 +1323,7 @@ func visitTypeAssert(instr *ssa.TypeAssert, infer *TypeInfer, ctx *Context) {
 		if meth, _ := types.MissingMethod(instr.X.Type(), iface, true); meth == nil { // No missing methods
 			inst, ok := ctx.F.locals[instr.X]
 			if !ok {
-				infer.Logger.Fatalf("typeassert: %s: iface X %+v", ErrUnknownValue, instr.X.Name())
+				infer.unsupported(instr, "typeassert: %s: iface X %+v", ErrUnknownValue, instr.X.Name())
 				return
 			}
 			if instr.CommaOk {
@@ -1285,12 +1337,12 @@ func visitTypeAssert(instr *ssa.TypeAssert, infer *TypeInfer, ctx *Context) {
 			infer.Logger.Print(ctx.F.Sprintf(SkipSymbol+"%s = typeassert iface %s", ctx.F.locals[instr], inst))
 			return
 		}
-		infer.Logger.Fatalf("typeassert: %s: %+v", ErrMethodNotFound, instr)
+		infer.unsupported(instr, "typeassert: %s: %+v", ErrMethodNotFound, instr)
 		return
 	}
 	inst, ok := ctx.F.locals[instr.X]
 	if !ok {
-		infer.Logger.Fatalf("typeassert: %s: assert from %+v", ErrUnknownValue, instr.X)
+		infer.unsupported(instr, "typeassert: %s: assert from %+v", ErrUnknownValue, instr.X)
 		return
 	}
 	if instr.CommaOk {
@@ -0,0 +1,58 @@
+package migoextract
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/damifur/migo"
+)
+
+// TestSkip checks that a function (or goroutine) with unsupported constructs is
+// skipped, leaving an empty definition and no definitions of its blocks.
+func TestSkip(t *testing.T) {
+	const src = `package main
+
+func ext() *[2]chan int
+
+func f() {
+	ch := make(chan int)
+	go func() { <-ch }()
+	for i := 0; i < 2; i++ {
+		ch <- 1
+	}
+	a := ext()
+	a[0] <- 1
+}
+
+func main() { %s }
+`
+	for _, call := range []string{"f()", "go f()"} {
+		infer := newInfer(t, buildSSA(t, fmt.Sprintf(src, call)))
+		run(t, infer)
+		if len(infer.Skipped) != 1 || infer.Skipped[0].Func.String() != "main.f" {
+			t.Fatalf("%s: expecting main.f skipped, got %v", call, infer.Skipped)
+		}
+		defined := make(map[string]bool)
+		for _, def := range infer.Env.MigoProg.Funcs {
+			defined[def.Name] = true
+			if def.Name == "main.f" && len(def.Stmts) != 0 || strings.HasPrefix(def.Name, "main.f#") {
+				t.Errorf("%s: expecting empty definition of skipped main.f, got:\n%s", call, infer.Env.MigoProg)
+			}
+		}
+		for _, def := range infer.Env.MigoProg.Funcs {
+			for _, stmt := range def.Stmts {
+				var name string
+				switch stmt := stmt.(type) {
+				case *migo.SpawnStatement:
+					name = stmt.Name
+				case *migo.CallStatement:
+					name = stmt.Name
+				}
+				if name != "" && !defined[name] {
+					t.Errorf("%s: expecting %s of %s defined, got:\n%s", call, name, def.Name, infer.Env.MigoProg)
+				}
+			}
+		}
+	}
+}
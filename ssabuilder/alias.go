@@ -0,0 +1,141 @@
+package ssabuilder
+
+// Alias analysis of channels.
+//
+// The pointer analysis is run once (on demand) for all channel values of the
+// program, and the channels (MakeChan) each value may point to are cached, so
+// alias queries do not re-run the analysis.
+
+import (
+	"go/types"
+	"sort"
+
+	"golang.org/x/tools/go/pointer"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// aliases is the cached result of the pointer analysis of channels.
+type aliases struct {
+	result *pointer.Result     // Result of the pointer analysis.
+	labels map[ssa.Value][]int // Channels (label indices) of a channel value.
+	makes  []ChanOp            // ChanMake of each label index.
+	ops    [][]int             // Operations (ops indices) on each label index.
+	chOps  []ChanOp            // All channel operations in the program.
+}
+
+// pta runs the pointer analysis of all channel values in the program (only
+// once), the result is nil if there is no analysis scope or the analysis
+// fails.
+func (info *SSAInfo) pta() *aliases {
+	info.ptaOnce.Do(func() {
+		if info.PtaConf == nil {
+			info.Logger.Print("Pointer analysis: no analysis scope")
+			return
+		}
+		conf := *info.PtaConf
+		conf.Queries, conf.IndirectQueries = nil, nil
+		conf.BuildCallGraph = info.BuildConf.Dispatch == PTADispatch
+		for _, v := range chanValues(info.Prog) {
+			conf.AddQuery(v)
+		}
+		result, err := pointer.Analyze(&conf)
+		if err != nil {
+			info.Logger.Print("Pointer analysis: ", ErrPtaInternal)
+			return
+		}
+		info.aliases = newAliases(result, progChanOps(info.Prog))
+	})
+	return info.aliases
+}
+
+// newAliases indexes the channels each queried value of result points to,
+// where the channels and the operations are indexed in order of position.
+func newAliases(result *pointer.Result, chOps []ChanOp) *aliases {
+	sort.SliceStable(chOps, func(i, j int) bool { return chOps[i].Pos < chOps[j].Pos })
+	a := &aliases{result: result, labels: make(map[ssa.Value][]int), chOps: chOps}
+	index := make(map[ssa.Value]int) // Label index of MakeChan.
+	for _, ptr := range result.Queries {
+		for _, label := range ptr.PointsTo().Labels() {
+			if _, ok := index[label.Value()]; !ok {
+				index[label.Value()] = len(a.makes)
+				a.makes = append(a.makes, ChanOp{label.Value(), ChanMake, label.Pos()})
+			}
+		}
+	}
+	sort.Slice(a.makes, func(i, j int) bool {
+		if a.makes[i].Pos != a.makes[j].Pos {
+			return a.makes[i].Pos < a.makes[j].Pos
+		}
+		return a.makes[i].Value.String() < a.makes[j].Value.String()
+	})
+	for i, mk := range a.makes {
+		index[mk.Value] = i
+	}
+	a.ops = make([][]int, len(a.makes))
+	for v, ptr := range result.Queries {
+		for _, label := range ptr.PointsTo().Labels() {
+			a.labels[v] = append(a.labels[v], index[label.Value()])
+		}
+		sort.Ints(a.labels[v])
+	}
+	for i, op := range chOps {
+		for _, l := range a.labels[op.Value] {
+			a.ops[l] = append(a.ops[l], i)
+		}
+	}
+	return a
+}
+
+// chanValues returns all channel values in the program.
+func chanValues(prog *ssa.Program) []ssa.Value {
+	var vals []ssa.Value
+	add := func(v ssa.Value) {
+		if _, ok := v.Type().Underlying().(*types.Chan); ok {
+			vals = append(vals, v)
+		}
+	}
+	for fn := range ssautil.AllFunctions(prog) {
+		for _, param := range fn.Params {
+			add(param)
+		}
+		for _, fv := range fn.FreeVars {
+			add(fv)
+		}
+		for _, b := range fn.Blocks {
+			for _, instr := range b.Instrs {
+				if v, ok := instr.(ssa.Value); ok {
+					add(v)
+				}
+			}
+		}
+	}
+	return vals
+}
+
+// FindChan returns the ChanOp related to a given chan ssa.Value (the MakeChan
+// and the operations on channels it may alias), by the cached pointer
+// analysis.
+func (info *SSAInfo) FindChan(ch ssa.Value) []ChanOp {
+	a := info.pta()
+	if a == nil {
+		return nil
+	}
+	var ops []ChanOp
+	var idx []int
+	seen := make(map[int]bool)
+	for _, l := range a.labels[ch] {
+		ops = append(ops, a.makes[l])
+		for _, i := range a.ops[l] {
+			if !seen[i] {
+				seen[i] = true
+				idx = append(idx, i)
+			}
+		}
+	}
+	sort.Ints(idx) // Order of position.
+	for _, i := range idx {
+		ops = append(ops, a.chOps[i])
+	}
+	return purgeChanOps(ops, ch)
+}
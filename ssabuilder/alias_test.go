@@ -0,0 +1,60 @@
+package ssabuilder
+
+import (
+	"testing"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// TestFindChan checks the channels (and their operations) a parameter may
+// point to, which are found by the pointer analysis run once.
+func TestFindChan(t *testing.T) {
+	const src = `package main
+
+func send(ch chan int) { ch <- 1 }
+
+func main() {
+	a := make(chan int)
+	b := make(chan int, 1)
+	go send(a)
+	go send(b)
+	<-a
+	close(b)
+}
+`
+	conf, err := NewConfigFromString(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := conf.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := MainPkg(info.Prog).Func("send").Params[0]
+	ops := info.FindChan(ch)
+	a := info.aliases
+	if a == nil {
+		t.Fatal("expecting pointer analysis cached")
+	}
+	want := []ChanOpType{ChanMake, ChanMake, ChanSend, ChanRecv, ChanClose}
+	if len(ops) != len(want) {
+		t.Fatalf("expecting %d channel operations but got %v", len(want), ops)
+	}
+	for i, op := range ops {
+		if op.Type != want[i] {
+			t.Errorf("expecting channel operation %d of type %d but got %d", i, want[i], op.Type)
+		}
+		// The makes then the operations, each in order of position.
+		if i > 0 && op.Type == ops[i-1].Type && op.Pos < ops[i-1].Pos {
+			t.Errorf("expecting channel operations in order of position but got %v", ops)
+		}
+	}
+	if mk, ok := ops[0].Value.(*ssa.MakeChan); !ok || mk.Size.String() != "0:int" {
+		t.Errorf("expecting unbuffered channel first but got %v", ops[0].Value)
+	}
+	for i := 0; i < 2; i++ {
+		if got := info.FindChan(ch); len(got) != len(ops) || info.aliases != a || info.pta() != a {
+			t.Errorf("expecting cached pointer analysis reused")
+		}
+	}
+}
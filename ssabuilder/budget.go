@@ -0,0 +1,53 @@
+package ssabuilder
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Budget is the resource budget of an analysis. Zero values are unlimited.
+//
+// An analysis which runs out of budget stops exploring the program and
+// returns a partial result, with the exhausted budgets listed. The Timeout
+// starts once the program is built, as the build cannot be cancelled.
+type Budget struct {
+	Timeout       time.Duration // Wall time of the analysis, after the build.
+	MaxBlocks     int           // Basic blocks visited per function instance.
+	MaxInstances  int           // Instances analysed of each function.
+	MaxGoroutines int           // Goroutines queued for analysis.
+}
+
+// Context returns a copy of ctx which is done after the Timeout of b.
+func (b Budget) Context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if b.Timeout > 0 {
+		return context.WithTimeout(ctx, b.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// BudgetError is the error of an exhausted budget.
+type BudgetError struct {
+	Budget string // Name of the budget, e.g. "max-blocks".
+	Limit  int
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("budget %s=%d exhausted", e.Budget, e.Limit)
+}
+
+// Partial is a list of reasons (exhausted budgets or cancellation) of an
+// analysis result being partial, each reason is recorded once.
+type Partial []error
+
+// Add records err, returns false if a reason with the same message is already
+// recorded.
+func (p *Partial) Add(err error) bool {
+	for _, e := range *p {
+		if e.Error() == err.Error() {
+			return false
+		}
+	}
+	*p = append(*p, err)
+	return true
+}
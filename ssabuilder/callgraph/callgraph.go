@@ -11,20 +11,25 @@ type Node struct {
 	Children []*Node
 }
 
-var (
-	visitedFunc  map[*ssa.Function]bool
-	visitedBlock map[*ssa.BasicBlock]bool
-)
+// builder holds the state of a call graph construction.
+type builder struct {
+	visitedFunc map[*ssa.Function]bool
+	callees     func(ssa.CallInstruction) []*ssa.Function
+}
 
-func Build(main *ssa.Function) *Node {
+// Build constructs the call graph from main, where callees returns the
+// functions possibly called at a call site (including dynamic calls).
+func Build(main *ssa.Function, callees func(ssa.CallInstruction) []*ssa.Function) *Node {
 	root := &Node{
 		Func:     main,
 		Children: []*Node{},
 	}
-	visitedFunc = make(map[*ssa.Function]bool)
-	visitedBlock = make(map[*ssa.BasicBlock]bool)
-	visitedFunc[root.Func] = true
-	visitBlock(root.Func.Blocks[0], root)
+	b := &builder{
+		visitedFunc: make(map[*ssa.Function]bool),
+		callees:     callees,
+	}
+	b.visitedFunc[root.Func] = true
+	b.visitFunc(root)
 	return root
 }
 
@@ -41,45 +46,26 @@ func (node *Node) Traverse(v FuncVisitor) {
 	}
 }
 
-func visitBlock(b *ssa.BasicBlock, node *Node) {
-	if _, ok := visitedBlock[b]; ok {
-		return
-	}
-	visitedBlock[b] = true
-	for _, instr := range b.Instrs {
-		switch instr := instr.(type) {
-		case *ssa.Call:
-			if f := instr.Common().StaticCallee(); f != nil {
-				if _, ok := visitedFunc[f]; !ok {
-					visitedFunc[f] = true
-					node.Children = append(node.Children, &Node{
-						Func:     f,
-						Children: []*Node{},
-					})
-				}
-			}
-		case *ssa.Go:
-			if f := instr.Common().StaticCallee(); f != nil {
-				if _, ok := visitedFunc[f]; !ok {
-					visitedFunc[f] = true
-					node.Children = append(node.Children, &Node{
-						Func:     f,
-						Children: []*Node{},
-					})
-				}
-			}
-		case *ssa.If:
-			visitBlock(instr.Block().Succs[0], node)
-			visitBlock(instr.Block().Succs[1], node)
-		case *ssa.Jump: // End of a block
-			visitBlock(instr.Block().Succs[0], node)
-		case *ssa.Return: // End of a function
-			for _, child := range node.Children {
-				if _, ok := visitedFunc[child.Func]; !ok {
-					visitedFunc[child.Func] = true
-					visitBlock(child.Func.Blocks[0], child)
+// visitFunc adds the functions called (or spawned) by node which are not in
+// the graph as its children, then visits the children.
+func (bld *builder) visitFunc(node *Node) {
+	for _, b := range node.Func.Blocks {
+		for _, instr := range b.Instrs {
+			switch instr := instr.(type) {
+			case *ssa.Call, *ssa.Go:
+				for _, f := range bld.callees(instr.(ssa.CallInstruction)) {
+					if _, ok := bld.visitedFunc[f]; !ok {
+						bld.visitedFunc[f] = true
+						node.Children = append(node.Children, &Node{
+							Func:     f,
+							Children: []*Node{},
+						})
+					}
 				}
 			}
 		}
 	}
+	for _, child := range node.Children {
+		bld.visitFunc(child)
+	}
 }
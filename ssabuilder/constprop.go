@@ -0,0 +1,206 @@
+package ssabuilder
+
+// Constant propagation of integer values, e.g. for channel buffer sizes.
+
+import (
+	"go/constant"
+	"go/token"
+	"go/types"
+	"math"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// BufferPolicy is the abstraction of channel buffer sizes which cannot be
+// resolved statically.
+type BufferPolicy int
+
+const (
+	BufferZero      BufferPolicy = iota // Unknown buffer treated as unbuffered.
+	BufferOne                           // Unknown buffer treated as size 1.
+	BufferUnbounded                     // Unknown buffer treated as unbounded.
+)
+
+// UnboundedBuf is the size of unbounded channel buffers in MiGo types.
+const UnboundedBuf int64 = math.MaxInt32
+
+// Size returns the buffer size of the policy.
+func (p BufferPolicy) Size() int64 {
+	switch p {
+	case BufferOne:
+		return 1
+	case BufferUnbounded:
+		return UnboundedBuf
+	}
+	return 0
+}
+
+func (p BufferPolicy) String() string {
+	switch p {
+	case BufferZero:
+		return "0"
+	case BufferOne:
+		return "1"
+	case BufferUnbounded:
+		return "unbounded"
+	}
+	return "BufferPolicy(?)"
+}
+
+// ParseBufferPolicy returns the policy by name ("0", "1" or "unbounded").
+func ParseBufferPolicy(s string) (BufferPolicy, bool) {
+	for _, p := range []BufferPolicy{BufferZero, BufferOne, BufferUnbounded} {
+		if p.String() == s {
+			return p, true
+		}
+	}
+	return BufferZero, false
+}
+
+// ConstInt resolves v to a constant integer by propagating constants through
+// the SSA, where arg returns the constant argument of a parameter (or free
+// variable) of the function, or nil if the argument is not constant.
+func ConstInt(v ssa.Value, arg func(ssa.Value) *ssa.Const) (int64, bool) {
+	return constIntVisit(v, arg, make(map[ssa.Value]bool))
+}
+
+func constIntVisit(v ssa.Value, arg func(ssa.Value) *ssa.Const, visited map[ssa.Value]bool) (int64, bool) {
+	if c, ok := v.(*ssa.Const); ok {
+		if c.Value == nil || c.Value.Kind() != constant.Int {
+			return 0, false
+		}
+		return constant.Int64Val(c.Value)
+	}
+	if visited[v] {
+		return 0, false
+	}
+	visited[v] = true
+	switch v := v.(type) {
+	case *ssa.Parameter, *ssa.FreeVar:
+		if c := arg(v); c != nil {
+			return constIntVisit(c, arg, visited)
+		}
+	case *ssa.Convert:
+		return constIntVisit(v.X, arg, visited)
+	case *ssa.ChangeType:
+		return constIntVisit(v.X, arg, visited)
+	case *ssa.UnOp:
+		if v.Op == token.SUB {
+			if x, ok := constIntVisit(v.X, arg, visited); ok {
+				return -x, true
+			}
+		}
+	case *ssa.BinOp:
+		x, ok := constIntVisit(v.X, arg, visited)
+		if !ok {
+			return 0, false
+		}
+		y, ok := constIntVisit(v.Y, arg, visited)
+		if !ok {
+			return 0, false
+		}
+		return constBinOp(v.Op, x, y)
+	case *ssa.Phi:
+		// All incoming edges must agree.
+		var val int64
+		for i, e := range v.Edges {
+			x, ok := constIntVisit(e, arg, visited)
+			if !ok || (i > 0 && x != val) {
+				return 0, false
+			}
+			val = x
+		}
+		return val, len(v.Edges) > 0
+	case *ssa.Call:
+		return constLen(v, arg, visited)
+	}
+	return 0, false
+}
+
+func constBinOp(op token.Token, x, y int64) (int64, bool) {
+	switch op {
+	case token.ADD:
+		return x + y, true
+	case token.SUB:
+		return x - y, true
+	case token.MUL:
+		return x * y, true
+	case token.QUO:
+		if y != 0 {
+			return x / y, true
+		}
+	case token.REM:
+		if y != 0 {
+			return x % y, true
+		}
+	case token.SHL:
+		if y >= 0 && y < 63 {
+			return x << uint(y), true
+		}
+	case token.SHR:
+		if y >= 0 && y < 63 {
+			return x >> uint(y), true
+		}
+	}
+	return 0, false
+}
+
+// constLen resolves calls to builtin len and cap of arrays, constant strings
+// and slices created with constant length or capacity.
+func constLen(call *ssa.Call, arg func(ssa.Value) *ssa.Const, visited map[ssa.Value]bool) (int64, bool) {
+	builtin, ok := call.Call.Value.(*ssa.Builtin)
+	if !ok || len(call.Call.Args) != 1 || (builtin.Name() != "len" && builtin.Name() != "cap") {
+		return 0, false
+	}
+	return constLenOf(call.Call.Args[0], builtin.Name() == "cap", arg, visited)
+}
+
+func constLenOf(v ssa.Value, isCap bool, arg func(ssa.Value) *ssa.Const, visited map[ssa.Value]bool) (int64, bool) {
+	if arr, ok := derefAll(v.Type()).Underlying().(*types.Array); ok {
+		return arr.Len(), true
+	}
+	switch v := v.(type) {
+	case *ssa.Const:
+		if v.Value != nil && v.Value.Kind() == constant.String {
+			return int64(len(constant.StringVal(v.Value))), true
+		}
+	case *ssa.MakeSlice:
+		if isCap {
+			return constIntVisit(v.Cap, arg, visited)
+		}
+		return constIntVisit(v.Len, arg, visited)
+	case *ssa.Slice:
+		// len = high - low, cap = max - low, where high and max default to
+		// the len and cap of the sliced operand.
+		var low int64
+		if v.Low != nil {
+			l, ok := constIntVisit(v.Low, arg, visited)
+			if !ok {
+				return 0, false
+			}
+			low = l
+		}
+		end := v.High
+		if isCap {
+			end = v.Max
+		}
+		if end == nil {
+			n, ok := constLenOf(v.X, isCap, arg, visited)
+			return n - low, ok
+		}
+		n, ok := constIntVisit(end, arg, visited)
+		return n - low, ok
+	}
+	return 0, false
+}
+
+// derefAll returns the type pointed to by t, through all levels of pointers.
+func derefAll(t types.Type) types.Type {
+	for {
+		p, ok := t.Underlying().(*types.Pointer)
+		if !ok {
+			return t
+		}
+		t = p.Elem()
+	}
+}
@@ -0,0 +1,78 @@
+package ssabuilder
+
+// Resolution of dynamic calls (through interfaces and function values).
+
+import (
+	"fmt"
+	"sort"
+
+	"golang.org/x/tools/go/callgraph/cha"
+	"golang.org/x/tools/go/callgraph/vta"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// A Dispatch value selects the analysis resolving the targets of dynamic
+// calls.
+type Dispatch string
+
+const (
+	StaticDispatch Dispatch = "static" // Static callees only (default).
+	CHADispatch    Dispatch = "cha"    // Class hierarchy analysis.
+	VTADispatch    Dispatch = "vta"    // Variable type analysis.
+	PTADispatch    Dispatch = "pta"    // Pointer analysis (of PtaConf).
+)
+
+// ParseDispatch returns the Dispatch named s.
+func ParseDispatch(s string) (Dispatch, error) {
+	switch d := Dispatch(s); d {
+	case StaticDispatch, CHADispatch, VTADispatch, PTADispatch:
+		return d, nil
+	case "":
+		return StaticDispatch, nil
+	}
+	return "", fmt.Errorf("unknown dispatch %q (static, cha, vta or pta)", s)
+}
+
+// Callees returns the functions possibly called at site, which are resolved
+// by the Dispatch of the build configuration if site is a dynamic call. The
+// callees are sorted by name.
+func (info *SSAInfo) Callees(site ssa.CallInstruction) []*ssa.Function {
+	if fn := site.Common().StaticCallee(); fn != nil {
+		return []*ssa.Function{fn}
+	}
+	info.dispatchOnce.Do(info.buildDispatch)
+	if info.dispatch == nil {
+		return nil
+	}
+	node, ok := info.dispatch.Nodes[site.Parent()]
+	if !ok {
+		return nil
+	}
+	var fns []*ssa.Function
+	seen := make(map[*ssa.Function]bool)
+	for _, edge := range node.Out {
+		if edge.Site == site && !seen[edge.Callee.Func] {
+			seen[edge.Callee.Func] = true
+			fns = append(fns, edge.Callee.Func)
+		}
+	}
+	sort.Slice(fns, func(i, j int) bool { return fns[i].String() < fns[j].String() })
+	return fns
+}
+
+// buildDispatch builds the call graph resolving dynamic calls, it is left nil
+// with StaticDispatch or if the pointer analysis fails. The pointer analysis
+// is shared with the alias analysis of channels (see alias.go).
+func (info *SSAInfo) buildDispatch() {
+	switch info.BuildConf.Dispatch {
+	case CHADispatch:
+		info.dispatch = cha.CallGraph(info.Prog)
+	case VTADispatch:
+		info.dispatch = vta.CallGraph(ssautil.AllFunctions(info.Prog), cha.CallGraph(info.Prog))
+	case PTADispatch:
+		if a := info.pta(); a != nil {
+			info.dispatch = a.result.CallGraph
+		}
+	}
+}
@@ -7,14 +7,13 @@ import (
 	"fmt"
 	"io"
 
-	"golang.org/x/tools/go/loader"
 	"golang.org/x/tools/go/pointer"
 	"golang.org/x/tools/go/ssa"
 )
 
-// Create a pointer.Config whose scope is the initial packages of lprog
+// Create a pointer.Config whose scope is the initial packages
 // and their dependencies.
-func setupPTA(prog *ssa.Program, lprog *loader.Program, ptaLog io.Writer) (*pointer.Config, error) {
+func setupPTA(prog *ssa.Program, initial []*ssa.Package, ptaLog io.Writer) (*pointer.Config, error) {
 	// TODO(adonovan): the body of this function is essentially
 	// duplicated in all go/pointer clients.  Refactor.
 
@@ -22,9 +21,7 @@ func setupPTA(prog *ssa.Program, lprog *loader.Program, ptaLog io.Writer) (*poin
 	// if it has a main function, analyze that,
 	// otherwise analyze its tests, if any.
 	var testPkgs, mains []*ssa.Package
-	for _, info := range lprog.InitialPackages() {
-		initialPkg := prog.Package(info.Pkg)
-
+	for _, initialPkg := range initial {
 		// Add package to the pointer analysis scope.
 		if initialPkg.Func("main") != nil {
 			mains = append(mains, initialPkg)
@@ -0,0 +1,139 @@
+package ssabuilder
+
+// Policies of packages, i.e. whether (and how) the functions of a package are
+// analysed.
+
+import (
+	"fmt"
+	"go/types"
+	"path"
+	"sort"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// A Policy value is the treatment of the functions of a package by the
+// analyses.
+type Policy string
+
+const (
+	Analyse Policy = "analyse" // Built and analysed (default).
+	Skip    Policy = "skip"    // Not built, so functions have no body.
+	Opaque  Policy = "opaque"  // Built but not analysed, effects are unknown.
+	Pure    Policy = "pure"    // Built but not analysed, no communication.
+)
+
+// ParsePolicy returns the Policy named s.
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(s); p {
+	case Analyse, Skip, Opaque, Pure:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown policy %q (analyse, skip, opaque or pure)", s)
+}
+
+// PackagePolicy is the policy of a package given by name (e.g. "rand") or by
+// import path (e.g. "math/rand").
+type PackagePolicy struct {
+	Package string // Package name or import path.
+	Policy  Policy
+	Reason  string
+}
+
+// SetPolicy sets the policy of package pkg (name or import path), replacing
+// its default policy. Setting Analyse removes pkg from the policies. A policy
+// given by name also replaces the policies of the import paths with that name,
+// e.g. "rand" replaces the policy of "math/rand", and applies to the packages
+// of that name. The default policies are given by import path only.
+func (conf *Config) SetPolicy(pkg string, p Policy, reason string) {
+	for _, pkgs := range []map[string]string{conf.BadPkgs, conf.OpaquePkgs, conf.PurePkgs} {
+		for key := range pkgs {
+			if key == pkg || path.Base(key) == pkg {
+				delete(pkgs, key)
+			}
+		}
+	}
+	if conf.names == nil {
+		conf.names = make(map[string]bool)
+	}
+	conf.names[pkg] = p != Analyse
+	set := func(pkgs *map[string]string) {
+		if *pkgs == nil {
+			*pkgs = make(map[string]string)
+		}
+		(*pkgs)[pkg] = reason
+	}
+	switch p {
+	case Skip:
+		set(&conf.BadPkgs)
+	case Opaque:
+		set(&conf.OpaquePkgs)
+	case Pure:
+		set(&conf.PurePkgs)
+	}
+}
+
+// Policies returns the policies of packages (other than Analyse), sorted by
+// package.
+func (conf *Config) Policies() []PackagePolicy {
+	var policies []PackagePolicy
+	add := func(p Policy, pkgs map[string]string) {
+		for pkg, reason := range pkgs {
+			policies = append(policies, PackagePolicy{Package: pkg, Policy: p, Reason: reason})
+		}
+	}
+	add(Skip, conf.BadPkgs)
+	add(Opaque, conf.OpaquePkgs)
+	add(Pure, conf.PurePkgs)
+	sort.SliceStable(policies, func(i, j int) bool { return policies[i].Package < policies[j].Package })
+	return policies
+}
+
+// PackagePolicy returns the policy of pkg and its reason. A policy given by
+// import path takes precedence over one given by package name.
+func (conf *Config) PackagePolicy(pkg *types.Package) (Policy, string) {
+	keys := []string{pkg.Path()}
+	if conf.names[pkg.Name()] {
+		keys = append(keys, pkg.Name())
+	}
+	for _, key := range keys {
+		if reason, ok := conf.BadPkgs[key]; ok {
+			return Skip, reason
+		}
+		if reason, ok := conf.OpaquePkgs[key]; ok {
+			return Opaque, reason
+		}
+		if reason, ok := conf.PurePkgs[key]; ok {
+			return Pure, reason
+		}
+	}
+	return Analyse, ""
+}
+
+// FuncPolicy returns the policy of the package of fn and its reason, which is
+// Analyse for functions without package (e.g. wrappers).
+func (conf *Config) FuncPolicy(fn *ssa.Function) (Policy, string) {
+	switch {
+	case fn.Pkg != nil:
+		return conf.PackagePolicy(fn.Pkg.Pkg)
+	case fn.Object() != nil && fn.Object().Pkg() != nil:
+		return conf.PackagePolicy(fn.Object().Pkg())
+	}
+	return Analyse, ""
+}
+
+// HasChanParams returns true if fn (or its receiver) takes channel parameters.
+func HasChanParams(fn *ssa.Function) bool {
+	sig := fn.Signature
+	if recv := sig.Recv(); recv != nil {
+		if _, ok := recv.Type().Underlying().(*types.Chan); ok {
+			return true
+		}
+	}
+	for i := 0; i < sig.Params().Len(); i++ {
+		if _, ok := sig.Params().At(i).Type().Underlying().(*types.Chan); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package ssabuilder
+
+import (
+	"go/types"
+	"testing"
+)
+
+// TestSetPolicy checks that policies given by name or import path replace the
+// default policies.
+func TestSetPolicy(t *testing.T) {
+	mathRand, cryptoRand := types.NewPackage("math/rand", "rand"), types.NewPackage("crypto/rand", "rand")
+	for _, tc := range []struct {
+		pkg    string
+		policy Policy
+		math   Policy // Policy of math/rand.
+		crypto Policy // Policy of crypto/rand.
+	}{
+		{"", Analyse, Skip, Skip}, // Default policies.
+		{"math/rand", Analyse, Analyse, Skip},
+		{"rand", Analyse, Analyse, Analyse},
+		{"math/rand", Pure, Pure, Skip},
+		{"rand", Opaque, Opaque, Opaque},
+	} {
+		conf, err := NewConfigFromString("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.pkg != "" {
+			conf.SetPolicy(tc.pkg, tc.policy, "")
+		}
+		if p, _ := conf.PackagePolicy(mathRand); p != tc.math {
+			t.Errorf("%s %s: expecting math/rand %s but got %s", tc.pkg, tc.policy, tc.math, p)
+		}
+		if p, _ := conf.PackagePolicy(cryptoRand); p != tc.crypto {
+			t.Errorf("%s %s: expecting crypto/rand %s but got %s", tc.pkg, tc.policy, tc.crypto, p)
+		}
+	}
+}
+
+// TestPackagePolicyName checks that default policies apply to import paths
+// only, and policies set by name to any package of that name.
+func TestPackagePolicyName(t *testing.T) {
+	std, own := types.NewPackage("context", "context"), types.NewPackage("example.com/m/context", "context")
+	conf, err := NewConfigFromString("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := conf.PackagePolicy(std); p != Skip {
+		t.Errorf("expecting context %s but got %s", Skip, p)
+	}
+	if p, _ := conf.PackagePolicy(own); p != Analyse {
+		t.Errorf("expecting example.com/m/context %s but got %s", Analyse, p)
+	}
+	conf.SetPolicy("context", Pure, "")
+	if p, _ := conf.PackagePolicy(own); p != Pure {
+		t.Errorf("expecting example.com/m/context %s by name but got %s", Pure, p)
+	}
+}
@@ -9,8 +9,11 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"sync"
 
+	gocallgraph "golang.org/x/tools/go/callgraph"
 	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/packages"
 	"golang.org/x/tools/go/pointer"
 	"golang.org/x/tools/go/ssa"
 	"golang.org/x/tools/go/ssa/ssautil"
@@ -27,6 +30,10 @@ const (
 
 	// FromString is option to use a string as body of initial package.
 	FromString
+
+	// FromPackages is option to use a list of (module-aware) package patterns
+	// for initial packages, e.g. "./cmd/server/..." or an import path.
+	FromPackages
 )
 
 // Config holds the configuration for building SSA IR.
@@ -34,37 +41,74 @@ type Config struct {
 	BuildMode Mode
 	Files     []string          // (Initial) files to load.
 	Source    string            // Source code.
+	Patterns  []string          // (Initial) package patterns to load.
+	Dir       string            // Directory to resolve package patterns in.
+	Tests     bool              // Load _test.go files of initial packages.
 	BuildLog  io.Writer         // Build log.
 	PtaLog    io.Writer         // Pointer analysis log.
 	LogFlags  int               // Flags for build/pta log.
 	BadPkgs   map[string]string // Packages not to load (with reasons).
+	Dispatch  Dispatch          // Resolution of dynamic calls (default static).
+
+	// OpaquePkgs and PurePkgs are packages loaded but not analysed (with
+	// reasons), see Policy. Packages (including BadPkgs) are given by import
+	// path, or by name if set by SetPolicy.
+	OpaquePkgs map[string]string
+	PurePkgs   map[string]string
+
+	names map[string]bool // Packages given by name (see SetPolicy).
 }
 
 // SSAInfo is the SSA IR + metainfo built from a given Config.
 type SSAInfo struct {
 	BuildConf   *Config  // Build configuration (initial files, logs).
-	IgnoredPkgs []string // Packages not loaded (respects BuildConf.BadPkgs).
+	IgnoredPkgs []string // Import paths of packages not loaded (see BuildConf.BadPkgs).
 
-	FSet    *token.FileSet  // FileSet for parsed source files.
-	Prog    *ssa.Program    // SSA IR for whole program.
-	PtaConf *pointer.Config // Pointer analysis config.
+	FSet        *token.FileSet  // FileSet for parsed source files.
+	Prog        *ssa.Program    // SSA IR for whole program.
+	InitialPkgs []*ssa.Package  // SSA packages of the initial files/patterns.
+	PtaConf     *pointer.Config // Pointer analysis config.
 
 	Logger *log.Logger // Build logger.
+
+	dispatchOnce sync.Once
+	dispatch     *gocallgraph.Graph // Call graph resolving dynamic calls.
+	ptaOnce      sync.Once
+	aliases      *aliases // Cached pointer analysis of channels.
 }
 
 var (
-	// Packages that should not be loaded (and reasons) by default
+	// Packages that should not be loaded (and reasons) by default, given by
+	// import path.
 	badPkgs = map[string]string{
-		"fmt":     "Recursive calls unrelated to communication",
-		"reflect": "Reflection not supported for static analyser",
-		"runtime": "Runtime contains threads that are not user related",
-		"strings": "Strings function does not have communication",
-		"sync":    "Atomics confuse analyser",
-		"time":    "Time not supported",
-		"rand":    "Math does not use channels",
+		"context":     "Context is modelled by the analyser",
+		"fmt":         "Recursive calls unrelated to communication",
+		"reflect":     "Reflection not supported for static analyser",
+		"runtime":     "Runtime contains threads that are not user related",
+		"strings":     "Strings function does not have communication",
+		"sync":        "Atomics confuse analyser",
+		"time":        "Time not supported",
+		"math/rand":   "Math does not use channels",
+		"crypto/rand": "Crypto does not use channels",
+
+		// Modelled by the standard stub specifications (see stubspec.Std).
+		"net/http": "Servers are modelled by stub specifications",
+
+		// Notify is modelled by the analyser as a process sending signals.
+		"os/signal": "Signals are modelled by the analyser",
 	}
 )
 
+// defaultBadPkgs returns a copy of the packages not to load by default, so that
+// configurations do not share (and modify) the same map.
+func defaultBadPkgs() map[string]string {
+	pkgs := make(map[string]string, len(badPkgs))
+	for pkg, reason := range badPkgs {
+		pkgs[pkg] = reason
+	}
+	return pkgs
+}
+
 // NewConfig creates a new default build configuration.
 func NewConfig(files []string) (*Config, error) {
 
@@ -77,7 +121,7 @@ func NewConfig(files []string) (*Config, error) {
 		BuildLog:  ioutil.Discard,
 		PtaLog:    ioutil.Discard,
 		LogFlags:  log.LstdFlags,
-		BadPkgs:   badPkgs,
+		BadPkgs:   defaultBadPkgs(),
 	}, nil
 }
 
@@ -89,56 +133,63 @@ func NewConfigFromString(s string) (*Config, error) {
 		BuildLog:  ioutil.Discard,
 		PtaLog:    ioutil.Discard,
 		LogFlags:  log.LstdFlags,
-		BadPkgs:   badPkgs,
+		BadPkgs:   defaultBadPkgs(),
+	}, nil
+}
+
+// NewConfigFromPackages creates a new default build configuration which loads
+// the packages matching the given patterns using the go command, so modules are
+// supported.
+func NewConfigFromPackages(patterns []string) (*Config, error) {
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("no packages specified for analysis")
+	}
+	return &Config{
+		BuildMode: FromPackages,
+		Patterns:  patterns,
+		BuildLog:  ioutil.Discard,
+		PtaLog:    ioutil.Discard,
+		LogFlags:  log.LstdFlags,
+		BadPkgs:   defaultBadPkgs(),
 	}, nil
 }
 
 // Build constructs the SSA IR using given config, and sets up pointer analysis.
 func (conf *Config) Build() (*SSAInfo, error) {
-	var lconf = loader.Config{Build: &build.Default}
 	buildLog := log.New(conf.BuildLog, "ssabuild: ", conf.LogFlags)
 
-	if conf.BuildMode == FromFiles {
-		args, err := lconf.FromArgs(conf.Files, false /* No tests */)
-		if err != nil {
-			return nil, err
-		}
-		if len(args) > 0 {
-			return nil, fmt.Errorf("surplus arguments: %q", args)
-		}
-	} else if conf.BuildMode == FromString {
-		f, err := lconf.ParseFile("", conf.Source)
-		if err != nil {
-			return nil, err
-		}
-		lconf.CreateFromFiles("", f)
-	} else {
-		buildLog.Fatal("Unknown build mode")
-
+	var (
+		fset    *token.FileSet
+		prog    *ssa.Program
+		initial []*ssa.Package
+		err     error
+	)
+	switch conf.BuildMode {
+	case FromFiles, FromString:
+		fset, prog, initial, err = conf.loadProgram()
+	case FromPackages:
+		fset, prog, initial, err = conf.loadPackages()
+	default:
+		err = fmt.Errorf("unknown build mode %d", conf.BuildMode)
 	}
-
-	// Load, parse and type-check program
-	lprog, err := lconf.Load()
 	if err != nil {
 		return nil, err
 	}
 	buildLog.Print("Program loaded and type checked")
 
-	prog := ssautil.CreateProgram(lprog, ssa.GlobalDebug|ssa.BareInits)
-
 	// Prepare Config for whole-program pointer analysis.
-	ptaConf, err := setupPTA(prog, lprog, conf.PtaLog)
+	ptaConf, err := setupPTA(prog, initial, conf.PtaLog)
 
 	ignoredPkgs := []string{}
 	if len(conf.BadPkgs) == 0 {
 		prog.Build()
 	} else {
-		for _, info := range lprog.AllPackages {
-			if reason, badPkg := conf.BadPkgs[info.Pkg.Name()]; badPkg {
-				buildLog.Printf("Skip package: %s (%s)", info.Pkg.Name(), reason)
-				ignoredPkgs = append(ignoredPkgs, info.Pkg.Name())
+		for _, pkg := range prog.AllPackages() {
+			if p, reason := conf.PackagePolicy(pkg.Pkg); p == Skip {
+				buildLog.Printf("Skip package: %s (%s)", pkg.Pkg.Path(), reason)
+				ignoredPkgs = append(ignoredPkgs, pkg.Pkg.Path())
 			} else {
-				prog.Package(info.Pkg).Build()
+				pkg.Build()
 			}
 		}
 	}
@@ -146,24 +197,90 @@ func (conf *Config) Build() (*SSAInfo, error) {
 	return &SSAInfo{
 		BuildConf:   conf,
 		IgnoredPkgs: ignoredPkgs,
-		FSet:        lprog.Fset,
+		FSet:        fset,
 		Prog:        prog,
+		InitialPkgs: initial,
 		PtaConf:     ptaConf,
 		Logger:      buildLog,
 	}, nil
 }
 
+// loadProgram loads the program from files or string with go/loader.
+func (conf *Config) loadProgram() (*token.FileSet, *ssa.Program, []*ssa.Package, error) {
+	var lconf = loader.Config{Build: &build.Default}
+
+	if conf.BuildMode == FromFiles {
+		args, err := lconf.FromArgs(conf.Files, conf.Tests)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if len(args) > 0 {
+			return nil, nil, nil, fmt.Errorf("surplus arguments: %q", args)
+		}
+	} else {
+		f, err := lconf.ParseFile("", conf.Source)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		lconf.CreateFromFiles("", f)
+	}
+
+	// Load, parse and type-check program
+	lprog, err := lconf.Load()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	prog := ssautil.CreateProgram(lprog, ssa.GlobalDebug|ssa.BareInits)
+	var initial []*ssa.Package
+	for _, info := range lprog.InitialPackages() {
+		initial = append(initial, prog.Package(info.Pkg))
+	}
+	return lprog.Fset, prog, initial, nil
+}
+
+// loadPackages loads the program from package patterns with go/packages.
+func (conf *Config) loadPackages() (*token.FileSet, *ssa.Program, []*ssa.Package, error) {
+	pconf := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedImports |
+			packages.NeedDeps | packages.NeedTypes | packages.NeedSyntax |
+			packages.NeedTypesInfo | packages.NeedTypesSizes,
+		Dir:   conf.Dir,
+		Tests: conf.Tests,
+	}
+	pkgs, err := packages.Load(pconf, conf.Patterns...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(pkgs) == 0 {
+		return nil, nil, nil, fmt.Errorf("no packages matching %q", conf.Patterns)
+	}
+	var errs []error
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		for _, err := range pkg.Errors {
+			errs = append(errs, err)
+		}
+	})
+	if len(errs) > 0 {
+		return nil, nil, nil, fmt.Errorf("cannot load packages: %v (and %d more errors)", errs[0], len(errs)-1)
+	}
+	prog, initial := ssautil.AllPackages(pkgs, ssa.GlobalDebug|ssa.BareInits)
+	return prog.Fset, prog, initial, nil
+}
+
 // CallGraph builds the call graph from the 'main.main' function.
 //
 // The call graph is rooted at 'main.main', all nodes appear only once in the
-// graph. A side-effect of building the call graph is obtaining a list of
-// functions used in a program (as functions not called will not appear in the
-// CallGraph).
+// graph. Dynamic calls are resolved by the Dispatch of the configuration. A
+// side-effect of building the call graph is obtaining a list of functions used
+// in a program (as functions not called will not appear in the CallGraph).
 // TODO(nickng) cache previously built CallGraph.
 func (info *SSAInfo) CallGraph() *callgraph.Node {
 	mainPkg := MainPkg(info.Prog)
+	if mainPkg == nil {
+		return nil
+	}
 	if mainFunc := mainPkg.Func("main"); mainFunc != nil {
-		return callgraph.Build(mainFunc)
+		return callgraph.Build(mainFunc, info.Callees)
 	}
 	return nil // No main pkg --> nothing is called
 }
@@ -177,37 +294,14 @@ func (info *SSAInfo) DecodePos(pos token.Pos) token.Position {
 
 // NewPta performs a custom pointer analysis on given values.
 func (info *SSAInfo) NewPta(vals ...ssa.Value) *pointer.Result {
+	conf := *info.PtaConf // Queries are not added to the shared PtaConf.
+	conf.Queries, conf.IndirectQueries = nil, nil
 	for _, val := range vals {
-		info.PtaConf.AddQuery(val)
+		conf.AddQuery(val)
 	}
-	result, err := pointer.Analyze(info.PtaConf)
+	result, err := pointer.Analyze(&conf)
 	if err != nil {
 		info.Logger.Print("NewPta:", ErrPtaInternal)
 	}
 	return result
 }
-
-// FindChan performs a ptr analysis on a given chan ssa.Value, returns a list of
-// related ChanOp on the chan.
-func (info *SSAInfo) FindChan(ch ssa.Value) []ChanOp {
-	chanOps := purgeChanOps(progChanOps(info.Prog), ch)
-	for _, op := range chanOps {
-		info.PtaConf.AddQuery(op.Value)
-	}
-	result, err := pointer.Analyze(info.PtaConf)
-	if err != nil {
-		info.Logger.Print("FindChan failed:", ErrPtaInternal)
-	}
-	queryCh := result.Queries[ch]
-	var ops []ChanOp
-	for _, label := range queryCh.PointsTo().Labels() {
-		// Add MakeChan to result
-		ops = append(ops, ChanOp{label.Value(), ChanMake, label.Pos()})
-	}
-	for _, op := range chanOps {
-		if ptr, ok := result.Queries[op.Value]; ok && ptr.MayAlias(queryCh) {
-			ops = append(ops, op)
-		}
-	}
-	return ops
-}
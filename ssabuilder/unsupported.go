@@ -0,0 +1,55 @@
+package ssabuilder
+
+import (
+	"fmt"
+	"go/token"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// UnsupportedError is the error of an SSA construct which cannot be analysed
+// by the extractors. The offending function (or goroutine) is skipped, and the
+// analysis continues with a partial model.
+type UnsupportedError struct {
+	Func   *ssa.Function   // Function skipped.
+	Instr  ssa.Instruction // Offending instruction, nil if unknown.
+	Pos    token.Position  // Source position.
+	Reason string
+}
+
+// NewUnsupportedError returns an UnsupportedError of instr in fn (which
+// defaults to the parent function of instr).
+func NewUnsupportedError(fn *ssa.Function, instr ssa.Instruction, format string, args ...interface{}) *UnsupportedError {
+	if fn == nil && instr != nil {
+		fn = instr.Parent()
+	}
+	e := &UnsupportedError{Instr: instr, Reason: fmt.Sprintf(format, args...)}
+	e.At(fn)
+	return e
+}
+
+// At sets the function of e to fn if not known, and locates e in the source.
+func (e *UnsupportedError) At(fn *ssa.Function) {
+	if e.Func == nil {
+		e.Func = fn
+	}
+	if e.Func == nil || e.Pos.IsValid() {
+		return
+	}
+	pos := e.Func.Pos()
+	if e.Instr != nil && e.Instr.Pos().IsValid() {
+		pos = e.Instr.Pos()
+	}
+	e.Pos = e.Func.Prog.Fset.Position(pos)
+}
+
+func (e *UnsupportedError) Error() string {
+	var buf []byte
+	if e.Pos.IsValid() {
+		buf = append(buf, e.Pos.String()+": "...)
+	}
+	if e.Func != nil {
+		buf = append(buf, e.Func.String()+": "...)
+	}
+	return string(append(buf, "unsupported: "+e.Reason...))
+}
@@ -1,6 +1,13 @@
 package ssabuilder
 
 import (
+	"fmt"
+	"go/types"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
 	"golang.org/x/tools/go/ssa"
 )
 
@@ -14,3 +21,154 @@ func MainPkg(prog *ssa.Program) *ssa.Package {
 	}
 	return nil // Not found
 }
+
+// ExportedFuncs returns the exported functions and methods of the initial
+// packages, sorted by name.
+//
+// These are the default analysis roots of a package without main function.
+func (info *SSAInfo) ExportedFuncs() []*ssa.Function {
+	var fns []*ssa.Function
+	for _, fn := range info.initialFuncs() {
+		if fn.Object() != nil && fn.Object().Exported() {
+			if recv := fn.Signature.Recv(); recv != nil {
+				if named, ok := derefNamed(recv.Type()); !ok || !named.Obj().Exported() {
+					continue // Method of unexported type.
+				}
+			}
+			fns = append(fns, fn)
+		}
+	}
+	return fns
+}
+
+// FindFunc looks up a function or method in the initial packages by name.
+//
+// The name can be fully qualified (e.g. "(*example.com/pkg.T).Run"), or
+// qualified by the package name (e.g. "pkg.Func" or "pkg.(*T).Run").
+func (info *SSAInfo) FindFunc(name string) *ssa.Function {
+	for _, fn := range info.initialFuncs() {
+		rel := fn.RelString(fn.Pkg.Pkg)
+		if name == fn.String() || name == fn.Pkg.Pkg.Name()+"."+rel {
+			return fn
+		}
+	}
+	return nil
+}
+
+// TestFuncs returns the test functions (func TestXxx(*testing.T)) and examples
+// (func ExampleXxx()) of the initial packages, sorted by name.
+//
+// The build configuration must have Tests set for the _test.go files to be
+// loaded.
+func (info *SSAInfo) TestFuncs() []*ssa.Function {
+	var fns []*ssa.Function
+	for _, fn := range info.initialFuncs() {
+		if fn.Signature.Recv() != nil || fn.Signature.Results().Len() > 0 {
+			continue
+		}
+		params := fn.Signature.Params()
+		switch {
+		case isTestName(fn.Name(), "Test") && params.Len() == 1 && isTestingT(params.At(0).Type()):
+			fns = append(fns, fn)
+		case isTestName(fn.Name(), "Example") && params.Len() == 0:
+			fns = append(fns, fn)
+		}
+	}
+	return fns
+}
+
+// Roots returns the analysis roots selected by name, the exported functions
+// (if lib) and the test functions (if tests and no names given), each once, or
+// nil if main.main should be used.
+func (info *SSAInfo) Roots(names []string, lib, tests bool) ([]*ssa.Function, error) {
+	var roots []*ssa.Function
+	seen := make(map[*ssa.Function]bool)
+	add := func(fns ...*ssa.Function) {
+		for _, fn := range fns {
+			if !seen[fn] {
+				seen[fn] = true
+				roots = append(roots, fn)
+			}
+		}
+	}
+	if tests && len(names) == 0 {
+		fns := info.TestFuncs()
+		if len(fns) == 0 {
+			return nil, fmt.Errorf("no test or example functions found")
+		}
+		add(fns...)
+	}
+	if lib {
+		fns := info.ExportedFuncs()
+		if len(fns) == 0 {
+			return nil, fmt.Errorf("no exported functions found")
+		}
+		add(fns...)
+	}
+	for _, name := range names {
+		fn := info.FindFunc(name)
+		if fn == nil {
+			return nil, fmt.Errorf("root function %s not found", name)
+		}
+		add(fn)
+	}
+	return roots, nil
+}
+
+// isTestName checks if name is a test/example name with the given prefix,
+// i.e. prefix is not followed by a lower case letter (same rule as go test).
+func isTestName(name, prefix string) bool {
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	if len(name) == len(prefix) {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(name[len(prefix):])
+	return !unicode.IsLower(r)
+}
+
+// isTestingT checks if t is *testing.T.
+func isTestingT(t types.Type) bool {
+	named, ok := derefNamed(t)
+	if !ok || named.Obj().Pkg() == nil {
+		return false
+	}
+	_, isPtr := t.(*types.Pointer)
+	return isPtr && named.Obj().Pkg().Path() == "testing" && named.Obj().Name() == "T"
+}
+
+// initialFuncs returns functions and methods (with body) declared in the
+// initial packages, sorted by name.
+func (info *SSAInfo) initialFuncs() []*ssa.Function {
+	var fns []*ssa.Function
+	for _, pkg := range info.InitialPkgs {
+		for _, memb := range pkg.Members {
+			switch memb := memb.(type) {
+			case *ssa.Function:
+				if memb.Blocks != nil && memb.Name() != "init" {
+					fns = append(fns, memb)
+				}
+			case *ssa.Type:
+				mset := info.Prog.MethodSets.MethodSet(types.NewPointer(memb.Type()))
+				for i := 0; i < mset.Len(); i++ {
+					fn := info.Prog.MethodValue(mset.At(i))
+					if fn != nil && fn.Blocks != nil && fn.Pkg == pkg && fn.Synthetic == "" {
+						fns = append(fns, fn)
+					}
+				}
+			}
+		}
+	}
+	sort.Slice(fns, func(i, j int) bool { return fns[i].String() < fns[j].String() })
+	return fns
+}
+
+// derefNamed returns the named type of t or *t.
+func derefNamed(t types.Type) (*types.Named, bool) {
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	named, ok := t.(*types.Named)
+	return named, ok
+}
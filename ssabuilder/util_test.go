@@ -0,0 +1,84 @@
+package ssabuilder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRoots checks the roots selected by name and exported functions, which
+// are each used once.
+func TestRoots(t *testing.T) {
+	const src = `package main
+
+func Send(ch chan int) { ch <- 1 }
+
+func recv(ch chan int) { <-ch }
+
+func main() {}
+`
+	conf, err := NewConfigFromString(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := conf.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots, err := info.Roots([]string{"main.Send", "main.recv"}, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"main.Send", "main.recv"}
+	if len(roots) != len(want) {
+		t.Fatalf("expecting roots %v but got %v", want, roots)
+	}
+	for i, fn := range roots {
+		if fn.String() != want[i] {
+			t.Errorf("expecting root %d to be %s but got %s", i, want[i], fn)
+		}
+	}
+	if _, err := info.Roots([]string{"main.send"}, false, false); err == nil {
+		t.Error("expecting error for unknown root function")
+	}
+}
+
+// TestRootsTests checks that the test roots are kept when the exported
+// functions are also roots.
+func TestRootsTests(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"lib.go":      "package lib\n\nfunc Send(ch chan int) { ch <- 1 }\n",
+		"lib_test.go": "package lib\n\nimport \"testing\"\n\nfunc TestSend(t *testing.T) { Send(make(chan int, 1)) }\n",
+	}
+	var paths []string
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	conf, err := NewConfig(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Tests = true
+	info, err := conf.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots, err := info.Roots(nil, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"lib.TestSend", "lib.Send"}
+	if len(roots) != len(want) {
+		t.Fatalf("expecting roots %v but got %v", want, roots)
+	}
+	for i, fn := range roots {
+		if fn.String() != want[i] {
+			t.Errorf("expecting root %d to be %s but got %s", i, want[i], fn)
+		}
+	}
+}
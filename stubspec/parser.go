@@ -0,0 +1,385 @@
+package stubspec
+
+// Parser of stub specifications.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/damifur/migo"
+)
+
+// def is a parsed definition, copied to a migo.Function when used.
+type def struct {
+	name   string
+	params []string
+	body   []*stmt
+}
+
+// stmt is a parsed statement.
+type stmt struct {
+	op    string   // Keyword of the statement, e.g. "send".
+	name  string   // Channel or definition name.
+	args  []string // Arguments of call or spawn.
+	size  int64    // Buffer size of newchan.
+	label string   // Channel name of newchan.
+	cases [][]*stmt
+}
+
+// name is a channel or parameter name.
+type name string
+
+func (n name) Name() string   { return string(n) }
+func (n name) String() string { return string(n) }
+
+// function returns a new migo.Function of d.
+func (d *def) function() *migo.Function {
+	fn := migo.NewFunction(d.name)
+	for _, p := range d.params {
+		fn.AddParams(&migo.Parameter{Caller: name(p), Callee: name(p)})
+	}
+	fn.AddStmts(statements(d.body)...)
+	return fn
+}
+
+// callees returns the definitions called or spawned by d, in order.
+func (d *def) callees() []string {
+	var names []string
+	var visit func([]*stmt)
+	visit = func(body []*stmt) {
+		for _, s := range body {
+			switch s.op {
+			case "call", "spawn":
+				names = append(names, s.name)
+			case "if", "select":
+				for _, c := range s.cases {
+					visit(c)
+				}
+			}
+		}
+	}
+	visit(d.body)
+	return names
+}
+
+// statements returns new MiGo statements of body, which are at line 0 (as
+// model statements).
+func statements(body []*stmt) []migo.Statement {
+	const line = "0"
+	stmts := make([]migo.Statement, 0, len(body))
+	for _, s := range body {
+		switch s.op {
+		case "send":
+			stmts = append(stmts, &migo.SendStatement{Chan: s.name, LineNum: line})
+		case "recv":
+			stmts = append(stmts, &migo.RecvStatement{Chan: s.name, LineNum: line})
+		case "close":
+			stmts = append(stmts, &migo.CloseStatement{Chan: s.name, LineNum: line})
+		case "tau":
+			stmts = append(stmts, &migo.TauStatement{LineNum: line})
+		case "call":
+			call := &migo.CallStatement{Name: s.name, LineNum: line}
+			for _, a := range s.args {
+				call.AddParams(&migo.Parameter{Caller: name(a), Callee: name(a)})
+			}
+			stmts = append(stmts, call)
+		case "spawn":
+			spawn := &migo.SpawnStatement{Name: s.name, LineNum: line}
+			for _, a := range s.args {
+				spawn.AddParams(&migo.Parameter{Caller: name(a), Callee: name(a)})
+			}
+			stmts = append(stmts, spawn)
+		case "let":
+			stmts = append(stmts, &migo.NewChanStatement{Name: name(s.name), Chan: s.label, Size: s.size, LineNum: line})
+		case "if":
+			stmts = append(stmts, &migo.IfStatement{Then: statements(s.cases[0]), Else: statements(s.cases[1]), LineNum: line})
+		case "select":
+			sel := &migo.SelectStatement{LineNum: line}
+			for _, c := range s.cases {
+				sel.Cases = append(sel.Cases, statements(c))
+			}
+			stmts = append(stmts, sel)
+		}
+	}
+	return stmts
+}
+
+// token is a lexical token: a punctuation (one of "(),;=:"), a string (with
+// quotes) or a word (keyword, name or number).
+type token struct {
+	text string
+	line int
+}
+
+type lexer struct {
+	tokens []token
+	err    error
+}
+
+// newLexer splits src into tokens.
+func newLexer(src string) *lexer {
+	l := new(lexer)
+	for i, line := range strings.Split(src, "\n") {
+		for line = strings.TrimSpace(line); line != ""; line = strings.TrimSpace(line) {
+			switch c := line[0]; {
+			case strings.HasPrefix(line, "--"):
+				line = ""
+			case strings.IndexByte("(),;=:", c) >= 0:
+				l.tokens = append(l.tokens, token{line[:1], i + 1})
+				line = line[1:]
+			case c == '"':
+				s, err := strconv.QuotedPrefix(line)
+				if err != nil {
+					l.err = fmt.Errorf("%d: unterminated string", i+1)
+					return l
+				}
+				l.tokens = append(l.tokens, token{s, i + 1})
+				line = line[len(s):]
+			default:
+				end := strings.IndexFunc(line, func(r rune) bool {
+					return unicode.IsSpace(r) || strings.ContainsRune("(),;=:\"", r)
+				})
+				if end < 0 {
+					end = len(line)
+				}
+				l.tokens = append(l.tokens, token{line[:end], i + 1})
+				line = line[end:]
+			}
+		}
+	}
+	return l
+}
+
+type parser struct {
+	lex  *lexer
+	file string
+	pos  int
+}
+
+// errorf returns an error at the current token.
+func (p *parser) errorf(format string, args ...interface{}) error {
+	line := 0
+	if p.pos < len(p.lex.tokens) {
+		line = p.lex.tokens[p.pos].line
+	} else if n := len(p.lex.tokens); n > 0 {
+		line = p.lex.tokens[n-1].line
+	}
+	return fmt.Errorf("%s:%d: %s", p.file, line, fmt.Sprintf(format, args...))
+}
+
+// peek returns the current token, or "" at the end.
+func (p *parser) peek() string {
+	if p.pos < len(p.lex.tokens) {
+		return p.lex.tokens[p.pos].text
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// accept skips the current token if it is t.
+func (p *parser) accept(t string) bool {
+	if p.peek() == t {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(t string) error {
+	if !p.accept(t) {
+		return p.errorf("expecting %q, found %q", t, p.peek())
+	}
+	return nil
+}
+
+// ident returns the current token if it is a name.
+func (p *parser) ident() (string, error) {
+	t := p.peek()
+	if t == "" || strings.ContainsAny(t[:1], "(),;=:\"") || keywords[t] {
+		return "", p.errorf("expecting name, found %q", t)
+	}
+	p.pos++
+	return t, nil
+}
+
+var keywords = map[string]bool{
+	"stub": true, "def": true, "send": true, "recv": true, "close": true,
+	"tau": true, "call": true, "spawn": true, "let": true, "newchan": true,
+	"if": true, "else": true, "endif": true, "select": true, "case": true,
+	"endselect": true,
+}
+
+// parse parses stub declarations and definitions.
+func (p *parser) parse() (map[string]string, []*def, error) {
+	if p.lex.err != nil {
+		return nil, nil, fmt.Errorf("%s:%v", p.file, p.lex.err)
+	}
+	stubs := make(map[string]string)
+	var defs []*def
+	for p.peek() != "" {
+		switch p.next() {
+		case "stub":
+			t := p.next()
+			fn, err := strconv.Unquote(t)
+			if err != nil || !strings.HasPrefix(t, "\"") {
+				p.pos--
+				return nil, nil, p.errorf("expecting quoted Go function, found %q", t)
+			}
+			if err := p.expect("="); err != nil {
+				return nil, nil, err
+			}
+			name, err := p.ident()
+			if err != nil {
+				return nil, nil, err
+			}
+			stubs[fn] = name
+			p.accept(";")
+		case "def":
+			d, err := p.def()
+			if err != nil {
+				return nil, nil, err
+			}
+			defs = append(defs, d)
+		default:
+			p.pos--
+			return nil, nil, p.errorf("expecting stub or def, found %q", p.peek())
+		}
+	}
+	return stubs, defs, nil
+}
+
+// def parses a definition (after def).
+func (p *parser) def() (*def, error) {
+	var (
+		d   = new(def)
+		err error
+	)
+	if d.name, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if d.params, err = p.args(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	d.body, err = p.stmts()
+	return d, err
+}
+
+// args parses a parenthesised list of names.
+func (p *parser) args() ([]string, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []string
+	for !p.accept(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// stmts parses statements until the end of a definition or branch.
+func (p *parser) stmts() ([]*stmt, error) {
+	var body []*stmt
+	for {
+		switch p.peek() {
+		case "", "stub", "def", "else", "endif", "case", "endselect":
+			return body, nil
+		}
+		s, err := p.stmt()
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, s)
+		p.accept(";")
+	}
+}
+
+func (p *parser) stmt() (*stmt, error) {
+	var err error
+	s := &stmt{op: p.next()}
+	switch s.op {
+	case "send", "recv", "close":
+		s.name, err = p.ident()
+	case "tau":
+	case "call", "spawn":
+		if s.name, err = p.ident(); err == nil {
+			s.args, err = p.args()
+		}
+	case "let":
+		if s.name, err = p.ident(); err != nil {
+			return nil, err
+		}
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		if err := p.expect("newchan"); err != nil {
+			return nil, err
+		}
+		if s.label, err = p.ident(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		t := p.next()
+		if s.size, err = strconv.ParseInt(t, 10, 64); err != nil || s.size < 0 {
+			p.pos--
+			return nil, p.errorf("expecting buffer size, found %q", t)
+		}
+	case "if":
+		then, err := p.stmts()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("else"); err != nil {
+			return nil, err
+		}
+		els, err := p.stmts()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("endif"); err != nil {
+			return nil, err
+		}
+		s.cases = [][]*stmt{then, els}
+	case "select":
+		for p.accept("case") {
+			c, err := p.stmts()
+			if err != nil {
+				return nil, err
+			}
+			s.cases = append(s.cases, c)
+		}
+		if len(s.cases) == 0 {
+			return nil, p.errorf("expecting case, found %q", p.peek())
+		}
+		if err := p.expect("endselect"); err != nil {
+			return nil, err
+		}
+	default:
+		p.pos--
+		return nil, p.errorf("expecting statement, found %q", s.op)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
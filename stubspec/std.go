@@ -0,0 +1,20 @@
+package stubspec
+
+// Std is the standard stub specifications, loaded before the specifications of
+// users (see Load).
+//
+// The servers of net/http (skipped by default) block until they fail or are
+// shut down, so the goroutine serving is never terminated early.
+const Std = `-- net/http servers.
+stub "net/http.ListenAndServe" = http.serve;
+stub "net/http.ListenAndServeTLS" = http.serve;
+stub "net/http.Serve" = http.serve;
+stub "net/http.ServeTLS" = http.serve;
+stub "(*net/http.Server).ListenAndServe" = http.serve;
+stub "(*net/http.Server).ListenAndServeTLS" = http.serve;
+stub "(*net/http.Server).Serve" = http.serve;
+stub "(*net/http.Server).ServeTLS" = http.serve;
+
+-- Serving stops (and returns an error) at any time, or never.
+def http.serve(): if tau; else call http.serve(); endif;
+`
@@ -0,0 +1,191 @@
+// Package stubspec provides behavioural stub specifications of functions
+// which are not analysed, e.g. functions without body or functions of skipped
+// packages (see ssabuilder.Policy).
+//
+// A specification is written in MiGo, with stub declarations mapping Go
+// functions (named as by ssa.Function.String) to MiGo definitions, e.g.
+//
+//	-- Serve blocks until the server fails or is closed.
+//	stub "(*net/http.Server).Serve" = http.serve;
+//	def http.serve(): if tau; else call http.serve(); endif;
+//
+// A definition named after a Go function (e.g. def example.com/lib.Wait(c):)
+// is the stub of that function without declaration. The parameters of a stub
+// are the channel arguments of a call of the Go function (the receiver first),
+// in order. Comments start with -- and end at the end of the line.
+//
+// The statements are the statements of MiGo:
+//
+//	send c; recv c; close c; tau;
+//	call f(c, ...); spawn f(c, ...);
+//	let c = newchan name, size;
+//	if stmts else stmts endif;
+//	select case stmts case stmts ... endselect;
+package stubspec // import "github.com/damifur/dingo-hunter/stubspec"
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/damifur/migo"
+)
+
+// Ext is the file extension of stub specification files in directories.
+const Ext = ".migo"
+
+// Spec is a set of stub specifications.
+type Spec struct {
+	stubs map[string]string // Definitions of Go functions.
+	defs  map[string]*def   // Definitions by name.
+	hash  []string          // Sources parsed.
+}
+
+// New returns an empty set of stub specifications.
+func New() *Spec {
+	return &Spec{
+		stubs: make(map[string]string),
+		defs:  make(map[string]*def),
+	}
+}
+
+// Load returns the standard stub specifications (see Std) updated by the
+// specifications of paths, which are files or directories of files ending in
+// Ext.
+func Load(paths ...string) (*Spec, error) {
+	s := New()
+	if err := s.Parse(strings.NewReader(Std), "std"); err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		files := []string{path}
+		if info.IsDir() {
+			if files, err = filepath.Glob(filepath.Join(path, "*"+Ext)); err != nil {
+				return nil, err
+			}
+			sort.Strings(files)
+		}
+		for _, file := range files {
+			f, err := os.Open(file)
+			if err != nil {
+				return nil, err
+			}
+			err = s.Parse(f, file)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// Parse parses the specifications of r (named name in errors) into s. Stubs
+// and definitions replace those of s with the same names.
+func (s *Spec) Parse(r io.Reader, name string) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	p := &parser{lex: newLexer(string(b)), file: name}
+	stubs, defs, err := p.parse()
+	if err != nil {
+		return err
+	}
+	for fn, d := range stubs {
+		s.stubs[fn] = d
+	}
+	for _, d := range defs {
+		s.defs[d.name] = d
+	}
+	s.hash = append(s.hash, string(b))
+	return nil
+}
+
+// Lookup returns the name of the stub definition of Go function fn, and false
+// if fn has no stub.
+func (s *Spec) Lookup(fn string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	if name, ok := s.stubs[fn]; ok {
+		_, defined := s.defs[name]
+		return name, defined
+	}
+	_, ok := s.defs[fn]
+	return fn, ok
+}
+
+// Defs returns new copies of definition name and of the definitions it calls
+// or spawns (transitively), in order of first use. Definitions used but not
+// defined are reported as errors.
+func (s *Spec) Defs(name string) ([]*migo.Function, error) {
+	var (
+		fns  []*migo.Function
+		seen = make(map[string]bool)
+		use  func(name string) error
+	)
+	use = func(name string) error {
+		if seen[name] {
+			return nil
+		}
+		seen[name] = true
+		d, ok := s.defs[name]
+		if !ok {
+			return fmt.Errorf("stub definition %s not defined", name)
+		}
+		fns = append(fns, d.function())
+		for _, callee := range d.callees() {
+			if err := use(callee); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := use(name); err != nil {
+		return nil, err
+	}
+	return fns, nil
+}
+
+// Hash returns a hash of the specifications parsed into s.
+func (s *Spec) Hash() string {
+	if s == nil {
+		return ""
+	}
+	h := sha256.New()
+	for _, src := range s.hash {
+		fmt.Fprintf(h, "%s\x00", src)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// String returns the stubs of s, sorted by Go function.
+func (s *Spec) String() string {
+	var fns []string
+	for fn := range s.stubs {
+		fns = append(fns, fn)
+	}
+	for name := range s.defs {
+		if _, ok := s.stubs[name]; !ok && strings.ContainsAny(name, "/") {
+			fns = append(fns, name)
+		}
+	}
+	sort.Strings(fns)
+	var b strings.Builder
+	for _, fn := range fns {
+		name, _ := s.Lookup(fn)
+		fmt.Fprintf(&b, "%s = %s\n", fn, name)
+	}
+	return b.String()
+}
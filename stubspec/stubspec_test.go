@@ -0,0 +1,98 @@
+package stubspec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/damifur/migo"
+)
+
+const spec = `-- Pool of workers.
+stub "(*example.com/pool.Pool).Wait" = pool.wait;
+def pool.wait(p): let done = newchan pool.done, 0; spawn pool.worker(p, done); recv done;
+def pool.worker(p, done):
+	select
+	case recv p; call pool.worker(p, done)
+	case tau; send done
+	endselect;
+def example.com/pool.Close(p): close p;
+`
+
+func TestParse(t *testing.T) {
+	s := New()
+	if err := s.Parse(strings.NewReader(spec), "pool.migo"); err != nil {
+		t.Fatal(err)
+	}
+	for fn, want := range map[string]string{
+		"(*example.com/pool.Pool).Wait": "pool.wait",
+		"example.com/pool.Close":        "example.com/pool.Close",
+	} {
+		if name, ok := s.Lookup(fn); !ok || name != want {
+			t.Errorf("expects stub %s of %s but got %s (%t)", want, fn, name, ok)
+		}
+	}
+	if _, ok := s.Lookup("example.com/pool.New"); ok {
+		t.Errorf("expects no stub of example.com/pool.New")
+	}
+	fns, err := s.Defs("pool.wait")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fns) != 2 || fns[0].Name != "pool.wait" || fns[1].Name != "pool.worker" {
+		t.Fatalf("expects pool.wait and pool.worker but got %v", fns)
+	}
+	if len(fns[1].Params) != 2 || len(fns[1].Stmts) != 1 {
+		t.Fatalf("expects pool.worker(p, done) with 1 statement but got %v", fns[1])
+	}
+	sel, ok := fns[1].Stmts[0].(*migo.SelectStatement)
+	if !ok || len(sel.Cases) != 2 {
+		t.Fatalf("expects select of 2 cases but got %v", fns[1].Stmts[0])
+	}
+	if _, ok := sel.Cases[0][1].(*migo.CallStatement); !ok {
+		t.Errorf("expects call in first case but got %v", sel.Cases[0][1])
+	}
+	again, _ := s.Defs("pool.wait")
+	if again[0] == fns[0] {
+		t.Errorf("expects new copies of definitions")
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, src := range []string{
+		`stub net/http.Serve = http.serve;`,
+		`def f(c): send;`,
+		`def f(c): if send c; endif;`,
+		`def f(c): let d = newchan d, -1;`,
+		`def f(c): select endselect;`,
+		`def f(c, ): tau;`,
+		`stub "f = g;`,
+		`recv c;`,
+	} {
+		if err := New().Parse(strings.NewReader(src), "bad.migo"); err == nil || !strings.HasPrefix(err.Error(), "bad.migo:1: ") {
+			t.Errorf("expects error at bad.migo:1 parsing %q but got %v", src, err)
+		}
+	}
+}
+
+func TestDefsUndefined(t *testing.T) {
+	s := New()
+	if err := s.Parse(strings.NewReader(`def f(c): call g(c);`), "f.migo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Defs("f"); err == nil {
+		t.Errorf("expects error for undefined g")
+	}
+}
+
+func TestLoadStd(t *testing.T) {
+	s, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, ok := s.Lookup("(*net/http.Server).ListenAndServe"); !ok || name != "http.serve" {
+		t.Errorf("expects standard stub http.serve but got %s (%t)", name, ok)
+	}
+	if s.Hash() == New().Hash() {
+		t.Errorf("expects hash of standard specifications")
+	}
+}
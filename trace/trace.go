@@ -0,0 +1,253 @@
+// Package trace maps counterexample traces of the MiGo types checker back to
+// Go source code.
+//
+// A trace is reported as the interleaving of goroutines, where each step is
+// located in the Go source (file:line, function and channel name), or as an
+// annotated listing of the source code involved.
+package trace // import "github.com/damifur/dingo-hunter/trace"
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/damifur/dingo-hunter/diagnostics"
+	"github.com/damifur/dingo-hunter/migocheck"
+	"github.com/damifur/dingo-hunter/ssabuilder"
+	"github.com/damifur/migo"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// contextLines is the number of lines shown around annotated lines in a
+// source listing.
+const contextLines = 2
+
+// Location is a step of a trace located in the Go source.
+type Location struct {
+	Step      int    // Index of step in trace (1-based).
+	Goroutine int    // Goroutine identifier (0 is the root).
+	Spawn     string // Function the goroutine is spawned with.
+	File      string // Source file.
+	Line      int    // Source line, 0 if unknown.
+	Function  string // Go function.
+	Channel   string // Channel name, if any.
+	Stmt      string // MiGo statement.
+	Blocked   bool   // True if the goroutine is blocked on the statement.
+}
+
+func (l Location) String() string {
+	pos := l.File
+	if l.Line > 0 {
+		pos = fmt.Sprintf("%s:%d", l.File, l.Line)
+	}
+	state := ""
+	if l.Blocked {
+		state = " (blocked)"
+	}
+	return fmt.Sprintf("%s\t%s\t%s%s", pos, l.Function, l.Stmt, state)
+}
+
+// Reporter locates traces in the source of a program.
+type Reporter struct {
+	SSA   *ssabuilder.SSAInfo
+	Root  *ssa.Function // Root of analysis (for the main.main definition).
+	funcs map[string]*ssa.Function
+}
+
+// NewReporter returns a trace reporter for the MiGo types extracted from root
+// (main.main if nil).
+func NewReporter(info *ssabuilder.SSAInfo, root *ssa.Function) *Reporter {
+	r := &Reporter{SSA: info, Root: root, funcs: make(map[string]*ssa.Function)}
+	for fn := range ssautil.AllFunctions(info.Prog) {
+		r.funcs[fn.String()] = fn
+	}
+	return r
+}
+
+// Func returns the Go function of a MiGo definition, nil if not found.
+// Definitions of blocks (fn#1), unrolled loops (fn#1_loop0) and summaries of
+// other argument shapes (fn#s1) are mapped to their enclosing functions.
+func (r *Reporter) Func(def string) *ssa.Function {
+	if i := strings.Index(def, "#"); i > 0 {
+		def = def[:i]
+	}
+	if def == "main.main" && r.Root != nil {
+		return r.Root
+	}
+	if fn, ok := r.funcs[def]; ok {
+		return fn
+	}
+	return r.SSA.FindFunc(def)
+}
+
+// Locate returns the source location of an action.
+func (r *Reporter) Locate(a migocheck.Action) Location {
+	loc := Location{Goroutine: a.Proc, Spawn: a.Spawn, Function: a.Func, Line: migocheck.Line(a.Stmt)}
+	if a.Stmt != nil {
+		loc.Stmt = a.Stmt.String()
+	}
+	switch s := a.Stmt.(type) {
+	case *migo.SendStatement:
+		loc.Channel = s.Chan
+	case *migo.RecvStatement:
+		loc.Channel = s.Chan
+	case *migo.CloseStatement:
+		loc.Channel = s.Chan
+	case *migo.NewChanStatement:
+		loc.Channel = s.Name.Name()
+	}
+	if fn := r.Func(a.Func); fn != nil {
+		loc.Function = fn.String()
+		pos := fn.Prog.Fset.Position(fn.Pos())
+		loc.File = pos.Filename
+		if loc.Line == 0 {
+			loc.Line = pos.Line
+		}
+	}
+	if fn := r.Func(a.Spawn); fn != nil {
+		loc.Spawn = fn.String()
+	}
+	return loc
+}
+
+// Locations returns the located steps of a violation, followed by the pending
+// statements of the blocked goroutines for deadlocks and liveness failures.
+func (r *Reporter) Locations(v migocheck.Violation) []Location {
+	var locs []Location
+	for i, step := range v.Trace {
+		for _, a := range step {
+			loc := r.Locate(a)
+			loc.Step = i + 1
+			locs = append(locs, loc)
+		}
+	}
+	switch v.Kind {
+	case migocheck.Deadlock:
+		for _, a := range v.Blocked {
+			loc := r.Locate(a)
+			loc.Step, loc.Blocked = len(v.Trace)+1, true
+			locs = append(locs, loc)
+		}
+	case migocheck.Liveness:
+		loc := r.Locate(v.At)
+		loc.Step, loc.Blocked = len(v.Trace)+1, true
+		locs = append(locs, loc)
+	}
+	return locs
+}
+
+// Diagnostics returns the trace of v as diagnostics steps.
+func (r *Reporter) Diagnostics(v migocheck.Violation) []diagnostics.Step {
+	var steps []diagnostics.Step
+	for _, loc := range r.Locations(v) {
+		msg := loc.Stmt
+		if loc.Blocked {
+			msg += " (blocked)"
+		}
+		steps = append(steps, diagnostics.Step{
+			Goroutine: loc.Goroutine,
+			File:      loc.File,
+			Line:      loc.Line,
+			Function:  loc.Function,
+			Channel:   loc.Channel,
+			Message:   msg,
+		})
+	}
+	return steps
+}
+
+// WriteTrace writes the interleaving of v to w, one step per line, where the
+// steps of each goroutine are indented in its own column.
+func (r *Reporter) WriteTrace(w io.Writer, v migocheck.Violation) {
+	locs := r.Locations(v)
+	var ids []int
+	spawns := make(map[int]string)
+	for _, loc := range locs {
+		if _, ok := spawns[loc.Goroutine]; !ok {
+			ids = append(ids, loc.Goroutine)
+		}
+		spawns[loc.Goroutine] = loc.Spawn
+	}
+	sort.Ints(ids)
+	column := make(map[int]int)
+	fmt.Fprintf(w, "%s: %s\n", v.Kind, v.Message)
+	for i, id := range ids {
+		column[id] = i
+		fmt.Fprintf(w, "  goroutine %d: %s\n", id, spawns[id])
+	}
+	for _, loc := range locs {
+		fmt.Fprintf(w, "%4d %s[%d] %s\n", loc.Step, strings.Repeat("    ", column[loc.Goroutine]), loc.Goroutine, loc)
+	}
+}
+
+// WriteListing writes the source code involved in v to w, where the lines of
+// the trace are annotated with the step, goroutine and statement.
+func (r *Reporter) WriteListing(w io.Writer, v migocheck.Violation) error {
+	notes := make(map[string]map[int][]string) // file -> line -> annotations
+	var files []string
+	for _, loc := range r.Locations(v) {
+		if loc.File == "" || loc.Line == 0 {
+			continue
+		}
+		if _, ok := notes[loc.File]; !ok {
+			notes[loc.File] = make(map[int][]string)
+			files = append(files, loc.File)
+		}
+		note := fmt.Sprintf("#%d g%d %s", loc.Step, loc.Goroutine, loc.Stmt)
+		if loc.Blocked {
+			note += " (blocked)"
+		}
+		notes[loc.File][loc.Line] = append(notes[loc.File][loc.Line], note)
+	}
+	fmt.Fprintf(w, "%s: %s\n", v.Kind, v.Message)
+	for _, file := range files {
+		lines, err := readLines(file)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "--- %s\n", file)
+		show := make([]bool, len(lines)+1)
+		for line := range notes[file] {
+			for i := line - contextLines; i <= line+contextLines; i++ {
+				if i > 0 && i <= len(lines) {
+					show[i] = true
+				}
+			}
+		}
+		gap := false
+		for i := 1; i <= len(lines); i++ {
+			if !show[i] {
+				gap = true
+				continue
+			}
+			if gap {
+				fmt.Fprintln(w, "   ...")
+				gap = false
+			}
+			fmt.Fprintf(w, "%5d  %s", i, lines[i-1])
+			if n, ok := notes[file][i]; ok {
+				fmt.Fprintf(w, "\t// ← %s", strings.Join(n, "; "))
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	return nil
+}
+
+func readLines(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	return lines, s.Err()
+}
@@ -16,22 +16,26 @@ func cfsmHandler(w http.ResponseWriter, req *http.Request) {
 	b, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		NewErrInternal(err, "Cannot read input Go source code").Report(w)
+		return
 	}
 	req.Body.Close()
 	conf, err := ssabuilder.NewConfigFromString(string(b))
 	if err != nil {
 		NewErrInternal(err, "Cannot initialise SSA").Report(w)
+		return
 	}
 	ssainfo, err := conf.Build()
 	if err != nil {
 		NewErrInternal(err, "Cannot build SSA").Report(w)
+		return
 	}
 	extract := cfsmextract.New(ssainfo, "extract", "/tmp")
-	go extract.Run()
+	go runAnalysis(extract.Run, extract.Error)
 
 	select {
-	case <-extract.Error:
+	case err := <-extract.Error:
 		NewErrInternal(err, "CFSM extraction failed").Report(w)
+		return
 	case <-extract.Done:
 		log.Println("CFSMs: analysis completed in", extract.Time)
 	}
@@ -40,15 +44,22 @@ func cfsmHandler(w http.ResponseWriter, req *http.Request) {
 	cfsms.WriteTo(bufCfsm)
 	dot := sesstype.NewGraphvizDot(extract.Session())
 	bufDot := new(bytes.Buffer)
-	dot.WriteTo(bufDot)
+	if _, err := dot.WriteTo(bufDot); err != nil {
+		NewErrInternal(err, "Cannot create dot graph").Report(w)
+		return
+	}
 	reply := struct {
 		CFSM string `json:"CFSM"`
 		Dot  string `json:"dot"`
 		Time string `json:"time"`
+
+		Skipped []string `json:"skipped,omitempty"`
 	}{
 		CFSM: bufCfsm.String(),
 		Dot:  bufDot.String(),
 		Time: extract.Time.String(),
+
+		Skipped: skipped(extract.Skipped),
 	}
 	json.NewEncoder(w).Encode(&reply)
 }
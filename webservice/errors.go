@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"runtime/debug"
+
+	"github.com/damifur/dingo-hunter/ssabuilder"
 )
 
 type ErrInternal struct {
@@ -22,5 +25,29 @@ func (e *ErrInternal) Error() string {
 // Report sends internal server error to web client also logs to console.
 func (e *ErrInternal) Report(w http.ResponseWriter) {
 	http.Error(w, e.Error(), http.StatusInternalServerError)
-	log.Fatal(e)
+	log.Print(e)
+}
+
+// runAnalysis runs analysis, and sends a panic of the analysis to errc instead
+// of crashing the server.
+func runAnalysis(analysis func(), errc chan<- error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("analysis panic: %v\n%s", r, debug.Stack())
+			select {
+			case errc <- fmt.Errorf("panic: %v", r):
+			default: // An error is pending already.
+			}
+		}
+	}()
+	analysis()
+}
+
+// skipped returns the functions skipped in an analysis as messages.
+func skipped(errs []*ssabuilder.UnsupportedError) []string {
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return msgs
 }
@@ -10,21 +10,17 @@ import (
 	"path"
 )
 
-var (
-	ExamplesDir string
-	TemplateDir string
-	StaticDir   string
-)
-
-func indexHandler(w http.ResponseWriter, req *http.Request) {
+func (s *Server) indexHandler(w http.ResponseWriter, req *http.Request) {
 	var examples []string
-	t, err := template.ParseFiles(path.Join(TemplateDir, "index.tmpl"))
+	t, err := template.ParseFiles(path.Join(s.TemplateDir, "index.tmpl"))
 	if err != nil {
 		NewErrInternal(err, "Cannot load template").Report(w)
+		return
 	}
-	d, err := ioutil.ReadDir(ExamplesDir)
+	d, err := ioutil.ReadDir(s.ExamplesDir)
 	if err != nil {
 		NewErrInternal(err, "Cannot read examples").Report(w)
+		return
 	}
 	for _, f := range d {
 		if f.IsDir() {
@@ -45,18 +41,21 @@ func indexHandler(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-func loadHandler(w http.ResponseWriter, req *http.Request) {
+func (s *Server) loadHandler(w http.ResponseWriter, req *http.Request) {
 	b, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		NewErrInternal(err, "Cannot read input").Report(w)
+		return
 	}
 	if err := req.Body.Close(); err != nil {
 		NewErrInternal(err, "Cannot close request").Report(w)
+		return
 	}
 	log.Println("Load example:", string(b))
-	file, err := os.Open(path.Join(ExamplesDir, string(b), "main.go"))
+	file, err := os.Open(path.Join(s.ExamplesDir, string(b), "main.go"))
 	if err != nil {
 		NewErrInternal(err, "Cannot open file").Report(w)
+		return
 	}
 	io.Copy(w, file)
 }
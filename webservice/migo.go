@@ -14,22 +14,26 @@ func migoHandler(w http.ResponseWriter, req *http.Request) {
 	b, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		NewErrInternal(err, "Cannot read input Go source code").Report(w)
+		return
 	}
 	req.Body.Close()
 	conf, err := ssabuilder.NewConfigFromString(string(b))
 	if err != nil {
 		NewErrInternal(err, "Cannot initialise SSA").Report(w)
+		return
 	}
 	info, err := conf.Build()
 	if err != nil {
 		NewErrInternal(err, "Cannot build SSA").Report(w)
+		return
 	}
 	extract, err := migoextract.New(info, ioutil.Discard)
-	go extract.Run()
+	go runAnalysis(extract.Run, extract.Error)
 
 	select {
-	case <-extract.Error:
+	case err := <-extract.Error:
 		NewErrInternal(err, "MiGo type inference failed").Report(w)
+		return
 	case <-extract.Done:
 		log.Println("MiGo: analysis completed in", extract.Time)
 		extract.Env.MigoProg.CleanUp()
@@ -38,9 +42,13 @@ func migoHandler(w http.ResponseWriter, req *http.Request) {
 	reply := struct {
 		MiGo string `json:"MiGo"`
 		Time string `json:"time"`
+
+		Skipped []string `json:"skipped,omitempty"`
 	}{
 		MiGo: extract.Env.MigoProg.String(),
 		Time: extract.Time.String(),
+
+		Skipped: skipped(extract.Skipped),
 	}
 	json.NewEncoder(w).Encode(&reply)
 }
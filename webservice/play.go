@@ -19,18 +19,18 @@ const basePkg = "golang.org/x/tools/cmd/present"
 
 var scripts = []string{"jquery.js", "jquery-ui.js", "playground.js", "play.js"}
 
-func initPlayground(origin *url.URL) {
+func initPlayground(mux *http.ServeMux, origin *url.URL) {
 	p, err := build.Default.Import(basePkg, "", build.FindOnly)
 	if err != nil {
 		log.Fatalf("Could not find gopresent files: %v", err)
 	}
 	basePath := p.Dir
 
-	playScript(basePath, "SocketTransport")
-	http.Handle("/socket", socket.NewHandler(origin))
+	playScript(mux, basePath, "SocketTransport")
+	mux.Handle("/socket", socket.NewHandler(origin))
 }
 
-func playScript(root, transport string) {
+func playScript(mux *http.ServeMux, root, transport string) {
 	modTime := time.Now()
 	var buf bytes.Buffer
 	for _, p := range scripts {
@@ -46,7 +46,7 @@ func playScript(root, transport string) {
 	}
 	fmt.Fprintf(&buf, "\ninitPlayground(new %v());\n", transport)
 	b := buf.Bytes()
-	http.HandleFunc("/play.js", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/play.js", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-type", "application/javascript")
 		http.ServeContent(w, r, "", modTime, bytes.NewReader(b))
 	})
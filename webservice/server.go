@@ -10,6 +10,10 @@ import (
 )
 
 type Server struct {
+	ExamplesDir string // Path to examples directory.
+	TemplateDir string // Path to templates directory.
+	StaticDir   string // Path to static files directory.
+
 	listener net.Listener
 	iface    string
 	port     string
@@ -26,19 +30,20 @@ func NewServer(iface string, port string) *Server {
 
 func (s *Server) Start() {
 	origin := &url.URL{Scheme: "http", Host: net.JoinHostPort(s.iface, s.port)}
-	initPlayground(origin)
-	http.HandleFunc("/", indexHandler)
-	fs := http.FileServer(http.Dir(StaticDir))
-	http.Handle("/static/", http.StripPrefix("/static/", fs))
-	http.HandleFunc("/ssa", ssaHandler)
-	http.HandleFunc("/load", loadHandler)
-	http.HandleFunc("/cfsm", cfsmHandler)
-	http.HandleFunc("/migo", migoHandler)
-	http.HandleFunc("/gong", gongHandler)
-	http.HandleFunc("/synthesis", synthesisHandler)
+	mux := http.NewServeMux()
+	initPlayground(mux, origin)
+	mux.HandleFunc("/", s.indexHandler)
+	fs := http.FileServer(http.Dir(s.StaticDir))
+	mux.Handle("/static/", http.StripPrefix("/static/", fs))
+	mux.HandleFunc("/ssa", ssaHandler)
+	mux.HandleFunc("/load", s.loadHandler)
+	mux.HandleFunc("/cfsm", cfsmHandler)
+	mux.HandleFunc("/migo", migoHandler)
+	mux.HandleFunc("/gong", gongHandler)
+	mux.HandleFunc("/synthesis", synthesisHandler)
 
 	log.Printf("Listening at %s", s.URL())
-	(&http.Server{}).Serve(s.Listener())
+	(&http.Server{Handler: mux}).Serve(s.Listener())
 }
 
 func (s *Server) Close() {
@@ -11,15 +11,18 @@ func ssaHandler(w http.ResponseWriter, req *http.Request) {
 	b, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		NewErrInternal(err, "Cannot read input Go source code").Report(w)
+		return
 	}
 	req.Body.Close()
 	conf, err := ssabuilder.NewConfigFromString(string(b))
 	if err != nil {
 		NewErrInternal(err, "Cannot initialise SSA").Report(w)
+		return
 	}
 	info, err := conf.Build()
 	if err != nil {
 		NewErrInternal(err, "Cannot build SSA").Report(w)
+		return
 	}
 	info.WriteTo(w)
 }